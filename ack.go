@@ -0,0 +1,154 @@
+// Copyright 2020 Kentaro Hibino. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package asynq
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/hibiken/asynq/internal/base"
+	"github.com/hibiken/asynq/internal/rdb"
+)
+
+// ackerKey is the context key for the Acker of the task.
+const ackerKey ctxKey = 2
+
+// errNotAcked is recorded as the error of a task which the handler
+// returned without acknowledging with Config.ExplicitAck set.
+var errNotAcked = errors.New("task was not acknowledged by the handler")
+
+// errNacked is recorded as the error of a task which the handler
+// negatively acknowledged without an error.
+var errNacked = errors.New("task was negatively acknowledged by the handler")
+
+// States of an Acker.
+const (
+	ackPending = iota
+	ackAcked
+	ackNacked
+	ackClosed // the handler returned without acknowledging the task.
+)
+
+// Acker acknowledges the task being processed on behalf of its handler,
+// when the background runs with Config.ExplicitAck set.
+//
+// Acker is safe for concurrent use by multiple goroutines.
+type Acker struct {
+	rdb *rdb.RDB
+	msg *base.TaskMessage
+
+	// beforeAck, if not nil, is called before the task is removed from the
+	// in-progress queue, e.g. to record the completion of the task.
+	beforeAck func()
+
+	mu      sync.Mutex
+	state   int
+	nackErr error
+}
+
+// withAcker returns a copy of ctx from which AckerFromContext returns ack.
+func withAcker(ctx context.Context, ack *Acker) context.Context {
+	return context.WithValue(ctx, ackerKey, ack)
+}
+
+// AckerFromContext returns the Acker of the task being processed, given
+// the context passed to the handler.
+//
+// It returns false if ctx is not a context passed to the handler by the
+// background, or if the background runs without Config.ExplicitAck.
+//
+// Example:
+//
+//	func (h *orderHandler) ProcessTask(ctx context.Context, t *asynq.Task) error {
+//	    ack, _ := asynq.AckerFromContext(ctx)
+//	    tx := h.db.Begin()
+//	    if err := placeOrder(tx, t); err != nil {
+//	        tx.Rollback()
+//	        return err
+//	    }
+//	    if err := tx.Commit(); err != nil {
+//	        return err
+//	    }
+//	    return ack.Ack()
+//	}
+func AckerFromContext(ctx context.Context) (*Acker, bool) {
+	ack, ok := ctx.Value(ackerKey).(*Acker)
+	return ack, ok
+}
+
+// Ack removes the task from the in-progress queue to mark the task as done.
+//
+// The task is done once Ack returns nil, regardless of the error returned by
+// the handler afterwards. If Ack returns an error, the task is not known to be
+// done, and it's retried unless Ack succeeds on another call before the handler
+// returns.
+//
+// It returns an error if the task was already negatively acknowledged or the
+// handler already returned.
+func (a *Acker) Ack() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	switch a.state {
+	case ackAcked:
+		return nil
+	case ackNacked:
+		return errors.New("asynq: task was already negatively acknowledged")
+	case ackClosed:
+		return errors.New("asynq: handler already returned")
+	}
+	if a.beforeAck != nil {
+		a.beforeAck()
+	}
+	if err := a.rdb.Done(a.msg); err != nil {
+		return err
+	}
+	a.state = ackAcked
+	return nil
+}
+
+// Nack negatively acknowledges the task, so that the task is retried
+// with the given error once the handler returns, or moved to the dead
+// queue if its retry is exhausted. The error returned by the handler
+// is ignored.
+//
+// It returns an error if the task was already acknowledged or the
+// handler already returned.
+func (a *Acker) Nack(err error) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	switch a.state {
+	case ackAcked:
+		return errors.New("asynq: task was already acknowledged")
+	case ackNacked:
+		return nil
+	case ackClosed:
+		return errors.New("asynq: handler already returned")
+	}
+	if err == nil {
+		err = errNacked
+	}
+	a.state, a.nackErr = ackNacked, err
+	return nil
+}
+
+// finish is called once the handler returned resErr, and reports whether
+// the task was acknowledged, along with the error to process the task with
+// if it was not. Further calls to Ack and Nack fail.
+func (a *Acker) finish(resErr error) (acked bool, err error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	switch a.state {
+	case ackAcked:
+		return true, nil
+	case ackNacked:
+		return false, a.nackErr
+	}
+	a.state = ackClosed
+	if resErr == nil {
+		resErr = errNotAcked
+	}
+	return false, resErr
+}
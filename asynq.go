@@ -5,10 +5,13 @@
 package asynq
 
 import (
+	"context"
 	"crypto/tls"
 	"fmt"
+	"net"
 
 	"github.com/go-redis/redis/v7"
+	"github.com/hibiken/asynq/internal/rdb"
 )
 
 // Task represents a unit of work to be performed.
@@ -34,7 +37,13 @@ func NewTask(typename string, payload map[string]interface{}) *Task {
 //
 // RedisConnOpt represents a sum of following types:
 //
-// RedisClientOpt | *RedisClientOpt | RedisFailoverClientOpt | *RedisFailoverClientOpt
+// RedisClientOpt | *RedisClientOpt | RedisFailoverClientOpt | *RedisFailoverClientOpt | redis.UniversalClient
+//
+// A redis.UniversalClient, such as *redis.Client, is used as is. It is not
+// closed by asynq, so that it can be shared with the rest of the application;
+// the caller is responsible for closing it.
+// *redis.ClusterClient is not supported, since asynq runs scripts accessing
+// keys which may be in different slots of a cluster.
 type RedisConnOpt interface{}
 
 // RedisClientOpt is used to create a redis client that connects
@@ -61,6 +70,15 @@ type RedisClientOpt struct {
 	// TLS Config used to connect to a server.
 	// TLS will be negotiated only if this field is set.
 	TLSConfig *tls.Config
+
+	// Dialer optionally specifies the function to create network connections,
+	// e.g. to connect through an SSH tunnel or a proxy.
+	// If set, Network and TLSConfig are not used to dial.
+	Dialer func(ctx context.Context, network, addr string) (net.Conn, error)
+
+	// OnConnect optionally specifies the hook called when a new connection
+	// is established, e.g. to authenticate with a refreshed token.
+	OnConnect func(conn *redis.Conn) error
 }
 
 // RedisFailoverClientOpt is used to creates a redis client that talks
@@ -92,33 +110,51 @@ type RedisFailoverClientOpt struct {
 	// TLS Config used to connect to a server.
 	// TLS will be negotiated only if this field is set.
 	TLSConfig *tls.Config
+
+	// Dialer optionally specifies the function to create network connections,
+	// e.g. to connect through an SSH tunnel or a proxy.
+	// If set, Network and TLSConfig are not used to dial.
+	Dialer func(ctx context.Context, network, addr string) (net.Conn, error)
+
+	// OnConnect optionally specifies the hook called when a new connection
+	// is established, e.g. to authenticate with a refreshed token.
+	OnConnect func(conn *redis.Conn) error
 }
 
 // createRedisClient returns a redis client given a redis connection configuration.
 //
+// The commands sent with a client created from the options are recorded for
+// RedisStats. A redis client given as RedisConnOpt is shared with the rest
+// of the application, so no hook is added to it and its commands are not
+// recorded.
+//
 // Passing an unexpected type as a RedisConnOpt argument will cause panic.
-func createRedisClient(r RedisConnOpt) *redis.Client {
+func createRedisClient(r RedisConnOpt) redis.UniversalClient {
 	switch r := r.(type) {
 	case *RedisClientOpt:
-		return redis.NewClient(&redis.Options{
+		return rdb.WithCommandStats(redis.NewClient(&redis.Options{
 			Network:   r.Network,
 			Addr:      r.Addr,
 			Password:  r.Password,
 			DB:        r.DB,
 			PoolSize:  r.PoolSize,
 			TLSConfig: r.TLSConfig,
-		})
+			Dialer:    r.Dialer,
+			OnConnect: r.OnConnect,
+		}))
 	case RedisClientOpt:
-		return redis.NewClient(&redis.Options{
+		return rdb.WithCommandStats(redis.NewClient(&redis.Options{
 			Network:   r.Network,
 			Addr:      r.Addr,
 			Password:  r.Password,
 			DB:        r.DB,
 			PoolSize:  r.PoolSize,
 			TLSConfig: r.TLSConfig,
-		})
+			Dialer:    r.Dialer,
+			OnConnect: r.OnConnect,
+		}))
 	case *RedisFailoverClientOpt:
-		return redis.NewFailoverClient(&redis.FailoverOptions{
+		return rdb.WithCommandStats(redis.NewFailoverClient(&redis.FailoverOptions{
 			MasterName:       r.MasterName,
 			SentinelAddrs:    r.SentinelAddrs,
 			SentinelPassword: r.SentinelPassword,
@@ -126,9 +162,11 @@ func createRedisClient(r RedisConnOpt) *redis.Client {
 			DB:               r.DB,
 			PoolSize:         r.PoolSize,
 			TLSConfig:        r.TLSConfig,
-		})
+			Dialer:           r.Dialer,
+			OnConnect:        r.OnConnect,
+		}))
 	case RedisFailoverClientOpt:
-		return redis.NewFailoverClient(&redis.FailoverOptions{
+		return rdb.WithCommandStats(redis.NewFailoverClient(&redis.FailoverOptions{
 			MasterName:       r.MasterName,
 			SentinelAddrs:    r.SentinelAddrs,
 			SentinelPassword: r.SentinelPassword,
@@ -136,8 +174,34 @@ func createRedisClient(r RedisConnOpt) *redis.Client {
 			DB:               r.DB,
 			PoolSize:         r.PoolSize,
 			TLSConfig:        r.TLSConfig,
-		})
+			Dialer:           r.Dialer,
+			OnConnect:        r.OnConnect,
+		}))
+	case *redis.ClusterClient:
+		panic("asynq: redis.ClusterClient is not supported for RedisConnOpt")
+	case redis.UniversalClient:
+		return sharedClient{r}
 	default:
 		panic(fmt.Sprintf("asynq: unexpected type %T for RedisConnOpt", r))
 	}
 }
+
+// sharedClient is a redis client given by the user, which is shared with
+// the rest of the application and therefore not closed by asynq.
+type sharedClient struct {
+	redis.UniversalClient
+}
+
+// Close does not close the client, the user is responsible for closing it.
+func (c sharedClient) Close() error {
+	return nil
+}
+
+// PoolStats returns the statistics of the pool of the client,
+// or nil if the client does not report them.
+func (c sharedClient) PoolStats() *redis.PoolStats {
+	if p, ok := c.UniversalClient.(interface{ PoolStats() *redis.PoolStats }); ok {
+		return p.PoolStats()
+	}
+	return nil
+}
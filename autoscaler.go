@@ -0,0 +1,137 @@
+// Copyright 2020 Kentaro Hibino. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package asynq
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/hibiken/asynq/internal/log"
+	"github.com/hibiken/asynq/internal/rdb"
+)
+
+// autoscaler is responsible for adjusting the number of active workers
+// of the processor based on the backlog and the latency of the queues.
+type autoscaler struct {
+	logger *log.Logger
+	rdb    *rdb.RDB
+
+	processor *processor
+
+	// queues to check the backlog of.
+	qnames []string
+
+	// lower bound of the number of active workers.
+	// upper bound is the configured concurrency of the processor.
+	min int
+
+	// channel to communicate back to the long running "autoscaler" goroutine.
+	done chan struct{}
+
+	// interval between adjustments.
+	interval time.Duration
+}
+
+func newAutoscaler(l *log.Logger, rdb *rdb.RDB, p *processor, queues map[string]int, min int, interval time.Duration) *autoscaler {
+	var qnames []string
+	for qname := range queues {
+		qnames = append(qnames, qname)
+	}
+	return &autoscaler{
+		logger:    l,
+		rdb:       rdb,
+		processor: p,
+		qnames:    qnames,
+		min:       min,
+		done:      make(chan struct{}),
+		interval:  interval,
+	}
+}
+
+func (s *autoscaler) terminate() {
+	s.logger.Info("Autoscaler shutting down...")
+	// Signal the autoscaler goroutine to stop.
+	s.done <- struct{}{}
+}
+
+func (s *autoscaler) start(wg *sync.WaitGroup) {
+	s.processor.setConcurrency(s.min)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		s.exec()
+		for {
+			select {
+			case <-s.done:
+				s.logger.Info("Autoscaler done")
+				return
+			case <-time.After(s.interval):
+				s.exec()
+			}
+		}
+	}()
+}
+
+func (s *autoscaler) exec() {
+	// queues outside of their active hours don't need workers.
+	qnames := s.processor.activeQueues(s.qnames, time.Now())
+	if groups := s.processor.groups; groups != nil {
+		qnames = groups.expand(qnames)
+	}
+	backlog, err := s.rdb.EnqueuedCount(qnames...)
+	if err != nil {
+		s.logger.Error("could not get the number of enqueued tasks: %v", err)
+		return
+	}
+	latencies, err := s.rdb.QueueLatencies(qnames...)
+	if err != nil {
+		s.logger.Error("could not get the latency of the queues: %v", err)
+		return
+	}
+	current := s.processor.concurrency()
+	busy := s.processor.ps.Get().ActiveWorkerCount
+	desired := busy + s.workersFor(backlog)
+	if maxLatency(latencies) > s.interval && desired < 2*current {
+		// tasks have been waiting longer than an interval, the workers
+		// are falling behind regardless of the estimate.
+		desired = 2 * current
+	}
+	if desired < current/2 {
+		// scale down gradually to avoid flapping.
+		desired = current / 2
+	}
+	if desired < s.min {
+		desired = s.min
+	}
+	if desired == current {
+		return
+	}
+	if n := s.processor.setConcurrency(desired); n != current {
+		s.logger.Info("Scaled workers from %d to %d", current, n)
+	}
+}
+
+// workersFor returns the number of workers needed to process
+// the given number of tasks within one interval.
+func (s *autoscaler) workersFor(backlog int) int {
+	avg := s.processor.averageDuration()
+	if avg <= 0 {
+		// no data yet, assume one worker per task.
+		return backlog
+	}
+	return int(math.Ceil(float64(backlog) * float64(avg) / float64(s.interval)))
+}
+
+// maxLatency returns the highest of the given latencies.
+func maxLatency(latencies map[string]time.Duration) time.Duration {
+	var max time.Duration
+	for _, l := range latencies {
+		if l > max {
+			max = l
+		}
+	}
+	return max
+}
@@ -0,0 +1,130 @@
+// Copyright 2020 Kentaro Hibino. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package asynq
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	h "github.com/hibiken/asynq/internal/asynqtest"
+	"github.com/hibiken/asynq/internal/base"
+	"github.com/hibiken/asynq/internal/rdb"
+)
+
+func TestAutoscaler(t *testing.T) {
+	r := setup(t)
+	rdbClient := rdb.NewRDB(r)
+
+	var msgs []*base.TaskMessage
+	for i := 0; i < 30; i++ {
+		msgs = append(msgs, h.NewTaskMessage("send_email", nil))
+	}
+	late := h.NewTaskMessage("send_email", nil)
+	late.EnqueuedAt = time.Now().Add(-time.Minute).Unix()
+
+	tests := []struct {
+		desc       string
+		enqueued   []*base.TaskMessage
+		avgDur     time.Duration
+		current    int
+		wantWorker int
+	}{
+		{
+			desc:       "scales up to the backlog without duration data",
+			enqueued:   msgs[:6],
+			current:    2,
+			wantWorker: 6,
+		},
+		{
+			desc:       "scales up to the max",
+			enqueued:   msgs,
+			current:    2,
+			wantWorker: 10,
+		},
+		{
+			desc:       "uses average duration to estimate needed workers",
+			enqueued:   msgs,
+			avgDur:     time.Second,
+			current:    2,
+			wantWorker: 6, // 30 tasks * 1s / 5s interval
+		},
+		{
+			desc:       "doubles the workers while tasks wait longer than an interval",
+			enqueued:   []*base.TaskMessage{late},
+			avgDur:     time.Second,
+			current:    3,
+			wantWorker: 6,
+		},
+		{
+			desc:       "scales down gradually",
+			enqueued:   []*base.TaskMessage{},
+			current:    10,
+			wantWorker: 5,
+		},
+		{
+			desc:       "does not scale below the min",
+			enqueued:   []*base.TaskMessage{},
+			current:    3,
+			wantWorker: 2,
+		},
+	}
+
+	for _, tc := range tests {
+		h.FlushDB(t, r)
+		h.SeedEnqueuedQueue(t, r, tc.enqueued)
+
+		ps := base.NewProcessState("localhost", 1234, 10, defaultQueueConfig, false)
+		p := newProcessor(processorParams{
+			logger:         testLogger,
+			rdb:            rdbClient,
+			ps:             ps,
+			retryDelayFunc: defaultDelayFunc,
+			baseCtxFn:      context.Background,
+			cancelations:   base.NewCancelations(),
+		})
+		p.avgDur = tc.avgDur
+		p.setConcurrency(tc.current)
+		s := newAutoscaler(testLogger, rdbClient, p, defaultQueueConfig, 2, 5*time.Second)
+
+		s.exec()
+
+		if got := p.concurrency(); got != tc.wantWorker {
+			t.Errorf("%s: concurrency after exec = %d, want %d", tc.desc, got, tc.wantWorker)
+		}
+	}
+}
+
+func TestAutoscalerDoesNotParkReservedWorkers(t *testing.T) {
+	r := setup(t)
+	rdbClient := rdb.NewRDB(r)
+
+	queues := map[string]int{"critical": 1, "low": 1}
+	ps := base.NewProcessState("localhost", 1234, 10, queues, false)
+	p := newProcessor(processorParams{
+		logger:         testLogger,
+		rdb:            rdbClient,
+		ps:             ps,
+		retryDelayFunc: defaultDelayFunc,
+		baseCtxFn:      context.Background,
+		cancelations:   base.NewCancelations(),
+		reservations:   map[string]int{"critical": 3},
+	})
+	p.setConcurrency(5)
+	s := newAutoscaler(testLogger, rdbClient, p, queues, 4, 5*time.Second)
+
+	s.exec() // scales down to the min.
+
+	if got := p.concurrency(); got != 4 {
+		t.Fatalf("concurrency after exec = %d, want 4", got)
+	}
+	// one worker is left for the queues without reservations.
+	p.slots.acquire("low")
+	want := []string{"critical"}
+	if got := p.slots.available([]string{"critical", "low"}); !cmp.Equal(want, got) {
+		t.Errorf("available() with one of 4 workers busy for %q = %v, want %v", "low", got, want)
+	}
+}
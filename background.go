@@ -10,9 +10,9 @@ import (
 	"math"
 	"math/rand"
 	"os"
-	"os/signal"
+	"sort"
+	"strings"
 	"sync"
-	"syscall"
 	"time"
 
 	"github.com/hibiken/asynq/internal/base"
@@ -48,6 +48,23 @@ type Background struct {
 	syncer      *syncer
 	heartbeater *heartbeater
 	subscriber  *subscriber
+	controller  *controller
+	autoscaler  *autoscaler     // nil if autoscaling is disabled
+	discoverer  *discoverer     // nil if no queue prefixes are configured
+	replicator  *replicator     // nil if replication is disabled
+	failures    *failureMonitor // nil if OnFailureAlarm is not set
+
+	// how long to wait for redis at startup. If zero, wait until it's reachable.
+	startupTimeout time.Duration
+	// if true, don't wait for redis at startup.
+	failFast bool
+
+	// error in the config which prevents the background from running.
+	cfgErr error
+
+	// closed by Shutdown to make Run return.
+	shutdownCh   chan struct{}
+	shutdownOnce sync.Once
 }
 
 // Config specifies the background-task processing behavior.
@@ -57,6 +74,21 @@ type Config struct {
 	// If set to a zero or negative value, NewBackground will overwrite the value to one.
 	Concurrency int
 
+	// MinConcurrency optionally enables autoscaling of the number of workers.
+	//
+	// If set to a positive value less than Concurrency, the number of active
+	// workers is adjusted periodically between MinConcurrency and Concurrency,
+	// based on the number of enqueued tasks, the average time it takes to
+	// process a task and the latency of the queues. Workers are added as soon
+	// as there is a backlog, or doubled while tasks wait longer than the five
+	// seconds between adjustments, and removed gradually once the backlog is gone.
+	// Workers reserved by QueueReservations are never removed, and Run
+	// returns an error if MinConcurrency is less than the reserved workers.
+	//
+	// If set to a zero or negative value, or a value not less than Concurrency,
+	// the number of workers is fixed to Concurrency.
+	MinConcurrency int
+
 	// Function to calculate retry delay for a failed task.
 	//
 	// By default, it uses exponential backoff algorithm to calculate the delay.
@@ -64,8 +96,36 @@ type Config struct {
 	// n is the number of times the task has been retried.
 	// e is the error returned by the task handler.
 	// t is the task in question.
+	//
+	// ExponentialBackoff, LinearBackoff, FixedDelay and WithJitter can be used
+	// to build a retry delay function.
+	//
+	// A handler can override the delay for a single failure by returning
+	// an error created with RetryIn.
 	RetryDelayFunc func(n int, e error, t *Task) time.Duration
 
+	// RetryJitter specifies the fraction of the delay returned by RetryDelayFunc
+	// by which the delay is randomized in either direction, as with WithJitter.
+	//
+	// Jitter spreads out the retries of tasks which failed at the same time,
+	// e.g. during an outage of a dependency, so that they don't stampede
+	// once it recovers. Delays given with RetryIn are not randomized.
+	//
+	// If unset or zero, delays are randomized by up to 10%.
+	// If negative, delays are used as is.
+	RetryJitter float64
+
+	// RetryDrainLimit optionally limits the number of retry tasks moved to
+	// their queues each time the background checks for due tasks, which is
+	// every 5 seconds, so that a large number of tasks due to be retried at
+	// once are processed gradually.
+	//
+	// Scheduled tasks are not limited, and retry tasks over the limit are moved
+	// at the next checks in the order they are due.
+	//
+	// If set to zero or a negative value, all due retry tasks are moved at once.
+	RetryDrainLimit int
+
 	// List of queues to process with given priority value. Keys are the names of the
 	// queues and values are associated priority value.
 	//
@@ -81,11 +141,95 @@ type Config struct {
 	// }
 	// With the above config and given that all queues are not empty, the tasks
 	// in "critical", "default", "low" should be processed 60%, 30%, 10% of
-	// the time respectively.
+	// the time respectively. Queues are selected by weighted round-robin, so
+	// out of every 10 dequeues, "low" queue is queried first exactly once.
 	//
 	// If a queue has a zero or negative priority value, the queue will be ignored.
+	//
+	// Queue names must not be empty, contain whitespace, control characters
+	// or "#", or start with "asynq:". Run returns an error for an invalid name.
 	Queues map[string]int
 
+	// QueuePrefixes optionally specifies prefixes of queue names with given priority
+	// value, to process queues which are not known in advance, e.g. a queue per tenant.
+	//
+	// Queues whose names start with a prefix are discovered periodically from
+	// the queues which tasks have been enqueued to. Together they are processed
+	// as a single queue with the priority of the prefix, and they are queried in
+	// round-robin order so that each of them gets a fair share of the workers
+	// regardless of the backlog of the others.
+	//
+	// Example:
+	// Queues: map[string]int{
+	//     "default": 1,
+	// },
+	// QueuePrefixes: map[string]int{
+	//     "tenant:": 3,
+	// }
+	// With the above config, tasks enqueued with asynq.Queue("tenant:" + id)
+	// are processed 75% of the time, evenly across the tenants.
+	//
+	// Queues listed in Queues are not part of any prefix. If a queue name
+	// matches multiple prefixes, it's part of the longest one.
+	// Prefixes can be used as keys of QueueReservations and QueueActiveHours
+	// to apply to all queues of the prefix.
+	//
+	// If a prefix has a zero or negative priority value, the prefix will be ignored.
+	QueuePrefixes map[string]int
+
+	// QueueShards optionally specifies the number of shards of queues.
+	// Keys are the names of the queues and values are the number of shards.
+	//
+	// Tasks of a hot queue can be spread over multiple redis lists by enqueuing
+	// them with asynq.Shards option, so that the queue is not bottlenecked on
+	// a single list. The number of shards should match the one given to the option.
+	// Shards of a queue are processed as a single queue with the priority of
+	// the queue, and they are queried in round-robin order.
+	// Tasks enqueued to the queue without sharding are processed as well.
+	//
+	// Shards for queues which are not in Queues are ignored.
+	// If the number of shards is less than two, the queue is not sharded.
+	QueueShards map[string]int
+
+	// QueueReservations optionally reserves worker slots for queues. Keys are the
+	// names of the queues and values are the number of workers reserved for the queue.
+	//
+	// Tasks from a queue can only run on the workers reserved for the queue
+	// or on the workers that are not reserved for any queue.
+	// This guarantees that, for example, long running tasks from low priority
+	// queues never occupy all the workers.
+	//
+	// Example:
+	// Concurrency: 10,
+	// QueueReservations: map[string]int{
+	//     "critical": 3,
+	//     "default":  1,
+	// }
+	// With the above config, tasks from "critical" queue can always use at least
+	// three workers, and tasks from other queues can use at most seven workers.
+	//
+	// Reservations for queues which are not in Queues are ignored.
+	// Run returns an error if the sum of reservations is greater than Concurrency.
+	QueueReservations map[string]int
+
+	// QueueActiveHours optionally restricts processing of queues to certain hours
+	// of the day. Keys are the names of the queues and values are the time windows
+	// during which tasks in the queue are processed.
+	//
+	// Tasks in a queue are not processed outside of its active hours, so that
+	// heavy batch work can yield to interactive workloads during the day.
+	// Queues which are not in the map are processed all day.
+	//
+	// Example:
+	// QueueActiveHours: map[string]asynq.ActiveHours{
+	//     "reports": {Start: 1 * time.Hour, End: 6 * time.Hour},
+	// }
+	// With the above config, tasks in "reports" queue are processed only
+	// between 01:00 and 06:00 local time.
+	//
+	// Active hours with out of range Start or End are ignored.
+	QueueActiveHours map[string]ActiveHours
+
 	// StrictPriority indicates whether the queue priority should be treated strictly.
 	//
 	// If set to true, tasks in the queue with the highest priority is processed first.
@@ -93,6 +237,51 @@ type Config struct {
 	// higher priorities are empty.
 	StrictPriority bool
 
+	// StrictQueues optionally lists the queues which are always queried before
+	// the other queues, e.g. "critical", while the other queues share the
+	// processing by their priority as usual.
+	//
+	// Tasks in the other queues are processed only when the strict queues are
+	// empty. The strict queues are queried in the order of their priority.
+	//
+	// Example:
+	// Queues: map[string]int{
+	//     "critical": 10,
+	//     "default":  6,
+	//     "low":      3,
+	// },
+	// StrictQueues: []string{"critical"}
+	// With the above config, tasks in "critical" queue are always processed
+	// first, and tasks in "default" and "low" queues are processed 66% and 33%
+	// of the time respectively.
+	//
+	// Queues which are not in Queues are ignored.
+	// StrictQueues is ignored if StrictPriority is true.
+	StrictQueues []string
+
+	// PriorityAging optionally prevents low priority queues from being starved
+	// in strict-priority mode.
+	//
+	// If set to a positive duration, a queue whose oldest task has been waiting
+	// for longer than the duration (because queues with higher priority always
+	// had tasks) is temporarily boosted and queried before other queues, so that
+	// tasks in every queue eventually get processed.
+	//
+	// If set to zero or a negative value, queues are queried strictly by priority.
+	// PriorityAging is ignored if StrictPriority is false.
+	PriorityAging time.Duration
+
+	// BaseContext optionally specifies a function that returns the base context for
+	// the contexts passed to the task handler.
+	//
+	// Use it to make values such as loggers, tracers or tenant information
+	// available to every handler invocation.
+	// If BaseContext is nil, the default is context.Background().
+	// If this is defined, then it MUST return a non-nil context.
+	//
+	// Note that task contexts are canceled when the base context is canceled.
+	BaseContext func() context.Context
+
 	// ErrorHandler handles errors returned by the task handler.
 	//
 	// HandleError is invoked only if the task handler returns a non-nil error.
@@ -107,8 +296,337 @@ type Config struct {
 	//
 	// ErrorHandler: asynq.ErrorHandlerFunc(reportError)
 	ErrorHandler ErrorHandler
+
+	// SlowTaskThreshold optionally specifies the processing time above which
+	// a task is considered slow.
+	//
+	// Once the task handler has been processing a task for longer than the
+	// threshold, while the task is still running, a warning is logged with
+	// the type, ID and processing time of the task, and OnSlowTask is called
+	// if it's set. A task is reported at most once.
+	//
+	// If set to zero or a negative value, slow tasks are not detected.
+	SlowTaskThreshold time.Duration
+
+	// OnSlowTask optionally specifies a function to call when the task handler
+	// has been processing a task for longer than SlowTaskThreshold.
+	//
+	// It's called while the handler is still running, so it's called for
+	// tasks whose handler never returns too. d is the time since the handler
+	// started processing the task.
+	OnSlowTask func(task *Task, d time.Duration)
+
+	// TaskHistorySize optionally enables recording of the execution history of tasks.
+	//
+	// If set to a positive value, the host, start time, duration and error of
+	// each attempt to process a task are recorded, keeping up to TaskHistorySize
+	// latest attempts per task. Use Inspector.TaskHistory to retrieve them.
+	//
+	// If set to zero or a negative value, no history is recorded.
+	TaskHistorySize int
+
+	// CircuitBreakerThreshold optionally enables a circuit breaker for each task type.
+	//
+	// If set to a positive value, processing of a task type is paused for
+	// CircuitBreakerCooldown once the handler fails to process
+	// CircuitBreakerThreshold tasks of the type in a row.
+	// While paused, tasks of the type are moved to the scheduled queue to be
+	// processed after the cool-down period, without counting as a retry.
+	// After the cool-down period, a successfully processed task resumes
+	// normal processing of the type, and another failure pauses it again.
+	//
+	// If set to zero or a negative value, the circuit breaker is disabled.
+	CircuitBreakerThreshold int
+
+	// CircuitBreakerCooldown specifies how long to pause processing of a task type
+	// once the circuit breaker is triggered.
+	//
+	// If unset or zero, the cool-down period is set to one minute.
+	CircuitBreakerCooldown time.Duration
+
+	// PanicQuarantineThreshold optionally enables quarantine of task types
+	// whose handler keeps panicking.
+	//
+	// If set to a positive value, processing of a task type is paused for
+	// PanicQuarantineDuration once the handler panics PanicQuarantineThreshold
+	// times within PanicQuarantineWindow while processing tasks of the type.
+	// While quarantined, tasks of the type are moved to the scheduled queue to
+	// be processed after the quarantine, without counting as a retry.
+	// Background.ReleaseQuarantine ends the quarantine early.
+	//
+	// Panics are counted by task type regardless of the threshold,
+	// see Background.PanicCounts.
+	//
+	// If set to zero or a negative value, task types are not quarantined.
+	PanicQuarantineThreshold int
+
+	// PanicQuarantineWindow specifies the period within which panics are
+	// counted toward PanicQuarantineThreshold. The stack of a panic is
+	// logged once per task type within the window.
+	//
+	// If unset or zero, the window is set to one minute.
+	PanicQuarantineWindow time.Duration
+
+	// PanicQuarantineDuration specifies how long to pause processing of
+	// a task type once it's quarantined.
+	//
+	// If unset or zero, the quarantine lasts ten minutes.
+	PanicQuarantineDuration time.Duration
+
+	// DeadTaskHandler optionally handles every task moved to the dead queue by
+	// the background: tasks which exhausted their retry count or MaxAttempts,
+	// failed validation, had no handler with UnhandledTaskKill, were not
+	// started by their StartBy time, or kept an unsupported message version.
+	// Tasks killed with the Inspector are not handled.
+	//
+	// It can be used to forward dead tasks to an external system.
+	// It is called on worker goroutines, so it may be called concurrently and
+	// takes up a worker while it runs.
+	// Info about the task includes the execution history of the task
+	// if TaskHistorySize is set.
+	//
+	// Example:
+	// func forwardDeadTask(task *asynq.Task, info *asynq.DeadTaskInfo) {
+	//     ticketingService.Create(task.Type, info.ID, info.ErrorMsg)
+	// }
+	//
+	// DeadTaskHandler: asynq.DeadTaskHandlerFunc(forwardDeadTask)
+	DeadTaskHandler DeadTaskHandler
+
+	// DeadTaskAgeAlarm, DeadTasksPerHourAlarm and RetryTaskAgeAlarm optionally
+	// specify the thresholds of alarms raised by calling OnFailureAlarm, so that
+	// tasks failing silently get noticed: the age of the oldest dead task, the
+	// number of tasks moved to the dead queue within the last hour, and the
+	// time since the oldest retry task failed for the first time.
+	//
+	// If set to zero or a negative value, the alarm is disabled.
+	DeadTaskAgeAlarm      time.Duration
+	DeadTasksPerHourAlarm int
+	RetryTaskAgeAlarm     time.Duration
+
+	// OnFailureAlarm optionally specifies a function to call when one of the
+	// alarm thresholds above is exceeded, with the statistics of the failed
+	// tasks. The function is called once each time the threshold is crossed,
+	// not on every check while the threshold stays exceeded.
+	//
+	// Thresholds are checked every FailureCheckInterval by only one of the
+	// background processes with OnFailureAlarm set, so the function is called
+	// by that process only. If the process stops, another one takes over and
+	// may call the function again for an alarm already raised.
+	// No check is made if OnFailureAlarm is nil.
+	OnFailureAlarm func(alarm FailureAlarm, stats *FailureStats)
+
+	// FailureCheckInterval specifies how often the alarm thresholds are checked.
+	// Each check reads up to 1000 retry tasks as Inspector.FailureStats does.
+	//
+	// If unset or zero, the interval is set to one minute.
+	FailureCheckInterval time.Duration
+
+	// UnhandledTaskPolicy specifies what to do with a task for which the handler
+	// returned an error made by NotFound, e.g. a task of a misspelled or
+	// deprecated type which matches no pattern registered to ServeMux.
+	//
+	// By default, the task is retried like any other failed task.
+	UnhandledTaskPolicy UnhandledTaskPolicy
+
+	// UnhandledTaskQueue specifies the queue to move unhandled tasks to
+	// with UnhandledTaskRequeue policy.
+	//
+	// Use a queue processed by other processes which handle the tasks,
+	// e.g. processes running a newer version of the application,
+	// or a queue which is not processed at all to keep the tasks for inspection.
+	// The queue must not be processed by this background, otherwise the tasks
+	// would be moved to the queue over and over.
+	//
+	// It must be set with UnhandledTaskRequeue policy; Run returns an error otherwise.
+	UnhandledTaskQueue string
+
+	// IdempotencyWindow optionally enables deduplication of tasks enqueued
+	// with asynq.IdempotencyKey option.
+	//
+	// If set to a positive value, the completion of a task with an idempotency key
+	// is remembered for IdempotencyWindow, and tasks with the same key are
+	// removed from the queue without being processed in the meantime.
+	//
+	// Note that deduplication is best-effort: a task may still be processed
+	// twice if the process crashes before the completion is recorded.
+	//
+	// If set to zero or a negative value, tasks are not deduplicated.
+	IdempotencyWindow time.Duration
+
+	// ExplicitAck, if true, requires handlers to acknowledge each task with
+	// the Acker returned by AckerFromContext for the task to be marked as done.
+	//
+	// The task is removed from the in-progress queue when Ack is called,
+	// e.g. after the handler commits a database transaction, rather than
+	// after the handler returns, so that the task is not marked as done
+	// while the side effects of the handler are rolled back.
+	// A task is retried if the handler negatively acknowledges it with Nack,
+	// or returns without acknowledging it, even if it returns nil.
+	ExplicitAck bool
+
+	// HeartbeatInterval specifies how often the background writes the state of
+	// the process and its workers to redis, which is shown by tools such as asynqmon.
+	// The state expires if it's not written for twice the interval, e.g. when
+	// the process is killed. It is deleted right away when the background stops.
+	//
+	// The interval is rounded up to whole seconds.
+	// If unset or zero, the interval is set to 5 seconds.
+	HeartbeatInterval time.Duration
+
+	// HideWorkerPayload, if true, omits the payload of the tasks being processed
+	// from the worker info written to redis, so that sensitive task data is not
+	// exposed to tools such as asynqmon.
+	HideWorkerPayload bool
+
+	// LogFormat specifies the format of the log messages written by the background.
+	//
+	// With LogFormatJSON, each message is written as a JSON object with
+	// "level", "ts", "msg" and "pid" fields. Messages about a task also have
+	// "task_id", "task_type" and "queue" fields, and "error" or "duration"
+	// fields where applicable.
+	//
+	// If unset, messages are written in plain text.
+	LogFormat LogFormat
+
+	// LogLevels optionally specifies the minimum level of log messages
+	// to write for each category of messages.
+	// Categories which are not in the map write messages of all levels.
+	//
+	// Example:
+	//
+	//     LogLevels: map[asynq.LogCategory]asynq.LogLevel{
+	//         asynq.LogCategoryTask: asynq.LogLevelError,
+	//     }
+	//
+	// With the above config, warnings about tasks (e.g. slow tasks or
+	// tasks moved to the dead queue) are not logged, while errors and
+	// messages of other categories still are.
+	LogLevels map[LogCategory]LogLevel
+
+	// ErrorLogInterval specifies the minimum interval between logs of
+	// recurring errors, such as failures to dequeue tasks when redis is down.
+	//
+	// Errors which occur within the interval are not logged, but their number
+	// is reported with the next error logged, or once the interval passed
+	// without another error, so that failure storms don't drown other log
+	// messages yet aren't dropped without notice.
+	//
+	// If unset or zero, the interval is set to 3 seconds.
+	// If negative, all errors are logged.
+	ErrorLogInterval time.Duration
+
+	// SyncInterval specifies the interval between retries of the requests to
+	// redis which failed, such as moving a processed task out of the
+	// in-progress queue.
+	//
+	// If unset or zero, the interval is set to 5 seconds.
+	SyncInterval time.Duration
+
+	// MaxSyncRequests specifies the maximum number of failed requests to redis
+	// buffered for retry. Once the limit is reached, the oldest request is
+	// dropped and logged.
+	//
+	// If unset or zero, the number of requests is not limited.
+	MaxSyncRequests int
+
+	// SyncJournalPath optionally specifies the path of a local file in which
+	// the failed requests to mark tasks as done, retried or dead are persisted.
+	//
+	// When the background starts, the requests in the file are retried before
+	// processing starts, so that the results of processed tasks aren't lost
+	// if the process dies while redis is unreachable.
+	// Each background process needs its own file.
+	//
+	// If unset, failed requests are kept in memory only.
+	SyncJournalPath string
+
+	// StartupTimeout specifies how long Run waits for redis to become
+	// reachable before it starts processing. While waiting, Run retries
+	// connecting with exponential backoff, logging each failed attempt.
+	//
+	// If redis is still unreachable after the timeout, Run returns an error.
+	// If unset or zero, Run waits until redis is reachable.
+	StartupTimeout time.Duration
+
+	// FailFast, if true, makes Run return an error right away if redis is
+	// unreachable at startup, instead of waiting for it, e.g. in CI environments.
+	FailFast bool
+
+	// Replica optionally specifies the connection to a secondary redis,
+	// e.g. in a standby region, into which the tasks enqueued to redis are
+	// copied asynchronously, so that the standby region can take over
+	// processing by calling Inspector.PromoteReplica if redis is lost.
+	//
+	// Tasks are copied while any background process with Replica is running
+	// and the replica is reachable, and are deleted from the replica once
+	// processed successfully, moved to the dead queue or deleted.
+	// Tasks which are retried are kept in the replica as they were enqueued,
+	// so they are processed again once the replica is promoted.
+	// Use Inspector.ReplicationStatus to monitor the replication lag.
+	//
+	// If unset, tasks are not replicated.
+	Replica RedisConnOpt
 }
 
+// UnhandledTaskPolicy specifies what to do with a task for which no handler is found.
+type UnhandledTaskPolicy int
+
+const (
+	// UnhandledTaskRetry retries the task like any other failed task,
+	// until it exhausts its retry count.
+	UnhandledTaskRetry UnhandledTaskPolicy = iota
+
+	// UnhandledTaskKill moves the task to the dead queue right away.
+	UnhandledTaskKill
+
+	// UnhandledTaskRequeue moves the task to Config.UnhandledTaskQueue,
+	// without counting it as retried.
+	UnhandledTaskRequeue
+)
+
+// LogLevel specifies the severity of log messages.
+type LogLevel int
+
+const (
+	// LogLevelInfo writes messages of all levels.
+	LogLevelInfo LogLevel = iota
+
+	// LogLevelWarn writes warnings and errors.
+	LogLevelWarn
+
+	// LogLevelError writes errors only.
+	LogLevelError
+)
+
+// LogCategory specifies a category of log messages.
+type LogCategory int
+
+const (
+	// LogCategoryLifecycle is the category of messages about starting and
+	// stopping the background and its components, and all other messages
+	// which don't belong to any other category.
+	LogCategoryLifecycle LogCategory = iota
+
+	// LogCategoryDequeue is the category of messages about dequeuing tasks.
+	LogCategoryDequeue
+
+	// LogCategoryTask is the category of messages about processing a task,
+	// including task failures.
+	LogCategoryTask
+)
+
+// LogFormat specifies the format of log messages.
+type LogFormat int
+
+const (
+	// LogFormatText writes log messages in plain text.
+	LogFormatText LogFormat = iota
+
+	// LogFormatJSON writes each log message as a JSON object on a single line.
+	LogFormatJSON
+)
+
 // An ErrorHandler handles errors returned by the task handler.
 type ErrorHandler interface {
 	HandleError(task *Task, err error, retried, maxRetry int)
@@ -123,6 +641,139 @@ func (fn ErrorHandlerFunc) HandleError(task *Task, err error, retried, maxRetry
 	fn(task, err, retried, maxRetry)
 }
 
+// A DeadTaskHandler handles tasks moved to the dead queue.
+type DeadTaskHandler interface {
+	HandleDeadTask(task *Task, info *DeadTaskInfo)
+}
+
+// The DeadTaskHandlerFunc type is an adapter to allow the use of ordinary functions as a DeadTaskHandler.
+// If f is a function with the appropriate signature, DeadTaskHandlerFunc(f) is a DeadTaskHandler that calls f.
+type DeadTaskHandlerFunc func(task *Task, info *DeadTaskInfo)
+
+// HandleDeadTask calls fn(task, info)
+func (fn DeadTaskHandlerFunc) HandleDeadTask(task *Task, info *DeadTaskInfo) {
+	fn(task, info)
+}
+
+// DeadTaskInfo holds information about a task moved to the dead queue.
+type DeadTaskInfo struct {
+	// ID and Queue identify the task.
+	ID    string
+	Queue string
+
+	// Retried is the number of times the task was retried,
+	// and MaxRetry is the max number of retries allowed for the task.
+	Retried  int
+	MaxRetry int
+
+	// ErrorMsg is the error which caused the task to be moved to the dead queue.
+	ErrorMsg string
+
+	// History holds the recorded attempts to process the task, in the order
+	// they happened. Empty unless Config.TaskHistorySize is set.
+	History []*TaskAttempt
+}
+
+// ActiveHours specifies a time window of a day in local time.
+//
+// Start and End are durations since midnight, and must be in the range of
+// [0, 24h). If End is before Start, the window spans midnight
+// (e.g. Start: 22h, End: 2h is from 22:00 to 02:00 the next day).
+// If Start equals End, the window covers the whole day.
+type ActiveHours struct {
+	Start time.Duration
+	End   time.Duration
+}
+
+func (h ActiveHours) valid() bool {
+	const day = 24 * time.Hour
+	return 0 <= h.Start && h.Start < day && 0 <= h.End && h.End < day
+}
+
+// contains reports whether the wall clock time of t is within the window.
+func (h ActiveHours) contains(t time.Time) bool {
+	hour, min, sec := t.Clock()
+	d := time.Duration(hour)*time.Hour + time.Duration(min)*time.Minute + time.Duration(sec)*time.Second
+	switch {
+	case h.Start == h.End:
+		return true
+	case h.Start < h.End:
+		return h.Start <= d && d < h.End
+	default:
+		// window spans midnight.
+		return h.Start <= d || d < h.End
+	}
+}
+
+// validateQueueNames returns an error for the first invalid queue name
+// or prefix in the config, in sorted order.
+func validateQueueNames(cfg *Config) error {
+	var qnames []string
+	for qname := range cfg.Queues {
+		qnames = append(qnames, qname)
+	}
+	for prefix := range cfg.QueuePrefixes {
+		qnames = append(qnames, prefix)
+	}
+	sort.Strings(qnames)
+	for _, qname := range qnames {
+		if err := base.ValidateQueueName(qname); err != nil {
+			return fmt.Errorf("asynq: %v", err)
+		}
+	}
+	return nil
+}
+
+// validateUnhandledTaskQueue returns an error if the queue to move unhandled
+// tasks to is not valid given the queues and queue prefixes processed by the background.
+func validateUnhandledTaskQueue(cfg *Config, queues map[string]int, prefixes []string) error {
+	if cfg.UnhandledTaskPolicy != UnhandledTaskRequeue {
+		return nil
+	}
+	qname := cfg.UnhandledTaskQueue
+	if qname == "" {
+		return fmt.Errorf("asynq: UnhandledTaskQueue must be set with UnhandledTaskRequeue policy")
+	}
+	if err := base.ValidateQueueName(qname); err != nil {
+		return fmt.Errorf("asynq: invalid UnhandledTaskQueue: %v", err)
+	}
+	processed := false
+	for q := range queues {
+		processed = processed || strings.EqualFold(q, qname)
+	}
+	for _, prefix := range prefixes {
+		processed = processed || strings.HasPrefix(strings.ToLower(qname), strings.ToLower(prefix))
+	}
+	if processed {
+		return fmt.Errorf("asynq: UnhandledTaskQueue %q must not be processed by the background", qname)
+	}
+	return nil
+}
+
+// errLogInterval returns the minimum interval between error logs given the configured value.
+func errLogInterval(d time.Duration) time.Duration {
+	switch {
+	case d == 0:
+		return 3 * time.Second
+	case d < 0:
+		return 0
+	default:
+		return d
+	}
+}
+
+// heartbeatInterval returns the interval between heartbeats given the configured value.
+// The interval is in whole seconds since the heartbeat data expires in seconds.
+func heartbeatInterval(d time.Duration) time.Duration {
+	if d <= 0 {
+		return 5 * time.Second
+	}
+	if r := d % time.Second; r != 0 {
+		d += time.Second - r
+	}
+	return d
+}
+
 // Formula taken from https://github.com/mperham/sidekiq.
 func defaultDelayFunc(n int, e error, t *Task) time.Duration {
 	r := rand.New(rand.NewSource(time.Now().UnixNano()))
@@ -130,6 +781,10 @@ func defaultDelayFunc(n int, e error, t *Task) time.Duration {
 	return time.Duration(s) * time.Second
 }
 
+// defaultRetryJitter is the fraction by which retry delays are randomized
+// if Config.RetryJitter is unset.
+const defaultRetryJitter = 0.1
+
 var defaultQueueConfig = map[string]int{
 	base.DefaultQueueName: 1,
 }
@@ -145,15 +800,91 @@ func NewBackground(r RedisConnOpt, cfg *Config) *Background {
 	if delayFunc == nil {
 		delayFunc = defaultDelayFunc
 	}
+	switch jitter := cfg.RetryJitter; {
+	case jitter == 0:
+		delayFunc = WithJitter(delayFunc, defaultRetryJitter)
+	case jitter > 0:
+		delayFunc = WithJitter(delayFunc, jitter)
+	}
+	baseCtxFn := cfg.BaseContext
+	if baseCtxFn == nil {
+		baseCtxFn = context.Background
+	}
+	cfgErr := validateQueueNames(cfg)
 	queues := make(map[string]int)
 	for qname, p := range cfg.Queues {
-		if p > 0 {
+		if p > 0 && base.ValidateQueueName(qname) == nil {
 			queues[qname] = p
 		}
 	}
 	if len(queues) == 0 {
 		queues = defaultQueueConfig
 	}
+	static := queues
+	var prefixes []string
+	if len(cfg.QueuePrefixes) > 0 {
+		queues = make(map[string]int)
+		for qname, p := range static {
+			queues[qname] = p
+		}
+		for prefix, p := range cfg.QueuePrefixes {
+			if _, ok := static[prefix]; !ok && base.ValidateQueueName(prefix) == nil && p > 0 {
+				queues[prefix] = p
+				prefixes = append(prefixes, prefix)
+			}
+		}
+	}
+	if cfgErr == nil {
+		cfgErr = validateUnhandledTaskQueue(cfg, queues, prefixes)
+	}
+	shards := make(map[string]int)
+	for qname, n := range cfg.QueueShards {
+		if _, ok := static[qname]; ok && n > 1 {
+			shards[qname] = n
+		}
+	}
+	var groups *queueGroups
+	if len(prefixes) > 0 || len(shards) > 0 {
+		groups = newQueueGroups(prefixes, shards, static)
+	}
+	reservations := make(map[string]int)
+	reserved := 0
+	for qname, r := range cfg.QueueReservations {
+		if _, ok := queues[qname]; ok && r > 0 {
+			reservations[qname] = r
+			reserved += r
+		}
+	}
+	if reserved > n {
+		if cfgErr == nil {
+			cfgErr = fmt.Errorf("asynq: QueueReservations reserve %d workers, more than Concurrency %d", reserved, n)
+		}
+		reservations = make(map[string]int)
+	}
+	activeHours := make(map[string]ActiveHours)
+	for qname, h := range cfg.QueueActiveHours {
+		if _, ok := queues[qname]; ok && h.valid() {
+			activeHours[qname] = h
+		}
+	}
+
+	var breaker *circuitBreaker
+	if cfg.CircuitBreakerThreshold > 0 {
+		cooldown := cfg.CircuitBreakerCooldown
+		if cooldown <= 0 {
+			cooldown = time.Minute
+		}
+		breaker = newCircuitBreaker(cfg.CircuitBreakerThreshold, cooldown)
+	}
+	quarantineWindow := cfg.PanicQuarantineWindow
+	if quarantineWindow <= 0 {
+		quarantineWindow = time.Minute
+	}
+	quarantineDuration := cfg.PanicQuarantineDuration
+	if quarantineDuration <= 0 {
+		quarantineDuration = 10 * time.Minute
+	}
+	panics := newPanicMonitor(cfg.PanicQuarantineThreshold, quarantineWindow, quarantineDuration)
 
 	host, err := os.Hostname()
 	if err != nil {
@@ -162,15 +893,94 @@ func NewBackground(r RedisConnOpt, cfg *Config) *Background {
 	pid := os.Getpid()
 
 	logger := log.NewLogger(os.Stderr)
+	if cfg.LogFormat == LogFormatJSON {
+		logger = log.NewJSONLogger(os.Stderr).With("pid", pid)
+	}
+	dequeueLog := logger.WithLevel(log.Level(cfg.LogLevels[LogCategoryDequeue]))
+	taskLog := logger.WithLevel(log.Level(cfg.LogLevels[LogCategoryTask]))
+	logger = logger.WithLevel(log.Level(cfg.LogLevels[LogCategoryLifecycle]))
+	var replica *rdb.RDB
+	if cfg.Replica != nil {
+		replica = rdb.NewRDB(createRedisClient(cfg.Replica))
+	}
 	rdb := rdb.NewRDB(createRedisClient(r))
 	ps := base.NewProcessState(host, pid, n, queues, cfg.StrictPriority)
 	syncCh := make(chan *syncRequest)
 	cancels := base.NewCancelations()
-	syncer := newSyncer(logger, syncCh, 5*time.Second)
-	heartbeater := newHeartbeater(logger, rdb, ps, 5*time.Second)
-	scheduler := newScheduler(logger, rdb, 5*time.Second, queues)
-	processor := newProcessor(logger, rdb, ps, delayFunc, syncCh, cancels, cfg.ErrorHandler)
+	syncInterval := cfg.SyncInterval
+	if syncInterval <= 0 {
+		syncInterval = 5 * time.Second
+	}
+	syncer := newSyncer(logger, syncCh, syncInterval)
+	if cfg.MaxSyncRequests > 0 {
+		syncer.maxRequests = cfg.MaxSyncRequests
+	}
+	if cfg.SyncJournalPath != "" {
+		syncer.journal = newSyncJournal(cfg.SyncJournalPath, rdb)
+	}
+	ps.HideWorkerPayload(cfg.HideWorkerPayload)
+	heartbeater := newHeartbeater(logger, rdb, ps, heartbeatInterval(cfg.HeartbeatInterval))
+	scheduler := newScheduler(logger, rdb, 5*time.Second, cfg.RetryDrainLimit)
+	processor := newProcessor(processorParams{
+		logger:         logger,
+		rdb:            rdb,
+		ps:             ps,
+		retryDelayFunc: delayFunc,
+		baseCtxFn:      baseCtxFn,
+		priorityAging:  cfg.PriorityAging,
+		strictQueues:   cfg.StrictQueues,
+		reservations:   reservations,
+		activeHours:    activeHours,
+		syncCh:         syncCh,
+		pendingSyncs:   syncer.pendingTasks,
+		cancelations:   cancels,
+		errHandler:     cfg.ErrorHandler,
+		slowThreshold:  cfg.SlowTaskThreshold,
+		onSlowTask:     cfg.OnSlowTask,
+		historySize:    cfg.TaskHistorySize,
+		breaker:        breaker,
+		panics:         panics,
+		deadHandler:    cfg.DeadTaskHandler,
+		groups:         groups,
+		unhandled:      cfg.UnhandledTaskPolicy,
+		unhandledQueue: cfg.UnhandledTaskQueue,
+
+		idempotencyWindow: cfg.IdempotencyWindow,
+		explicitAck:       cfg.ExplicitAck,
+		dequeueLog:        dequeueLog,
+		taskLog:           taskLog,
+		errLogInterval:    errLogInterval(cfg.ErrorLogInterval),
+	})
 	subscriber := newSubscriber(logger, rdb, cancels)
+	controller := newController(logger, rdb, ps)
+	var autoscaler *autoscaler
+	if min := cfg.MinConcurrency; min > 0 && min < n {
+		if min < reserved && cfgErr == nil {
+			cfgErr = fmt.Errorf("asynq: MinConcurrency %d is less than the %d workers reserved by QueueReservations", min, reserved)
+		}
+		autoscaler = newAutoscaler(logger, rdb, processor, queues, min, 5*time.Second)
+	}
+	var discoverer *discoverer
+	if len(prefixes) > 0 {
+		discoverer = newDiscoverer(logger, rdb, groups, 5*time.Second)
+	}
+	var failures *failureMonitor
+	if cfg.OnFailureAlarm != nil {
+		thresholds := failureThresholds{
+			deadAge:     cfg.DeadTaskAgeAlarm,
+			deadPerHour: cfg.DeadTasksPerHourAlarm,
+			retryAge:    cfg.RetryTaskAgeAlarm,
+		}
+		interval := cfg.FailureCheckInterval
+		if interval <= 0 {
+			interval = time.Minute
+		}
+		failures = newFailureMonitor(logger, rdb, fmt.Sprintf("%s:%d", host, pid), thresholds, cfg.OnFailureAlarm, interval)
+	}
+	var replicator *replicator
+	if replica != nil {
+		replicator = newReplicator(logger, rdb, replica, fmt.Sprintf("%s:%d", host, pid), time.Second)
+	}
 	return &Background{
 		logger:      logger,
 		rdb:         rdb,
@@ -180,6 +990,16 @@ func NewBackground(r RedisConnOpt, cfg *Config) *Background {
 		syncer:      syncer,
 		heartbeater: heartbeater,
 		subscriber:  subscriber,
+		controller:  controller,
+		autoscaler:  autoscaler,
+		discoverer:  discoverer,
+		replicator:  replicator,
+		failures:    failures,
+
+		startupTimeout: cfg.StartupTimeout,
+		failFast:       cfg.FailFast,
+		cfgErr:         cfgErr,
+		shutdownCh:     make(chan struct{}),
 	}
 }
 
@@ -206,33 +1026,99 @@ func (fn HandlerFunc) ProcessTask(ctx context.Context, task *Task) error {
 }
 
 // Run starts the background-task processing and blocks until
-// an os signal to exit the program is received. Once it receives
-// a signal, it gracefully shuts down all pending workers and other
-// goroutines to process the tasks.
-func (bg *Background) Run(handler Handler) {
+// an os signal to exit the program is received, or Shutdown is called.
+// Once it receives a signal, it gracefully shuts down all pending workers
+// and other goroutines to process the tasks.
+//
+// On unix systems, Run also handles SIGTSTP by stopping the processing
+// of new tasks while letting in-progress tasks finish.
+//
+// Run waits for redis to become reachable before it starts processing,
+// and returns an error without processing any task if redis is
+// unreachable after Config.StartupTimeout, or right away with Config.FailFast.
+// It also returns an error if the config has an invalid queue name,
+// or an invalid UnhandledTaskQueue.
+func (bg *Background) Run(handler Handler) error {
 	bg.logger.SetPrefix(fmt.Sprintf("asynq: pid=%d ", os.Getpid()))
+	if bg.cfgErr != nil {
+		bg.logger.Error("Invalid config: %v", bg.cfgErr)
+		return bg.cfgErr
+	}
+	if err := bg.waitForRedis(); err != nil {
+		bg.logger.Error("Could not start processing: %v", err)
+		return err
+	}
+	select {
+	case <-bg.shutdownCh:
+		bg.logger.Info("Shutdown requested before processing started")
+		return nil
+	default:
+	}
 	bg.logger.Info("Starting processing")
 
 	bg.start(handler)
 	defer bg.stop()
 
-	bg.logger.Info("Send signal TSTP to stop processing new tasks")
-	bg.logger.Info("Send signal TERM or INT to terminate the process")
+	bg.waitForSignals()
+	fmt.Println()
+	bg.logger.Info("Starting graceful shutdown")
+	return nil
+}
+
+// Shutdown makes Run gracefully shut down the background-task processing
+// as if it received a signal to exit the program, e.g. to stop the
+// background from the program itself. It returns without waiting for the
+// shutdown, which is complete once Run returns.
+//
+// If Shutdown is called before the processing started, e.g. while Run
+// waits for redis to become reachable, Run returns nil without processing
+// any task.
+func (bg *Background) Shutdown() {
+	bg.shutdownOnce.Do(func() { close(bg.shutdownCh) })
+}
+
+// Backoff between attempts to connect to redis at startup.
+const (
+	minStartupBackoff = 500 * time.Millisecond
+	maxStartupBackoff = 10 * time.Second
+)
 
-	// Wait for a signal to terminate.
-	sigs := make(chan os.Signal, 1)
-	signal.Notify(sigs, syscall.SIGTERM, syscall.SIGINT, syscall.SIGTSTP)
+// waitForRedis blocks until redis is reachable, retrying with exponential
+// backoff until the startup timeout passes or the shutdown is requested.
+func (bg *Background) waitForRedis() error {
+	var deadline time.Time
+	if bg.startupTimeout > 0 {
+		deadline = time.Now().Add(bg.startupTimeout)
+	}
+	backoff := minStartupBackoff
 	for {
-		sig := <-sigs
-		if sig == syscall.SIGTSTP {
-			bg.processor.stop()
-			bg.ps.SetStatus(base.StatusStopped)
-			continue
+		err := bg.rdb.Ping()
+		if err == nil {
+			return nil
+		}
+		if bg.failFast {
+			return fmt.Errorf("redis is unreachable: %v", err)
+		}
+		wait := backoff
+		if !deadline.IsZero() {
+			left := time.Until(deadline)
+			if left <= 0 {
+				return fmt.Errorf("redis is unreachable after %v: %v", bg.startupTimeout, err)
+			}
+			if left < wait {
+				wait = left
+			}
+		}
+		bg.logger.Warn("Could not connect to redis: %v; Retrying in %v", err, wait)
+		select {
+		case <-bg.shutdownCh:
+			return nil
+		case <-time.After(wait):
+		}
+		if backoff *= 2; backoff > maxStartupBackoff {
+			backoff = maxStartupBackoff
 		}
-		break
 	}
-	fmt.Println()
-	bg.logger.Info("Starting graceful shutdown")
 }
 
 // starts the background-task processing.
@@ -248,9 +1134,22 @@ func (bg *Background) start(handler Handler) {
 
 	bg.heartbeater.start(&bg.wg)
 	bg.subscriber.start(&bg.wg)
+	bg.controller.start(&bg.wg)
 	bg.syncer.start(&bg.wg)
 	bg.scheduler.start(&bg.wg)
+	if bg.discoverer != nil {
+		bg.discoverer.start(&bg.wg)
+	}
 	bg.processor.start(&bg.wg)
+	if bg.autoscaler != nil {
+		bg.autoscaler.start(&bg.wg)
+	}
+	if bg.replicator != nil {
+		bg.replicator.start(&bg.wg)
+	}
+	if bg.failures != nil {
+		bg.failures.start(&bg.wg)
+	}
 }
 
 // stops the background-task processing.
@@ -265,9 +1164,22 @@ func (bg *Background) stop() {
 	// Sender goroutines should be terminated before the receiver goroutines.
 	//
 	// processor -> syncer (via syncCh)
+	if bg.autoscaler != nil {
+		bg.autoscaler.terminate()
+	}
 	bg.scheduler.terminate()
 	bg.processor.terminate()
+	if bg.discoverer != nil {
+		bg.discoverer.terminate()
+	}
 	bg.syncer.terminate()
+	if bg.replicator != nil {
+		bg.replicator.terminate()
+	}
+	if bg.failures != nil {
+		bg.failures.terminate()
+	}
+	bg.controller.terminate()
 	bg.subscriber.terminate()
 	bg.heartbeater.terminate()
 
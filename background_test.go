@@ -47,6 +47,72 @@ func TestBackground(t *testing.T) {
 	bg.stop()
 }
 
+func TestNewBackgroundWithQueueReservations(t *testing.T) {
+	tests := []struct {
+		cfg             *Config
+		wantConcurrency int
+		wantSlots       bool // whether the processor tracks reserved slots
+		wantErr         bool
+	}{
+		{
+			cfg: &Config{
+				Concurrency:       10,
+				Queues:            map[string]int{"critical": 6, "default": 3, "low": 1},
+				QueueReservations: map[string]int{"critical": 3, "default": 1},
+			},
+			wantConcurrency: 10,
+			wantSlots:       true,
+		},
+		{
+			cfg: &Config{
+				Concurrency:       2,
+				Queues:            map[string]int{"critical": 6, "default": 3, "low": 1},
+				QueueReservations: map[string]int{"critical": 3, "default": 1},
+			},
+			wantConcurrency: 2,
+			wantSlots:       false,
+			wantErr:         true,
+		},
+		{
+			cfg: &Config{
+				Concurrency:       2,
+				Queues:            map[string]int{"critical": 6, "default": 3},
+				QueueReservations: map[string]int{"unknown": 5, "default": 0},
+			},
+			wantConcurrency: 2,
+			wantSlots:       false,
+		},
+		{
+			cfg: &Config{
+				Concurrency:       10,
+				MinConcurrency:    2,
+				Queues:            map[string]int{"critical": 6, "default": 3, "low": 1},
+				QueueReservations: map[string]int{"critical": 3, "default": 1},
+			},
+			wantConcurrency: 10,
+			wantSlots:       true,
+			wantErr:         true, // autoscaling would park reserved workers.
+		},
+	}
+
+	for _, tc := range tests {
+		bg := NewBackground(RedisClientOpt{Addr: redisAddr, DB: redisDB}, tc.cfg)
+		if got := bg.ps.Get().Concurrency; got != tc.wantConcurrency {
+			t.Errorf("NewBackground with QueueReservations %v: concurrency = %d, want %d",
+				tc.cfg.QueueReservations, got, tc.wantConcurrency)
+		}
+		if got := bg.processor.slots != nil; got != tc.wantSlots {
+			t.Errorf("NewBackground with QueueReservations %v: processor reserves slots = %t, want %t",
+				tc.cfg.QueueReservations, got, tc.wantSlots)
+		}
+		if gotErr := bg.cfgErr != nil; gotErr != tc.wantErr {
+			t.Errorf("NewBackground with QueueReservations %v: config error = %v, want error %t",
+				tc.cfg.QueueReservations, bg.cfgErr, tc.wantErr)
+		}
+		bg.rdb.Close()
+	}
+}
+
 func TestGCD(t *testing.T) {
 	tests := []struct {
 		input []int
@@ -126,3 +192,323 @@ func TestNormalizeQueueCfg(t *testing.T) {
 		}
 	}
 }
+
+func TestNewBackgroundWithQueueActiveHours(t *testing.T) {
+	cfg := &Config{
+		Queues: map[string]int{"default": 1, "reports": 1},
+		QueueActiveHours: map[string]ActiveHours{
+			"reports": {Start: time.Hour, End: 6 * time.Hour},
+			"unknown": {Start: time.Hour, End: 6 * time.Hour},
+			"default": {Start: -time.Hour, End: 25 * time.Hour}, // invalid
+		},
+	}
+	bg := NewBackground(RedisClientOpt{Addr: redisAddr, DB: redisDB}, cfg)
+	want := map[string]ActiveHours{
+		"reports": {Start: time.Hour, End: 6 * time.Hour},
+	}
+	if diff := cmp.Diff(want, bg.processor.activeHours); diff != "" {
+		t.Errorf("NewBackground with QueueActiveHours %v: active hours = %v, want %v; (-want,+got)\n%s",
+			cfg.QueueActiveHours, bg.processor.activeHours, want, diff)
+	}
+	bg.rdb.Close()
+}
+
+func TestNewBackgroundWithRetryJitter(t *testing.T) {
+	const delay = 10 * time.Second
+	tests := []struct {
+		jitter   float64
+		min, max time.Duration
+	}{
+		{0, 9 * time.Second, 11 * time.Second}, // 10% by default
+		{0.5, 5 * time.Second, 15 * time.Second},
+		{-1, delay, delay},
+	}
+
+	for _, tc := range tests {
+		bg := NewBackground(RedisClientOpt{Addr: redisAddr, DB: redisDB}, &Config{
+			RetryDelayFunc:  FixedDelay(delay),
+			RetryJitter:     tc.jitter,
+			RetryDrainLimit: 100,
+		})
+		seen := make(map[time.Duration]bool)
+		for i := 0; i < 100; i++ {
+			d := bg.processor.retryDelayFunc(0, nil, NewTask("send_email", nil))
+			if d < tc.min || d > tc.max {
+				t.Errorf("with RetryJitter %v, retry delay = %v, want between %v and %v", tc.jitter, d, tc.min, tc.max)
+			}
+			seen[d] = true
+		}
+		if tc.min != tc.max && len(seen) < 2 {
+			t.Errorf("with RetryJitter %v, retry delays are not randomized", tc.jitter)
+		}
+		if bg.scheduler.retryLimit != 100 {
+			t.Errorf("with RetryDrainLimit 100, scheduler retry limit = %d, want 100", bg.scheduler.retryLimit)
+		}
+		bg.rdb.Close()
+	}
+}
+
+func TestNewBackgroundWithFailureAlarm(t *testing.T) {
+	onAlarm := func(alarm FailureAlarm, stats *FailureStats) {}
+	tests := []struct {
+		cfg          *Config
+		wantMonitor  bool
+		wantInterval time.Duration
+	}{
+		{&Config{DeadTasksPerHourAlarm: 10}, false, 0},
+		{&Config{DeadTasksPerHourAlarm: 10, OnFailureAlarm: onAlarm}, true, time.Minute},
+		{&Config{RetryTaskAgeAlarm: time.Hour, OnFailureAlarm: onAlarm, FailureCheckInterval: 5 * time.Minute}, true, 5 * time.Minute},
+	}
+
+	for _, tc := range tests {
+		bg := NewBackground(RedisClientOpt{Addr: redisAddr, DB: redisDB}, tc.cfg)
+		if got := bg.failures != nil; got != tc.wantMonitor {
+			t.Errorf("NewBackground(%+v) has failure monitor = %t, want %t", tc.cfg, got, tc.wantMonitor)
+		} else if got && bg.failures.interval != tc.wantInterval {
+			t.Errorf("NewBackground(%+v) checks failures every %v, want %v", tc.cfg, bg.failures.interval, tc.wantInterval)
+		}
+		bg.rdb.Close()
+	}
+}
+
+func TestNewBackgroundWithQueuePrefixes(t *testing.T) {
+	cfg := &Config{
+		Concurrency: 4,
+		Queues:      map[string]int{"default": 1, "tenant:": 1},
+		QueuePrefixes: map[string]int{
+			"tenant:": 3, // same as a queue in Queues
+			"org:":    2,
+			"user:":   0,
+		},
+		QueueReservations: map[string]int{"org:": 2},
+	}
+	bg := NewBackground(RedisClientOpt{Addr: redisAddr, DB: redisDB}, cfg)
+	defer bg.rdb.Close()
+
+	wantQueues := map[string]int{"default": 1, "tenant:": 1, "org:": 2}
+	if diff := cmp.Diff(wantQueues, bg.processor.queueConfig); diff != "" {
+		t.Errorf("NewBackground with QueuePrefixes %v: queues = %v, want %v; (-want,+got)\n%s",
+			cfg.QueuePrefixes, bg.processor.queueConfig, wantQueues, diff)
+	}
+	if bg.discoverer == nil {
+		t.Fatal("NewBackground with QueuePrefixes did not create a discoverer")
+	}
+	if !bg.processor.groups.isGroup("org:") || bg.processor.groups.isGroup("tenant:") {
+		t.Errorf("NewBackground with QueuePrefixes %v: prefixes = %v, want [org:]",
+			cfg.QueuePrefixes, bg.processor.groups.prefixes)
+	}
+	if bg.processor.slots == nil {
+		t.Error("NewBackground did not apply the reservation for the queue prefix")
+	}
+}
+
+func TestNewBackgroundWithQueueShards(t *testing.T) {
+	cfg := &Config{
+		Queues: map[string]int{"default": 1, "events": 3},
+		QueueShards: map[string]int{
+			"events":  4,
+			"default": 1, // not sharded
+			"unknown": 2,
+		},
+	}
+	bg := NewBackground(RedisClientOpt{Addr: redisAddr, DB: redisDB}, cfg)
+	defer bg.rdb.Close()
+
+	if bg.discoverer != nil {
+		t.Error("NewBackground without QueuePrefixes created a discoverer")
+	}
+	groups := bg.processor.groups
+	if groups == nil {
+		t.Fatal("NewBackground with QueueShards did not set queue groups")
+	}
+	want := map[string][]string{
+		"events": {"events", "events#0", "events#1", "events#2", "events#3"},
+	}
+	if diff := cmp.Diff(want, groups.shards); diff != "" {
+		t.Errorf("NewBackground with QueueShards %v: shards = %v, want %v; (-want,+got)\n%s",
+			cfg.QueueShards, groups.shards, want, diff)
+	}
+}
+
+func TestErrLogInterval(t *testing.T) {
+	tests := []struct {
+		d    time.Duration
+		want time.Duration
+	}{
+		{0, 3 * time.Second},
+		{-time.Second, 0},
+		{10 * time.Second, 10 * time.Second},
+	}
+
+	for _, tc := range tests {
+		if got := errLogInterval(tc.d); got != tc.want {
+			t.Errorf("errLogInterval(%v) = %v, want %v", tc.d, got, tc.want)
+		}
+	}
+}
+
+func TestHeartbeatInterval(t *testing.T) {
+	tests := []struct {
+		d    time.Duration
+		want time.Duration
+	}{
+		{0, 5 * time.Second},
+		{-time.Second, 5 * time.Second},
+		{500 * time.Millisecond, time.Second},
+		{time.Second, time.Second},
+		{1500 * time.Millisecond, 2 * time.Second},
+		{30 * time.Second, 30 * time.Second},
+	}
+
+	for _, tc := range tests {
+		if got := heartbeatInterval(tc.d); got != tc.want {
+			t.Errorf("heartbeatInterval(%v) = %v, want %v", tc.d, got, tc.want)
+		}
+	}
+}
+
+func TestActiveHoursContains(t *testing.T) {
+	at := func(hour, min int) time.Time {
+		return time.Date(2020, time.March, 1, hour, min, 0, 0, time.Local)
+	}
+	tests := []struct {
+		hours ActiveHours
+		t     time.Time
+		want  bool
+	}{
+		{ActiveHours{Start: time.Hour, End: 6 * time.Hour}, at(0, 59), false},
+		{ActiveHours{Start: time.Hour, End: 6 * time.Hour}, at(1, 0), true},
+		{ActiveHours{Start: time.Hour, End: 6 * time.Hour}, at(5, 59), true},
+		{ActiveHours{Start: time.Hour, End: 6 * time.Hour}, at(6, 0), false},
+		{ActiveHours{Start: 22 * time.Hour, End: 2 * time.Hour}, at(23, 0), true},
+		{ActiveHours{Start: 22 * time.Hour, End: 2 * time.Hour}, at(1, 30), true},
+		{ActiveHours{Start: 22 * time.Hour, End: 2 * time.Hour}, at(12, 0), false},
+		{ActiveHours{Start: 3 * time.Hour, End: 3 * time.Hour}, at(12, 0), true},
+	}
+
+	for _, tc := range tests {
+		if got := tc.hours.contains(tc.t); got != tc.want {
+			t.Errorf("%+v.contains(%v) = %t, want %t", tc.hours, tc.t.Format("15:04"), got, tc.want)
+		}
+	}
+}
+
+func TestBackgroundWaitForRedis(t *testing.T) {
+	up := NewBackground(RedisClientOpt{Addr: redisAddr, DB: redisDB}, &Config{FailFast: true})
+	if err := up.waitForRedis(); err != nil {
+		t.Errorf("waitForRedis() with reachable redis returned error: %v", err)
+	}
+
+	tests := []struct {
+		desc    string
+		cfg     *Config
+		maxWait time.Duration
+	}{
+		{"fail fast", &Config{FailFast: true}, 500 * time.Millisecond},
+		{"startup timeout", &Config{StartupTimeout: 2 * time.Second}, 3 * time.Second},
+	}
+
+	for _, tc := range tests {
+		bg := NewBackground(RedisClientOpt{Addr: "localhost:1"}, tc.cfg)
+		start := time.Now()
+		err := bg.waitForRedis()
+		elapsed := time.Since(start)
+		if err == nil {
+			t.Errorf("%s: waitForRedis() with unreachable redis returned nil error", tc.desc)
+		}
+		if elapsed > tc.maxWait {
+			t.Errorf("%s: waitForRedis() took %v; want at most %v", tc.desc, elapsed, tc.maxWait)
+		}
+		if tc.cfg.StartupTimeout > 0 && elapsed < tc.cfg.StartupTimeout {
+			t.Errorf("%s: waitForRedis() returned after %v; want at least %v", tc.desc, elapsed, tc.cfg.StartupTimeout)
+		}
+	}
+}
+
+func TestBackgroundRunWithInvalidQueueName(t *testing.T) {
+	bg := NewBackground(RedisClientOpt{Addr: redisAddr, DB: redisDB}, &Config{
+		Queues: map[string]int{
+			"default":       1,
+			"high priority": 2,
+		},
+	})
+
+	done := make(chan error, 1)
+	go func() {
+		done <- bg.Run(HandlerFunc(func(ctx context.Context, t *Task) error { return nil }))
+	}()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("Run with an invalid queue name returned nil error")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run with an invalid queue name did not return")
+	}
+}
+
+func TestBackgroundShutdown(t *testing.T) {
+	bg := NewBackground(RedisClientOpt{Addr: redisAddr, DB: redisDB}, &Config{Concurrency: 2})
+
+	done := make(chan error, 1)
+	go func() {
+		done <- bg.Run(HandlerFunc(func(ctx context.Context, t *Task) error { return nil }))
+	}()
+	time.Sleep(time.Second) // wait for the background to start.
+	bg.Shutdown()
+	bg.Shutdown() // calling Shutdown again is a no-op.
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Run returned error %v after Shutdown, want nil", err)
+		}
+	case <-time.After(15 * time.Second):
+		t.Fatal("Run did not return after Shutdown")
+	}
+}
+
+func TestBackgroundShutdownWhileWaitingForRedis(t *testing.T) {
+	bg := NewBackground(RedisClientOpt{Addr: "localhost:1"}, &Config{})
+
+	done := make(chan error, 1)
+	go func() {
+		done <- bg.Run(HandlerFunc(func(ctx context.Context, t *Task) error { return nil }))
+	}()
+	time.Sleep(time.Second) // wait for the background to retry connecting.
+	bg.Shutdown()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Run returned error %v after Shutdown, want nil", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return after Shutdown while waiting for redis")
+	}
+}
+
+func TestNewBackgroundWithUnhandledTaskQueue(t *testing.T) {
+	tests := []struct {
+		cfg     *Config
+		wantErr bool
+	}{
+		{&Config{UnhandledTaskPolicy: UnhandledTaskKill}, false},
+		{&Config{UnhandledTaskPolicy: UnhandledTaskRequeue, UnhandledTaskQueue: "unhandled"}, false},
+		{&Config{UnhandledTaskPolicy: UnhandledTaskRequeue}, true},
+		{&Config{UnhandledTaskPolicy: UnhandledTaskRequeue, UnhandledTaskQueue: "asynq:unhandled"}, true},
+		// the queue is processed by the background.
+		{&Config{UnhandledTaskPolicy: UnhandledTaskRequeue, UnhandledTaskQueue: "Default"}, true},
+		{&Config{
+			QueuePrefixes:       map[string]int{"tenant:": 1},
+			UnhandledTaskPolicy: UnhandledTaskRequeue,
+			UnhandledTaskQueue:  "tenant:unhandled",
+		}, true},
+	}
+
+	for _, tc := range tests {
+		bg := NewBackground(RedisClientOpt{Addr: redisAddr, DB: redisDB}, tc.cfg)
+		if gotErr := bg.cfgErr != nil; gotErr != tc.wantErr {
+			t.Errorf("NewBackground with policy %d and UnhandledTaskQueue %q: config error = %v, want error %t",
+				tc.cfg.UnhandledTaskPolicy, tc.cfg.UnhandledTaskQueue, bg.cfgErr, tc.wantErr)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+// Copyright 2020 Kentaro Hibino. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package asynq
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// ExponentialBackoff returns a retry delay function which returns base
+// for the first retry and doubles the delay on each subsequent retry,
+// up to max.
+func ExponentialBackoff(base, max time.Duration) func(n int, e error, t *Task) time.Duration {
+	return func(n int, e error, t *Task) time.Duration {
+		d := float64(base) * math.Pow(2, float64(n))
+		if d > float64(max) {
+			return max
+		}
+		return time.Duration(d)
+	}
+}
+
+// LinearBackoff returns a retry delay function which returns base
+// for the first retry and increases the delay by base on each subsequent
+// retry, up to max.
+func LinearBackoff(base, max time.Duration) func(n int, e error, t *Task) time.Duration {
+	return func(n int, e error, t *Task) time.Duration {
+		d := float64(base) * float64(n+1)
+		if d > float64(max) {
+			return max
+		}
+		return time.Duration(d)
+	}
+}
+
+// FixedDelay returns a retry delay function which always returns d.
+func FixedDelay(d time.Duration) func(n int, e error, t *Task) time.Duration {
+	return func(n int, e error, t *Task) time.Duration {
+		return d
+	}
+}
+
+// WithJitter returns a retry delay function which randomizes the delay
+// returned by fn by up to the given fraction of the delay in either direction.
+//
+// For example, a fraction of 0.1 randomizes a delay of 10s to between 9s and 11s.
+// Jitter spreads out retries of tasks which failed at the same time, so that
+// they don't all hit a recovering dependency at once.
+//
+// The fraction is clamped to the range of [0, 1].
+//
+// Example:
+//
+//	cfg := &asynq.Config{
+//	    RetryDelayFunc: asynq.WithJitter(asynq.ExponentialBackoff(time.Second, time.Hour), 0.2),
+//	}
+//
+// With the above config, a failed task is retried after about 1s, 2s, 4s, ...
+// up to an hour, each delay randomized by up to 20% in either direction.
+func WithJitter(fn func(n int, e error, t *Task) time.Duration, fraction float64) func(n int, e error, t *Task) time.Duration {
+	if fraction < 0 {
+		fraction = 0
+	}
+	if fraction > 1 {
+		fraction = 1
+	}
+	return func(n int, e error, t *Task) time.Duration {
+		d := float64(fn(n, e, t))
+		// random value in [-fraction, fraction).
+		r := (rand.Float64()*2 - 1) * fraction
+		return time.Duration(d + d*r)
+	}
+}
+
+// RetryIn returns an error which tells the background to retry the task
+// after the given delay instead of the delay returned by Config.RetryDelayFunc.
+//
+// A handler can return it when the reason for the failure also tells when
+// to try again, e.g. a downstream API responding with a Retry-After header.
+// The task is still counted as retried and is killed once it exhausts its
+// max retry count.
+//
+// The returned error reports the message of err, which may be nil.
+func RetryIn(d time.Duration, err error) error {
+	return &retryInError{delay: d, err: err}
+}
+
+// retryInError is the error returned by RetryIn.
+type retryInError struct {
+	delay time.Duration
+	err   error
+}
+
+func (e *retryInError) Error() string {
+	if e.err == nil {
+		return fmt.Sprintf("retry in %v", e.delay)
+	}
+	return e.err.Error()
+}
+
+func (e *retryInError) Unwrap() error { return e.err }
+
+// retryDelayOf reports the delay given to RetryIn, if err or any error
+// it wraps was returned by RetryIn.
+func retryDelayOf(err error) (time.Duration, bool) {
+	var e *retryInError
+	if !errors.As(err, &e) {
+		return 0, false
+	}
+	return e.delay, true
+}
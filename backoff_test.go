@@ -0,0 +1,97 @@
+// Copyright 2020 Kentaro Hibino. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package asynq
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	task := NewTask("send_email", nil)
+	err := errors.New("something went wrong")
+
+	tests := []struct {
+		desc string
+		fn   func(n int, e error, t *Task) time.Duration
+		n    int
+		want time.Duration
+	}{
+		{"exponential first retry", ExponentialBackoff(time.Second, time.Minute), 0, time.Second},
+		{"exponential third retry", ExponentialBackoff(time.Second, time.Minute), 2, 4 * time.Second},
+		{"exponential capped", ExponentialBackoff(time.Second, time.Minute), 10, time.Minute},
+		{"exponential large retry count", ExponentialBackoff(time.Second, time.Minute), 1000, time.Minute},
+		{"linear first retry", LinearBackoff(time.Second, time.Minute), 0, time.Second},
+		{"linear third retry", LinearBackoff(time.Second, time.Minute), 2, 3 * time.Second},
+		{"linear capped", LinearBackoff(time.Second, time.Minute), 100, time.Minute},
+		{"fixed", FixedDelay(5 * time.Second), 7, 5 * time.Second},
+	}
+
+	for _, tc := range tests {
+		if got := tc.fn(tc.n, err, task); got != tc.want {
+			t.Errorf("%s: delay for n=%d = %v, want %v", tc.desc, tc.n, got, tc.want)
+		}
+	}
+}
+
+func TestWithJitter(t *testing.T) {
+	task := NewTask("send_email", nil)
+	err := errors.New("something went wrong")
+
+	tests := []struct {
+		fraction float64
+		min, max time.Duration
+	}{
+		{0.1, 9 * time.Second, 11 * time.Second},
+		{0, 10 * time.Second, 10 * time.Second},
+		{-1, 10 * time.Second, 10 * time.Second}, // clamped to 0
+		{5, 0, 20 * time.Second},                 // clamped to 1
+	}
+
+	for _, tc := range tests {
+		fn := WithJitter(FixedDelay(10*time.Second), tc.fraction)
+		for i := 0; i < 100; i++ {
+			if got := fn(0, err, task); got < tc.min || got > tc.max {
+				t.Errorf("WithJitter(FixedDelay(10s), %v) returned %v, want between %v and %v",
+					tc.fraction, got, tc.min, tc.max)
+				break
+			}
+		}
+	}
+}
+
+// wrappedError wraps an error the same way fmt.Errorf with %w does.
+type wrappedError struct{ err error }
+
+func (e *wrappedError) Error() string { return "wrapped: " + e.err.Error() }
+func (e *wrappedError) Unwrap() error { return e.err }
+
+func TestRetryIn(t *testing.T) {
+	err := errors.New("too many requests")
+
+	tests := []struct {
+		err       error
+		wantDelay time.Duration
+		wantOK    bool
+		wantMsg   string
+	}{
+		{RetryIn(30*time.Second, err), 30 * time.Second, true, "too many requests"},
+		{RetryIn(time.Minute, nil), time.Minute, true, "retry in 1m0s"},
+		{&wrappedError{RetryIn(time.Hour, err)}, time.Hour, true, "wrapped: too many requests"},
+		{err, 0, false, "too many requests"},
+		{&wrappedError{err}, 0, false, "wrapped: too many requests"},
+	}
+
+	for _, tc := range tests {
+		d, ok := retryDelayOf(tc.err)
+		if d != tc.wantDelay || ok != tc.wantOK {
+			t.Errorf("retryDelayOf(%v) = %v, %t; want %v, %t", tc.err, d, ok, tc.wantDelay, tc.wantOK)
+		}
+		if got := tc.err.Error(); got != tc.wantMsg {
+			t.Errorf("Error() = %q, want %q", got, tc.wantMsg)
+		}
+	}
+}
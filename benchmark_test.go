@@ -13,6 +13,41 @@ import (
 	"time"
 )
 
+func BenchmarkClientEnqueue(b *testing.B) {
+	setup(b)
+	client := NewClient(&RedisClientOpt{
+		Addr: redisAddr,
+		DB:   redisDB,
+	})
+	t := NewTask("send_email", map[string]interface{}{"subject": "hello", "recipient_id": 123})
+
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		if err := client.Enqueue(t); err != nil {
+			b.Fatalf("could not enqueue a task: %v", err)
+		}
+	}
+}
+
+func BenchmarkClientEnqueueParallel(b *testing.B) {
+	setup(b)
+	client := NewClient(&RedisClientOpt{
+		Addr: redisAddr,
+		DB:   redisDB,
+	})
+	t := NewTask("send_email", map[string]interface{}{"subject": "hello", "recipient_id": 123})
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if err := client.Enqueue(t); err != nil {
+				b.Errorf("could not enqueue a task: %v", err)
+				return
+			}
+		}
+	})
+}
+
 // Simple E2E Benchmark testing with no scheduled tasks and retries.
 func BenchmarkEndToEndSimple(b *testing.B) {
 	const count = 100000
@@ -0,0 +1,76 @@
+// Copyright 2020 Kentaro Hibino. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package asynq
+
+import (
+	"sync"
+	"time"
+)
+
+// circuitBreaker keeps track of consecutive failures of each task type
+// and opens the circuit for the type once the failures reach the threshold.
+//
+// While the circuit is open, tasks of the type should not be processed.
+// Once the cool-down period passes, tasks of the type are processed again;
+// a success closes the circuit and another failure opens it right away.
+//
+// circuitBreaker is safe for concurrent use by multiple goroutines.
+type circuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu        sync.Mutex           // guards fields below
+	failures  map[string]int       // number of consecutive failures by task type
+	openUntil map[string]time.Time // end of the cool-down period by task type
+}
+
+// newCircuitBreaker returns a circuitBreaker which opens the circuit
+// for a task type after threshold consecutive failures, for the duration
+// of cooldown.
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		failures:  make(map[string]int),
+		openUntil: make(map[string]time.Time),
+	}
+}
+
+// open reports whether the circuit for the task type is open at the
+// given time, and if so, when it closes.
+func (b *circuitBreaker) open(typename string, now time.Time) (time.Time, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	until, ok := b.openUntil[typename]
+	if !ok {
+		return time.Time{}, false
+	}
+	if !now.Before(until) {
+		delete(b.openUntil, typename)
+		return time.Time{}, false
+	}
+	return until, true
+}
+
+// record records the result of processing a task of the type,
+// and reports whether the failure opened the circuit for the type.
+func (b *circuitBreaker) record(typename string, failed bool, now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !failed {
+		delete(b.failures, typename)
+		return false
+	}
+	b.failures[typename]++
+	if b.failures[typename] < b.threshold {
+		return false
+	}
+	if until, ok := b.openUntil[typename]; ok && now.Before(until) {
+		// already open, failure of a task which started before the circuit opened.
+		return false
+	}
+	b.openUntil[typename] = now.Add(b.cooldown)
+	return true
+}
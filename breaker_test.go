@@ -0,0 +1,58 @@
+// Copyright 2020 Kentaro Hibino. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package asynq
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	now := time.Now()
+	b := newCircuitBreaker(3, time.Minute)
+
+	// failures of other types and successes in between don't count.
+	b.record("sync", true, now)
+	b.record("sync", true, now)
+	b.record("send_email", true, now)
+	b.record("sync", false, now)
+	if _, open := b.open("sync", now); open {
+		t.Fatal("circuit for sync is open after a success, want closed")
+	}
+
+	for i := 0; i < 2; i++ {
+		if b.record("sync", true, now) {
+			t.Fatalf("record opened the circuit after %d failures, want 3", i+1)
+		}
+	}
+	if !b.record("sync", true, now) {
+		t.Fatal("record did not open the circuit after 3 failures")
+	}
+	if b.record("sync", true, now) {
+		t.Error("record reported opening an already open circuit")
+	}
+	until, open := b.open("sync", now.Add(30*time.Second))
+	if !open || !until.Equal(now.Add(time.Minute)) {
+		t.Errorf("open(sync) = %v, %t; want %v, true", until, open, now.Add(time.Minute))
+	}
+	if _, open := b.open("send_email", now); open {
+		t.Error("circuit for send_email is open, want closed")
+	}
+
+	// after the cool-down period, a single failure opens the circuit again.
+	later := now.Add(time.Minute)
+	if _, open := b.open("sync", later); open {
+		t.Fatal("circuit for sync is open after the cool-down period, want closed")
+	}
+	if !b.record("sync", true, later) {
+		t.Error("record did not reopen the circuit after the cool-down period")
+	}
+
+	// a success closes the circuit.
+	b.record("sync", false, later)
+	if b.record("sync", true, later.Add(time.Minute)) {
+		t.Error("record opened the circuit after a success followed by a failure")
+	}
+}
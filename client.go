@@ -5,7 +5,10 @@
 package asynq
 
 import (
+	"errors"
+	"fmt"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/hibiken/asynq/internal/base"
@@ -21,25 +24,103 @@ import (
 // Clients are safe for concurrent use by multiple goroutines.
 type Client struct {
 	rdb *rdb.RDB
+
+	// shard is incremented for each task enqueued with the Shards option,
+	// to spread the tasks over the shards in round-robin order.
+	// It must be accessed atomically.
+	shard uint32
 }
 
 // NewClient and returns a new Client given a redis connection option.
 func NewClient(r RedisConnOpt) *Client {
 	rdb := rdb.NewRDB(createRedisClient(r))
-	return &Client{rdb}
+	return &Client{rdb: rdb}
 }
 
 // Option specifies the task processing behavior.
-type Option interface{}
+type Option interface {
+	// String returns a string representation of the option,
+	// e.g. MaxRetry(5), for debugging which options were applied.
+	String() string
+
+	// Type describes the type of the option.
+	Type() OptionType
+
+	// Value returns the value used to create the option.
+	Value() interface{}
+}
+
+// OptionType describes the type of an Option.
+type OptionType int
+
+// Types of options.
+const (
+	MaxRetryOpt OptionType = iota
+	QueueOpt
+	TimeoutOpt
+	DeadlineOpt
+	StartByOpt
+	ShardsOpt
+	IdempotencyKeyOpt
+	MaxAttemptsOpt
+	LabelsOpt
+)
 
 // Internal option representations.
 type (
-	retryOption    int
-	queueOption    string
-	timeoutOption  time.Duration
-	deadlineOption time.Time
+	retryOption          int
+	queueOption          string
+	timeoutOption        time.Duration
+	deadlineOption       time.Time
+	startByOption        time.Time
+	shardsOption         int
+	idempotencyKeyOption string
+	maxAttemptsOption    int
+	labelsOption         map[string]string
 )
 
+func (n retryOption) String() string     { return fmt.Sprintf("MaxRetry(%d)", int(n)) }
+func (n retryOption) Type() OptionType   { return MaxRetryOpt }
+func (n retryOption) Value() interface{} { return int(n) }
+
+func (name queueOption) String() string     { return fmt.Sprintf("Queue(%q)", string(name)) }
+func (name queueOption) Type() OptionType   { return QueueOpt }
+func (name queueOption) Value() interface{} { return string(name) }
+
+func (d timeoutOption) String() string     { return fmt.Sprintf("Timeout(%v)", time.Duration(d)) }
+func (d timeoutOption) Type() OptionType   { return TimeoutOpt }
+func (d timeoutOption) Value() interface{} { return time.Duration(d) }
+
+func (t deadlineOption) String() string {
+	return fmt.Sprintf("Deadline(%v)", time.Time(t).Format(time.RFC3339))
+}
+func (t deadlineOption) Type() OptionType   { return DeadlineOpt }
+func (t deadlineOption) Value() interface{} { return time.Time(t) }
+
+func (t startByOption) String() string {
+	return fmt.Sprintf("StartBy(%v)", time.Time(t).Format(time.RFC3339))
+}
+func (t startByOption) Type() OptionType   { return StartByOpt }
+func (t startByOption) Value() interface{} { return time.Time(t) }
+
+func (n shardsOption) String() string     { return fmt.Sprintf("Shards(%d)", int(n)) }
+func (n shardsOption) Type() OptionType   { return ShardsOpt }
+func (n shardsOption) Value() interface{} { return int(n) }
+
+func (key idempotencyKeyOption) String() string {
+	return fmt.Sprintf("IdempotencyKey(%q)", string(key))
+}
+func (key idempotencyKeyOption) Type() OptionType   { return IdempotencyKeyOpt }
+func (key idempotencyKeyOption) Value() interface{} { return string(key) }
+
+func (n maxAttemptsOption) String() string     { return fmt.Sprintf("MaxAttempts(%d)", int(n)) }
+func (n maxAttemptsOption) Type() OptionType   { return MaxAttemptsOpt }
+func (n maxAttemptsOption) Value() interface{} { return int(n) }
+
+func (l labelsOption) String() string     { return fmt.Sprintf("Labels(%v)", map[string]string(l)) }
+func (l labelsOption) Type() OptionType   { return LabelsOpt }
+func (l labelsOption) Value() interface{} { return copyLabels(l) }
+
 // MaxRetry returns an option to specify the max number of times
 // the task will be retried.
 //
@@ -54,30 +135,124 @@ func MaxRetry(n int) Option {
 // Queue returns an option to specify the queue to enqueue the task into.
 //
 // Queue name is case-insensitive and the lowercased version is used.
+// Enqueue returns an error if the name is empty, contains whitespace,
+// control characters or "#", or starts with "asynq:".
 func Queue(name string) Option {
 	return queueOption(strings.ToLower(name))
 }
 
 // Timeout returns an option to specify how long a task may run.
 //
-// Zero duration means no limit.
+// Zero duration means no limit. Negative duration is invalid.
 func Timeout(d time.Duration) Option {
 	return timeoutOption(d)
 }
 
 // Deadline returns an option to specify the deadline for the given task.
+//
+// The deadline must not be before the time the task is processed,
+// nor before the timeout of the task expires if Timeout is also given.
 func Deadline(t time.Time) Option {
 	return deadlineOption(t)
 }
 
+// StartBy returns an option to specify the time by which processing
+// of the task must start.
+//
+// If the task has not started processing by the given time, the task
+// is moved to the dead queue without being processed.
+// Retries of the task are processed after the time.
+// Zero time means no limit.
+func StartBy(t time.Time) Option {
+	return startByOption(t)
+}
+
+// Shards returns an option to spread tasks over n shards of the queue,
+// so that a single hot queue is not bottlenecked on a single redis list.
+//
+// Tasks enqueued by a client are written to the shards in round-robin order.
+// Background processes should be configured with the same number of shards
+// for the queue in Config.QueueShards to process the tasks in all shards.
+//
+// n less than two means no sharding.
+func Shards(n int) Option {
+	return shardsOption(n)
+}
+
+// IdempotencyKey returns an option to specify the idempotency key of the task.
+//
+// If a task with the same key has been completed, the task is not processed
+// and is removed from the queue, as long as the background remembers
+// the completion for Config.IdempotencyWindow. This prevents tasks from
+// being processed twice, e.g. when a client retries enqueuing a task or
+// when a task is restored after a crash.
+//
+// Empty string means no idempotency key.
+func IdempotencyKey(key string) Option {
+	return idempotencyKeyOption(key)
+}
+
+// MaxAttempts returns an option to specify the max number of times
+// the task is processed, independently of MaxRetry.
+//
+// Both the attempts failed with an error and the attempts not finished,
+// e.g. because the process crashed and the task was restored at restart,
+// are counted. Once the task is attempted n times without success, it is
+// moved to the dead queue instead of being processed again, so that a task
+// which repeatedly crashes the processes is not restored forever.
+// Attempts interrupted by a graceful shutdown are not counted, nor are the
+// tasks which a starting process restores while other live processes are
+// processing them.
+//
+// Zero or negative n means no limit.
+func MaxAttempts(n int) Option {
+	if n < 0 {
+		n = 0
+	}
+	return maxAttemptsOption(n)
+}
+
+// Labels returns an option to attach labels to the task, i.e. operational
+// metadata such as the tenant, the source service or the trace ID, which
+// is carried with the task apart from its payload.
+//
+// Labels are reported by Inspector and can be used to filter the tasks
+// listed with Inspector.ListTasks. The handler gets the labels of the task
+// with LabelsFromContext. Child tasks enqueued with EnqueueFromHandler
+// don't inherit the labels.
+//
+// Empty label keys are invalid.
+func Labels(labels map[string]string) Option {
+	return labelsOption(copyLabels(labels))
+}
+
+// copyLabels returns a copy of labels, or nil if labels is empty.
+func copyLabels(labels map[string]string) map[string]string {
+	if len(labels) == 0 {
+		return nil
+	}
+	res := make(map[string]string, len(labels))
+	for k, v := range labels {
+		res[k] = v
+	}
+	return res
+}
+
 type option struct {
 	retry    int
 	queue    string
 	timeout  time.Duration
 	deadline time.Time
+	startBy  time.Time
+	shards   int
+	idemKey  string
+	attempts int
+	labels   map[string]string
 }
 
-func composeOptions(opts ...Option) option {
+// composeOptions merges the options for a task to be processed at processAt,
+// and returns an error if any option or a combination of options is invalid.
+func composeOptions(processAt time.Time, opts ...Option) (option, error) {
 	res := option{
 		retry:    defaultMaxRetry,
 		queue:    base.DefaultQueueName,
@@ -94,11 +269,42 @@ func composeOptions(opts ...Option) option {
 			res.timeout = time.Duration(opt)
 		case deadlineOption:
 			res.deadline = time.Time(opt)
+		case startByOption:
+			res.startBy = time.Time(opt)
+		case shardsOption:
+			res.shards = int(opt)
+		case idempotencyKeyOption:
+			res.idemKey = string(opt)
+		case maxAttemptsOption:
+			res.attempts = int(opt)
+		case labelsOption:
+			res.labels = map[string]string(opt)
 		default:
-			// ignore unexpected option
+			return option{}, fmt.Errorf("asynq: unexpected option %v", opt)
 		}
 	}
-	return res
+	if err := base.ValidateQueueName(res.queue); err != nil {
+		return option{}, fmt.Errorf("asynq: %v", err)
+	}
+	if _, ok := res.labels[""]; ok {
+		return option{}, errors.New("asynq: empty label key")
+	}
+	if res.timeout < 0 {
+		return option{}, fmt.Errorf("asynq: negative timeout %v", res.timeout)
+	}
+	if !res.deadline.IsZero() {
+		if now := time.Now(); processAt.Before(now) {
+			processAt = now
+		}
+		if res.deadline.Before(processAt) {
+			return option{}, fmt.Errorf("asynq: deadline %v is before the task is processed", res.deadline.Format(time.RFC3339))
+		}
+		if res.timeout > 0 && res.deadline.Before(processAt.Add(res.timeout)) {
+			return option{}, fmt.Errorf("asynq: deadline %v is before the timeout %v expires; use either of them",
+				res.deadline.Format(time.RFC3339), res.timeout)
+		}
+	}
+	return res, nil
 }
 
 const (
@@ -112,20 +318,44 @@ const (
 //
 // The argument opts specifies the behavior of task processing.
 // If there are conflicting Option values the last one overrides others.
+// EnqueueAt returns an error without enqueuing the task if the options are invalid.
 func (c *Client) EnqueueAt(t time.Time, task *Task, opts ...Option) error {
-	opt := composeOptions(opts...)
-	msg := &base.TaskMessage{
-		ID:       xid.New(),
-		Type:     task.Type,
-		Payload:  task.Payload.data,
-		Queue:    opt.queue,
-		Retry:    opt.retry,
-		Timeout:  opt.timeout.String(),
-		Deadline: opt.deadline.Format(time.RFC3339),
+	msg, err := c.newMessage(t, task, opts...)
+	if err != nil {
+		return err
 	}
 	return c.enqueue(msg, t)
 }
 
+// newMessage returns a message of the task to be processed at t given the options.
+func (c *Client) newMessage(t time.Time, task *Task, opts ...Option) (*base.TaskMessage, error) {
+	opt, err := composeOptions(t, opts...)
+	if err != nil {
+		return nil, err
+	}
+	queue := opt.queue
+	if opt.shards > 1 {
+		i := atomic.AddUint32(&c.shard, 1) % uint32(opt.shards)
+		queue = base.ShardQueue(queue, int(i))
+	}
+	msg := &base.TaskMessage{
+		ID:             xid.New(),
+		Type:           task.Type,
+		Payload:        task.Payload.data,
+		Queue:          queue,
+		Retry:          opt.retry,
+		Timeout:        opt.timeout.String(),
+		Deadline:       opt.deadline.Format(time.RFC3339),
+		IdempotencyKey: opt.idemKey,
+		MaxAttempts:    opt.attempts,
+		Labels:         opt.labels,
+	}
+	if !opt.startBy.IsZero() {
+		msg.StartBy = opt.startBy.Unix()
+	}
+	return msg, nil
+}
+
 // Enqueue enqueues task to be processed immediately.
 //
 // Enqueue returns nil if the task is enqueued successfully, otherwise returns a non-nil error.
@@ -147,8 +377,10 @@ func (c *Client) EnqueueIn(d time.Duration, task *Task, opts ...Option) error {
 }
 
 func (c *Client) enqueue(msg *base.TaskMessage, t time.Time) error {
-	if time.Now().After(t) {
+	if now := time.Now(); now.After(t) {
+		msg.EnqueuedAt = now.Unix()
 		return c.rdb.Enqueue(msg)
 	}
+	msg.EnqueuedAt = t.Unix()
 	return c.rdb.Schedule(msg, t)
 }
@@ -5,9 +5,13 @@
 package asynq
 
 import (
+	"context"
+	"net"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/go-redis/redis/v7"
 	"github.com/google/go-cmp/cmp"
 	h "github.com/hibiken/asynq/internal/asynqtest"
 	"github.com/hibiken/asynq/internal/base"
@@ -46,12 +50,13 @@ func TestClientEnqueueAt(t *testing.T) {
 			wantEnqueued: map[string][]*base.TaskMessage{
 				"default": []*base.TaskMessage{
 					&base.TaskMessage{
-						Type:     task.Type,
-						Payload:  task.Payload.data,
-						Retry:    defaultMaxRetry,
-						Queue:    "default",
-						Timeout:  noTimeout,
-						Deadline: noDeadline,
+						Type:       task.Type,
+						Payload:    task.Payload.data,
+						Retry:      defaultMaxRetry,
+						Queue:      "default",
+						Timeout:    noTimeout,
+						Deadline:   noDeadline,
+						EnqueuedAt: now.Unix(),
 					},
 				},
 			},
@@ -66,12 +71,13 @@ func TestClientEnqueueAt(t *testing.T) {
 			wantScheduled: []h.ZSetEntry{
 				{
 					Msg: &base.TaskMessage{
-						Type:     task.Type,
-						Payload:  task.Payload.data,
-						Retry:    defaultMaxRetry,
-						Queue:    "default",
-						Timeout:  noTimeout,
-						Deadline: noDeadline,
+						Type:       task.Type,
+						Payload:    task.Payload.data,
+						Retry:      defaultMaxRetry,
+						Queue:      "default",
+						Timeout:    noTimeout,
+						Deadline:   noDeadline,
+						EnqueuedAt: oneHourLater.Unix(),
 					},
 					Score: float64(oneHourLater.Unix()),
 				},
@@ -90,13 +96,13 @@ func TestClientEnqueueAt(t *testing.T) {
 
 		for qname, want := range tc.wantEnqueued {
 			gotEnqueued := h.GetEnqueuedMessages(t, r, qname)
-			if diff := cmp.Diff(want, gotEnqueued, h.IgnoreIDOpt); diff != "" {
+			if diff := cmp.Diff(want, gotEnqueued, h.IgnoreIDOpt, h.ApproxEnqueuedAtOpt); diff != "" {
 				t.Errorf("%s;\nmismatch found in %q; (-want,+got)\n%s", tc.desc, base.QueueKey(qname), diff)
 			}
 		}
 
 		gotScheduled := h.GetScheduledEntries(t, r)
-		if diff := cmp.Diff(tc.wantScheduled, gotScheduled, h.IgnoreIDOpt); diff != "" {
+		if diff := cmp.Diff(tc.wantScheduled, gotScheduled, h.IgnoreIDOpt, h.ApproxEnqueuedAtOpt); diff != "" {
 			t.Errorf("%s;\nmismatch found in %q; (-want,+got)\n%s", tc.desc, base.ScheduledQueue, diff)
 		}
 	}
@@ -114,6 +120,7 @@ func TestClientEnqueue(t *testing.T) {
 	var (
 		noTimeout  = time.Duration(0).String()
 		noDeadline = time.Time{}.Format(time.RFC3339)
+		deadline   = time.Now().Add(time.Hour)
 	)
 
 	tests := []struct {
@@ -131,12 +138,13 @@ func TestClientEnqueue(t *testing.T) {
 			wantEnqueued: map[string][]*base.TaskMessage{
 				"default": []*base.TaskMessage{
 					&base.TaskMessage{
-						Type:     task.Type,
-						Payload:  task.Payload.data,
-						Retry:    3,
-						Queue:    "default",
-						Timeout:  noTimeout,
-						Deadline: noDeadline,
+						Type:       task.Type,
+						Payload:    task.Payload.data,
+						Retry:      3,
+						Queue:      "default",
+						Timeout:    noTimeout,
+						Deadline:   noDeadline,
+						EnqueuedAt: time.Now().Unix(),
 					},
 				},
 			},
@@ -150,12 +158,13 @@ func TestClientEnqueue(t *testing.T) {
 			wantEnqueued: map[string][]*base.TaskMessage{
 				"default": []*base.TaskMessage{
 					&base.TaskMessage{
-						Type:     task.Type,
-						Payload:  task.Payload.data,
-						Retry:    0, // Retry count should be set to zero
-						Queue:    "default",
-						Timeout:  noTimeout,
-						Deadline: noDeadline,
+						Type:       task.Type,
+						Payload:    task.Payload.data,
+						Retry:      0, // Retry count should be set to zero
+						Queue:      "default",
+						Timeout:    noTimeout,
+						Deadline:   noDeadline,
+						EnqueuedAt: time.Now().Unix(),
 					},
 				},
 			},
@@ -170,12 +179,13 @@ func TestClientEnqueue(t *testing.T) {
 			wantEnqueued: map[string][]*base.TaskMessage{
 				"default": []*base.TaskMessage{
 					&base.TaskMessage{
-						Type:     task.Type,
-						Payload:  task.Payload.data,
-						Retry:    10, // Last option takes precedence
-						Queue:    "default",
-						Timeout:  noTimeout,
-						Deadline: noDeadline,
+						Type:       task.Type,
+						Payload:    task.Payload.data,
+						Retry:      10, // Last option takes precedence
+						Queue:      "default",
+						Timeout:    noTimeout,
+						Deadline:   noDeadline,
+						EnqueuedAt: time.Now().Unix(),
 					},
 				},
 			},
@@ -189,12 +199,13 @@ func TestClientEnqueue(t *testing.T) {
 			wantEnqueued: map[string][]*base.TaskMessage{
 				"custom": []*base.TaskMessage{
 					&base.TaskMessage{
-						Type:     task.Type,
-						Payload:  task.Payload.data,
-						Retry:    defaultMaxRetry,
-						Queue:    "custom",
-						Timeout:  noTimeout,
-						Deadline: noDeadline,
+						Type:       task.Type,
+						Payload:    task.Payload.data,
+						Retry:      defaultMaxRetry,
+						Queue:      "custom",
+						Timeout:    noTimeout,
+						Deadline:   noDeadline,
+						EnqueuedAt: time.Now().Unix(),
 					},
 				},
 			},
@@ -208,12 +219,13 @@ func TestClientEnqueue(t *testing.T) {
 			wantEnqueued: map[string][]*base.TaskMessage{
 				"high": []*base.TaskMessage{
 					&base.TaskMessage{
-						Type:     task.Type,
-						Payload:  task.Payload.data,
-						Retry:    defaultMaxRetry,
-						Queue:    "high",
-						Timeout:  noTimeout,
-						Deadline: noDeadline,
+						Type:       task.Type,
+						Payload:    task.Payload.data,
+						Retry:      defaultMaxRetry,
+						Queue:      "high",
+						Timeout:    noTimeout,
+						Deadline:   noDeadline,
+						EnqueuedAt: time.Now().Unix(),
 					},
 				},
 			},
@@ -227,12 +239,13 @@ func TestClientEnqueue(t *testing.T) {
 			wantEnqueued: map[string][]*base.TaskMessage{
 				"default": []*base.TaskMessage{
 					&base.TaskMessage{
-						Type:     task.Type,
-						Payload:  task.Payload.data,
-						Retry:    defaultMaxRetry,
-						Queue:    "default",
-						Timeout:  (20 * time.Second).String(),
-						Deadline: noDeadline,
+						Type:       task.Type,
+						Payload:    task.Payload.data,
+						Retry:      defaultMaxRetry,
+						Queue:      "default",
+						Timeout:    (20 * time.Second).String(),
+						Deadline:   noDeadline,
+						EnqueuedAt: time.Now().Unix(),
 					},
 				},
 			},
@@ -241,17 +254,39 @@ func TestClientEnqueue(t *testing.T) {
 			desc: "With deadline option",
 			task: task,
 			opts: []Option{
-				Deadline(time.Date(2020, time.June, 24, 0, 0, 0, 0, time.UTC)),
+				Deadline(deadline),
 			},
 			wantEnqueued: map[string][]*base.TaskMessage{
 				"default": []*base.TaskMessage{
 					&base.TaskMessage{
-						Type:     task.Type,
-						Payload:  task.Payload.data,
-						Retry:    defaultMaxRetry,
-						Queue:    "default",
-						Timeout:  noTimeout,
-						Deadline: time.Date(2020, time.June, 24, 0, 0, 0, 0, time.UTC).Format(time.RFC3339),
+						Type:       task.Type,
+						Payload:    task.Payload.data,
+						Retry:      defaultMaxRetry,
+						Queue:      "default",
+						Timeout:    noTimeout,
+						Deadline:   deadline.Format(time.RFC3339),
+						EnqueuedAt: time.Now().Unix(),
+					},
+				},
+			},
+		},
+		{
+			desc: "With start-by option",
+			task: task,
+			opts: []Option{
+				StartBy(time.Date(2020, time.June, 24, 0, 10, 0, 0, time.UTC)),
+			},
+			wantEnqueued: map[string][]*base.TaskMessage{
+				"default": []*base.TaskMessage{
+					&base.TaskMessage{
+						Type:       task.Type,
+						Payload:    task.Payload.data,
+						Retry:      defaultMaxRetry,
+						Queue:      "default",
+						Timeout:    noTimeout,
+						Deadline:   noDeadline,
+						EnqueuedAt: time.Now().Unix(),
+						StartBy:    time.Date(2020, time.June, 24, 0, 10, 0, 0, time.UTC).Unix(),
 					},
 				},
 			},
@@ -269,7 +304,7 @@ func TestClientEnqueue(t *testing.T) {
 
 		for qname, want := range tc.wantEnqueued {
 			got := h.GetEnqueuedMessages(t, r, qname)
-			if diff := cmp.Diff(want, got, h.IgnoreIDOpt); diff != "" {
+			if diff := cmp.Diff(want, got, h.IgnoreIDOpt, h.ApproxEnqueuedAtOpt); diff != "" {
 				t.Errorf("%s;\nmismatch found in %q; (-want,+got)\n%s", tc.desc, base.QueueKey(qname), diff)
 			}
 		}
@@ -307,12 +342,13 @@ func TestClientEnqueueIn(t *testing.T) {
 			wantScheduled: []h.ZSetEntry{
 				{
 					Msg: &base.TaskMessage{
-						Type:     task.Type,
-						Payload:  task.Payload.data,
-						Retry:    defaultMaxRetry,
-						Queue:    "default",
-						Timeout:  noTimeout,
-						Deadline: noDeadline,
+						Type:       task.Type,
+						Payload:    task.Payload.data,
+						Retry:      defaultMaxRetry,
+						Queue:      "default",
+						Timeout:    noTimeout,
+						Deadline:   noDeadline,
+						EnqueuedAt: time.Now().Add(time.Hour).Unix(),
 					},
 					Score: float64(time.Now().Add(time.Hour).Unix()),
 				},
@@ -326,12 +362,13 @@ func TestClientEnqueueIn(t *testing.T) {
 			wantEnqueued: map[string][]*base.TaskMessage{
 				"default": []*base.TaskMessage{
 					&base.TaskMessage{
-						Type:     task.Type,
-						Payload:  task.Payload.data,
-						Retry:    defaultMaxRetry,
-						Queue:    "default",
-						Timeout:  noTimeout,
-						Deadline: noDeadline,
+						Type:       task.Type,
+						Payload:    task.Payload.data,
+						Retry:      defaultMaxRetry,
+						Queue:      "default",
+						Timeout:    noTimeout,
+						Deadline:   noDeadline,
+						EnqueuedAt: time.Now().Unix(),
 					},
 				},
 			},
@@ -350,14 +387,268 @@ func TestClientEnqueueIn(t *testing.T) {
 
 		for qname, want := range tc.wantEnqueued {
 			gotEnqueued := h.GetEnqueuedMessages(t, r, qname)
-			if diff := cmp.Diff(want, gotEnqueued, h.IgnoreIDOpt); diff != "" {
+			if diff := cmp.Diff(want, gotEnqueued, h.IgnoreIDOpt, h.ApproxEnqueuedAtOpt); diff != "" {
 				t.Errorf("%s;\nmismatch found in %q; (-want,+got)\n%s", tc.desc, base.QueueKey(qname), diff)
 			}
 		}
 
 		gotScheduled := h.GetScheduledEntries(t, r)
-		if diff := cmp.Diff(tc.wantScheduled, gotScheduled, h.IgnoreIDOpt); diff != "" {
+		if diff := cmp.Diff(tc.wantScheduled, gotScheduled, h.IgnoreIDOpt, h.ApproxEnqueuedAtOpt); diff != "" {
 			t.Errorf("%s;\nmismatch found in %q; (-want,+got)\n%s", tc.desc, base.ScheduledQueue, diff)
 		}
 	}
 }
+
+func TestClientEnqueueWithShards(t *testing.T) {
+	r := setup(t)
+	client := NewClient(RedisClientOpt{
+		Addr: redisAddr,
+		DB:   redisDB,
+	})
+
+	task := NewTask("track_event", nil)
+	for i := 0; i < 6; i++ {
+		if err := client.Enqueue(task, Queue("events"), Shards(3)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := client.Enqueue(task, Queue("events"), Shards(1)); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]int{
+		"events":   1,
+		"events#0": 2,
+		"events#1": 2,
+		"events#2": 2,
+	}
+	for qname, n := range want {
+		msgs := h.GetEnqueuedMessages(t, r, qname)
+		if len(msgs) != n {
+			t.Errorf("%q has %d tasks, want %d", base.QueueKey(qname), len(msgs), n)
+		}
+		for _, msg := range msgs {
+			if msg.Queue != qname {
+				t.Errorf("task in %q has Queue %q, want %q", base.QueueKey(qname), msg.Queue, qname)
+			}
+		}
+	}
+}
+
+func TestClientEnqueueWithIdempotencyKey(t *testing.T) {
+	r := setup(t)
+	client := NewClient(RedisClientOpt{
+		Addr: redisAddr,
+		DB:   redisDB,
+	})
+
+	task := NewTask("charge", map[string]interface{}{"amount": 100})
+	if err := client.Enqueue(task, IdempotencyKey("charge:123")); err != nil {
+		t.Fatal(err)
+	}
+	msgs := h.GetEnqueuedMessages(t, r, base.DefaultQueueName)
+	if len(msgs) != 1 || msgs[0].IdempotencyKey != "charge:123" {
+		t.Errorf("enqueued messages = %v, want one message with IdempotencyKey %q", msgs, "charge:123")
+	}
+}
+
+func TestClientEnqueueWithMaxAttempts(t *testing.T) {
+	r := setup(t)
+	client := NewClient(RedisClientOpt{
+		Addr: redisAddr,
+		DB:   redisDB,
+	})
+
+	task := NewTask("render_video", nil)
+	if err := client.Enqueue(task, MaxAttempts(3), MaxRetry(10)); err != nil {
+		t.Fatal(err)
+	}
+	msgs := h.GetEnqueuedMessages(t, r, base.DefaultQueueName)
+	if len(msgs) != 1 || msgs[0].MaxAttempts != 3 || msgs[0].Retry != 10 {
+		t.Errorf("enqueued messages = %v, want one message with MaxAttempts 3 and Retry 10", msgs)
+	}
+}
+
+func TestClientEnqueueWithLabels(t *testing.T) {
+	r := setup(t)
+	client := NewClient(RedisClientOpt{
+		Addr: redisAddr,
+		DB:   redisDB,
+	})
+
+	labels := map[string]string{"tenant": "acme", "trace_id": "4bf92f3577b34da6"}
+	if err := client.Enqueue(NewTask("send_email", nil), Labels(labels)); err != nil {
+		t.Fatal(err)
+	}
+	// changes to the map after creating the option don't affect the task.
+	labels["tenant"] = "initech"
+	msgs := h.GetEnqueuedMessages(t, r, base.DefaultQueueName)
+	want := map[string]string{"tenant": "acme", "trace_id": "4bf92f3577b34da6"}
+	if len(msgs) != 1 || !cmp.Equal(want, msgs[0].Labels) {
+		t.Errorf("enqueued messages = %v, want one message with labels %v", msgs, want)
+	}
+}
+
+func TestClientEnqueueWithInvalidOptions(t *testing.T) {
+	r := setup(t)
+	client := NewClient(RedisClientOpt{
+		Addr: redisAddr,
+		DB:   redisDB,
+	})
+	task := NewTask("send_email", nil)
+	now := time.Now()
+
+	tests := []struct {
+		desc      string
+		processAt time.Time
+		opts      []Option
+	}{
+		{"empty queue name", now, []Option{Queue("")}},
+		{"queue name with whitespace", now, []Option{Queue("high priority")}},
+		{"queue name with shard separator", now, []Option{Queue("emails#1")}},
+		{"queue key as queue name", now, []Option{Queue("asynq:queues:default")}},
+		{"negative timeout", now, []Option{Timeout(-time.Second)}},
+		{"empty label key", now, []Option{Labels(map[string]string{"": "acme"})}},
+		{"deadline in the past", now, []Option{Deadline(now.Add(-time.Minute))}},
+		{"deadline before scheduled time", now.Add(time.Hour), []Option{Deadline(now.Add(time.Minute))}},
+		{"deadline before timeout", now, []Option{Timeout(time.Hour), Deadline(now.Add(time.Minute))}},
+	}
+
+	for _, tc := range tests {
+		h.FlushDB(t, r)
+
+		if err := client.EnqueueAt(tc.processAt, task, tc.opts...); err == nil {
+			t.Errorf("%s: EnqueueAt(%v, task, %v) returned nil error", tc.desc, tc.processAt, tc.opts)
+		}
+		if got := h.GetEnqueuedMessages(t, r); len(got) != 0 {
+			t.Errorf("%s: %d tasks were enqueued with invalid options; want none", tc.desc, len(got))
+		}
+		if got := h.GetScheduledMessages(t, r); len(got) != 0 {
+			t.Errorf("%s: %d tasks were scheduled with invalid options; want none", tc.desc, len(got))
+		}
+	}
+}
+
+func TestOptionString(t *testing.T) {
+	deadline := time.Date(2020, time.June, 24, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		opt      Option
+		wantStr  string
+		wantType OptionType
+		wantVal  interface{}
+	}{
+		{MaxRetry(10), "MaxRetry(10)", MaxRetryOpt, 10},
+		{Queue("Critical"), `Queue("critical")`, QueueOpt, "critical"},
+		{Timeout(time.Minute), "Timeout(1m0s)", TimeoutOpt, time.Minute},
+		{Deadline(deadline), "Deadline(2020-06-24T00:00:00Z)", DeadlineOpt, deadline},
+		{StartBy(deadline), "StartBy(2020-06-24T00:00:00Z)", StartByOpt, deadline},
+		{Shards(4), "Shards(4)", ShardsOpt, 4},
+		{IdempotencyKey("order:123"), `IdempotencyKey("order:123")`, IdempotencyKeyOpt, "order:123"},
+		{MaxAttempts(3), "MaxAttempts(3)", MaxAttemptsOpt, 3},
+		{MaxAttempts(-1), "MaxAttempts(0)", MaxAttemptsOpt, 0},
+	}
+
+	for _, tc := range tests {
+		if got := tc.opt.String(); got != tc.wantStr {
+			t.Errorf("String() = %q, want %q", got, tc.wantStr)
+		}
+		if got := tc.opt.Type(); got != tc.wantType {
+			t.Errorf("%v: Type() = %v, want %v", tc.opt, got, tc.wantType)
+		}
+		if got := tc.opt.Value(); got != tc.wantVal {
+			t.Errorf("%v: Value() = %v, want %v", tc.opt, got, tc.wantVal)
+		}
+	}
+}
+
+func TestClientEnqueueWithUniversalClient(t *testing.T) {
+	r := setup(t)
+	inspector := NewInspector(r)
+	client := NewClient(r)
+
+	task := NewTask("send_email", map[string]interface{}{"to": "user@example.com"})
+	if err := client.Enqueue(task); err != nil {
+		t.Fatal(err)
+	}
+	// the client is owned by the caller, so it should not be closed.
+	if err := inspector.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Ping().Err(); err != nil {
+		t.Fatalf("redis client was closed by Inspector.Close: %v", err)
+	}
+	if msgs := h.GetEnqueuedMessages(t, r, base.DefaultQueueName); len(msgs) != 1 {
+		t.Errorf("enqueued %d messages, want 1", len(msgs))
+	}
+}
+
+func TestNewClientWithClusterClientPanics(t *testing.T) {
+	c := redis.NewClusterClient(&redis.ClusterOptions{Addrs: []string{redisAddr}})
+	defer c.Close()
+	defer func() {
+		if x := recover(); x == nil {
+			t.Errorf("NewClient with *redis.ClusterClient did not panic")
+		}
+	}()
+	NewClient(c)
+}
+
+func TestClientEnqueueWithDialerAndOnConnect(t *testing.T) {
+	setup(t)
+	var dials, connects int32
+	client := NewClient(RedisClientOpt{
+		Addr: "unused:6379",
+		DB:   redisDB,
+		Dialer: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			atomic.AddInt32(&dials, 1)
+			var d net.Dialer
+			return d.DialContext(ctx, network, redisAddr)
+		},
+		OnConnect: func(conn *redis.Conn) error {
+			atomic.AddInt32(&connects, 1)
+			return nil
+		},
+	})
+
+	if err := client.Enqueue(NewTask("send_email", nil)); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&dials); n == 0 {
+		t.Errorf("Dialer was not called")
+	}
+	if n := atomic.LoadInt32(&connects); n == 0 {
+		t.Errorf("OnConnect was not called")
+	}
+}
+
+func TestClientRedisStats(t *testing.T) {
+	setup(t)
+	client := NewClient(RedisClientOpt{Addr: redisAddr, DB: redisDB})
+
+	for i := 0; i < 3; i++ {
+		if err := client.Enqueue(NewTask("send_email", nil)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stats := client.RedisStats()
+	if stats.TotalConns == 0 || stats.Hits+stats.Misses == 0 {
+		t.Errorf("RedisStats() = %+v, want pool stats of used connections", stats)
+	}
+	if got := stats.Commands["evalsha"]; got.Count < 3 || got.Errors != 0 || got.AvgDuration() <= 0 {
+		t.Errorf("RedisStats().Commands[%q] = %+v, want at least 3 commands with no errors", "evalsha", got)
+	}
+
+	// commands are not recorded with a client owned by the caller.
+	r := setup(t)
+	client = NewClient(r)
+	if err := client.Enqueue(NewTask("send_email", nil)); err != nil {
+		t.Fatal(err)
+	}
+	stats = client.RedisStats()
+	if len(stats.Commands) != 0 {
+		t.Errorf("RedisStats().Commands = %v with a client owned by the caller, want none", stats.Commands)
+	}
+	if stats.TotalConns == 0 {
+		t.Errorf("RedisStats().TotalConns = 0 with a client owned by the caller, want at least 1")
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright 2020 Kentaro Hibino. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package asynq
+
+import (
+	"sync"
+
+	"github.com/go-redis/redis/v7"
+	"github.com/hibiken/asynq/internal/base"
+	"github.com/hibiken/asynq/internal/log"
+	"github.com/hibiken/asynq/internal/rdb"
+)
+
+// controller is responsible for receiving commands sent to this process
+// (e.g. pause, resume) and updating the process state accordingly.
+type controller struct {
+	logger *log.Logger
+	rdb    *rdb.RDB
+
+	ps *base.ProcessState
+
+	// channel to communicate back to the long running "controller" goroutine.
+	done chan struct{}
+}
+
+func newController(l *log.Logger, rdb *rdb.RDB, ps *base.ProcessState) *controller {
+	return &controller{
+		logger: l,
+		rdb:    rdb,
+		ps:     ps,
+		done:   make(chan struct{}),
+	}
+}
+
+func (c *controller) terminate() {
+	c.logger.Info("Controller shutting down...")
+	// Signal the controller goroutine to stop.
+	c.done <- struct{}{}
+}
+
+func (c *controller) start(wg *sync.WaitGroup) {
+	info := c.ps.Get()
+	// Note: cmdCh stays nil if subscription fails so that the goroutine
+	// still runs and can be terminated.
+	var cmdCh <-chan *redis.Message
+	pubsub, err := c.rdb.ControlPubSub(info.Host, info.PID)
+	if err != nil {
+		c.logger.Error("cannot subscribe to control channel: %v", err)
+	} else {
+		cmdCh = pubsub.Channel()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-c.done:
+				if pubsub != nil {
+					pubsub.Close()
+				}
+				c.logger.Info("Controller done")
+				return
+			case msg := <-cmdCh:
+				c.handle(msg.Payload)
+			}
+		}
+	}()
+}
+
+func (c *controller) handle(cmd string) {
+	switch cmd {
+	case base.PauseCommand:
+		// Only a running process can be paused; a stopped process
+		// should stay stopped.
+		if c.ps.Status() == base.StatusRunning {
+			c.ps.SetStatus(base.StatusPaused)
+			c.logger.Info("Paused processing new tasks")
+		}
+	case base.ResumeCommand:
+		if c.ps.Status() == base.StatusPaused {
+			c.ps.SetStatus(base.StatusRunning)
+			c.logger.Info("Resumed processing new tasks")
+		}
+	default:
+		c.logger.Warn("Received unknown command %q", cmd)
+	}
+}
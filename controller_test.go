@@ -0,0 +1,56 @@
+// Copyright 2020 Kentaro Hibino. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package asynq
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hibiken/asynq/internal/base"
+	"github.com/hibiken/asynq/internal/rdb"
+)
+
+func TestController(t *testing.T) {
+	r := setup(t)
+	rdbClient := rdb.NewRDB(r)
+	host, pid := "localhost", 1234
+
+	tests := []struct {
+		initStatus base.PStatus // process status before sending command
+		command    string       // command to send
+		wantStatus base.PStatus // process status after sending command
+	}{
+		{base.StatusRunning, base.PauseCommand, base.StatusPaused},
+		{base.StatusPaused, base.ResumeCommand, base.StatusRunning},
+		{base.StatusStopped, base.PauseCommand, base.StatusStopped},
+		{base.StatusStopped, base.ResumeCommand, base.StatusStopped},
+		{base.StatusRunning, "bogus", base.StatusRunning},
+	}
+
+	for _, tc := range tests {
+		ps := base.NewProcessState(host, pid, 10, defaultQueueConfig, false)
+		ps.SetStatus(tc.initStatus)
+
+		controller := newController(testLogger, rdbClient, ps)
+		var wg sync.WaitGroup
+		controller.start(&wg)
+
+		if err := rdbClient.PublishControl(host, pid, tc.command); err != nil {
+			controller.terminate()
+			t.Fatalf("could not publish command: %v", err)
+		}
+
+		// allow for redis to publish message
+		time.Sleep(time.Second)
+
+		if got := ps.Status(); got != tc.wantStatus {
+			t.Errorf("after receiving %q command, process status = %v, want %v",
+				tc.command, got, tc.wantStatus)
+		}
+
+		controller.terminate()
+	}
+}
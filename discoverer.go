@@ -0,0 +1,232 @@
+// Copyright 2020 Kentaro Hibino. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package asynq
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hibiken/asynq/internal/base"
+	"github.com/hibiken/asynq/internal/log"
+	"github.com/hibiken/asynq/internal/rdb"
+)
+
+// queueGroups keeps track of groups of queues which are processed as
+// a single queue in the config: the queues discovered for each queue prefix,
+// and the shards of each sharded queue.
+//
+// Queues of a group are queried in round-robin order so that every queue
+// of the group gets a fair share of the workers.
+//
+// queueGroups is safe for concurrent use by multiple goroutines.
+type queueGroups struct {
+	prefixes []string            // sorted from longest to shortest
+	static   map[string]bool     // queues which don't belong to any prefix
+	shards   map[string][]string // shards of each sharded queue
+	shardOf  map[string]string   // sharded queue of each shard
+
+	mu      sync.Mutex          // guards fields below
+	members map[string][]string // discovered queues sorted by name, by prefix
+	groupOf map[string]string   // prefix of each discovered queue
+	offset  map[string]int      // index of the queue to query first, by group
+}
+
+// newQueueGroups returns a queueGroups given the prefixes, the number of
+// shards of each sharded queue, and the names of the queues which should
+// not be part of any prefix.
+//
+// A sharded queue is a group of the queue itself and its shards,
+// so that tasks enqueued without sharding are processed as well.
+func newQueueGroups(prefixes []string, shards map[string]int, static map[string]int) *queueGroups {
+	g := &queueGroups{
+		prefixes: append([]string(nil), prefixes...),
+		static:   make(map[string]bool),
+		shards:   make(map[string][]string),
+		shardOf:  make(map[string]string),
+		members:  make(map[string][]string),
+		groupOf:  make(map[string]string),
+		offset:   make(map[string]int),
+	}
+	sort.Slice(g.prefixes, func(i, j int) bool {
+		return len(g.prefixes[i]) > len(g.prefixes[j])
+	})
+	for qname := range static {
+		g.static[qname] = true
+	}
+	for qname, n := range shards {
+		qs := []string{qname}
+		for i := 0; i < n; i++ {
+			shard := base.ShardQueue(qname, i)
+			qs = append(qs, shard)
+			g.shardOf[shard] = qname
+		}
+		g.shards[qname] = qs
+	}
+	return g
+}
+
+// update assigns each of the given queues to the group of the longest
+// matching prefix, replacing the previously discovered queues.
+func (g *queueGroups) update(qnames []string) {
+	members := make(map[string][]string)
+	groupOf := make(map[string]string)
+	for _, qname := range qnames {
+		if _, ok := g.shardOf[qname]; ok || g.static[qname] {
+			continue
+		}
+		for _, prefix := range g.prefixes {
+			if strings.HasPrefix(qname, prefix) && qname != prefix {
+				members[prefix] = append(members[prefix], qname)
+				groupOf[qname] = prefix
+				break
+			}
+		}
+	}
+	for _, qs := range members {
+		sort.Strings(qs)
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.members = members
+	g.groupOf = groupOf
+}
+
+// isGroup reports whether qname is one of the prefixes or sharded queues.
+func (g *queueGroups) isGroup(qname string) bool {
+	if _, ok := g.shards[qname]; ok {
+		return true
+	}
+	for _, prefix := range g.prefixes {
+		if prefix == qname {
+			return true
+		}
+	}
+	return false
+}
+
+// queues returns the queues of the group. g.mu must be held.
+func (g *queueGroups) queues(group string) []string {
+	if qs, ok := g.shards[group]; ok {
+		return qs
+	}
+	return g.members[group]
+}
+
+// expand returns the given list of queues with each group replaced by
+// the queues of the group.
+func (g *queueGroups) expand(qnames []string) []string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	var res []string
+	for _, qname := range qnames {
+		if !g.isGroup(qname) {
+			res = append(res, qname)
+			continue
+		}
+		res = append(res, g.queues(qname)...)
+	}
+	return res
+}
+
+// next is like expand, but rotates the queues of each group
+// so that a different queue of the group is queried first
+// for each dequeue.
+func (g *queueGroups) next(qnames []string) []string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	var res []string
+	for _, qname := range qnames {
+		if !g.isGroup(qname) {
+			res = append(res, qname)
+			continue
+		}
+		members := g.queues(qname)
+		if len(members) == 0 {
+			continue
+		}
+		i := g.offset[qname] % len(members)
+		res = append(res, members[i:]...)
+		res = append(res, members[:i]...)
+		g.offset[qname] = i + 1
+	}
+	return res
+}
+
+// configured returns the name of the queue or prefix in the config
+// which the given queue belongs to.
+func (g *queueGroups) configured(qname string) string {
+	if sharded, ok := g.shardOf[qname]; ok {
+		return sharded
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if prefix, ok := g.groupOf[qname]; ok {
+		return prefix
+	}
+	return qname
+}
+
+// discoverer is responsible for periodically discovering
+// queues of the queue prefixes.
+type discoverer struct {
+	logger *log.Logger
+	rdb    *rdb.RDB
+
+	groups *queueGroups
+
+	// channel to communicate back to the long running "discoverer" goroutine.
+	done chan struct{}
+
+	// interval between discoveries.
+	interval time.Duration
+}
+
+func newDiscoverer(l *log.Logger, rdb *rdb.RDB, groups *queueGroups, interval time.Duration) *discoverer {
+	return &discoverer{
+		logger:   l,
+		rdb:      rdb,
+		groups:   groups,
+		done:     make(chan struct{}),
+		interval: interval,
+	}
+}
+
+func (d *discoverer) terminate() {
+	d.logger.Info("Discoverer shutting down...")
+	// Signal the discoverer goroutine to stop.
+	d.done <- struct{}{}
+}
+
+// start starts the "discoverer" goroutine.
+//
+// Queues are discovered once before start returns, so that the processor
+// can process the queues as soon as it starts.
+func (d *discoverer) start(wg *sync.WaitGroup) {
+	d.exec()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-d.done:
+				d.logger.Info("Discoverer done")
+				return
+			case <-time.After(d.interval):
+				d.exec()
+			}
+		}
+	}()
+}
+
+func (d *discoverer) exec() {
+	qnames, err := d.rdb.QueueNames()
+	if err != nil {
+		d.logger.Error("Could not discover queues: %v", err)
+		return
+	}
+	d.groups.update(qnames)
+}
@@ -0,0 +1,163 @@
+// Copyright 2020 Kentaro Hibino. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package asynq
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	h "github.com/hibiken/asynq/internal/asynqtest"
+	"github.com/hibiken/asynq/internal/base"
+	"github.com/hibiken/asynq/internal/rdb"
+)
+
+func TestQueueGroups(t *testing.T) {
+	g := newQueueGroups([]string{"tenant:", "tenant:vip:"}, nil, map[string]int{"default": 1, "tenant:admin": 1})
+	g.update([]string{"default", "low", "tenant:", "tenant:a", "tenant:admin", "tenant:b", "tenant:vip:x"})
+
+	qnames := []string{"tenant:", "default", "tenant:vip:", "org:"}
+	if got, want := g.expand(qnames), []string{"tenant:a", "tenant:b", "default", "tenant:vip:x", "org:"}; !cmp.Equal(got, want) {
+		t.Errorf("expand(%v) = %v, want %v", qnames, got, want)
+	}
+
+	// queues of a group are rotated on each call.
+	wantNext := [][]string{
+		{"tenant:a", "tenant:b", "default", "tenant:vip:x", "org:"},
+		{"tenant:b", "tenant:a", "default", "tenant:vip:x", "org:"},
+		{"tenant:a", "tenant:b", "default", "tenant:vip:x", "org:"},
+	}
+	for _, want := range wantNext {
+		if got := g.next(qnames); !cmp.Equal(got, want) {
+			t.Errorf("next(%v) = %v, want %v", qnames, got, want)
+		}
+	}
+
+	tests := []struct {
+		qname string
+		want  string
+	}{
+		{"tenant:a", "tenant:"},
+		{"tenant:vip:x", "tenant:vip:"},
+		{"tenant:admin", "tenant:admin"}, // listed in Queues
+		{"default", "default"},
+		{"tenant:c", "tenant:c"}, // not discovered yet
+	}
+	for _, tc := range tests {
+		if got := g.configured(tc.qname); got != tc.want {
+			t.Errorf("configured(%q) = %q, want %q", tc.qname, got, tc.want)
+		}
+	}
+
+	// groups without discovered queues are skipped.
+	g.update([]string{"default"})
+	if got := g.next([]string{"tenant:", "default"}); !cmp.Equal(got, []string{"default"}) {
+		t.Errorf("next after queues are removed = %v, want [default]", got)
+	}
+}
+
+func TestQueueGroupsWithShards(t *testing.T) {
+	g := newQueueGroups([]string{"e"}, map[string]int{"events": 2}, map[string]int{"events": 1, "default": 1})
+	g.update([]string{"default", "events", "events#0", "events#1", "emails"})
+
+	qnames := []string{"events", "default", "e"}
+	if got, want := g.expand(qnames), []string{"events", "events#0", "events#1", "default", "emails"}; !cmp.Equal(got, want) {
+		t.Errorf("expand(%v) = %v, want %v", qnames, got, want)
+	}
+	wantNext := [][]string{
+		{"events", "events#0", "events#1", "default", "emails"},
+		{"events#0", "events#1", "events", "default", "emails"},
+		{"events#1", "events", "events#0", "default", "emails"},
+	}
+	for _, want := range wantNext {
+		if got := g.next(qnames); !cmp.Equal(got, want) {
+			t.Errorf("next(%v) = %v, want %v", qnames, got, want)
+		}
+	}
+	for _, qname := range []string{"events", "events#0", "events#1"} {
+		if got := g.configured(qname); got != "events" {
+			t.Errorf("configured(%q) = %q, want %q", qname, got, "events")
+		}
+	}
+}
+
+func TestDiscoverer(t *testing.T) {
+	r := setup(t)
+	rdbClient := rdb.NewRDB(r)
+	h.SeedEnqueuedQueue(t, r, []*base.TaskMessage{h.NewTaskMessageWithQueue("sync", nil, "tenant:a")}, "tenant:a")
+
+	groups := newQueueGroups([]string{"tenant:"}, nil, defaultQueueConfig)
+	d := newDiscoverer(testLogger, rdbClient, groups, 100*time.Millisecond)
+
+	var wg sync.WaitGroup
+	d.start(&wg)
+	defer d.terminate()
+
+	// queues are discovered before start returns.
+	if got, want := groups.expand([]string{"tenant:"}), []string{"tenant:a"}; !cmp.Equal(got, want) {
+		t.Errorf("discovered queues after start = %v, want %v", got, want)
+	}
+
+	h.SeedEnqueuedQueue(t, r, []*base.TaskMessage{h.NewTaskMessageWithQueue("sync", nil, "tenant:b")}, "tenant:b")
+	time.Sleep(300 * time.Millisecond)
+	if got, want := groups.expand([]string{"tenant:"}), []string{"tenant:a", "tenant:b"}; !cmp.Equal(got, want) {
+		t.Errorf("discovered queues = %v, want %v", got, want)
+	}
+}
+
+func TestProcessorWithQueuePrefixes(t *testing.T) {
+	r := setup(t)
+	rdbClient := rdb.NewRDB(r)
+
+	// type of each task is the name of its queue.
+	for _, qname := range []string{"tenant:a", "tenant:a", "tenant:a", "tenant:b", "tenant:c"} {
+		msg := h.NewTaskMessageWithQueue(qname, nil, qname)
+		h.SeedEnqueuedQueue(t, r, []*base.TaskMessage{msg}, qname)
+	}
+	groups := newQueueGroups([]string{"tenant:"}, nil, nil)
+	qnames, err := rdbClient.QueueNames()
+	if err != nil {
+		t.Fatal(err)
+	}
+	groups.update(qnames)
+
+	var (
+		mu        sync.Mutex // guards processed
+		processed []string   // queues of the processed tasks in order
+	)
+	handler := func(ctx context.Context, task *Task) error {
+		mu.Lock()
+		defer mu.Unlock()
+		processed = append(processed, task.Type)
+		return nil
+	}
+	// process one task at a time to observe the order.
+	ps := base.NewProcessState("localhost", 1234, 1, map[string]int{"tenant:": 1}, false)
+	p := newProcessor(processorParams{
+		logger:         testLogger,
+		rdb:            rdbClient,
+		ps:             ps,
+		retryDelayFunc: defaultDelayFunc,
+		baseCtxFn:      context.Background,
+		cancelations:   base.NewCancelations(),
+		groups:         groups,
+	})
+	p.handler = HandlerFunc(handler)
+
+	var wg sync.WaitGroup
+	p.start(&wg)
+	time.Sleep(time.Second) // wait for all tasks to be processed.
+	p.terminate()
+
+	mu.Lock()
+	defer mu.Unlock()
+	// tenants are served in turn, regardless of the backlog of tenant:a.
+	want := []string{"tenant:a", "tenant:b", "tenant:c", "tenant:a", "tenant:a"}
+	if diff := cmp.Diff(want, processed); diff != "" {
+		t.Errorf("processed tasks in order %v, want %v; (-want,+got)\n%s", processed, want, diff)
+	}
+}
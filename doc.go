@@ -36,7 +36,9 @@ handler.
         Concurrency: 10,
     })
 
-    bg.Run(handler)
+    if err := bg.Run(handler); err != nil {
+        log.Fatal(err)
+    }
 
 Handler is an interface with one method ProcessTask which
 takes a task and returns an error. Handler should return nil if
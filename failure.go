@@ -0,0 +1,261 @@
+// Copyright 2020 Kentaro Hibino. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package asynq
+
+import (
+	"sync"
+	"time"
+
+	"github.com/hibiken/asynq/internal/log"
+	"github.com/hibiken/asynq/internal/rdb"
+)
+
+// failureAgeBounds are the upper bounds of the age buckets of FailureStats.
+var failureAgeBounds = []time.Duration{
+	time.Minute,
+	10 * time.Minute,
+	time.Hour,
+	6 * time.Hour,
+	24 * time.Hour,
+	7 * 24 * time.Hour,
+}
+
+// FailureStats holds the statistics of the tasks which failed to be
+// processed, i.e. the tasks in retry and dead state, to notice failures
+// accumulating without anyone looking into them.
+type FailureStats struct {
+	// Retry and Dead are the number of tasks in retry and dead state.
+	Retry int
+	Dead  int
+
+	// OldestRetryAge is the time since the oldest retry task failed for the
+	// first time, and OldestDeadAge is the time since the oldest dead task
+	// was moved to the dead queue. Zero if there are no such tasks.
+	OldestRetryAge time.Duration
+	OldestDeadAge  time.Duration
+
+	// DeadInLastHour is the number of tasks moved to the dead queue
+	// within the last hour.
+	DeadInLastHour int
+
+	// RetryAges and DeadAges are the number of retry and dead tasks by age,
+	// from the youngest to the oldest, with the age of the tasks defined as
+	// for OldestRetryAge and OldestDeadAge.
+	RetryAges []AgeBucket
+	DeadAges  []AgeBucket
+}
+
+// AgeBucket is the number of tasks whose age is in a range.
+type AgeBucket struct {
+	// MaxAge is the upper bound of the age of the tasks, exclusive.
+	// Zero for the last bucket, which has no upper bound.
+	MaxAge time.Duration
+
+	Count int
+}
+
+// FailureStats returns the statistics of the tasks in retry and dead state.
+//
+// FailureStats reads the retry tasks to find the time they first failed.
+// If there are more than 1000 retry tasks, the age distribution of the retry
+// tasks is estimated from a random sample of 1000 of them, and OldestRetryAge
+// is the age of the oldest task of the sample.
+func (i *Inspector) FailureStats() (*FailureStats, error) {
+	return failureStats(i.rdb, time.Now())
+}
+
+func failureStats(r *rdb.RDB, now time.Time) (*FailureStats, error) {
+	ages, err := r.FailureAges(now, failureAgeBounds)
+	if err != nil {
+		return nil, err
+	}
+	stats := &FailureStats{
+		RetryAges: ageBuckets(ages.RetryCounts),
+		DeadAges:  ageBuckets(ages.DeadCounts),
+	}
+	for _, b := range stats.RetryAges {
+		stats.Retry += b.Count
+	}
+	for _, b := range stats.DeadAges {
+		stats.Dead += b.Count
+		if b.MaxAge != 0 && b.MaxAge <= time.Hour {
+			stats.DeadInLastHour += b.Count
+		}
+	}
+	if !ages.OldestRetry.IsZero() {
+		stats.OldestRetryAge = nonNegative(now.Sub(ages.OldestRetry))
+	}
+	if !ages.OldestDead.IsZero() {
+		stats.OldestDeadAge = nonNegative(now.Sub(ages.OldestDead))
+	}
+	return stats, nil
+}
+
+// ageBuckets returns the buckets of the task counts by failureAgeBounds.
+func ageBuckets(counts []int64) []AgeBucket {
+	res := make([]AgeBucket, len(counts))
+	for i, n := range counts {
+		res[i].Count = int(n)
+		if i < len(failureAgeBounds) {
+			res[i].MaxAge = failureAgeBounds[i]
+		}
+	}
+	return res
+}
+
+func nonNegative(d time.Duration) time.Duration {
+	if d < 0 {
+		return 0
+	}
+	return d
+}
+
+// FailureAlarm specifies an alarm raised when failed tasks exceed
+// a threshold, see Config.OnFailureAlarm.
+type FailureAlarm int
+
+const (
+	// AlarmDeadTaskAge is raised when the oldest dead task was moved to the
+	// dead queue longer ago than Config.DeadTaskAgeAlarm.
+	AlarmDeadTaskAge FailureAlarm = iota
+
+	// AlarmDeadTasksPerHour is raised when more tasks than
+	// Config.DeadTasksPerHourAlarm were moved to the dead queue
+	// within the last hour.
+	AlarmDeadTasksPerHour
+
+	// AlarmRetryTaskAge is raised when the oldest retry task failed for the
+	// first time longer ago than Config.RetryTaskAgeAlarm.
+	AlarmRetryTaskAge
+)
+
+func (a FailureAlarm) String() string {
+	switch a {
+	case AlarmDeadTaskAge:
+		return "dead_task_age"
+	case AlarmDeadTasksPerHour:
+		return "dead_tasks_per_hour"
+	case AlarmRetryTaskAge:
+		return "retry_task_age"
+	}
+	return "unknown"
+}
+
+// failureThresholds holds the thresholds of the failure alarms.
+// Zero or negative threshold disables the alarm.
+type failureThresholds struct {
+	deadAge     time.Duration
+	deadPerHour int
+	retryAge    time.Duration
+}
+
+// exceeded returns the alarms whose threshold is exceeded by the stats.
+func (t failureThresholds) exceeded(s *FailureStats) map[FailureAlarm]bool {
+	res := make(map[FailureAlarm]bool)
+	if t.deadAge > 0 && s.OldestDeadAge > t.deadAge {
+		res[AlarmDeadTaskAge] = true
+	}
+	if t.deadPerHour > 0 && s.DeadInLastHour > t.deadPerHour {
+		res[AlarmDeadTasksPerHour] = true
+	}
+	if t.retryAge > 0 && s.OldestRetryAge > t.retryAge {
+		res[AlarmRetryTaskAge] = true
+	}
+	return res
+}
+
+// failureMonitor is responsible for periodically checking the failed tasks
+// and raising the alarms whose threshold is crossed.
+//
+// Only one failure monitor at a time checks the failed tasks, while the
+// others wait to take over in case it stops.
+type failureMonitor struct {
+	logger *log.Logger
+	rdb    *rdb.RDB
+
+	// owner identifies the failure monitor holding the lock to check.
+	owner string
+
+	thresholds failureThresholds
+	onAlarm    func(alarm FailureAlarm, stats *FailureStats)
+
+	// alarms whose threshold was exceeded at the last check.
+	raised map[FailureAlarm]bool
+
+	// channel to communicate back to the long running "failure monitor" goroutine.
+	done chan struct{}
+
+	// interval between checks.
+	interval time.Duration
+}
+
+func newFailureMonitor(l *log.Logger, rdb *rdb.RDB, owner string, thresholds failureThresholds,
+	onAlarm func(FailureAlarm, *FailureStats), interval time.Duration) *failureMonitor {
+	return &failureMonitor{
+		logger:     l,
+		rdb:        rdb,
+		owner:      owner,
+		thresholds: thresholds,
+		onAlarm:    onAlarm,
+		raised:     make(map[FailureAlarm]bool),
+		done:       make(chan struct{}),
+		interval:   interval,
+	}
+}
+
+func (m *failureMonitor) terminate() {
+	m.logger.Info("Failure monitor shutting down...")
+	// Signal the failure monitor goroutine to stop.
+	m.done <- struct{}{}
+}
+
+func (m *failureMonitor) start(wg *sync.WaitGroup) {
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-m.done:
+				// let another failure monitor take over right away.
+				if err := m.rdb.ReleaseFailureCheckLock(m.owner); err != nil {
+					m.logger.Error("could not release failure check lock: %v", err)
+				}
+				m.logger.Info("Failure monitor done")
+				return
+			case <-time.After(m.interval):
+				m.exec()
+			}
+		}
+	}()
+}
+
+func (m *failureMonitor) exec() {
+	// Note: Set TTL to be long enough so that the lock doesn't expire
+	// before we check again.
+	ok, err := m.rdb.AcquireFailureCheckLock(m.owner, 3*m.interval)
+	if err != nil {
+		m.logger.Error("Could not acquire failure check lock: %v", err)
+		return
+	}
+	if !ok {
+		// another process checks the failed tasks.
+		m.raised = make(map[FailureAlarm]bool)
+		return
+	}
+	stats, err := failureStats(m.rdb, time.Now())
+	if err != nil {
+		m.logger.Error("Could not check failed tasks: %v", err)
+		return
+	}
+	exceeded := m.thresholds.exceeded(stats)
+	for _, alarm := range []FailureAlarm{AlarmDeadTaskAge, AlarmDeadTasksPerHour, AlarmRetryTaskAge} {
+		if exceeded[alarm] && !m.raised[alarm] {
+			m.logger.Warn("Failure alarm %v raised: %d retry tasks, %d dead tasks, %d dead in the last hour",
+				alarm, stats.Retry, stats.Dead, stats.DeadInLastHour)
+			m.onAlarm(alarm, stats)
+		}
+	}
+	m.raised = exceeded
+}
@@ -0,0 +1,180 @@
+// Copyright 2020 Kentaro Hibino. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package asynq
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	h "github.com/hibiken/asynq/internal/asynqtest"
+	"github.com/hibiken/asynq/internal/base"
+	"github.com/hibiken/asynq/internal/rdb"
+)
+
+func TestInspectorFailureStats(t *testing.T) {
+	r := setup(t)
+	inspector := NewInspector(RedisClientOpt{
+		Addr: redisAddr,
+		DB:   redisDB,
+	})
+	defer inspector.Close()
+
+	m1 := h.NewTaskMessage("send_email", nil)
+	m1.Retried = 3
+	m1.FailedAt = time.Now().Add(-2 * time.Hour).Unix()
+	m2 := h.NewTaskMessage("reindex", nil)
+	m3 := h.NewTaskMessage("reindex", nil)
+	m4 := h.NewTaskMessage("generate_csv", nil)
+	h.SeedInProgressQueue(t, r, []*base.TaskMessage{m1})
+	if err := rdb.NewRDB(r).Retry(m1, time.Now().Add(time.Minute), "error"); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	h.SeedDeadQueue(t, r, []h.ZSetEntry{
+		{Msg: m2, Score: float64(now.Add(-5 * time.Minute).Unix())},
+		{Msg: m3, Score: float64(now.Add(-30 * time.Minute).Unix())},
+		{Msg: m4, Score: float64(now.Add(-72 * time.Hour).Unix())},
+	})
+
+	got, err := inspector.FailureStats()
+	if err != nil {
+		t.Fatalf("FailureStats returned error: %v", err)
+	}
+	if got.Retry != 1 || got.Dead != 3 || got.DeadInLastHour != 2 {
+		t.Errorf("FailureStats() = %d retry, %d dead, %d dead in last hour; want 1, 3, 2",
+			got.Retry, got.Dead, got.DeadInLastHour)
+	}
+	if d := got.OldestRetryAge - 2*time.Hour; d < 0 || d > 5*time.Second {
+		t.Errorf("FailureStats().OldestRetryAge = %v, want about 2h", got.OldestRetryAge)
+	}
+	if d := got.OldestDeadAge - 72*time.Hour; d < 0 || d > 5*time.Second {
+		t.Errorf("FailureStats().OldestDeadAge = %v, want about 72h", got.OldestDeadAge)
+	}
+	wantDead := []AgeBucket{
+		{time.Minute, 0},
+		{10 * time.Minute, 1},
+		{time.Hour, 1},
+		{6 * time.Hour, 0},
+		{24 * time.Hour, 0},
+		{7 * 24 * time.Hour, 1},
+		{0, 0},
+	}
+	if diff := cmp.Diff(wantDead, got.DeadAges); diff != "" {
+		t.Errorf("FailureStats().DeadAges mismatch; (-want,+got)\n%s", diff)
+	}
+	if len(got.RetryAges) != len(wantDead) || got.RetryAges[3].Count != 1 {
+		t.Errorf("FailureStats().RetryAges = %v, want the task in the bucket up to 6h", got.RetryAges)
+	}
+}
+
+func TestFailureMonitor(t *testing.T) {
+	r := setup(t)
+	now := time.Now()
+	m1 := h.NewTaskMessage("send_email", nil)
+	m2 := h.NewTaskMessage("send_email", nil)
+	m3 := h.NewTaskMessage("reindex", nil)
+	h.SeedDeadQueue(t, r, []h.ZSetEntry{
+		{Msg: m1, Score: float64(now.Add(-time.Minute).Unix())},
+		{Msg: m2, Score: float64(now.Add(-2 * time.Minute).Unix())},
+		{Msg: m3, Score: float64(now.Add(-48 * time.Hour).Unix())},
+	})
+
+	var (
+		mu     sync.Mutex
+		alarms []FailureAlarm
+	)
+	onAlarm := func(alarm FailureAlarm, stats *FailureStats) {
+		mu.Lock()
+		alarms = append(alarms, alarm)
+		mu.Unlock()
+	}
+	thresholds := failureThresholds{deadAge: 24 * time.Hour, deadPerHour: 1, retryAge: time.Hour}
+	const interval = 100 * time.Millisecond
+	m := newFailureMonitor(testLogger, rdb.NewRDB(r), "localhost:1234", thresholds, onAlarm, interval)
+	var wg sync.WaitGroup
+	m.start(&wg)
+	defer func() {
+		m.terminate()
+		wg.Wait()
+	}()
+
+	// alarms are raised once while the thresholds stay exceeded.
+	time.Sleep(5 * interval)
+	mu.Lock()
+	if diff := cmp.Diff([]FailureAlarm{AlarmDeadTaskAge, AlarmDeadTasksPerHour}, alarms); diff != "" {
+		t.Errorf("raised alarms mismatch; (-want,+got)\n%s", diff)
+	}
+	alarms = nil
+	mu.Unlock()
+
+	// alarms are raised again once the thresholds are crossed again.
+	h.FlushDB(t, r)
+	time.Sleep(3 * interval)
+	h.SeedDeadQueue(t, r, []h.ZSetEntry{{Msg: m3, Score: float64(now.Add(-48 * time.Hour).Unix())}})
+	time.Sleep(3 * interval)
+	mu.Lock()
+	if diff := cmp.Diff([]FailureAlarm{AlarmDeadTaskAge}, alarms); diff != "" {
+		t.Errorf("raised alarms after the thresholds are crossed again mismatch; (-want,+got)\n%s", diff)
+	}
+	mu.Unlock()
+}
+
+func TestFailureMonitorRetryTaskAge(t *testing.T) {
+	r := setup(t)
+	rdbClient := rdb.NewRDB(r)
+	msg := h.NewTaskMessage("send_email", nil)
+	msg.Retried = 10
+	msg.FailedAt = time.Now().Add(-2 * time.Hour).Unix()
+	h.SeedInProgressQueue(t, r, []*base.TaskMessage{msg})
+	// the task is retried again in an hour.
+	if err := rdbClient.Retry(msg, time.Now().Add(time.Hour), "error"); err != nil {
+		t.Fatal(err)
+	}
+
+	var alarms []FailureAlarm
+	onAlarm := func(alarm FailureAlarm, stats *FailureStats) {
+		alarms = append(alarms, alarm)
+	}
+	thresholds := failureThresholds{retryAge: time.Hour}
+	m := newFailureMonitor(testLogger, rdbClient, "localhost:1234", thresholds, onAlarm, time.Minute)
+	m.exec()
+	if diff := cmp.Diff([]FailureAlarm{AlarmRetryTaskAge}, alarms); diff != "" {
+		t.Errorf("raised alarms mismatch; (-want,+got)\n%s", diff)
+	}
+}
+
+func TestFailureMonitorWithLockHeldByAnotherProcess(t *testing.T) {
+	r := setup(t)
+	rdbClient := rdb.NewRDB(r)
+	h.SeedDeadQueue(t, r, []h.ZSetEntry{
+		{Msg: h.NewTaskMessage("reindex", nil), Score: float64(time.Now().Add(-48 * time.Hour).Unix())},
+	})
+
+	var alarms []FailureAlarm
+	onAlarm := func(alarm FailureAlarm, stats *FailureStats) {
+		alarms = append(alarms, alarm)
+	}
+	thresholds := failureThresholds{deadAge: 24 * time.Hour}
+	m := newFailureMonitor(testLogger, rdbClient, "localhost:1234", thresholds, onAlarm, time.Minute)
+	if ok, err := rdbClient.AcquireFailureCheckLock("localhost:5678", time.Minute); !ok || err != nil {
+		t.Fatalf("AcquireFailureCheckLock() = %t, %v; want true, nil", ok, err)
+	}
+
+	m.exec()
+	if len(alarms) != 0 {
+		t.Errorf("alarms %v raised while another process holds the lock, want none", alarms)
+	}
+
+	// the monitor takes over once the lock is released.
+	if err := rdbClient.ReleaseFailureCheckLock("localhost:5678"); err != nil {
+		t.Fatal(err)
+	}
+	m.exec()
+	if diff := cmp.Diff([]FailureAlarm{AlarmDeadTaskAge}, alarms); diff != "" {
+		t.Errorf("raised alarms after the lock is released mismatch; (-want,+got)\n%s", diff)
+	}
+}
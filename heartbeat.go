@@ -54,7 +54,12 @@ func (h *heartbeater) start(wg *sync.WaitGroup) {
 		for {
 			select {
 			case <-h.done:
-				h.rdb.ClearProcessState(h.ps)
+				h.writeDequeueStats()
+				// clear the process and worker info right away, instead of
+				// letting them expire, so that the process is no longer listed.
+				if err := h.rdb.ClearProcessState(h.ps); err != nil {
+					h.logger.Error("could not clear heartbeat data: %v", err)
+				}
 				h.logger.Info("Heartbeater done")
 				return
 			case <-time.After(h.interval):
@@ -71,4 +76,16 @@ func (h *heartbeater) beat() {
 	if err != nil {
 		h.logger.Error("could not write heartbeat data: %v", err)
 	}
+	h.writeDequeueStats()
+}
+
+// writeDequeueStats adds the statistics of the queries of the queues
+// recorded since the last write to the statistics in redis.
+func (h *heartbeater) writeDequeueStats() {
+	stats := h.ps.TakeDequeueStats()
+	if err := h.rdb.RecordDequeueStats(stats); err != nil {
+		h.logger.Error("could not write dequeue stats: %v", err)
+		// keep them to write with the next beat.
+		h.ps.RestoreDequeueStats(stats)
+	}
 }
@@ -99,5 +99,16 @@ func TestHeartbeater(t *testing.T) {
 		}
 
 		hb.terminate()
+		wg.Wait()
+
+		// process state should be cleared on shutdown.
+		ps, err = rdbClient.ListProcesses()
+		if err != nil {
+			t.Errorf("could not read process status from redis: %v", err)
+			continue
+		}
+		if len(ps) != 0 {
+			t.Errorf("(*RDB).ListProcesses returned %d process info after shutdown, want 0", len(ps))
+		}
 	}
 }
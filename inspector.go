@@ -0,0 +1,1001 @@
+// Copyright 2020 Kentaro Hibino. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package asynq
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hibiken/asynq/internal/base"
+	"github.com/hibiken/asynq/internal/rdb"
+	"github.com/rs/xid"
+)
+
+// Inspector is a client interface to inspect and mutate the state of
+// queues, tasks and background processes.
+//
+// Inspectors are safe for concurrent use by multiple goroutines.
+type Inspector struct {
+	rdb *rdb.RDB
+}
+
+// NewInspector returns a new Inspector given a redis connection option.
+func NewInspector(r RedisConnOpt) *Inspector {
+	return &Inspector{rdb.NewRDB(createRedisClient(r))}
+}
+
+// Close closes the connection with redis server.
+func (i *Inspector) Close() error {
+	return i.rdb.Close()
+}
+
+// ErrProcessNotFound indicates that a background process that matches
+// the given host and pid was not found.
+var ErrProcessNotFound = rdb.ErrProcessNotFound
+
+// PauseProcess tells the background process identified by host and pid
+// to stop pulling new tasks from queues. Tasks that are already in progress
+// are not affected.
+//
+// Unlike sending SIGTSTP to the process, a paused process can be resumed
+// by calling ResumeProcess.
+//
+// If the process is not running, it returns ErrProcessNotFound.
+func (i *Inspector) PauseProcess(host string, pid int) error {
+	return i.rdb.PublishControl(host, pid, base.PauseCommand)
+}
+
+// ResumeProcess tells the paused background process identified by
+// host and pid to start pulling new tasks from queues again.
+//
+// If the process is not running, it returns ErrProcessNotFound.
+func (i *Inspector) ResumeProcess(host string, pid int) error {
+	return i.rdb.PublishControl(host, pid, base.ResumeCommand)
+}
+
+// QueueLatencies returns the latency of each queue, keyed by queue name.
+//
+// Latency of a queue is the time elapsed since the oldest enqueued task
+// in the queue was enqueued, or was scheduled to be enqueued if the task was
+// a scheduled or retry task. Latency of an empty queue is zero.
+//
+// A growing latency indicates that tasks are enqueued faster than they are processed.
+func (i *Inspector) QueueLatencies() (map[string]time.Duration, error) {
+	return i.rdb.Latencies()
+}
+
+// DequeueStats holds the statistics of the queries of a queue by the
+// background processes to dequeue tasks, to tune the priority of queues.
+type DequeueStats struct {
+	Queue string
+
+	// Attempts is the number of times the queue was queried for a task,
+	// and Hits is the number of times a task was dequeued from the queue.
+	//
+	// A queue with few attempts compared to other queues is rarely selected,
+	// e.g. because of its low priority. A queue with a low ratio of hits to
+	// attempts is often selected while it has no tasks.
+	Attempts int64
+	Hits     int64
+
+	// Size is the number of tasks in the queue.
+	Size int
+
+	// LastQueried is the time the queue was last queried by any process.
+	// Zero if the queue was never queried.
+	LastQueried time.Time
+
+	// Starvation is how long the queue has had tasks to process without
+	// being queried: the time elapsed since the queue was last queried,
+	// or since its oldest task was enqueued if more recent.
+	// Zero if the queue is empty.
+	Starvation time.Duration
+}
+
+// HitRate returns the ratio of hits to attempts, or zero if the queue
+// was never queried.
+func (s *DequeueStats) HitRate() float64 {
+	if s.Attempts == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Attempts)
+}
+
+// DequeueStats returns the statistics of the queries of each queue by the
+// background processes since the statistics were first recorded,
+// sorted by queue name.
+//
+// Statistics are written to redis by each process with its heartbeat,
+// so the latest queries may not be counted yet.
+// The shards of a queue are reported as the queue.
+func (i *Inspector) DequeueStats() ([]*DequeueStats, error) {
+	names, err := i.rdb.QueueNames()
+	if err != nil {
+		return nil, err
+	}
+	latencies, err := i.rdb.Latencies()
+	if err != nil {
+		return nil, err
+	}
+	// shards are queried as the queue they are shards of.
+	shards := make(map[string][]string)
+	var qnames []string
+	for _, name := range names {
+		qname := base.UnshardQueue(name)
+		if _, ok := shards[qname]; !ok {
+			qnames = append(qnames, qname)
+		}
+		shards[qname] = append(shards[qname], name)
+	}
+	stats, err := i.rdb.DequeueStats(qnames...)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	var res []*DequeueStats
+	for _, qname := range qnames {
+		size, err := i.rdb.EnqueuedCount(shards[qname]...)
+		if err != nil {
+			return nil, err
+		}
+		s := stats[qname]
+		ds := &DequeueStats{
+			Queue:       qname,
+			Attempts:    s.Attempts,
+			Hits:        s.Hits,
+			Size:        size,
+			LastQueried: s.LastQueried,
+		}
+		if size > 0 {
+			var latency time.Duration
+			for _, name := range shards[qname] {
+				if latencies[name] > latency {
+					latency = latencies[name]
+				}
+			}
+			ds.Starvation = latency
+			if !s.LastQueried.IsZero() {
+				// latency is zero if the enqueue time of the task is unknown.
+				if d := now.Sub(s.LastQueried); latency == 0 || d < latency {
+					ds.Starvation = d
+				}
+			}
+		}
+		res = append(res, ds)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Queue < res[j].Queue })
+	return res, nil
+}
+
+// MemoryUsage holds the estimated number of bytes used in redis by the tasks
+// of a queue, by state.
+type MemoryUsage struct {
+	Enqueued  int64
+	Scheduled int64
+	Retry     int64
+	Dead      int64
+}
+
+// Total returns the total number of bytes used by the tasks of the queue.
+func (u *MemoryUsage) Total() int64 {
+	return u.Enqueued + u.Scheduled + u.Retry + u.Dead
+}
+
+// QueueMemoryUsage estimates the memory used in redis by the enqueued,
+// scheduled, retry and dead tasks of the queue, to help plan the capacity of redis.
+//
+// The usage is estimated with the MEMORY USAGE command, which samples the
+// elements of large lists and sets. Since the scheduled, retry and dead tasks
+// of all queues are stored together, the usage of those is estimated from the
+// share of the queue in a random sample of the tasks, and is less accurate
+// when there are a lot of tasks.
+func (i *Inspector) QueueMemoryUsage(qname string) (*MemoryUsage, error) {
+	u, err := i.rdb.QueueMemoryUsage(qname)
+	if err != nil {
+		return nil, err
+	}
+	return &MemoryUsage{
+		Enqueued:  u.Enqueued,
+		Scheduled: u.Scheduled,
+		Retry:     u.Retry,
+		Dead:      u.Dead,
+	}, nil
+}
+
+// TaskTree describes a task and the tasks spawned by it with EnqueueFromHandler.
+type TaskTree struct {
+	// Task describes the task. For a task which is not found,
+	// e.g. a completed task of which no history is recorded,
+	// only ID and Queue are set.
+	Task *TaskInfo
+
+	// Children are the trees of the tasks spawned by the task,
+	// in the order they were spawned.
+	Children []*TaskTree
+}
+
+// GetTaskTree returns the tree of the task given its queue and ID,
+// and of the tasks spawned by it, recursively.
+//
+// Children are kept as long as the execution history of tasks, see
+// Config.TaskHistorySize. If the task is not found and has no children,
+// it returns ErrTaskNotFound.
+//
+// Note that GetTaskTree calls GetTaskInfo for each task of the tree,
+// so it may be slow for large trees.
+func (i *Inspector) GetTaskTree(qname, id string) (*TaskTree, error) {
+	tree, err := i.getTaskTree(qname, id, make(map[string]bool))
+	if err != nil {
+		return nil, err
+	}
+	if tree.Task.State == 0 && len(tree.Children) == 0 {
+		return nil, ErrTaskNotFound
+	}
+	return tree, nil
+}
+
+// getTaskTree returns the tree of the task, skipping the tasks already seen.
+func (i *Inspector) getTaskTree(qname, id string, seen map[string]bool) (*TaskTree, error) {
+	seen[id] = true
+	info, err := i.GetTaskInfo(qname, id)
+	if err == ErrTaskNotFound {
+		info = &TaskInfo{ID: id, Queue: strings.ToLower(qname)}
+	} else if err != nil {
+		return nil, err
+	}
+	refs, err := i.rdb.Children(id)
+	if err != nil {
+		return nil, err
+	}
+	tree := &TaskTree{Task: info}
+	for _, ref := range refs {
+		if seen[ref.ID] {
+			continue
+		}
+		child, err := i.getTaskTree(ref.Queue, ref.ID, seen)
+		if err != nil {
+			return nil, err
+		}
+		tree.Children = append(tree.Children, child)
+	}
+	return tree, nil
+}
+
+// TaskAttempt holds information about an attempt to process a task.
+type TaskAttempt struct {
+	// Host and PID identify the background process which processed the task.
+	Host string
+	PID  int
+
+	// Started is the time the attempt started.
+	Started time.Time
+
+	// Duration is the time it took to process the task.
+	Duration time.Duration
+
+	// ErrorMsg is the error returned by the handler.
+	// Empty if the attempt succeeded.
+	ErrorMsg string
+}
+
+// TaskHistory returns the recorded attempts to process the task given its ID,
+// in the order they happened.
+//
+// Attempts are recorded only by background processes with
+// Config.TaskHistorySize set to a positive value.
+// It returns an empty list if no attempts were recorded for the task.
+func (i *Inspector) TaskHistory(id string) ([]*TaskAttempt, error) {
+	attempts, err := i.rdb.TaskHistory(id)
+	if err != nil {
+		return nil, err
+	}
+	return newTaskAttempts(attempts), nil
+}
+
+func newTaskAttempts(attempts []*base.TaskAttempt) []*TaskAttempt {
+	var res []*TaskAttempt
+	for _, a := range attempts {
+		res = append(res, &TaskAttempt{
+			Host:     a.Host,
+			PID:      a.PID,
+			Started:  a.Started,
+			Duration: a.Duration,
+			ErrorMsg: a.ErrorMsg,
+		})
+	}
+	return res
+}
+
+// MoveTasks moves enqueued tasks whose type matches the given pattern
+// from one queue to another, and returns the number of tasks moved.
+// Tasks in the shards of the source queue are moved as well.
+//
+// The pattern syntax is the same as path.Match, for example "export:*"
+// matches all tasks whose type starts with "export:".
+// Empty pattern matches all tasks in the queue.
+//
+// Each task is moved atomically. Tasks which are dequeued by background
+// processes while moving the tasks are not moved.
+func (i *Inspector) MoveTasks(from, to, pattern string) (int, error) {
+	n, err := i.rdb.MoveTasks(from, to, pattern)
+	return int(n), err
+}
+
+// CancelTasksByType sends a cancelation signal to the goroutines processing
+// the in-progress tasks whose type matches the given pattern, in all background
+// processes, and returns the number of tasks signaled.
+//
+// The pattern syntax is the same as path.Match; use "*" to cancel all
+// in-progress tasks. Tasks which start processing after the call are not
+// canceled; use PauseProcess to keep the background processes from
+// processing more tasks.
+//
+// Handler implementation needs to be context aware for cancelation signal
+// to actually cancel the processing.
+func (i *Inspector) CancelTasksByType(pattern string) (int, error) {
+	n, err := i.rdb.CancelTasksByType(pattern)
+	return int(n), err
+}
+
+// ErrTaskNotFound indicates that a task that matches the given
+// identifier was not found.
+var ErrTaskNotFound = rdb.ErrTaskNotFound
+
+// KillEnqueuedTask moves the enqueued task with the given ID from the queue
+// to the dead queue without processing it, so that a task which keeps crashing
+// workers can be kept for later analysis.
+//
+// If the task is not found in the queue, it returns ErrTaskNotFound.
+func (i *Inspector) KillEnqueuedTask(qname, id string) error {
+	taskID, err := xid.FromString(id)
+	if err != nil {
+		return ErrTaskNotFound
+	}
+	return i.rdb.KillEnqueuedTask(qname, taskID)
+}
+
+// KillAllEnqueuedTasks moves all enqueued tasks in the queue and its shards
+// to the dead queue without processing them, and returns the number of tasks moved.
+func (i *Inspector) KillAllEnqueuedTasks(qname string) (int, error) {
+	n, err := i.rdb.KillAllEnqueuedTasks(qname)
+	return int(n), err
+}
+
+// RescheduleTask changes the time to process the scheduled task with the
+// given ID to processAt, keeping the ID and the rest of the task intact.
+// The time can be earlier or later than the original one; a time in the
+// past makes the task to be processed right away.
+//
+// If the task is not found in the scheduled tasks of the queue or its shards,
+// it returns ErrTaskNotFound.
+func (i *Inspector) RescheduleTask(qname, id string, processAt time.Time) error {
+	taskID, err := xid.FromString(id)
+	if err != nil {
+		return ErrTaskNotFound
+	}
+	return i.rdb.RescheduleTask(qname, taskID, processAt)
+}
+
+// ErrQueueNotFound indicates that the specified queue does not exist.
+type ErrQueueNotFound = rdb.ErrQueueNotFound
+
+// ErrQueueNotEmpty indicates that the specified queue has tasks.
+type ErrQueueNotEmpty = rdb.ErrQueueNotEmpty
+
+// DeleteQueue removes the specified queue and its shards along with all of
+// its enqueued, scheduled, retry and dead tasks.
+//
+// If force is set to false, it removes the queue only if the queue has
+// no tasks, and returns *ErrQueueNotEmpty otherwise.
+// If the queue does not exist, it returns *ErrQueueNotFound.
+//
+// Note that tasks in progress are not affected.
+func (i *Inspector) DeleteQueue(qname string, force bool) error {
+	return i.rdb.RemoveQueue(qname, force)
+}
+
+// TaskState denotes the state of a task.
+type TaskState int
+
+const (
+	// TaskStateEnqueued indicates that the task is in a queue
+	// and ready to be processed.
+	TaskStateEnqueued TaskState = iota + 1
+
+	// TaskStateInProgress indicates that the task is being processed.
+	TaskStateInProgress
+
+	// TaskStateScheduled indicates that the task is scheduled
+	// to be processed in the future.
+	TaskStateScheduled
+
+	// TaskStateRetry indicates that the task failed and
+	// is scheduled to be retried.
+	TaskStateRetry
+
+	// TaskStateDead indicates that the task exhausted its retries
+	// or was killed, and will not be processed.
+	TaskStateDead
+
+	// TaskStateCompleted indicates that the task was processed successfully.
+	TaskStateCompleted
+)
+
+func (s TaskState) String() string {
+	switch s {
+	case TaskStateEnqueued:
+		return "enqueued"
+	case TaskStateInProgress:
+		return "in_progress"
+	case TaskStateScheduled:
+		return "scheduled"
+	case TaskStateRetry:
+		return "retry"
+	case TaskStateDead:
+		return "dead"
+	case TaskStateCompleted:
+		return "completed"
+	}
+	return "unknown"
+}
+
+var taskStates = map[string]TaskState{
+	rdb.TaskStateEnqueued:   TaskStateEnqueued,
+	rdb.TaskStateInProgress: TaskStateInProgress,
+	rdb.TaskStateScheduled:  TaskStateScheduled,
+	rdb.TaskStateRetry:      TaskStateRetry,
+	rdb.TaskStateDead:       TaskStateDead,
+	rdb.TaskStateCompleted:  TaskStateCompleted,
+}
+
+// TaskInfo describes a task and its current state.
+type TaskInfo struct {
+	ID      string
+	Queue   string
+	Type    string
+	Payload Payload
+
+	// State is the current state of the task.
+	State TaskState
+
+	// NextProcessAt is the time the task is scheduled to be processed.
+	// Zero unless the task is in scheduled or retry state.
+	NextProcessAt time.Time
+
+	// LastFailedAt is the time the task was moved to dead state.
+	// Zero unless the task is in dead state.
+	LastFailedAt time.Time
+
+	// LastErr is the error message from the last failure, if any.
+	LastErr string
+
+	// Retried is the number of times the task has been retried so far,
+	// and MaxRetry is the max number of retries for the task.
+	Retried  int
+	MaxRetry int
+
+	// Restored is the number of times the task was restored to its queue
+	// because its processing was not finished, and MaxAttempts is the max
+	// number of times the task is processed. MaxAttempts is zero if there
+	// is no limit.
+	Restored    int
+	MaxAttempts int
+
+	// Progress is the JSON encoded progress of the task last reported
+	// by the handler with ReportProgress.
+	// Nil unless the task is in progress and the handler reported its progress.
+	Progress []byte
+
+	// ParentID is the ID of the task which spawned the task with
+	// EnqueueFromHandler, and RootID is the ID of the first task of
+	// the lineage. Both are empty if the task was not spawned by a task.
+	ParentID string
+	RootID   string
+
+	// Labels are the labels attached to the task with the Labels option.
+	Labels map[string]string
+}
+
+// GetTaskInfo returns information about the task given its queue and ID.
+//
+// Since tasks are deleted once processed successfully, TaskStateCompleted is
+// reported only by background processes with Config.TaskHistorySize set to a
+// positive value. For completed tasks, only ID, Queue and State are set.
+//
+// If the task is not found, it returns ErrTaskNotFound.
+//
+// Note that GetTaskInfo scans the tasks in every state, so it may be slow
+// when there are a lot of tasks.
+func (i *Inspector) GetTaskInfo(qname, id string) (*TaskInfo, error) {
+	taskID, err := xid.FromString(id)
+	if err != nil {
+		return nil, ErrTaskNotFound
+	}
+	info, err := i.rdb.GetTaskInfo(qname, taskID)
+	if err != nil {
+		return nil, err
+	}
+	res := newTaskInfo(id, qname, info)
+	if res.State == TaskStateInProgress {
+		p, err := i.rdb.Progress(id)
+		if err != nil {
+			return nil, err
+		}
+		// ignore the progress reported by the earlier attempts.
+		if p != nil && info.Msg != nil && p.Retried == info.Msg.Retried {
+			res.Progress = p.Data
+		}
+	}
+	return res, nil
+}
+
+func newTaskInfo(id, qname string, info *rdb.TaskInfo) *TaskInfo {
+	res := &TaskInfo{
+		ID:    id,
+		Queue: strings.ToLower(base.UnshardQueue(qname)),
+		State: taskStates[info.State],
+	}
+	if msg := info.Msg; msg != nil {
+		res.Type = msg.Type
+		res.Payload = Payload{msg.Payload}
+		res.LastErr = msg.ErrorMsg
+		res.Retried = msg.Retried
+		res.MaxRetry = msg.Retry
+		res.Restored = msg.Restored
+		res.MaxAttempts = msg.MaxAttempts
+		res.ParentID = msg.ParentID
+		res.RootID = msg.RootID
+		res.Labels = copyLabels(msg.Labels)
+	}
+	switch res.State {
+	case TaskStateScheduled, TaskStateRetry:
+		res.NextProcessAt = time.Unix(info.Score, 0)
+	case TaskStateDead:
+		res.LastFailedAt = time.Unix(info.Score, 0)
+	}
+	return res
+}
+
+// TaskQuery specifies the tasks to list with Inspector.ListTasks.
+// Zero value of each filter matches all tasks.
+type TaskQuery struct {
+	// State is the state of the tasks to list. It is required and should be
+	// one of TaskStateEnqueued, TaskStateInProgress, TaskStateScheduled,
+	// TaskStateRetry or TaskStateDead.
+	State TaskState
+
+	// Queue is the queue of the tasks to list.
+	// It is required to list enqueued tasks.
+	Queue string
+
+	// Type is a pattern which the type of the tasks matches.
+	// The pattern syntax is the same as path.Match, e.g. "email:*".
+	Type string
+
+	// PayloadContains is a string contained in the JSON encoded payload
+	// of the tasks, e.g. `"customer_id":123`.
+	PayloadContains string
+
+	// PayloadFields are the values of fields of the payload of the tasks,
+	// keyed by the path of the field. A path is the keys of the nested
+	// objects joined by "." and array elements are specified by their
+	// index, e.g. "customer.id" or "items.0.sku".
+	//
+	// Values are compared with the string representation of the field
+	// value, e.g. "123" matches both 123 and "123".
+	PayloadFields map[string]string
+
+	// Labels are the values of the labels of the tasks, keyed by the
+	// label key. Empty value matches the tasks with the label set to
+	// any value.
+	Labels map[string]string
+
+	// ErrorContains is a string contained in the error message
+	// from the last failure of the tasks.
+	ErrorContains string
+
+	// EnqueuedAfter and EnqueuedBefore specify the range of the time
+	// the tasks were enqueued. For scheduled and retry tasks, it is the
+	// time the tasks are scheduled to be enqueued.
+	EnqueuedAfter  time.Time
+	EnqueuedBefore time.Time
+
+	// Size is the maximum number of tasks to list, 30 if zero.
+	Size int
+
+	// Cursor is the cursor returned by the previous call to ListTasks
+	// to list the next tasks. Empty string lists from the first task.
+	Cursor string
+}
+
+// TaskPage is a list of tasks returned by Inspector.ListTasks.
+type TaskPage struct {
+	// Tasks are the tasks which matched the query, in the order they are
+	// processed for enqueued tasks, in the order they are scheduled for
+	// scheduled and retry tasks, and in the order they failed for dead tasks.
+	Tasks []*TaskInfo
+
+	// Cursor is the cursor to list the next tasks,
+	// or empty string if all tasks were scanned.
+	Cursor string
+}
+
+const (
+	// defaultListSize is the number of tasks listed by ListTasks by default.
+	defaultListSize = 30
+
+	// listScanBatch is the number of tasks fetched from redis at a time by ListTasks.
+	listScanBatch = 1000
+
+	// listScanLimit is the maximum number of tasks scanned by a call to ListTasks.
+	listScanLimit = 20 * listScanBatch
+)
+
+var rdbTaskStates = map[TaskState]string{
+	TaskStateEnqueued:   rdb.TaskStateEnqueued,
+	TaskStateInProgress: rdb.TaskStateInProgress,
+	TaskStateScheduled:  rdb.TaskStateScheduled,
+	TaskStateRetry:      rdb.TaskStateRetry,
+	TaskStateDead:       rdb.TaskStateDead,
+}
+
+// ListTasks lists the tasks which matched the query, and returns the
+// cursor to list the next tasks.
+//
+// The tasks are filtered while scanning them in batches, and a call scans a
+// limited number of tasks so that it returns in a bounded time. So a page may
+// have fewer tasks than the size, or no tasks, but still have a cursor to
+// continue from. Scan all the tasks by calling ListTasks with the returned
+// cursor until it's empty:
+//
+//	q := &asynq.TaskQuery{State: asynq.TaskStateDead, PayloadFields: map[string]string{"customer_id": "123"}}
+//	for {
+//	    page, err := inspector.ListTasks(q)
+//	    if err != nil {
+//	        return err
+//	    }
+//	    // use page.Tasks
+//	    if page.Cursor == "" {
+//	        break
+//	    }
+//	    q.Cursor = page.Cursor
+//	}
+//
+// Enqueued tasks of a sharded queue are listed shard after shard.
+// A task which changes its state while listing the tasks may be skipped
+// or listed twice.
+func (i *Inspector) ListTasks(q *TaskQuery) (*TaskPage, error) {
+	state, ok := rdbTaskStates[q.State]
+	if !ok {
+		return nil, fmt.Errorf("asynq: cannot list tasks in %v state", q.State)
+	}
+	if q.State == TaskStateEnqueued && q.Queue == "" {
+		return nil, errors.New("asynq: queue is required to list enqueued tasks")
+	}
+	if _, err := path.Match(q.Type, ""); err != nil {
+		return nil, fmt.Errorf("asynq: invalid type pattern %q: %v", q.Type, err)
+	}
+	size := q.Size
+	if size <= 0 {
+		size = defaultListSize
+	}
+	var offset int64
+	if q.Cursor != "" {
+		n, err := strconv.ParseInt(q.Cursor, 36, 64)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("asynq: invalid cursor %q", q.Cursor)
+		}
+		offset = n
+	}
+	page := &TaskPage{}
+	for scanned := 0; scanned < listScanLimit; scanned += listScanBatch {
+		tasks, next, err := i.rdb.ScanTasks(state, q.Queue, offset, listScanBatch)
+		if err != nil {
+			return nil, err
+		}
+		for j, t := range tasks {
+			if !q.matches(t.Msg, t.Score) {
+				continue
+			}
+			page.Tasks = append(page.Tasks, newTaskInfo(t.Msg.ID.String(), t.Msg.Queue, t))
+			if len(page.Tasks) == size {
+				if j < len(tasks)-1 || next != 0 {
+					page.Cursor = strconv.FormatInt(offset+int64(j)+1, 36)
+				}
+				return page, nil
+			}
+		}
+		if next == 0 {
+			return page, nil
+		}
+		offset = next
+	}
+	page.Cursor = strconv.FormatInt(offset, 36)
+	return page, nil
+}
+
+// matches reports whether the task message with the given score matches the query.
+func (q *TaskQuery) matches(msg *base.TaskMessage, score int64) bool {
+	if q.Queue != "" && !strings.EqualFold(base.UnshardQueue(msg.Queue), q.Queue) {
+		return false
+	}
+	if q.Type != "" {
+		if ok, _ := path.Match(q.Type, msg.Type); !ok {
+			return false
+		}
+	}
+	if q.ErrorContains != "" && !strings.Contains(msg.ErrorMsg, q.ErrorContains) {
+		return false
+	}
+	if !q.EnqueuedAfter.IsZero() || !q.EnqueuedBefore.IsZero() {
+		enqueuedAt := enqueuedAt(msg, score, q.State)
+		if !q.EnqueuedAfter.IsZero() && enqueuedAt.Before(q.EnqueuedAfter) {
+			return false
+		}
+		if !q.EnqueuedBefore.IsZero() && !enqueuedAt.Before(q.EnqueuedBefore) {
+			return false
+		}
+	}
+	if q.PayloadContains != "" {
+		data, err := json.Marshal(msg.Payload)
+		if err != nil || !strings.Contains(string(data), q.PayloadContains) {
+			return false
+		}
+	}
+	for k, want := range q.Labels {
+		v, ok := msg.Labels[k]
+		if !ok || (want != "" && v != want) {
+			return false
+		}
+	}
+	for p, want := range q.PayloadFields {
+		v, ok := payloadField(msg.Payload, p)
+		if !ok || fieldString(v) != want {
+			return false
+		}
+	}
+	return true
+}
+
+// enqueuedAt returns the time the task was enqueued, or is scheduled to be
+// enqueued for scheduled and retry tasks.
+func enqueuedAt(msg *base.TaskMessage, score int64, state TaskState) time.Time {
+	switch {
+	case state == TaskStateScheduled || state == TaskStateRetry:
+		return time.Unix(score, 0)
+	case msg.EnqueuedAt != 0:
+		return time.Unix(msg.EnqueuedAt, 0)
+	}
+	// the time the task was created, for tasks enqueued by the older versions.
+	return msg.ID.Time()
+}
+
+// payloadField returns the value of the field of the payload at the given path.
+func payloadField(payload map[string]interface{}, p string) (interface{}, bool) {
+	var v interface{} = payload
+	for _, key := range strings.Split(p, ".") {
+		switch x := v.(type) {
+		case map[string]interface{}:
+			elem, ok := x[key]
+			if !ok {
+				return nil, false
+			}
+			v = elem
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(x) {
+				return nil, false
+			}
+			v = x[i]
+		default:
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+// fieldString returns the string representation of the payload field value,
+// formatting numbers without exponent so that e.g. 12345678 is "12345678".
+func fieldString(v interface{}) string {
+	if f, ok := v.(float64); ok {
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	return fmt.Sprint(v)
+}
+
+// exportedTask is a task written by ExportTasks, one per line.
+type exportedTask struct {
+	State   string          `json:"state"`
+	Score   int64           `json:"score,omitempty"`
+	Message json.RawMessage `json:"message"`
+}
+
+// exportStates are the states of the tasks exported by ExportTasks by default.
+var exportStates = []TaskState{TaskStateEnqueued, TaskStateScheduled, TaskStateRetry, TaskStateDead}
+
+// ExportTasks writes the tasks of the queue in the given states to w as
+// newline delimited JSON, one task per line, and returns the number of
+// tasks written. The tasks can be imported with ImportTasks, e.g. into
+// another redis instance.
+//
+// The states should be TaskStateEnqueued, TaskStateScheduled, TaskStateRetry
+// or TaskStateDead. If no states are given, tasks in all those states are written.
+//
+// Tasks in the shards of the queue are exported, and imported back into
+// their shards unless imported into another queue.
+// Tasks are exported as is, including the fields unknown to this version,
+// and keep their ID. Since tasks are not locked while exporting, a task which
+// changes its state while exporting the tasks may be skipped or written twice.
+func (i *Inspector) ExportTasks(w io.Writer, qname string, states ...TaskState) (int, error) {
+	if qname == "" {
+		return 0, errors.New("asynq: queue name is required to export tasks")
+	}
+	if len(states) == 0 {
+		states = exportStates
+	}
+	for _, s := range states {
+		if s == TaskStateInProgress || rdbTaskStates[s] == "" {
+			return 0, fmt.Errorf("asynq: cannot export tasks in %v state", s)
+		}
+	}
+	enc := json.NewEncoder(w)
+	var n int
+	for _, s := range states {
+		state := rdbTaskStates[s]
+		var offset int64
+		for {
+			tasks, next, err := i.rdb.ScanTasks(state, qname, offset, listScanBatch)
+			if err != nil {
+				return n, err
+			}
+			for _, t := range tasks {
+				if !strings.EqualFold(base.UnshardQueue(t.Msg.Queue), qname) {
+					continue
+				}
+				data, err := json.Marshal(t.Msg)
+				if err != nil {
+					return n, err
+				}
+				if err := enc.Encode(&exportedTask{State: state, Score: t.Score, Message: data}); err != nil {
+					return n, err
+				}
+				n++
+			}
+			if next == 0 {
+				break
+			}
+			offset = next
+		}
+	}
+	return n, nil
+}
+
+// ImportTasks reads the tasks written by ExportTasks from r and adds them in
+// their states, and returns the number of tasks added. If qname is not empty,
+// the tasks are added to the queue qname instead of their own queues.
+//
+// Tasks are added in batches, and each batch is added atomically. If an
+// error occurs, the tasks read before the error may have been added.
+// Note that importing the same tasks twice enqueues the enqueued tasks twice.
+func (i *Inspector) ImportTasks(r io.Reader, qname string) (int, error) {
+	if qname != "" {
+		if err := base.ValidateQueueName(qname); err != nil {
+			return 0, fmt.Errorf("asynq: %v", err)
+		}
+	}
+	dec := json.NewDecoder(r)
+	var (
+		n     int
+		batch []*rdb.TaskInfo
+	)
+	for line := 1; ; line++ {
+		var t exportedTask
+		err := dec.Decode(&t)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return n, fmt.Errorf("asynq: could not read task %d: %v", line, err)
+		}
+		var msg base.TaskMessage
+		if err := json.Unmarshal(t.Message, &msg); err != nil {
+			return n, fmt.Errorf("asynq: could not decode task %d: %v", line, err)
+		}
+		switch t.State {
+		case rdb.TaskStateEnqueued, rdb.TaskStateScheduled, rdb.TaskStateRetry, rdb.TaskStateDead:
+		default:
+			return n, fmt.Errorf("asynq: task %d: cannot import tasks in %q state", line, t.State)
+		}
+		if qname != "" {
+			msg.Queue = strings.ToLower(qname)
+		}
+		batch = append(batch, &rdb.TaskInfo{Msg: &msg, State: t.State, Score: t.Score})
+		if len(batch) == listScanBatch {
+			if err := i.rdb.ImportTasks(batch); err != nil {
+				return n, err
+			}
+			n += len(batch)
+			batch = batch[:0]
+		}
+	}
+	if err := i.rdb.ImportTasks(batch); err != nil {
+		return n, err
+	}
+	return n + len(batch), nil
+}
+
+// ReplicationStatus describes the replication of the tasks of redis into
+// the replica configured with Config.Replica.
+type ReplicationStatus struct {
+	// Enabled reports whether a background process is replicating the tasks.
+	Enabled bool
+
+	// Backlog is the number of changes, i.e. tasks enqueued or processed,
+	// which are not replicated yet.
+	Backlog int
+
+	// Lag is the time elapsed since the oldest change which is not
+	// replicated yet was made. Zero if all changes are replicated.
+	Lag time.Duration
+}
+
+// ReplicationStatus returns the status of the replication of the tasks
+// into the replica. Call it against the primary redis.
+func (i *Inspector) ReplicationStatus() (*ReplicationStatus, error) {
+	enabled, backlog, oldest, err := i.rdb.ReplicationInfo()
+	if err != nil {
+		return nil, err
+	}
+	s := &ReplicationStatus{Enabled: enabled, Backlog: int(backlog)}
+	if !oldest.IsZero() {
+		s.Lag = time.Since(oldest)
+	}
+	return s, nil
+}
+
+// ReplicaStatus describes the tasks kept by a replica.
+type ReplicaStatus struct {
+	// Tasks is the number of tasks which were enqueued to the primary
+	// redis and not processed successfully yet.
+	Tasks int
+
+	// LastSynced is the time the last change replicated to the replica was
+	// made in the primary redis. Zero if no changes were replicated.
+	LastSynced time.Time
+}
+
+// ReplicaStatus returns the status of the tasks kept by the replica
+// configured with Config.Replica. Call it against the replica.
+func (i *Inspector) ReplicaStatus() (*ReplicaStatus, error) {
+	tasks, synced, err := i.rdb.ReplicaInfo()
+	if err != nil {
+		return nil, err
+	}
+	return &ReplicaStatus{Tasks: int(tasks), LastSynced: synced}, nil
+}
+
+// PromoteReplica adds the tasks kept by the replica to their queues, in the
+// order they were enqueued, so that background processes connected to the
+// replica process them, and returns the number of tasks added.
+// Call it against the replica, once the primary redis is lost.
+//
+// Tasks are added in the state they were enqueued, either enqueued or
+// scheduled, and keep their ID. Since tasks are replicated asynchronously,
+// tasks enqueued or processed in the primary redis shortly before it was lost
+// may be missing or processed again.
+//
+// Tasks are added in batches. If an error occurs, calling PromoteReplica
+// again adds the rest of the tasks, but may add the last batch twice.
+func (i *Inspector) PromoteReplica() (int, error) {
+	n, err := i.rdb.PromoteReplica()
+	return int(n), err
+}
@@ -0,0 +1,477 @@
+// Copyright 2020 Kentaro Hibino. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package asynq
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	h "github.com/hibiken/asynq/internal/asynqtest"
+	"github.com/hibiken/asynq/internal/base"
+	"github.com/hibiken/asynq/internal/rdb"
+)
+
+func TestInspectorGetTaskInfo(t *testing.T) {
+	r := setup(t)
+	inspector := NewInspector(RedisClientOpt{
+		Addr: redisAddr,
+		DB:   redisDB,
+	})
+	defer inspector.Close()
+
+	m1 := h.NewTaskMessage("send_email", map[string]interface{}{"to": "user@example.com"})
+	m1.Retried = 2
+	m1.ErrorMsg = "SMTP server is not responding"
+	m2 := h.NewTaskMessageWithQueue("reindex", nil, "low")
+	m3 := h.NewTaskMessageWithQueue("send_push", nil, base.ShardQueue("push", 3))
+	retryAt := time.Now().Add(time.Hour).Truncate(time.Second)
+	failedAt := time.Now().Add(-time.Hour).Truncate(time.Second)
+	h.SeedRetryQueue(t, r, []h.ZSetEntry{{Msg: m1, Score: float64(retryAt.Unix())}})
+	h.SeedDeadQueue(t, r, []h.ZSetEntry{{Msg: m2, Score: float64(failedAt.Unix())}})
+	h.SeedEnqueuedQueue(t, r, []*base.TaskMessage{m3}, m3.Queue)
+
+	tests := []struct {
+		qname string
+		id    string
+		want  *TaskInfo
+	}{
+		{
+			qname: "default",
+			id:    m1.ID.String(),
+			want: &TaskInfo{
+				ID:            m1.ID.String(),
+				Queue:         "default",
+				Type:          "send_email",
+				Payload:       Payload{m1.Payload},
+				State:         TaskStateRetry,
+				NextProcessAt: retryAt,
+				LastErr:       m1.ErrorMsg,
+				Retried:       2,
+				MaxRetry:      m1.Retry,
+			},
+		},
+		{
+			qname: "LOW",
+			id:    m2.ID.String(),
+			want: &TaskInfo{
+				ID:           m2.ID.String(),
+				Queue:        "low",
+				Type:         "reindex",
+				Payload:      Payload{m2.Payload},
+				State:        TaskStateDead,
+				LastFailedAt: failedAt,
+				MaxRetry:     m2.Retry,
+			},
+		},
+		{
+			qname: "push", // task in a shard of the queue
+			id:    m3.ID.String(),
+			want: &TaskInfo{
+				ID:       m3.ID.String(),
+				Queue:    "push",
+				Type:     "send_push",
+				Payload:  Payload{m3.Payload},
+				State:    TaskStateEnqueued,
+				MaxRetry: m3.Retry,
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		got, err := inspector.GetTaskInfo(tc.qname, tc.id)
+		if err != nil {
+			t.Errorf("GetTaskInfo(%q, %q) returned error: %v", tc.qname, tc.id, err)
+			continue
+		}
+		if diff := cmp.Diff(tc.want, got, cmp.AllowUnexported(Payload{})); diff != "" {
+			t.Errorf("GetTaskInfo(%q, %q) = %+v, want %+v; (-want,+got)\n%s", tc.qname, tc.id, got, tc.want, diff)
+		}
+	}
+
+	if _, err := inspector.GetTaskInfo("default", "invalid-id"); err != ErrTaskNotFound {
+		t.Errorf("GetTaskInfo with invalid id returned error %v, want %v", err, ErrTaskNotFound)
+	}
+	if got, want := TaskStateInProgress.String(), "in_progress"; got != want {
+		t.Errorf("TaskStateInProgress.String() = %q, want %q", got, want)
+	}
+}
+
+func TestInspectorRescheduleTask(t *testing.T) {
+	r := setup(t)
+	inspector := NewInspector(RedisClientOpt{
+		Addr: redisAddr,
+		DB:   redisDB,
+	})
+	defer inspector.Close()
+
+	msg := h.NewTaskMessage("send_reminder", nil)
+	h.SeedScheduledQueue(t, r, []h.ZSetEntry{{Msg: msg, Score: float64(time.Now().Add(time.Hour).Unix())}})
+	processAt := time.Now().Add(3 * time.Hour).Truncate(time.Second)
+
+	if err := inspector.RescheduleTask("default", msg.ID.String(), processAt); err != nil {
+		t.Fatalf("RescheduleTask returned error: %v", err)
+	}
+	got, err := inspector.GetTaskInfo("default", msg.ID.String())
+	if err != nil {
+		t.Fatalf("GetTaskInfo returned error: %v", err)
+	}
+	if got.State != TaskStateScheduled || !got.NextProcessAt.Equal(processAt) {
+		t.Errorf("task after RescheduleTask: state=%v next process at=%v; want state=%v next process at=%v",
+			got.State, got.NextProcessAt, TaskStateScheduled, processAt)
+	}
+
+	if err := inspector.RescheduleTask("low", msg.ID.String(), processAt); err != ErrTaskNotFound {
+		t.Errorf("RescheduleTask with wrong queue = %v, want %v", err, ErrTaskNotFound)
+	}
+
+	sharded := h.NewTaskMessageWithQueue("send_reminder", nil, base.ShardQueue("reminders", 2))
+	h.SeedScheduledQueue(t, r, []h.ZSetEntry{{Msg: sharded, Score: float64(time.Now().Add(time.Hour).Unix())}})
+	if err := inspector.RescheduleTask("reminders", sharded.ID.String(), processAt); err != nil {
+		t.Errorf("RescheduleTask of a task in a shard of the queue returned error: %v", err)
+	}
+	if err := inspector.RescheduleTask("remind", sharded.ID.String(), processAt); err != ErrTaskNotFound {
+		t.Errorf("RescheduleTask with a prefix of the queue name = %v, want %v", err, ErrTaskNotFound)
+	}
+}
+
+func TestInspectorListTasks(t *testing.T) {
+	r := setup(t)
+	inspector := NewInspector(RedisClientOpt{
+		Addr: redisAddr,
+		DB:   redisDB,
+	})
+	defer inspector.Close()
+
+	now := time.Now().Truncate(time.Second)
+	m1 := h.NewTaskMessage("email:welcome", map[string]interface{}{"customer_id": 123, "to": "a@example.com"})
+	m1.ErrorMsg = "SMTP server is not responding"
+	m1.EnqueuedAt = now.Add(-3 * time.Hour).Unix()
+	m1.Labels = map[string]string{"tenant": "acme", "source": "signup"}
+	m2 := h.NewTaskMessage("email:welcome", map[string]interface{}{"customer_id": 456})
+	m2.ErrorMsg = "SMTP server is not responding"
+	m2.EnqueuedAt = now.Add(-2 * time.Hour).Unix()
+	m2.Labels = map[string]string{"tenant": "initech"}
+	m3 := h.NewTaskMessageWithQueue("report:daily", map[string]interface{}{
+		"customer": map[string]interface{}{"id": 12345678, "tags": []interface{}{"vip"}},
+	}, "low")
+	m3.ErrorMsg = "timed out"
+	m3.EnqueuedAt = now.Add(-time.Hour).Unix()
+	m4 := h.NewTaskMessage("email:reminder", map[string]interface{}{"customer_id": 123})
+	m4.EnqueuedAt = now.Add(-time.Hour).Unix()
+	h.SeedDeadQueue(t, r, []h.ZSetEntry{
+		{Msg: m1, Score: float64(now.Add(-50 * time.Minute).Unix())},
+		{Msg: m2, Score: float64(now.Add(-40 * time.Minute).Unix())},
+		{Msg: m3, Score: float64(now.Add(-30 * time.Minute).Unix())},
+	})
+	h.SeedEnqueuedQueue(t, r, []*base.TaskMessage{m4})
+
+	tests := []struct {
+		desc  string
+		query *TaskQuery
+		want  []string // IDs of the tasks
+	}{
+		{
+			desc:  "all dead tasks",
+			query: &TaskQuery{State: TaskStateDead},
+			want:  []string{m1.ID.String(), m2.ID.String(), m3.ID.String()},
+		},
+		{
+			desc:  "by queue",
+			query: &TaskQuery{State: TaskStateDead, Queue: "LOW"},
+			want:  []string{m3.ID.String()},
+		},
+		{
+			desc:  "by type pattern",
+			query: &TaskQuery{State: TaskStateDead, Type: "email:*"},
+			want:  []string{m1.ID.String(), m2.ID.String()},
+		},
+		{
+			desc:  "by payload substring",
+			query: &TaskQuery{State: TaskStateDead, PayloadContains: `"customer_id":456`},
+			want:  []string{m2.ID.String()},
+		},
+		{
+			desc:  "by payload fields",
+			query: &TaskQuery{State: TaskStateDead, PayloadFields: map[string]string{"customer.id": "12345678", "customer.tags.0": "vip"}},
+			want:  []string{m3.ID.String()},
+		},
+		{
+			desc:  "by labels",
+			query: &TaskQuery{State: TaskStateDead, Labels: map[string]string{"tenant": "acme"}},
+			want:  []string{m1.ID.String()},
+		},
+		{
+			desc:  "by label key",
+			query: &TaskQuery{State: TaskStateDead, Labels: map[string]string{"tenant": ""}},
+			want:  []string{m1.ID.String(), m2.ID.String()},
+		},
+		{
+			desc:  "by error message",
+			query: &TaskQuery{State: TaskStateDead, ErrorContains: "SMTP"},
+			want:  []string{m1.ID.String(), m2.ID.String()},
+		},
+		{
+			desc:  "by enqueue time",
+			query: &TaskQuery{State: TaskStateDead, EnqueuedAfter: now.Add(-150 * time.Minute), EnqueuedBefore: now.Add(-time.Hour)},
+			want:  []string{m2.ID.String()},
+		},
+		{
+			desc:  "enqueued tasks",
+			query: &TaskQuery{State: TaskStateEnqueued, Queue: "default", PayloadFields: map[string]string{"customer_id": "123"}},
+			want:  []string{m4.ID.String()},
+		},
+	}
+
+	for _, tc := range tests {
+		page, err := inspector.ListTasks(tc.query)
+		if err != nil {
+			t.Errorf("%s; ListTasks returned error: %v", tc.desc, err)
+			continue
+		}
+		var got []string
+		for _, task := range page.Tasks {
+			got = append(got, task.ID)
+		}
+		if diff := cmp.Diff(tc.want, got); diff != "" {
+			t.Errorf("%s; ListTasks returned tasks %v, want %v; (-want,+got)\n%s", tc.desc, got, tc.want, diff)
+		}
+		if page.Cursor != "" {
+			t.Errorf("%s; ListTasks returned cursor %q, want empty", tc.desc, page.Cursor)
+		}
+	}
+
+	// page through the tasks with the cursor.
+	q := &TaskQuery{State: TaskStateDead, Size: 2}
+	var got []string
+	for n := 0; n < 3; n++ {
+		page, err := inspector.ListTasks(q)
+		if err != nil {
+			t.Fatalf("ListTasks returned error: %v", err)
+		}
+		for _, task := range page.Tasks {
+			got = append(got, task.ID)
+		}
+		if page.Cursor == "" {
+			break
+		}
+		q.Cursor = page.Cursor
+	}
+	if want := []string{m1.ID.String(), m2.ID.String(), m3.ID.String()}; !cmp.Equal(want, got) {
+		t.Errorf("ListTasks with cursor listed %v, want %v", got, want)
+	}
+
+	errQueries := []*TaskQuery{
+		{State: TaskStateCompleted},
+		{State: TaskStateEnqueued},
+		{State: TaskStateDead, Type: "["},
+		{State: TaskStateDead, Cursor: "not a cursor!"},
+	}
+	for _, q := range errQueries {
+		if _, err := inspector.ListTasks(q); err == nil {
+			t.Errorf("ListTasks(%+v) returned nil error, want non-nil", q)
+		}
+	}
+}
+
+func TestInspectorExportImportTasks(t *testing.T) {
+	r := setup(t)
+	inspector := NewInspector(RedisClientOpt{
+		Addr: redisAddr,
+		DB:   redisDB,
+	})
+	defer inspector.Close()
+
+	m1 := h.NewTaskMessage("send_email", map[string]interface{}{"to": "user@example.com"})
+	m2 := h.NewTaskMessage("reindex", nil)
+	m3 := h.NewTaskMessage("sync", nil)
+	m3.ErrorMsg = "connection refused"
+	m4 := h.NewTaskMessageWithQueue("gen_thumbnail", nil, "low")
+	processAt := time.Now().Add(time.Hour).Unix()
+	failedAt := time.Now().Add(-time.Hour).Unix()
+	h.SeedEnqueuedQueue(t, r, []*base.TaskMessage{m1})
+	h.SeedScheduledQueue(t, r, []h.ZSetEntry{{Msg: m2, Score: float64(processAt)}})
+	h.SeedDeadQueue(t, r, []h.ZSetEntry{{Msg: m3, Score: float64(failedAt)}, {Msg: m4, Score: float64(failedAt)}})
+
+	var buf bytes.Buffer
+	n, err := inspector.ExportTasks(&buf, "default")
+	if err != nil {
+		t.Fatalf("ExportTasks returned error: %v", err)
+	}
+	if n != 3 {
+		t.Errorf("ExportTasks wrote %d tasks, want 3", n)
+	}
+	if lines := strings.Count(buf.String(), "\n"); lines != 3 {
+		t.Errorf("ExportTasks wrote %d lines, want 3", lines)
+	}
+
+	// import into another queue after the tasks are gone.
+	h.FlushDB(t, r)
+	n, err = inspector.ImportTasks(&buf, "restored")
+	if err != nil {
+		t.Fatalf("ImportTasks returned error: %v", err)
+	}
+	if n != 3 {
+		t.Errorf("ImportTasks added %d tasks, want 3", n)
+	}
+	for _, msg := range []*base.TaskMessage{m1, m2, m3} {
+		msg.Queue = "restored"
+	}
+	if diff := cmp.Diff([]*base.TaskMessage{m1}, h.GetEnqueuedMessages(t, r, "restored")); diff != "" {
+		t.Errorf("mismatch found in enqueued tasks; (-want,+got)\n%s", diff)
+	}
+	if diff := cmp.Diff([]h.ZSetEntry{{Msg: m2, Score: float64(processAt)}}, h.GetScheduledEntries(t, r)); diff != "" {
+		t.Errorf("mismatch found in scheduled tasks; (-want,+got)\n%s", diff)
+	}
+	if diff := cmp.Diff([]h.ZSetEntry{{Msg: m3, Score: float64(failedAt)}}, h.GetDeadEntries(t, r)); diff != "" {
+		t.Errorf("mismatch found in dead tasks; (-want,+got)\n%s", diff)
+	}
+
+	// tasks in the shards of a queue are exported and imported into their shards.
+	h.FlushDB(t, r)
+	s1 := h.NewTaskMessageWithQueue("send_push", nil, base.ShardQueue("push", 0))
+	s2 := h.NewTaskMessageWithQueue("send_push", nil, base.ShardQueue("push", 1))
+	h.SeedEnqueuedQueue(t, r, []*base.TaskMessage{s1}, s1.Queue)
+	h.SeedEnqueuedQueue(t, r, []*base.TaskMessage{s2}, s2.Queue)
+	buf.Reset()
+	if n, err := inspector.ExportTasks(&buf, "push"); n != 2 || err != nil {
+		t.Errorf("ExportTasks of a sharded queue = %d, %v, want 2, nil", n, err)
+	}
+	h.FlushDB(t, r)
+	if n, err := inspector.ImportTasks(&buf, ""); n != 2 || err != nil {
+		t.Errorf("ImportTasks of a sharded queue = %d, %v, want 2, nil", n, err)
+	}
+	for _, msg := range []*base.TaskMessage{s1, s2} {
+		if diff := cmp.Diff([]*base.TaskMessage{msg}, h.GetEnqueuedMessages(t, r, msg.Queue)); diff != "" {
+			t.Errorf("mismatch found in enqueued tasks of %q; (-want,+got)\n%s", msg.Queue, diff)
+		}
+	}
+
+	if _, err := inspector.ExportTasks(&buf, "default", TaskStateInProgress); err == nil {
+		t.Errorf("ExportTasks with in-progress state returned nil error, want non-nil")
+	}
+	if _, err := inspector.ImportTasks(strings.NewReader(`{"state":"dead","message":`), ""); err == nil {
+		t.Errorf("ImportTasks with truncated input returned nil error, want non-nil")
+	}
+}
+
+func TestInspectorDequeueStats(t *testing.T) {
+	r := setup(t)
+	inspector := NewInspector(RedisClientOpt{Addr: redisAddr, DB: redisDB})
+	defer inspector.Close()
+	now := time.Now()
+
+	m1 := h.NewTaskMessage("send_email", nil)
+	m1.EnqueuedAt = now.Add(-time.Hour).Unix()
+	m2 := h.NewTaskMessageWithQueue("gen_report", nil, "reports#0")
+	m2.EnqueuedAt = now.Add(-10 * time.Minute).Unix()
+	m3 := h.NewTaskMessageWithQueue("gen_report", nil, "reports#1")
+	m3.EnqueuedAt = now.Add(-time.Minute).Unix()
+	h.SeedEnqueuedQueue(t, r, []*base.TaskMessage{m1})
+	h.SeedEnqueuedQueue(t, r, []*base.TaskMessage{m2}, "reports#0")
+	h.SeedEnqueuedQueue(t, r, []*base.TaskMessage{m3}, "reports#1")
+	h.SeedEnqueuedQueue(t, r, []*base.TaskMessage{}, "low")
+
+	if err := rdb.NewRDB(r).RecordDequeueStats(map[string]*base.DequeueStats{
+		"default": {Attempts: 100, Hits: 25, LastQueried: now.Add(-5 * time.Second)},
+		"reports": {Attempts: 10, Hits: 10, LastQueried: now.Add(-time.Hour)},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := inspector.DequeueStats()
+	if err != nil {
+		t.Fatalf("DequeueStats returned error: %v", err)
+	}
+	if len(got) != 3 {
+		t.Fatalf("DequeueStats returned %d queues, want 3", len(got))
+	}
+	// reports was last queried an hour ago, but its oldest task was enqueued
+	// 10 minutes ago.
+	tests := []struct {
+		want       DequeueStats
+		starvation time.Duration
+		hitRate    float64
+	}{
+		{DequeueStats{Queue: "default", Attempts: 100, Hits: 25, Size: 1}, 5 * time.Second, 0.25},
+		{DequeueStats{Queue: "low"}, 0, 0},
+		{DequeueStats{Queue: "reports", Attempts: 10, Hits: 10, Size: 2}, 10 * time.Minute, 1},
+	}
+	for i, tc := range tests {
+		s := got[i]
+		if s.Queue != tc.want.Queue || s.Attempts != tc.want.Attempts || s.Hits != tc.want.Hits || s.Size != tc.want.Size {
+			t.Errorf("DequeueStats()[%d] = %+v, want %+v", i, s, tc.want)
+		}
+		if d := s.Starvation - tc.starvation; d < 0 || d > 2*time.Second {
+			t.Errorf("Starvation of %q = %v, want %v", s.Queue, s.Starvation, tc.starvation)
+		}
+		if s.HitRate() != tc.hitRate {
+			t.Errorf("HitRate() of %q = %v, want %v", s.Queue, s.HitRate(), tc.hitRate)
+		}
+	}
+}
+
+func TestInspectorCancelTasksByType(t *testing.T) {
+	setup(t)
+	redisOpt := RedisClientOpt{Addr: redisAddr, DB: redisDB}
+	client := NewClient(redisOpt)
+	inspector := NewInspector(redisOpt)
+	defer inspector.Close()
+
+	var (
+		mu       sync.Mutex
+		canceled []string
+	)
+	started := make(chan struct{}, 3)
+	release := make(chan struct{})
+	handler := func(ctx context.Context, task *Task) error {
+		started <- struct{}{}
+		select {
+		case <-ctx.Done():
+			mu.Lock()
+			canceled = append(canceled, task.Type)
+			mu.Unlock()
+			return ctx.Err()
+		case <-release:
+			return nil
+		}
+	}
+	bg := NewBackground(redisOpt, &Config{Concurrency: 3})
+	bg.start(HandlerFunc(handler))
+	defer bg.stop()
+	defer close(release)
+
+	for _, typename := range []string{"export:csv", "export:pdf", "reindex"} {
+		if err := client.Enqueue(NewTask(typename, nil)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		select {
+		case <-started:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("only %d tasks started processing", i)
+		}
+	}
+
+	n, err := inspector.CancelTasksByType("export:*")
+	if err != nil {
+		t.Fatalf("CancelTasksByType returned error: %v", err)
+	}
+	if n != 2 {
+		t.Errorf("CancelTasksByType(%q) = %d, want 2", "export:*", n)
+	}
+	time.Sleep(time.Second)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if diff := cmp.Diff([]string{"export:csv", "export:pdf"}, canceled, h.SortStringSliceOpt); diff != "" {
+		t.Errorf("canceled tasks mismatch; (-want,+got)\n%s", diff)
+	}
+}
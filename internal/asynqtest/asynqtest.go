@@ -72,6 +72,20 @@ var SortStringSliceOpt = cmp.Transformer("SortStringSlice", func(in []string) []
 // IgnoreIDOpt is an cmp.Option to ignore ID field in task messages when comparing.
 var IgnoreIDOpt = cmpopts.IgnoreFields(base.TaskMessage{}, "ID")
 
+// IgnoreEncodedOpt is an cmp.Option to ignore the encoding the task messages
+// were read with when comparing.
+var IgnoreEncodedOpt = cmpopts.IgnoreFields(base.TaskMessage{}, "Encoded")
+
+// ApproxEnqueuedAtOpt is an cmp.Option to compare the enqueue times of task
+// messages with a tolerance of one second, for the expected times computed
+// before or after the tasks were enqueued.
+var ApproxEnqueuedAtOpt = cmp.FilterPath(func(p cmp.Path) bool {
+	return p.Last().String() == ".EnqueuedAt"
+}, cmp.Comparer(func(x, y int64) bool {
+	d := x - y
+	return -1 <= d && d <= 1
+}))
+
 // NewTaskMessage returns a new instance of TaskMessage given a task type and payload.
 func NewTaskMessage(taskType string, payload map[string]interface{}) *base.TaskMessage {
 	return &base.TaskMessage{
@@ -141,7 +155,7 @@ func MustUnmarshalSlice(tb testing.TB, data []string) []*base.TaskMessage {
 }
 
 // FlushDB deletes all the keys of the currently selected DB.
-func FlushDB(tb testing.TB, r *redis.Client) {
+func FlushDB(tb testing.TB, r redis.UniversalClient) {
 	tb.Helper()
 	if err := r.FlushDB().Err(); err != nil {
 		tb.Fatal(err)
@@ -151,7 +165,7 @@ func FlushDB(tb testing.TB, r *redis.Client) {
 // SeedEnqueuedQueue initializes the specified queue with the given messages.
 //
 // If queue name option is not passed, it defaults to the default queue.
-func SeedEnqueuedQueue(tb testing.TB, r *redis.Client, msgs []*base.TaskMessage, queueOpt ...string) {
+func SeedEnqueuedQueue(tb testing.TB, r redis.UniversalClient, msgs []*base.TaskMessage, queueOpt ...string) {
 	tb.Helper()
 	queue := base.DefaultQueue
 	if len(queueOpt) > 0 {
@@ -162,30 +176,30 @@ func SeedEnqueuedQueue(tb testing.TB, r *redis.Client, msgs []*base.TaskMessage,
 }
 
 // SeedInProgressQueue initializes the in-progress queue with the given messages.
-func SeedInProgressQueue(tb testing.TB, r *redis.Client, msgs []*base.TaskMessage) {
+func SeedInProgressQueue(tb testing.TB, r redis.UniversalClient, msgs []*base.TaskMessage) {
 	tb.Helper()
 	seedRedisList(tb, r, base.InProgressQueue, msgs)
 }
 
 // SeedScheduledQueue initializes the scheduled queue with the given messages.
-func SeedScheduledQueue(tb testing.TB, r *redis.Client, entries []ZSetEntry) {
+func SeedScheduledQueue(tb testing.TB, r redis.UniversalClient, entries []ZSetEntry) {
 	tb.Helper()
 	seedRedisZSet(tb, r, base.ScheduledQueue, entries)
 }
 
 // SeedRetryQueue initializes the retry queue with the given messages.
-func SeedRetryQueue(tb testing.TB, r *redis.Client, entries []ZSetEntry) {
+func SeedRetryQueue(tb testing.TB, r redis.UniversalClient, entries []ZSetEntry) {
 	tb.Helper()
 	seedRedisZSet(tb, r, base.RetryQueue, entries)
 }
 
 // SeedDeadQueue initializes the dead queue with the given messages.
-func SeedDeadQueue(tb testing.TB, r *redis.Client, entries []ZSetEntry) {
+func SeedDeadQueue(tb testing.TB, r redis.UniversalClient, entries []ZSetEntry) {
 	tb.Helper()
 	seedRedisZSet(tb, r, base.DeadQueue, entries)
 }
 
-func seedRedisList(tb testing.TB, c *redis.Client, key string, msgs []*base.TaskMessage) {
+func seedRedisList(tb testing.TB, c redis.UniversalClient, key string, msgs []*base.TaskMessage) {
 	data := MustMarshalSlice(tb, msgs)
 	for _, s := range data {
 		if err := c.LPush(key, s).Err(); err != nil {
@@ -194,7 +208,7 @@ func seedRedisList(tb testing.TB, c *redis.Client, key string, msgs []*base.Task
 	}
 }
 
-func seedRedisZSet(tb testing.TB, c *redis.Client, key string, items []ZSetEntry) {
+func seedRedisZSet(tb testing.TB, c redis.UniversalClient, key string, items []ZSetEntry) {
 	for _, item := range items {
 		z := &redis.Z{Member: MustMarshal(tb, item.Msg), Score: float64(item.Score)}
 		if err := c.ZAdd(key, z).Err(); err != nil {
@@ -206,7 +220,7 @@ func seedRedisZSet(tb testing.TB, c *redis.Client, key string, items []ZSetEntry
 // GetEnqueuedMessages returns all task messages in the specified queue.
 //
 // If queue name option is not passed, it defaults to the default queue.
-func GetEnqueuedMessages(tb testing.TB, r *redis.Client, queueOpt ...string) []*base.TaskMessage {
+func GetEnqueuedMessages(tb testing.TB, r redis.UniversalClient, queueOpt ...string) []*base.TaskMessage {
 	tb.Helper()
 	queue := base.DefaultQueue
 	if len(queueOpt) > 0 {
@@ -216,58 +230,58 @@ func GetEnqueuedMessages(tb testing.TB, r *redis.Client, queueOpt ...string) []*
 }
 
 // GetInProgressMessages returns all task messages in the in-progress queue.
-func GetInProgressMessages(tb testing.TB, r *redis.Client) []*base.TaskMessage {
+func GetInProgressMessages(tb testing.TB, r redis.UniversalClient) []*base.TaskMessage {
 	tb.Helper()
 	return getListMessages(tb, r, base.InProgressQueue)
 }
 
 // GetScheduledMessages returns all task messages in the scheduled queue.
-func GetScheduledMessages(tb testing.TB, r *redis.Client) []*base.TaskMessage {
+func GetScheduledMessages(tb testing.TB, r redis.UniversalClient) []*base.TaskMessage {
 	tb.Helper()
 	return getZSetMessages(tb, r, base.ScheduledQueue)
 }
 
 // GetRetryMessages returns all task messages in the retry queue.
-func GetRetryMessages(tb testing.TB, r *redis.Client) []*base.TaskMessage {
+func GetRetryMessages(tb testing.TB, r redis.UniversalClient) []*base.TaskMessage {
 	tb.Helper()
 	return getZSetMessages(tb, r, base.RetryQueue)
 }
 
 // GetDeadMessages returns all task messages in the dead queue.
-func GetDeadMessages(tb testing.TB, r *redis.Client) []*base.TaskMessage {
+func GetDeadMessages(tb testing.TB, r redis.UniversalClient) []*base.TaskMessage {
 	tb.Helper()
 	return getZSetMessages(tb, r, base.DeadQueue)
 }
 
 // GetScheduledEntries returns all task messages and its score in the scheduled queue.
-func GetScheduledEntries(tb testing.TB, r *redis.Client) []ZSetEntry {
+func GetScheduledEntries(tb testing.TB, r redis.UniversalClient) []ZSetEntry {
 	tb.Helper()
 	return getZSetEntries(tb, r, base.ScheduledQueue)
 }
 
 // GetRetryEntries returns all task messages and its score in the retry queue.
-func GetRetryEntries(tb testing.TB, r *redis.Client) []ZSetEntry {
+func GetRetryEntries(tb testing.TB, r redis.UniversalClient) []ZSetEntry {
 	tb.Helper()
 	return getZSetEntries(tb, r, base.RetryQueue)
 }
 
 // GetDeadEntries returns all task messages and its score in the dead queue.
-func GetDeadEntries(tb testing.TB, r *redis.Client) []ZSetEntry {
+func GetDeadEntries(tb testing.TB, r redis.UniversalClient) []ZSetEntry {
 	tb.Helper()
 	return getZSetEntries(tb, r, base.DeadQueue)
 }
 
-func getListMessages(tb testing.TB, r *redis.Client, list string) []*base.TaskMessage {
+func getListMessages(tb testing.TB, r redis.UniversalClient, list string) []*base.TaskMessage {
 	data := r.LRange(list, 0, -1).Val()
 	return MustUnmarshalSlice(tb, data)
 }
 
-func getZSetMessages(tb testing.TB, r *redis.Client, zset string) []*base.TaskMessage {
+func getZSetMessages(tb testing.TB, r redis.UniversalClient, zset string) []*base.TaskMessage {
 	data := r.ZRange(zset, 0, -1).Val()
 	return MustUnmarshalSlice(tb, data)
 }
 
-func getZSetEntries(tb testing.TB, r *redis.Client, zset string) []ZSetEntry {
+func getZSetEntries(tb testing.TB, r redis.UniversalClient, zset string) []ZSetEntry {
 	data := r.ZRangeWithScores(zset, 0, -1).Val()
 	var entries []ZSetEntry
 	for _, z := range data {
@@ -6,11 +6,15 @@
 package base
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
+	"reflect"
 	"strings"
 	"sync"
 	"time"
+	"unicode"
 
 	"github.com/rs/xid"
 )
@@ -34,6 +38,30 @@ const (
 	DeadQueue       = "asynq:dead"                   // ZSET
 	InProgressQueue = "asynq:in_progress"            // LIST
 	CancelChannel   = "asynq:cancel"                 // PubSub channel
+	controlPrefix   = "asynq:control:"               // PubSub channel - asynq:control:<host>:<pid>
+	historyPrefix   = "asynq:history:"               // LIST   - asynq:history:<task_id>
+	progressPrefix  = "asynq:progress:"              // STRING - asynq:progress:<task_id>
+	completedPrefix = "asynq:completed:"             // STRING - asynq:completed:<idempotency_key>
+	childrenPrefix  = "asynq:children:"              // LIST   - asynq:children:<task_id>
+	dequeuePrefix   = "asynq:dequeue:"               // HASH   - asynq:dequeue:<qname>
+
+	FailureCheckLock = "asynq:failure_check:lock" // STRING - <host>:<pid> of the process checking failed tasks
+
+	ReplicationEnabled = "asynq:replication"      // STRING - set while tasks are replicated
+	ReplicationLock    = "asynq:replication:lock" // STRING - <host>:<pid> of the replicating process
+	ReplicationLog     = "asynq:replication:log"  // LIST   - changes not replicated yet
+	ReplicaTasks       = "asynq:replica:tasks"    // HASH   - task id -> replicated change, in replica
+	ReplicaOrder       = "asynq:replica:order"    // ZSET   - task ids by time enqueued, in replica
+	ReplicaSynced      = "asynq:replica:synced"   // STRING - time of the last replicated change, in replica
+)
+
+// Commands that can be sent to a process via its control channel.
+const (
+	// PauseCommand tells a process to stop pulling new tasks from queues.
+	PauseCommand = "pause"
+
+	// ResumeCommand tells a paused process to start pulling tasks again.
+	ResumeCommand = "resume"
 )
 
 // QueueKey returns a redis key for the given queue name.
@@ -61,8 +89,96 @@ func WorkersKey(hostname string, pid int) string {
 	return fmt.Sprintf("%s%s:%d", workersPrefix, hostname, pid)
 }
 
+// DequeueStatsKey returns a redis key for the statistics of the queries
+// of the given queue by Dequeue.
+func DequeueStatsKey(qname string) string {
+	return dequeuePrefix + strings.ToLower(qname)
+}
+
+// ControlChannel returns a pubsub channel name used to send commands
+// to the process given hostname and pid.
+func ControlChannel(hostname string, pid int) string {
+	return fmt.Sprintf("%s%s:%d", controlPrefix, hostname, pid)
+}
+
+// shardSeparator separates the name of a queue and the index of its shard.
+const shardSeparator = "#"
+
+// ShardQueue returns the name of the i-th shard of the given queue.
+func ShardQueue(qname string, i int) string {
+	return fmt.Sprintf("%s%s%d", qname, shardSeparator, i)
+}
+
+// UnshardQueue returns the name of the queue given the name of one of its
+// shards. It returns qname as is if it's not the name of a shard.
+func UnshardQueue(qname string) string {
+	if i := strings.LastIndex(qname, shardSeparator); i >= 0 {
+		return qname[:i]
+	}
+	return qname
+}
+
+// ValidateQueueName returns an error if the queue name is not valid.
+//
+// A queue name must not be empty, nor contain whitespace or control
+// characters, nor the shard separator "#" so that it does not collide
+// with the shards of another queue. It must not start with "asynq:"
+// either, so that it is not mistaken for a redis key used by asynq,
+// e.g. when a queue key is passed instead of a queue name.
+func ValidateQueueName(qname string) error {
+	if strings.TrimSpace(qname) == "" {
+		return fmt.Errorf("queue name must not be empty")
+	}
+	for _, r := range qname {
+		if unicode.IsSpace(r) || unicode.IsControl(r) {
+			return fmt.Errorf("queue name %q must not contain whitespace or control characters", qname)
+		}
+	}
+	if strings.Contains(qname, shardSeparator) {
+		return fmt.Errorf("queue name %q must not contain %q, which separates the shards of a queue", qname, shardSeparator)
+	}
+	if strings.HasPrefix(strings.ToLower(qname), "asynq:") {
+		return fmt.Errorf("queue name %q must not start with %q, which is reserved for keys used by asynq", qname, "asynq:")
+	}
+	return nil
+}
+
+// HistoryKey returns a redis key for the execution history of the task given its ID.
+func HistoryKey(id string) string {
+	return historyPrefix + id
+}
+
+// ChildrenKey returns a redis key for the tasks spawned by the task given its ID.
+func ChildrenKey(id string) string {
+	return childrenPrefix + id
+}
+
+// ProgressKey returns a redis key for the progress of the task given its ID.
+func ProgressKey(id string) string {
+	return progressPrefix + id
+}
+
+// CompletedKey returns a redis key for the completion record of tasks
+// with the given idempotency key.
+func CompletedKey(key string) string {
+	return completedPrefix + key
+}
+
+// MessageVersion is the version of the TaskMessage encoding written by this package.
+//
+// The version is incremented only for changes which older versions of the
+// package cannot process correctly. Fields can be added without changing
+// the version, since older versions keep the fields unknown to them intact.
+//
+// The initial version is zero, which is omitted from the encoding.
+const MessageVersion = 0
+
 // TaskMessage is the internal representation of a task with additional metadata fields.
 // Serialized data of this type gets written to redis.
+//
+// New fields must be added at the end of the struct, so that the fields
+// known to older versions are encoded first and in the same order,
+// followed by the fields unknown to them.
 type TaskMessage struct {
 	// Type indicates the kind of the task to be performed.
 	Type string
@@ -97,6 +213,230 @@ type TaskMessage struct {
 	//
 	// time.Time's zero value means no deadline.
 	Deadline string
+
+	// EnqueuedAt is the time the task was enqueued in Unix time.
+	// For scheduled and retry tasks, it is the time the task is
+	// scheduled to be enqueued.
+	//
+	// Zero means unknown.
+	EnqueuedAt int64
+
+	// StartBy is the time by which processing of the task must start
+	// in Unix time. The task is not processed if it's dequeued after the time
+	// before it was ever retried or restored.
+	//
+	// Zero means no limit.
+	StartBy int64
+
+	// IdempotencyKey identifies the task for deduplication.
+	// Once a task with the key is completed, other tasks with the same key
+	// are not processed while the completion is remembered.
+	//
+	// Empty string means no deduplication.
+	IdempotencyKey string
+
+	// Version is the version of the encoding the message was written with.
+	// See MessageVersion.
+	Version int `json:",omitempty"`
+
+	// ParentID is the ID of the task which spawned this task from its handler,
+	// and RootID is the ID of the first task of the lineage.
+	//
+	// Empty string means the task was not spawned by another task.
+	ParentID string `json:",omitempty"`
+	RootID   string `json:",omitempty"`
+
+	// Restored is the number of times the task was restored to its queue
+	// because its processing was not finished, e.g. the process crashed.
+	Restored int `json:",omitempty"`
+
+	// MaxAttempts is the max number of times the task is processed,
+	// counting both the retries and the restorations of the task.
+	//
+	// Zero means no limit.
+	MaxAttempts int `json:",omitempty"`
+
+	// FailedAt is the time the task failed for the first time in Unix time.
+	// It is kept as the task is retried, unlike EnqueuedAt.
+	//
+	// Zero means the task has not failed yet.
+	FailedAt int64 `json:",omitempty"`
+
+	// PostponedAt is the time the task was postponed for the first time
+	// because its message version was unsupported, in Unix time.
+	//
+	// Zero means the task has not been postponed.
+	PostponedAt int64 `json:",omitempty"`
+
+	// Labels holds the operational metadata of the task, e.g. the tenant
+	// or the trace ID, kept apart from the payload.
+	Labels map[string]string `json:",omitempty"`
+
+	// Unknown holds the fields of the encoded message which are unknown
+	// to this version of the package, e.g. fields written by a newer version,
+	// as a JSON object in the order they were encoded.
+	//
+	// Unknown fields are written back as they were when the message is
+	// encoded again, so that the message can be found by its encoding
+	// and no data is lost. nil means no unknown fields.
+	Unknown json.RawMessage `json:"-"`
+
+	// Encoded is the encoding of the message as it was read from redis,
+	// e.g. when the message was dequeued.
+	//
+	// Messages are removed from redis lists by their encoding, which may
+	// differ from the encoding of the message by this version, e.g. for a
+	// message written by an older version without the fields added since.
+	// Empty string means the message was not read from redis.
+	Encoded string `json:"-"`
+}
+
+// taskMessage has the same fields as TaskMessage without the methods,
+// to encode and decode the known fields with the default behavior.
+type taskMessage TaskMessage
+
+// MarshalJSON returns the JSON encoding of the message,
+// followed by its unknown fields.
+func (msg TaskMessage) MarshalJSON() ([]byte, error) {
+	data, err := json.Marshal(taskMessage(msg))
+	if err != nil || len(msg.Unknown) == 0 {
+		return data, err
+	}
+	var unknown map[string]json.RawMessage
+	if err := json.Unmarshal(msg.Unknown, &unknown); err != nil {
+		return nil, fmt.Errorf("invalid unknown fields: %v", err)
+	}
+	if len(unknown) == 0 {
+		return data, nil
+	}
+	// replace the closing brace of the object with the members of Unknown.
+	res := make([]byte, 0, len(data)+len(msg.Unknown))
+	res = append(res, data[:len(data)-1]...)
+	res = append(res, ',')
+	res = append(res, bytes.TrimSpace(msg.Unknown)[1:]...)
+	return res, nil
+}
+
+// UnmarshalJSON decodes the JSON encoding of a message,
+// keeping the fields unknown to this version in msg.Unknown.
+func (msg *TaskMessage) UnmarshalJSON(data []byte) error {
+	var m taskMessage
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&m); err == nil {
+		*msg = TaskMessage(m)
+		return nil
+	}
+	// the data is malformed or has unknown fields.
+	m = taskMessage{}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return err
+	}
+	unknown, err := unknownFields(data)
+	if err != nil {
+		return err
+	}
+	m.Unknown = unknown
+	*msg = TaskMessage(m)
+	return nil
+}
+
+// messageFields holds the names of the encoded fields of TaskMessage.
+var messageFields = func() []string {
+	var names []string
+	t := reflect.TypeOf(TaskMessage{})
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		names = append(names, name)
+	}
+	return names
+}()
+
+// isMessageField reports whether key is decoded into one of the fields
+// of TaskMessage. Keys are matched case-insensitively like json.Unmarshal.
+func isMessageField(key string) bool {
+	for _, name := range messageFields {
+		if strings.EqualFold(name, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// unknownFields returns the members of the encoded JSON object data which
+// are not fields of TaskMessage, as a JSON object in the same order.
+// It returns nil if there are no such members.
+func unknownFields(data []byte) (json.RawMessage, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if _, err := dec.Token(); err != nil { // opening brace
+		return nil, err
+	}
+	var b bytes.Buffer
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		key, _ := t.(string)
+		var v json.RawMessage
+		if err := dec.Decode(&v); err != nil {
+			return nil, err
+		}
+		if isMessageField(key) {
+			continue
+		}
+		if b.Len() == 0 {
+			b.WriteByte('{')
+		} else {
+			b.WriteByte(',')
+		}
+		k, err := json.Marshal(key)
+		if err != nil {
+			return nil, err
+		}
+		b.Write(k)
+		b.WriteByte(':')
+		b.Write(v)
+	}
+	if b.Len() == 0 {
+		return nil, nil
+	}
+	b.WriteByte('}')
+	return b.Bytes(), nil
+}
+
+// TaskAttempt holds information about an attempt to process a task.
+type TaskAttempt struct {
+	// Host and PID identify the process which processed the task.
+	Host string
+	PID  int
+
+	// Started is the time the attempt started.
+	Started time.Time
+
+	// Duration is the time it took to process the task.
+	Duration time.Duration
+
+	// ErrorMsg holds the error message if the attempt failed.
+	// Empty if the attempt succeeded.
+	ErrorMsg string
+}
+
+// TaskProgress holds the progress of a task reported by its handler.
+type TaskProgress struct {
+	// Retried is the retry count of the task when the progress was reported.
+	// It tells the progress of the current attempt apart from the earlier ones.
+	Retried int
+
+	// Data is the JSON encoded progress.
+	Data json.RawMessage
 }
 
 // ProcessState holds process level information.
@@ -112,6 +452,23 @@ type ProcessState struct {
 	status         PStatus
 	started        time.Time
 	workers        map[string]*workerStats
+	hidePayload    bool // whether to omit task payloads from worker info
+
+	// statistics of the queries of each queue by Dequeue,
+	// since they were last taken with TakeDequeueStats.
+	dequeueStats map[string]*DequeueStats
+}
+
+// DequeueStats holds the statistics of the queries of a queue by Dequeue.
+type DequeueStats struct {
+	// Attempts is the number of times the queue was queried,
+	// and Hits is the number of times a task was dequeued from it.
+	Attempts int64
+	Hits     int64
+
+	// LastQueried is the time the queue was last queried.
+	// Zero if the queue was never queried.
+	LastQueried time.Time
 }
 
 // PStatus represents status of a process.
@@ -126,16 +483,21 @@ const (
 
 	// StatusStopped indicates process is up but not processing new tasks.
 	StatusStopped
+
+	// StatusPaused indicates process is up but temporarily not processing
+	// new tasks until it receives a resume command.
+	StatusPaused
 )
 
 var statuses = []string{
 	"idle",
 	"running",
 	"stopped",
+	"paused",
 }
 
 func (s PStatus) String() string {
-	if StatusIdle <= s && s <= StatusStopped {
+	if StatusIdle <= s && s <= StatusPaused {
 		return statuses[s]
 	}
 	return "unknown status"
@@ -156,6 +518,7 @@ func NewProcessState(host string, pid, concurrency int, queues map[string]int, s
 		strictPriority: strict,
 		status:         StatusIdle,
 		workers:        make(map[string]*workerStats),
+		dequeueStats:   make(map[string]*DequeueStats),
 	}
 }
 
@@ -166,6 +529,13 @@ func (ps *ProcessState) SetStatus(status PStatus) {
 	ps.status = status
 }
 
+// Status returns the current state of process.
+func (ps *ProcessState) Status() PStatus {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	return ps.status
+}
+
 // SetStarted records when the process started processing.
 func (ps *ProcessState) SetStarted(t time.Time) {
 	ps.mu.Lock()
@@ -173,6 +543,14 @@ func (ps *ProcessState) SetStarted(t time.Time) {
 	ps.started = t
 }
 
+// HideWorkerPayload sets whether GetWorkers omits the payload of the tasks
+// being processed by the workers.
+func (ps *ProcessState) HideWorkerPayload(hide bool) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	ps.hidePayload = hide
+}
+
 // AddWorkerStats records when a worker started and which task it's processing.
 func (ps *ProcessState) AddWorkerStats(msg *TaskMessage, started time.Time) {
 	ps.mu.Lock()
@@ -187,6 +565,52 @@ func (ps *ProcessState) DeleteWorkerStats(msg *TaskMessage) {
 	delete(ps.workers, msg.ID.String())
 }
 
+// RecordDequeue records that the queue was queried by Dequeue at the
+// given time, and whether a task was dequeued from it.
+func (ps *ProcessState) RecordDequeue(qname string, hit bool, t time.Time) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	ps.addDequeueStats(qname, &DequeueStats{Attempts: 1, LastQueried: t})
+	if hit {
+		ps.dequeueStats[qname].Hits++
+	}
+}
+
+// TakeDequeueStats returns the statistics of the queries of each queue
+// recorded since the last call, and resets them.
+func (ps *ProcessState) TakeDequeueStats() map[string]*DequeueStats {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	res := ps.dequeueStats
+	ps.dequeueStats = make(map[string]*DequeueStats)
+	return res
+}
+
+// RestoreDequeueStats adds back the statistics returned by TakeDequeueStats,
+// e.g. when they could not be written to redis.
+func (ps *ProcessState) RestoreDequeueStats(stats map[string]*DequeueStats) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	for qname, s := range stats {
+		ps.addDequeueStats(qname, s)
+	}
+}
+
+// addDequeueStats adds s to the statistics of the queue.
+// The caller must hold ps.mu.
+func (ps *ProcessState) addDequeueStats(qname string, s *DequeueStats) {
+	cur, ok := ps.dequeueStats[qname]
+	if !ok {
+		cur = &DequeueStats{}
+		ps.dequeueStats[qname] = cur
+	}
+	cur.Attempts += s.Attempts
+	cur.Hits += s.Hits
+	if s.LastQueried.After(cur.LastQueried) {
+		cur.LastQueried = s.LastQueried
+	}
+}
+
 // Get returns current state of process as a ProcessInfo.
 func (ps *ProcessState) Get() *ProcessInfo {
 	ps.mu.Lock()
@@ -209,15 +633,18 @@ func (ps *ProcessState) GetWorkers() []*WorkerInfo {
 	defer ps.mu.Unlock()
 	var res []*WorkerInfo
 	for _, w := range ps.workers {
-		res = append(res, &WorkerInfo{
+		info := &WorkerInfo{
 			Host:    ps.host,
 			PID:     ps.pid,
 			ID:      w.msg.ID,
 			Type:    w.msg.Type,
-			Queue:   w.msg.Queue,
-			Payload: clonePayload(w.msg.Payload),
+			Queue:   UnshardQueue(w.msg.Queue),
 			Started: w.started,
-		})
+		}
+		if !ps.hidePayload {
+			info.Payload = clonePayload(w.msg.Payload)
+		}
+		res = append(res, info)
 	}
 	return res
 }
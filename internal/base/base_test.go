@@ -6,6 +6,7 @@ package base
 
 import (
 	"context"
+	"encoding/json"
 	"math/rand"
 	"sync"
 	"testing"
@@ -103,8 +104,123 @@ func TestWorkersKey(t *testing.T) {
 	}
 }
 
+func TestControlChannel(t *testing.T) {
+	tests := []struct {
+		hostname string
+		pid      int
+		want     string
+	}{
+		{"localhost", 9876, "asynq:control:localhost:9876"},
+		{"127.0.0.1", 1234, "asynq:control:127.0.0.1:1234"},
+	}
+
+	for _, tc := range tests {
+		got := ControlChannel(tc.hostname, tc.pid)
+		if got != tc.want {
+			t.Errorf("ControlChannel(%q, %d) = %q, want = %q", tc.hostname, tc.pid, got, tc.want)
+		}
+	}
+}
+
+func TestDequeueStatsKey(t *testing.T) {
+	tests := []struct {
+		qname string
+		want  string
+	}{
+		{"default", "asynq:dequeue:default"},
+		{"Critical", "asynq:dequeue:critical"},
+	}
+
+	for _, tc := range tests {
+		got := DequeueStatsKey(tc.qname)
+		if got != tc.want {
+			t.Errorf("DequeueStatsKey(%q) = %q, want = %q", tc.qname, got, tc.want)
+		}
+	}
+}
+
+func TestValidateQueueName(t *testing.T) {
+	tests := []struct {
+		qname string
+		valid bool
+	}{
+		{"default", true},
+		{"tenant:123", true},
+		{"critical_emails", true},
+		{"", false},
+		{"  ", false},
+		{"high priority", false},
+		{"default\n", false},
+		{"emails#1", false},
+		{"asynq:queues:default", false},
+		{"ASYNQ:scheduled", false},
+	}
+
+	for _, tc := range tests {
+		err := ValidateQueueName(tc.qname)
+		if tc.valid && err != nil {
+			t.Errorf("ValidateQueueName(%q) = %v, want nil", tc.qname, err)
+		}
+		if !tc.valid && err == nil {
+			t.Errorf("ValidateQueueName(%q) = nil, want non-nil error", tc.qname)
+		}
+	}
+}
+
 // Test for process state being accessed by multiple goroutines.
 // Run with -race flag to check for data race.
+func TestTaskMessageEncoding(t *testing.T) {
+	id := xid.New()
+	known := `{"Type":"send_email","Payload":{"to":"user@example.com"},"ID":"` + id.String() + `",` +
+		`"Queue":"default","Retry":25,"Retried":0,"ErrorMsg":"","Timeout":"","Deadline":"",` +
+		`"EnqueuedAt":0,"StartBy":0,"IdempotencyKey":""`
+	tests := []struct {
+		data        string
+		wantVersion int
+		wantUnknown string
+	}{
+		{known + `}`, 0, ""},
+		{known + `,"Version":2}`, 2, ""},
+		{known + `,"Annotations":{"tenant":"acme"},"Priority":3}`, 0, `{"Annotations":{"tenant":"acme"},"Priority":3}`},
+		{known + `,"Version":1,"Priority":3}`, 1, `{"Priority":3}`},
+	}
+
+	for _, tc := range tests {
+		var msg TaskMessage
+		if err := json.Unmarshal([]byte(tc.data), &msg); err != nil {
+			t.Errorf("json.Unmarshal(%s) returned error: %v", tc.data, err)
+			continue
+		}
+		if msg.ID != id || msg.Type != "send_email" || msg.Retry != 25 {
+			t.Errorf("json.Unmarshal(%s) = %+v, want known fields to be decoded", tc.data, msg)
+		}
+		if msg.Version != tc.wantVersion {
+			t.Errorf("json.Unmarshal(%s).Version = %d, want %d", tc.data, msg.Version, tc.wantVersion)
+		}
+		if string(msg.Unknown) != tc.wantUnknown {
+			t.Errorf("json.Unmarshal(%s).Unknown = %s, want %s", tc.data, msg.Unknown, tc.wantUnknown)
+		}
+		// the message should be encoded as it was.
+		data, err := json.Marshal(&msg)
+		if err != nil {
+			t.Errorf("json.Marshal(%+v) returned error: %v", msg, err)
+			continue
+		}
+		if string(data) != tc.data {
+			t.Errorf("json.Marshal(%+v) = %s, want %s", msg, data, tc.data)
+		}
+	}
+}
+
+func TestTaskMessageDecodingError(t *testing.T) {
+	for _, data := range []string{`{"Type":1}`, `{"Type":"send_email"`, `[]`} {
+		var msg TaskMessage
+		if err := json.Unmarshal([]byte(data), &msg); err == nil {
+			t.Errorf("json.Unmarshal(%s) returned nil error, want error", data)
+		}
+	}
+}
+
 func TestProcessStateConcurrentAccess(t *testing.T) {
 	ps := NewProcessState("127.0.0.1", 1234, 10, map[string]int{"default": 1}, false)
 	var wg sync.WaitGroup
@@ -165,6 +281,64 @@ func TestProcessStateConcurrentAccess(t *testing.T) {
 	}
 }
 
+func TestProcessStateHideWorkerPayload(t *testing.T) {
+	ps := NewProcessState("127.0.0.1", 1234, 10, map[string]int{"default": 1}, false)
+	msg := &TaskMessage{ID: xid.New(), Type: "charge", Payload: map[string]interface{}{"card": "4242"}}
+	ps.AddWorkerStats(msg, time.Now())
+
+	tests := []struct {
+		hide        bool
+		wantPayload map[string]interface{}
+	}{
+		{false, map[string]interface{}{"card": "4242"}},
+		{true, nil},
+	}
+
+	for _, tc := range tests {
+		ps.HideWorkerPayload(tc.hide)
+		workers := ps.GetWorkers()
+		if len(workers) != 1 {
+			t.Fatalf("(*ProcessState).GetWorkers() returned %d workers, want 1", len(workers))
+		}
+		if diff := cmp.Diff(tc.wantPayload, workers[0].Payload); diff != "" {
+			t.Errorf("with HideWorkerPayload(%t), worker payload = %v, want %v; (-want,+got)\n%s",
+				tc.hide, workers[0].Payload, tc.wantPayload, diff)
+		}
+	}
+}
+
+func TestProcessStateDequeueStats(t *testing.T) {
+	ps := NewProcessState("127.0.0.1", 1234, 10, map[string]int{"default": 1, "low": 1}, false)
+	now := time.Now()
+	ps.RecordDequeue("default", false, now)
+	ps.RecordDequeue("low", true, now)
+	ps.RecordDequeue("default", true, now.Add(time.Second))
+
+	want := map[string]*DequeueStats{
+		"default": {Attempts: 2, Hits: 1, LastQueried: now.Add(time.Second)},
+		"low":     {Attempts: 1, Hits: 1, LastQueried: now},
+	}
+	got := ps.TakeDequeueStats()
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("(*ProcessState).TakeDequeueStats() = %v, want %v; (-want,+got)\n%s", got, want, diff)
+	}
+	if got := ps.TakeDequeueStats(); len(got) != 0 {
+		t.Errorf("(*ProcessState).TakeDequeueStats() = %v after taking the stats, want empty", got)
+	}
+
+	// restored stats are merged with the stats recorded in the meantime.
+	ps.RecordDequeue("default", false, now.Add(2*time.Second))
+	ps.RestoreDequeueStats(want)
+	want = map[string]*DequeueStats{
+		"default": {Attempts: 3, Hits: 1, LastQueried: now.Add(2 * time.Second)},
+		"low":     {Attempts: 1, Hits: 1, LastQueried: now},
+	}
+	got = ps.TakeDequeueStats()
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("(*ProcessState).TakeDequeueStats() after restore = %v, want %v; (-want,+got)\n%s", got, want, diff)
+	}
+}
+
 // Test for cancelations being accessed by multiple goroutines.
 // Run with -race flag to check for data race.
 func TestCancelationsConcurrentAccess(t *testing.T) {
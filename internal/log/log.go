@@ -6,31 +6,220 @@
 package log
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
 	"io"
 	stdlog "log"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Level is the severity of a log message.
+type Level int
+
+// Log levels in the increasing order of severity.
+const (
+	InfoLevel Level = iota
+	WarnLevel
+	ErrorLevel
 )
 
 func NewLogger(out io.Writer) *Logger {
 	return &Logger{
-		stdlog.New(out, "", stdlog.Ldate|stdlog.Ltime|stdlog.Lmicroseconds|stdlog.LUTC),
+		Logger: stdlog.New(out, "", stdlog.Ldate|stdlog.Ltime|stdlog.Lmicroseconds|stdlog.LUTC),
+	}
+}
+
+// NewJSONLogger returns a Logger which writes each message as a JSON object
+// on a single line, with "level", "ts" and "msg" fields followed by the fields
+// added with With.
+func NewJSONLogger(out io.Writer) *Logger {
+	return &Logger{
+		Logger: stdlog.New(out, "", 0),
+		json:   true,
 	}
 }
 
 type Logger struct {
 	*stdlog.Logger
+
+	// json is true if messages are written as JSON objects.
+	json bool
+
+	// fields holds key-value pairs added to each JSON message, in order.
+	fields []interface{}
+
+	// level is the minimum level of messages to write.
+	level Level
+}
+
+// With returns a Logger which adds the given key-value pairs to each message.
+// Keys must be strings.
+//
+// Fields are written only by the JSON logger, since text messages
+// already describe the values.
+func (l *Logger) With(kvs ...interface{}) *Logger {
+	fields := make([]interface{}, 0, len(l.fields)+len(kvs))
+	fields = append(fields, l.fields...)
+	fields = append(fields, kvs...)
+	return &Logger{Logger: l.Logger, json: l.json, fields: fields, level: l.level}
+}
+
+// WithLevel returns a Logger which writes only messages
+// of the given level or higher.
+func (l *Logger) WithLevel(level Level) *Logger {
+	return &Logger{Logger: l.Logger, json: l.json, fields: l.fields, level: level}
+}
+
+// SetPrefix sets the prefix of text messages.
+// JSON messages are written without a prefix.
+func (l *Logger) SetPrefix(prefix string) {
+	if !l.json {
+		l.Logger.SetPrefix(prefix)
+	}
 }
 
 func (l *Logger) Info(format string, args ...interface{}) {
-	format = "INFO: " + format
-	l.Printf(format, args...)
+	l.output(InfoLevel, "INFO", format, args...)
 }
 
 func (l *Logger) Warn(format string, args ...interface{}) {
-	format = "WARN: " + format
-	l.Printf(format, args...)
+	l.output(WarnLevel, "WARN", format, args...)
 }
 
 func (l *Logger) Error(format string, args ...interface{}) {
-	format = "ERROR: " + format
-	l.Printf(format, args...)
+	l.output(ErrorLevel, "ERROR", format, args...)
+}
+
+func (l *Logger) output(lvl Level, level, format string, args ...interface{}) {
+	if lvl < l.level {
+		return
+	}
+	if !l.json {
+		l.Printf(level+": "+format, args...)
+		return
+	}
+	var b bytes.Buffer
+	b.WriteString(`{"level":`)
+	writeJSON(&b, strings.ToLower(level))
+	b.WriteString(`,"ts":`)
+	writeJSON(&b, time.Now().UTC().Format(time.RFC3339Nano))
+	b.WriteString(`,"msg":`)
+	writeJSON(&b, strings.TrimSuffix(fmt.Sprintf(format, args...), "\n"))
+	for i := 0; i+1 < len(l.fields); i += 2 {
+		b.WriteByte(',')
+		writeJSON(&b, fmt.Sprint(l.fields[i]))
+		b.WriteByte(':')
+		writeJSON(&b, l.fields[i+1])
+	}
+	b.WriteByte('}')
+	l.Print(b.String())
+}
+
+// writeJSON writes the JSON encoding of v to b.
+// Errors and durations are written as strings, and values which
+// cannot be encoded are written with their default format.
+func writeJSON(b *bytes.Buffer, v interface{}) {
+	switch x := v.(type) {
+	case error:
+		v = x.Error()
+	case time.Duration:
+		v = x.String()
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		data, _ = json.Marshal(fmt.Sprint(v))
+	}
+	b.Write(data)
+}
+
+// Limiter limits the rate of messages, to prevent spamming logs
+// with a bunch of errors or warnings.
+//
+// Messages which are not written are counted, and the count is
+// reported with the next message written, or on its own once the
+// interval passed without another message, or when Flush is called.
+//
+// Limiter is safe for concurrent use by multiple goroutines.
+type Limiter struct {
+	limiter  *rate.Limiter // nil if the rate is not limited
+	interval time.Duration
+
+	mu         sync.Mutex  // guards fields below
+	suppressed int         // number of messages not written since the last one
+	last       func(n int) // writes the count of suppressed messages with the logger of the last one
+	timer      *time.Timer // reports the suppressed messages; nil if none are suppressed
+}
+
+// NewLimiter returns a Limiter which writes at most one message per interval.
+// If interval is zero or negative, the rate is not limited.
+func NewLimiter(interval time.Duration) *Limiter {
+	lim := &Limiter{interval: interval}
+	if interval > 0 {
+		lim.limiter = rate.NewLimiter(rate.Every(interval), 1)
+	}
+	return lim
+}
+
+// Error writes the error message with l unless the rate limit is exceeded,
+// and reports whether the message was written.
+func (lim *Limiter) Error(l *Logger, format string, args ...interface{}) bool {
+	return lim.output((*Logger).Error, l, "errors", format, args...)
+}
+
+// Warn writes the warning message with l unless the rate limit is exceeded,
+// and reports whether the message was written.
+func (lim *Limiter) Warn(l *Logger, format string, args ...interface{}) bool {
+	return lim.output((*Logger).Warn, l, "warnings", format, args...)
+}
+
+// Flush writes the number of messages suppressed since the last one
+// written, if any, e.g. at shutdown.
+func (lim *Limiter) Flush() {
+	lim.mu.Lock()
+	n, last := lim.suppressed, lim.last
+	lim.reset()
+	lim.mu.Unlock()
+	if n > 0 {
+		last(n)
+	}
+}
+
+// reset forgets the suppressed messages. lim.mu must be held.
+func (lim *Limiter) reset() {
+	lim.suppressed = 0
+	lim.last = nil
+	if lim.timer != nil {
+		lim.timer.Stop()
+		lim.timer = nil
+	}
+}
+
+func (lim *Limiter) output(write func(*Logger, string, ...interface{}), l *Logger, kind, format string, args ...interface{}) bool {
+	lim.mu.Lock()
+	if lim.limiter != nil && !lim.limiter.Allow() {
+		lim.suppressed++
+		lim.last = func(n int) {
+			write(l.With("suppressed", n), "%d more %s were suppressed", n, kind)
+		}
+		if lim.timer == nil {
+			// report the suppressed messages even if no other message follows.
+			lim.timer = time.AfterFunc(lim.interval, lim.Flush)
+		}
+		lim.mu.Unlock()
+		return false
+	}
+	n := lim.suppressed
+	lim.reset()
+	lim.mu.Unlock()
+	if n > 0 {
+		l = l.With("suppressed", n)
+		format += fmt.Sprintf(" (%d more %s were suppressed)", n, kind)
+	}
+	write(l, format, args...)
+	return true
 }
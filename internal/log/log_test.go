@@ -6,9 +6,18 @@ package log
 
 import (
 	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"regexp"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/time/rate"
 )
 
 // regexp for timestamps
@@ -119,3 +128,194 @@ func TestLoggerError(t *testing.T) {
 		}
 	}
 }
+
+func TestLoggerWithFieldsWritesText(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewLogger(&buf)
+	logger.SetPrefix("asynq: ")
+
+	logger.With("task_id", "abc").Warn("Retry exhausted for task id=%s", "abc")
+
+	want := fmt.Sprintf("^asynq: %s %s%s WARN: Retry exhausted for task id=abc\n$", rgxdate, rgxtime, rgxmicroseconds)
+	got := buf.String()
+	matched, err := regexp.MatchString(want, got)
+	if err != nil {
+		t.Fatal("pattern did not compile:", err)
+	}
+	if !matched {
+		t.Errorf("logger.With(...).Warn outputted %q, should match pattern %q", got, want)
+	}
+}
+
+func TestJSONLogger(t *testing.T) {
+	tests := []struct {
+		desc string
+		log  func(l *Logger)
+		want map[string]interface{} // fields other than ts
+	}{
+		{
+			desc: "without fields",
+			log:  func(l *Logger) { l.Info("hello, %s!\n", "world") },
+			want: map[string]interface{}{"level": "info", "msg": "hello, world!"},
+		},
+		{
+			desc: "with fields",
+			log: func(l *Logger) {
+				l.With("task_id", "abc", "queue", "default").With("error", errors.New("boom")).Error("task failed")
+			},
+			want: map[string]interface{}{
+				"level":   "error",
+				"msg":     "task failed",
+				"task_id": "abc",
+				"queue":   "default",
+				"error":   "boom",
+			},
+		},
+		{
+			desc: "with duration and number",
+			log:  func(l *Logger) { l.With("pid", 1234, "duration", 1500*time.Millisecond).Warn("slow task") },
+			want: map[string]interface{}{
+				"level":    "warn",
+				"msg":      "slow task",
+				"pid":      float64(1234),
+				"duration": "1.5s",
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		var buf bytes.Buffer
+		logger := NewJSONLogger(&buf)
+		logger.SetPrefix("asynq: ") // ignored by JSON logger
+
+		tc.log(logger)
+
+		out := buf.String()
+		if strings.Count(out, "\n") != 1 || !strings.HasSuffix(out, "\n") {
+			t.Errorf("%s: output %q is not a single line", tc.desc, out)
+		}
+		var got map[string]interface{}
+		if err := json.Unmarshal([]byte(out), &got); err != nil {
+			t.Errorf("%s: output %q is not a JSON object: %v", tc.desc, out, err)
+			continue
+		}
+		ts, ok := got["ts"].(string)
+		if _, err := time.Parse(time.RFC3339Nano, ts); !ok || err != nil {
+			t.Errorf("%s: ts = %v, want a RFC3339 timestamp", tc.desc, got["ts"])
+		}
+		delete(got, "ts")
+		if diff := cmp.Diff(tc.want, got); diff != "" {
+			t.Errorf("%s: output %q; (-want,+got)\n%s", tc.desc, out, diff)
+		}
+	}
+}
+
+func TestLoggerWithLevel(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewLogger(&buf).WithLevel(WarnLevel)
+
+	logger.Info("info message")
+	logger.With("task_id", "abc").Info("info message with fields")
+	logger.Warn("warn message")
+	logger.Error("error message")
+
+	got := buf.String()
+	if strings.Contains(got, "INFO") {
+		t.Errorf("logger with WarnLevel wrote info messages: %q", got)
+	}
+	if !strings.Contains(got, "WARN: warn message") || !strings.Contains(got, "ERROR: error message") {
+		t.Errorf("logger with WarnLevel did not write warn and error messages: %q", got)
+	}
+}
+
+func TestLimiter(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewLogger(&buf)
+	lim := NewLimiter(time.Hour)
+
+	for i := 0; i < 3; i++ {
+		if got, want := lim.Error(logger, "dequeue error: %d", i), i == 0; got != want {
+			t.Errorf("Error() for error %d = %t, want %t", i, got, want)
+		}
+	}
+	lim.limiter.SetLimit(rate.Inf) // let the next message through.
+	if !lim.Error(logger, "dequeue error: %d", 3) {
+		t.Error("Error() after the limit is lifted = false, want true")
+	}
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("limiter wrote %d lines, want 2: %q", len(lines), buf.String())
+	}
+	if !strings.HasSuffix(lines[0], "ERROR: dequeue error: 0") {
+		t.Errorf("first line = %q, want the first error", lines[0])
+	}
+	if !strings.HasSuffix(lines[1], "ERROR: dequeue error: 3 (2 more errors were suppressed)") {
+		t.Errorf("second line = %q, want the last error with the number of suppressed errors", lines[1])
+	}
+}
+
+func TestLimiterFlush(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewLogger(&buf)
+	lim := NewLimiter(time.Hour)
+
+	for i := 0; i < 3; i++ {
+		lim.Error(logger, "dequeue error: %d", i)
+	}
+	lim.Flush()
+	lim.Flush() // nothing more to report.
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("limiter wrote %d lines, want 2: %q", len(lines), buf.String())
+	}
+	if !strings.HasSuffix(lines[1], "ERROR: 2 more errors were suppressed") {
+		t.Errorf("second line = %q, want the number of suppressed errors", lines[1])
+	}
+}
+
+func TestLimiterReportsSuppressedAfterInterval(t *testing.T) {
+	var (
+		mu  sync.Mutex
+		buf bytes.Buffer
+	)
+	logger := NewLogger(&lockedWriter{mu: &mu, w: &buf})
+	lim := NewLimiter(100 * time.Millisecond)
+
+	lim.Warn(logger, "could not sync")
+	lim.Warn(logger, "could not sync")
+	time.Sleep(300 * time.Millisecond) // no other message within the interval.
+
+	mu.Lock()
+	defer mu.Unlock()
+	if !strings.HasSuffix(strings.TrimSuffix(buf.String(), "\n"), "WARN: 1 more warnings were suppressed") {
+		t.Errorf("limiter wrote %q, want the number of suppressed warnings after the interval", buf.String())
+	}
+}
+
+// lockedWriter is an io.Writer safe for concurrent use.
+type lockedWriter struct {
+	mu *sync.Mutex
+	w  io.Writer
+}
+
+func (w *lockedWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.w.Write(p)
+}
+
+func TestLimiterWithoutInterval(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewLogger(&buf)
+	lim := NewLimiter(0)
+
+	for i := 0; i < 3; i++ {
+		lim.Error(logger, "dequeue error: %d", i)
+	}
+
+	if got := strings.Count(buf.String(), "\n"); got != 3 {
+		t.Errorf("limiter without interval wrote %d lines, want 3: %q", got, buf.String())
+	}
+}
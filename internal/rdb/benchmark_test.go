@@ -39,3 +39,43 @@ func BenchmarkDone(b *testing.B) {
 		rdb.Done(msg)
 	}
 }
+
+func BenchmarkEnqueue(b *testing.B) {
+	r := redis.NewClient(&redis.Options{
+		Addr: "localhost:6379",
+		DB:   8,
+	})
+	h.FlushDB(b, r)
+	rdb := NewRDB(r)
+	msg := h.NewTaskMessage("send_email", map[string]interface{}{"subject": "hello", "recipient_id": 123})
+
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		if err := rdb.Enqueue(msg); err != nil {
+			b.Fatalf("Enqueue failed: %v", err)
+		}
+	}
+}
+
+func BenchmarkDequeue(b *testing.B) {
+	r := redis.NewClient(&redis.Options{
+		Addr: "localhost:6379",
+		DB:   8,
+	})
+	h.FlushDB(b, r)
+	rdb := NewRDB(r)
+
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		b.StopTimer()
+		msg := h.NewTaskMessage("send_email", map[string]interface{}{"subject": "hello", "recipient_id": 123})
+		if err := rdb.Enqueue(msg); err != nil {
+			b.Fatalf("Enqueue failed: %v", err)
+		}
+		b.StartTimer()
+
+		if _, err := rdb.Dequeue(base.DefaultQueueName); err != nil {
+			b.Fatalf("Dequeue failed: %v", err)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+// Copyright 2020 Kentaro Hibino. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package rdb
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+)
+
+// ConnStats holds the statistics of the connection pool of the client and
+// of the commands sent with the client.
+type ConnStats struct {
+	// Pool is nil if the client does not report the statistics of its pool.
+	Pool *redis.PoolStats
+
+	// Commands holds the statistics of the commands by command name.
+	Commands map[string]CommandStats
+}
+
+// CommandStats holds the statistics of a command.
+type CommandStats struct {
+	// Count is the number of times the command was sent,
+	// and Errors is the number of times it failed.
+	Count  int64
+	Errors int64
+
+	// TotalDuration is the total time taken by the command to complete,
+	// including the time to get a connection from the pool.
+	TotalDuration time.Duration
+}
+
+// ConnStats returns the statistics of the connection pool and the commands
+// sent since the client was created.
+//
+// Commands is empty unless the client of the RDB was returned by WithCommandStats.
+func (r *RDB) ConnStats() *ConnStats {
+	stats := &ConnStats{Commands: make(map[string]CommandStats)}
+	if r.commands != nil {
+		stats.Commands = r.commands.snapshot()
+	}
+	if c, ok := r.client.(interface{ PoolStats() *redis.PoolStats }); ok {
+		stats.Pool = c.PoolStats()
+	}
+	return stats
+}
+
+// WithCommandStats adds a hook to the client to record the statistics of the
+// commands, and returns the client to give to NewRDB for ConnStats to report
+// them.
+//
+// It should be called once when the client is created, since every call adds
+// another hook to the client.
+func WithCommandStats(client redis.UniversalClient) redis.UniversalClient {
+	commands := newCommandStats()
+	client.AddHook(commands)
+	return &statsClient{UniversalClient: client, commands: commands}
+}
+
+// statsClient is a redis client whose commands are recorded by commands.
+type statsClient struct {
+	redis.UniversalClient
+	commands *commandStats
+}
+
+// PoolStats returns the statistics of the pool of the client,
+// or nil if the client does not report them.
+func (c *statsClient) PoolStats() *redis.PoolStats {
+	if p, ok := c.UniversalClient.(interface{ PoolStats() *redis.PoolStats }); ok {
+		return p.PoolStats()
+	}
+	return nil
+}
+
+// startKey is the context key for the time a command was started.
+type startKey struct{}
+
+// commandStats is a redis.Hook to record the statistics of the commands.
+//
+// commandStats is safe for concurrent use by multiple goroutines.
+type commandStats struct {
+	mu   sync.Mutex
+	cmds map[string]*CommandStats
+}
+
+func newCommandStats() *commandStats {
+	return &commandStats{cmds: make(map[string]*CommandStats)}
+}
+
+func (s *commandStats) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	return context.WithValue(ctx, startKey{}, time.Now()), nil
+}
+
+func (s *commandStats) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	if start, ok := ctx.Value(startKey{}).(time.Time); ok {
+		s.record(cmd.Name(), time.Since(start), cmd.Err())
+	}
+	return nil
+}
+
+func (s *commandStats) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	return context.WithValue(ctx, startKey{}, time.Now()), nil
+}
+
+func (s *commandStats) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	if start, ok := ctx.Value(startKey{}).(time.Time); ok {
+		var err error
+		for _, cmd := range cmds {
+			if cmd.Err() != nil && cmd.Err() != redis.Nil {
+				err = cmd.Err()
+			}
+		}
+		s.record("pipeline", time.Since(start), err)
+	}
+	return nil
+}
+
+// record records a command which took d to complete with the error err.
+//
+// A nil reply is not counted as an error, nor is a script which is not
+// cached yet, since the script is loaded and run again right away.
+func (s *commandStats) record(name string, d time.Duration, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st, ok := s.cmds[name]
+	if !ok {
+		st = &CommandStats{}
+		s.cmds[name] = st
+	}
+	st.Count++
+	st.TotalDuration += d
+	if err != nil && err != redis.Nil && !strings.HasPrefix(err.Error(), "NOSCRIPT") {
+		st.Errors++
+	}
+}
+
+// snapshot returns a copy of the statistics of the commands.
+func (s *commandStats) snapshot() map[string]CommandStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	res := make(map[string]CommandStats, len(s.cmds))
+	for name, st := range s.cmds {
+		res[name] = *st
+	}
+	return res
+}
@@ -0,0 +1,67 @@
+// Copyright 2020 Kentaro Hibino. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package rdb
+
+import (
+	"testing"
+
+	h "github.com/hibiken/asynq/internal/asynqtest"
+)
+
+func TestConnStats(t *testing.T) {
+	r := NewRDB(WithCommandStats(setup(t).client))
+	// FlushDB in setup is sent before the hook is added.
+	before := r.ConnStats().Commands["flushdb"].Count
+
+	if err := r.Enqueue(h.NewTaskMessage("send_email", nil)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Dequeue("default"); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.client.Get("asynq:nokey").Err(); err == nil {
+		t.Fatal("GET on a missing key returned no error")
+	}
+	if err := r.client.Do("nocommand").Err(); err == nil {
+		t.Fatal("unknown command returned no error")
+	}
+	r.client.FlushDB()
+
+	stats := r.ConnStats()
+	if stats.Pool == nil {
+		t.Fatal("(*RDB).ConnStats().Pool = nil, want pool stats")
+	}
+	if stats.Pool.TotalConns == 0 {
+		t.Errorf("(*RDB).ConnStats().Pool.TotalConns = 0, want at least 1")
+	}
+	if got := stats.Commands["flushdb"].Count - before; got != 1 {
+		t.Errorf("flushdb count = %d, want 1", got)
+	}
+	// a script not cached yet is not counted as an error.
+	if got := stats.Commands["evalsha"]; got.Count == 0 || got.Errors != 0 || got.TotalDuration <= 0 {
+		t.Errorf("evalsha stats = %+v, want commands with no errors", got)
+	}
+	if got := stats.Commands["get"]; got.Count != 1 || got.Errors != 0 {
+		t.Errorf("get stats = %+v, want 1 command with no errors", got)
+	}
+	if got := stats.Commands["nocommand"]; got.Count != 1 || got.Errors != 1 {
+		t.Errorf("nocommand stats = %+v, want 1 command with 1 error", got)
+	}
+}
+
+func TestConnStatsWithoutCommandStats(t *testing.T) {
+	r := setup(t)
+	if err := r.Enqueue(h.NewTaskMessage("send_email", nil)); err != nil {
+		t.Fatal(err)
+	}
+
+	stats := r.ConnStats()
+	if len(stats.Commands) != 0 {
+		t.Errorf("(*RDB).ConnStats().Commands = %v of a client without command stats, want none", stats.Commands)
+	}
+	if stats.Pool == nil {
+		t.Error("(*RDB).ConnStats().Pool = nil, want pool stats")
+	}
+}
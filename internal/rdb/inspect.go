@@ -6,7 +6,12 @@ package rdb
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"math/rand"
+	"path"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -172,6 +177,420 @@ func (r *RDB) CurrentStats() (*Stats, error) {
 	return stats, nil
 }
 
+// Latencies returns the latency of each queue, which is the time elapsed
+// since the oldest task in the queue was enqueued.
+//
+// Latency of an empty queue is zero.
+func (r *RDB) Latencies() (map[string]time.Duration, error) {
+	qkeys, err := r.client.SMembers(base.AllQueues).Result()
+	if err != nil {
+		return nil, err
+	}
+	var qnames []string
+	for _, qkey := range qkeys {
+		qnames = append(qnames, strings.TrimPrefix(qkey, base.QueuePrefix))
+	}
+	return r.QueueLatencies(qnames...)
+}
+
+// QueueLatencies returns the latency of each of the given queues,
+// keyed by queue name. See Latencies.
+func (r *RDB) QueueLatencies(qnames ...string) (map[string]time.Duration, error) {
+	res := make(map[string]time.Duration)
+	if len(qnames) == 0 {
+		return res, nil
+	}
+	pipe := r.client.Pipeline()
+	cmds := make(map[string]*redis.StringCmd)
+	for _, qname := range qnames {
+		// tasks are dequeued from the tail of the list.
+		cmds[qname] = pipe.LIndex(base.QueueKey(qname), -1)
+	}
+	if _, err := pipe.Exec(); err != nil && err != redis.Nil {
+		return nil, err
+	}
+	now := time.Now()
+	for qname, cmd := range cmds {
+		data, err := cmd.Result()
+		if err == redis.Nil {
+			res[qname] = 0
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		var msg base.TaskMessage
+		if err := json.Unmarshal([]byte(data), &msg); err != nil {
+			return nil, err
+		}
+		res[qname] = latency(&msg, now)
+	}
+	return res, nil
+}
+
+// DequeueStats returns the statistics of the queries of each of the given
+// queues by Dequeue of all processes, keyed by queue name.
+// The statistics of a queue never queried are zero.
+func (r *RDB) DequeueStats(qnames ...string) (map[string]*base.DequeueStats, error) {
+	res := make(map[string]*base.DequeueStats)
+	if len(qnames) == 0 {
+		return res, nil
+	}
+	pipe := r.client.Pipeline()
+	cmds := make(map[string]*redis.StringStringMapCmd)
+	for _, qname := range qnames {
+		cmds[qname] = pipe.HGetAll(base.DequeueStatsKey(qname))
+	}
+	if _, err := pipe.Exec(); err != nil {
+		return nil, err
+	}
+	for qname, cmd := range cmds {
+		data := cmd.Val()
+		s := &base.DequeueStats{
+			Attempts: cast.ToInt64(data["attempts"]),
+			Hits:     cast.ToInt64(data["hits"]),
+		}
+		if ms := cast.ToInt64(data["queried"]); ms > 0 {
+			s.LastQueried = fromUnixMilli(ms)
+		}
+		res[qname] = s
+	}
+	return res, nil
+}
+
+// FailureAges holds the age distribution of the retry and dead tasks.
+//
+// Counts are the number of tasks by age, where Counts[i] is the number of
+// tasks younger than bounds[i] and not younger than bounds[i-1], and the
+// last element is the number of tasks not younger than the last bound.
+type FailureAges struct {
+	RetryCounts []int64
+	DeadCounts  []int64
+
+	// OldestRetry is the time the oldest retry task first failed, and
+	// OldestDead is the time the oldest dead task was moved to dead queue.
+	// Zero if there are no tasks.
+	OldestRetry time.Time
+	OldestDead  time.Time
+}
+
+// failureSamples is the number of retry tasks read at most to estimate
+// the age distribution of the retry tasks.
+const failureSamples = 1000
+
+// FailureAges returns the age distribution of the retry and dead tasks at
+// the given time, given the ascending upper bounds of the age buckets.
+//
+// The age of a dead task is the time since it was moved to dead queue, and
+// the age of a retry task is the time since it failed for the first time,
+// i.e. how long it has been failing.
+//
+// Dead tasks are counted in redis. Retry tasks are read to find the time they
+// failed, so if there are more than 1000 retry tasks, the distribution
+// of the retry tasks is estimated from a random sample of 1000 of them, and
+// OldestRetry is the oldest of the sample.
+func (r *RDB) FailureAges(now time.Time, bounds []time.Duration) (*FailureAges, error) {
+	res := &FailureAges{
+		RetryCounts: make([]int64, len(bounds)+1),
+		DeadCounts:  make([]int64, len(bounds)+1),
+	}
+	pipe := r.client.Pipeline()
+	oldestCmd := pipe.ZRangeWithScores(base.DeadQueue, 0, 0)
+	countCmds := make([]*redis.IntCmd, len(bounds)+1)
+	max := "+inf"
+	for i, b := range bounds {
+		// tasks moved to dead queue in (now-bound, now-previous bound].
+		min := "(" + strconv.FormatInt(now.Add(-b).Unix(), 10)
+		countCmds[i] = pipe.ZCount(base.DeadQueue, min, max)
+		max = strconv.FormatInt(now.Add(-b).Unix(), 10)
+	}
+	countCmds[len(bounds)] = pipe.ZCount(base.DeadQueue, "-inf", max)
+	if _, err := pipe.Exec(); err != nil {
+		return nil, err
+	}
+	samples, err := r.sampleZSet(base.RetryQueue, failureSamples)
+	if err != nil {
+		return nil, err
+	}
+	for i, cmd := range countCmds {
+		res.DeadCounts[i] = cmd.Val()
+	}
+	if z := oldestCmd.Val(); len(z) > 0 {
+		res.OldestDead = time.Unix(int64(z[0].Score), 0)
+	}
+	for _, s := range samples {
+		var msg base.TaskMessage
+		if err := json.Unmarshal([]byte(s), &msg); err != nil {
+			continue // bad data, ignore and continue
+		}
+		// EnqueuedAt of a retry task is the time of the next retry.
+		failedAt := msg.ID.Time()
+		if msg.FailedAt != 0 {
+			failedAt = time.Unix(msg.FailedAt, 0)
+		}
+		if res.OldestRetry.IsZero() || failedAt.Before(res.OldestRetry) {
+			res.OldestRetry = failedAt
+		}
+		age := now.Sub(failedAt)
+		i := sort.Search(len(bounds), func(i int) bool { return age < bounds[i] })
+		res.RetryCounts[i]++
+	}
+	if len(samples) == failureSamples {
+		// the retry set may be larger than the sample.
+		total, err := r.client.ZCard(base.RetryQueue).Result()
+		if err != nil {
+			return nil, err
+		}
+		res.RetryCounts = scaleCounts(res.RetryCounts, total)
+	}
+	return res, nil
+}
+
+// scaleCounts scales the counts of a sample to the given total, keeping
+// their proportions and rounding so that they sum up to the total.
+func scaleCounts(counts []int64, total int64) []int64 {
+	var sum int64
+	for _, n := range counts {
+		sum += n
+	}
+	if sum == 0 {
+		return counts
+	}
+	res := make([]int64, len(counts))
+	var scaled int64
+	for i, n := range counts {
+		res[i] = n * total / sum
+		scaled += res[i]
+	}
+	// give the remainder to the buckets with the largest rounding errors.
+	for scaled < total {
+		best, bestErr := -1, int64(-1)
+		for i, n := range counts {
+			if err := n*total - res[i]*sum; err > bestErr {
+				best, bestErr = i, err
+			}
+		}
+		res[best]++
+		scaled++
+	}
+	return res
+}
+
+// KEYS[1] -> asynq:failure_check:lock
+// ARGV[1] -> owner of the lock
+// ARGV[2] -> TTL of the lock in milliseconds
+var acquireFailureCheckLockCmd = redis.NewScript(`
+local owner = redis.call("GET", KEYS[1])
+if owner and owner ~= ARGV[1] then
+	return 0
+end
+redis.call("SET", KEYS[1], ARGV[1], "PX", ARGV[2])
+return 1`)
+
+// AcquireFailureCheckLock reports whether the owner holds the lock to check
+// the failed tasks, taking or extending the lock for the given TTL unless
+// it's held by another owner, so that only one process checks them.
+func (r *RDB) AcquireFailureCheckLock(owner string, ttl time.Duration) (bool, error) {
+	n, err := acquireFailureCheckLockCmd.Run(r.client,
+		[]string{base.FailureCheckLock}, owner, ttl.Milliseconds()).Int()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+// KEYS[1] -> asynq:failure_check:lock
+// ARGV[1] -> owner of the lock
+var releaseFailureCheckLockCmd = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	redis.call("DEL", KEYS[1])
+end
+return redis.status_reply("OK")`)
+
+// ReleaseFailureCheckLock releases the lock to check the failed tasks if held
+// by the owner, so that another owner can take it over right away.
+func (r *RDB) ReleaseFailureCheckLock(owner string) error {
+	return releaseFailureCheckLockCmd.Run(r.client, []string{base.FailureCheckLock}, owner).Err()
+}
+
+// latency returns the time elapsed since the given task was enqueued.
+func latency(msg *base.TaskMessage, now time.Time) time.Duration {
+	if msg.EnqueuedAt == 0 {
+		return 0 // unknown
+	}
+	d := now.Sub(time.Unix(msg.EnqueuedAt, 0))
+	if d < 0 {
+		// task was enqueued before its scheduled time.
+		return 0
+	}
+	return d
+}
+
+// MemoryUsage holds the estimated number of bytes used in redis by the tasks of a queue.
+type MemoryUsage struct {
+	Enqueued  int64
+	Scheduled int64
+	Retry     int64
+	Dead      int64
+}
+
+// memorySamples is the number of entries of a zset sampled at random
+// to estimate the share of a queue in the zset.
+const memorySamples = 100
+
+// QueueMemoryUsage estimates the number of bytes used by the enqueued,
+// scheduled, retry and dead tasks of the queue, using MEMORY USAGE.
+//
+// Since scheduled, retry and dead tasks of all queues are stored together,
+// the usage of those is estimated from the share of the queue in a random
+// sample of the entries of each zset.
+func (r *RDB) QueueMemoryUsage(qname string) (*MemoryUsage, error) {
+	qname = strings.ToLower(qname)
+	enqueued, err := r.memoryUsage(base.QueueKey(qname))
+	if err != nil {
+		return nil, err
+	}
+	res := &MemoryUsage{Enqueued: enqueued}
+	for _, z := range []struct {
+		key   string
+		usage *int64
+	}{
+		{base.ScheduledQueue, &res.Scheduled},
+		{base.RetryQueue, &res.Retry},
+		{base.DeadQueue, &res.Dead},
+	} {
+		if *z.usage, err = r.zsetMemoryUsage(z.key, qname); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// memoryUsage returns the number of bytes used by the key, or zero if the key does not exist.
+func (r *RDB) memoryUsage(key string) (int64, error) {
+	n, err := r.client.MemoryUsage(key).Result()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	return n, err
+}
+
+// zsetMemoryUsage estimates the number of bytes used by the tasks of
+// the queue in the zset.
+func (r *RDB) zsetMemoryUsage(key, qname string) (int64, error) {
+	total, err := r.memoryUsage(key)
+	if err != nil || total == 0 {
+		return 0, err
+	}
+	samples, err := r.sampleZSet(key, memorySamples)
+	if err != nil {
+		return 0, err
+	}
+	var all, matched int
+	for _, s := range samples {
+		var msg base.TaskMessage
+		if err := json.Unmarshal([]byte(s), &msg); err != nil {
+			return 0, err
+		}
+		all += len(s)
+		if base.UnshardQueue(msg.Queue) == qname {
+			matched += len(s)
+		}
+	}
+	if all == 0 {
+		return 0, nil
+	}
+	return int64(float64(total) * float64(matched) / float64(all)), nil
+}
+
+// sampleZSet returns n entries of the zset picked at random, or all the
+// entries if the zset has no more than n entries.
+func (r *RDB) sampleZSet(key string, n int) ([]string, error) {
+	card, err := r.client.ZCard(key).Result()
+	if err != nil {
+		return nil, err
+	}
+	if card <= int64(n) {
+		return r.client.ZRange(key, 0, -1).Result()
+	}
+	pipe := r.client.Pipeline()
+	cmds := make([]*redis.StringSliceCmd, n)
+	for i := range cmds {
+		rank := rand.Int63n(card)
+		cmds[i] = pipe.ZRange(key, rank, rank)
+	}
+	if _, err := pipe.Exec(); err != nil {
+		return nil, err
+	}
+	var res []string
+	for _, cmd := range cmds {
+		// entries may have been removed since the zset was counted.
+		res = append(res, cmd.Val()...)
+	}
+	return res, nil
+}
+
+// TaskHistory returns the recorded attempts to process the task given its ID,
+// in the order they happened.
+func (r *RDB) TaskHistory(id string) ([]*base.TaskAttempt, error) {
+	data, err := r.client.LRange(base.HistoryKey(id), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	var res []*base.TaskAttempt
+	// attempts are stored from the latest to the oldest.
+	for i := len(data) - 1; i >= 0; i-- {
+		var a base.TaskAttempt
+		if err := json.Unmarshal([]byte(data[i]), &a); err != nil {
+			return nil, err
+		}
+		res = append(res, &a)
+	}
+	return res, nil
+}
+
+// TaskRef identifies a task by its ID and the queue it was enqueued to.
+type TaskRef struct {
+	ID    string
+	Queue string
+}
+
+// Children returns the tasks spawned by the task given its ID,
+// in the order they were spawned.
+func (r *RDB) Children(id string) ([]*TaskRef, error) {
+	data, err := r.client.LRange(base.ChildrenKey(id), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	var res []*TaskRef
+	for _, s := range data {
+		// IDs don't contain colons, while queue names may.
+		kv := strings.SplitN(s, ":", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid child task reference %q", s)
+		}
+		res = append(res, &TaskRef{ID: kv[0], Queue: kv[1]})
+	}
+	return res, nil
+}
+
+// Progress returns the latest progress of the task given its ID.
+// It returns nil if no progress was reported for the task.
+func (r *RDB) Progress(id string) (*base.TaskProgress, error) {
+	data, err := r.client.Get(base.ProgressKey(id)).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var p base.TaskProgress
+	if err := json.Unmarshal([]byte(data), &p); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
 var historicalStatsCmd = redis.NewScript(`
 local res = {}
 for _, key in ipairs(KEYS) do
@@ -285,7 +704,7 @@ func (r *RDB) ListEnqueued(qname string, pgn Pagination) ([]*EnqueuedTask, error
 			ID:      msg.ID,
 			Type:    msg.Type,
 			Payload: msg.Payload,
-			Queue:   msg.Queue,
+			Queue:   base.UnshardQueue(msg.Queue),
 		})
 	}
 	return tasks, nil
@@ -341,7 +760,7 @@ func (r *RDB) ListScheduled(pgn Pagination) ([]*ScheduledTask, error) {
 			ID:        msg.ID,
 			Type:      msg.Type,
 			Payload:   msg.Payload,
-			Queue:     msg.Queue,
+			Queue:     base.UnshardQueue(msg.Queue),
 			ProcessAt: processAt,
 			Score:     int64(z.Score),
 		})
@@ -375,7 +794,7 @@ func (r *RDB) ListRetry(pgn Pagination) ([]*RetryTask, error) {
 			ErrorMsg:  msg.ErrorMsg,
 			Retry:     msg.Retry,
 			Retried:   msg.Retried,
-			Queue:     msg.Queue,
+			Queue:     base.UnshardQueue(msg.Queue),
 			ProcessAt: processAt,
 			Score:     int64(z.Score),
 		})
@@ -406,7 +825,7 @@ func (r *RDB) ListDead(pgn Pagination) ([]*DeadTask, error) {
 			Type:         msg.Type,
 			Payload:      msg.Payload,
 			ErrorMsg:     msg.ErrorMsg,
-			Queue:        msg.Queue,
+			Queue:        base.UnshardQueue(msg.Queue),
 			LastFailedAt: lastFailedAt,
 			Score:        int64(z.Score),
 		})
@@ -474,6 +893,14 @@ func (r *RDB) EnqueueAllDeadTasks() (int64, error) {
 	return r.removeAndEnqueueAll(base.DeadQueue)
 }
 
+// KEYS[1] -> ZSET to move task from (e.g., retry queue)
+// KEYS[2] -> asynq:replication
+// KEYS[3] -> asynq:replication:log
+// ARGV[1] -> score of the task to enqueue
+// ARGV[2] -> id of the task to enqueue
+// ARGV[3] -> queue prefix
+// ARGV[4] -> current unix time in milliseconds
+// ARGV[5] -> "1" to replicate the task, e.g. for a dead task, "0" otherwise
 var removeAndEnqueueCmd = redis.NewScript(`
 local msgs = redis.call("ZRANGEBYSCORE", KEYS[1], ARGV[1], ARGV[1])
 for _, msg in ipairs(msgs) do
@@ -482,13 +909,28 @@ for _, msg in ipairs(msgs) do
 		local qkey = ARGV[3] .. decoded["Queue"]
 		redis.call("LPUSH", qkey, msg)
 		redis.call("ZREM", KEYS[1], msg)
+		if ARGV[5] == "1" and redis.call("EXISTS", KEYS[2]) == 1 then
+			redis.call("RPUSH", KEYS[3],
+				'{"op":"add","state":"enqueued","time":' .. ARGV[4] .. ',"msg":' .. msg .. '}')
+		end
 		return 1
 	end
 end
 return 0`)
 
+// replicateArg returns the script argument to replicate the tasks moved
+// from the given zset to their queues. Only dead tasks are not in the replica.
+func replicateArg(zset string) string {
+	if zset == base.DeadQueue {
+		return "1"
+	}
+	return "0"
+}
+
 func (r *RDB) removeAndEnqueue(zset, id string, score float64) (int64, error) {
-	res, err := removeAndEnqueueCmd.Run(r.client, []string{zset}, score, id, base.QueuePrefix).Result()
+	res, err := removeAndEnqueueCmd.Run(r.client,
+		[]string{zset, base.ReplicationEnabled, base.ReplicationLog},
+		score, id, base.QueuePrefix, unixMilli(time.Now()), replicateArg(zset)).Result()
 	if err != nil {
 		return 0, err
 	}
@@ -499,18 +941,31 @@ func (r *RDB) removeAndEnqueue(zset, id string, score float64) (int64, error) {
 	return n, nil
 }
 
+// KEYS[1] -> ZSET to move tasks from (e.g., retry queue)
+// KEYS[2] -> asynq:replication
+// KEYS[3] -> asynq:replication:log
+// ARGV[1] -> queue prefix
+// ARGV[2] -> current unix time in milliseconds
+// ARGV[3] -> "1" to replicate the tasks, e.g. for dead tasks, "0" otherwise
 var removeAndEnqueueAllCmd = redis.NewScript(`
+local replicate = ARGV[3] == "1" and redis.call("EXISTS", KEYS[2]) == 1
 local msgs = redis.call("ZRANGE", KEYS[1], 0, -1)
 for _, msg in ipairs(msgs) do
 	local decoded = cjson.decode(msg)
 	local qkey = ARGV[1] .. decoded["Queue"]
 	redis.call("LPUSH", qkey, msg)
 	redis.call("ZREM", KEYS[1], msg)
+	if replicate then
+		redis.call("RPUSH", KEYS[3],
+			'{"op":"add","state":"enqueued","time":' .. ARGV[2] .. ',"msg":' .. msg .. '}')
+	end
 end
 return table.getn(msgs)`)
 
 func (r *RDB) removeAndEnqueueAll(zset string) (int64, error) {
-	res, err := removeAndEnqueueAllCmd.Run(r.client, []string{zset}, base.QueuePrefix).Result()
+	res, err := removeAndEnqueueAllCmd.Run(r.client,
+		[]string{zset, base.ReplicationEnabled, base.ReplicationLog},
+		base.QueuePrefix, unixMilli(time.Now()), replicateArg(zset)).Result()
 	if err != nil {
 		return 0, err
 	}
@@ -561,13 +1016,120 @@ func (r *RDB) KillAllScheduledTasks() (int64, error) {
 	return r.removeAndKillAll(base.ScheduledQueue)
 }
 
+// KillEnqueuedTask finds a task that matches the given id from the given queue
+// or its shards and moves it to dead queue. If a task that matches the id does not exist,
+// it returns ErrTaskNotFound.
+func (r *RDB) KillEnqueuedTask(qname string, id xid.ID) error {
+	qkeys, _, err := r.queueKeys(qname)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	limit := now.AddDate(0, 0, -deadExpirationInDays).Unix() // 90 days ago
+	for _, qkey := range qkeys {
+		res, err := killEnqueuedCmd.Run(r.client,
+			[]string{qkey, base.DeadQueue, base.ReplicationEnabled, base.ReplicationLog},
+			id.String(), now.Unix(), limit, maxDeadTasks, unixMilli(now)).Result()
+		if err != nil {
+			return err
+		}
+		n, ok := res.(int64)
+		if !ok {
+			return fmt.Errorf("could not cast %v to int64", res)
+		}
+		if n > 0 {
+			return nil
+		}
+	}
+	return ErrTaskNotFound
+}
+
+// KillAllEnqueuedTasks moves all tasks from the given queue and its shards
+// to dead queue and returns the number of tasks that were moved.
+// Tasks in each shard are moved atomically.
+func (r *RDB) KillAllEnqueuedTasks(qname string) (int64, error) {
+	qkeys, _, err := r.queueKeys(qname)
+	if err != nil {
+		return 0, err
+	}
+	now := time.Now()
+	limit := now.AddDate(0, 0, -deadExpirationInDays).Unix() // 90 days ago
+	var total int64
+	for _, qkey := range qkeys {
+		res, err := killAllEnqueuedCmd.Run(r.client,
+			[]string{qkey, base.DeadQueue, base.ReplicationEnabled, base.ReplicationLog},
+			now.Unix(), limit, maxDeadTasks, unixMilli(now)).Result()
+		if err != nil {
+			return total, err
+		}
+		n, ok := res.(int64)
+		if !ok {
+			return total, fmt.Errorf("could not cast %v to int64", res)
+		}
+		total += n
+	}
+	return total, nil
+}
+
+// KEYS[1] -> asynq:queues:<qname>
+// KEYS[2] -> asynq:dead
+// KEYS[3] -> asynq:replication
+// KEYS[4] -> asynq:replication:log
+// ARGV[1] -> id of the task to kill
+// ARGV[2] -> current timestamp
+// ARGV[3] -> cutoff timestamp (e.g., 90 days ago)
+// ARGV[4] -> max number of tasks in dead queue (e.g., 100)
+// ARGV[5] -> current unix time in milliseconds
+var killEnqueuedCmd = redis.NewScript(`
+local msgs = redis.call("LRANGE", KEYS[1], 0, -1)
+for _, msg in ipairs(msgs) do
+	local decoded = cjson.decode(msg)
+	if decoded["ID"] == ARGV[1] then
+		redis.call("LREM", KEYS[1], 1, msg)
+		redis.call("ZADD", KEYS[2], ARGV[2], msg)
+		redis.call("ZREMRANGEBYSCORE", KEYS[2], "-inf", ARGV[3])
+		redis.call("ZREMRANGEBYRANK", KEYS[2], 0, -ARGV[4])
+		if redis.call("EXISTS", KEYS[3]) == 1 then
+			redis.call("RPUSH", KEYS[4], '{"op":"del","id":"' .. ARGV[1] .. '","time":' .. ARGV[5] .. '}')
+		end
+		return 1
+	end
+end
+return 0`)
+
+// KEYS[1] -> asynq:queues:<qname>
+// KEYS[2] -> asynq:dead
+// KEYS[3] -> asynq:replication
+// KEYS[4] -> asynq:replication:log
+// ARGV[1] -> current timestamp
+// ARGV[2] -> cutoff timestamp (e.g., 90 days ago)
+// ARGV[3] -> max number of tasks in dead queue (e.g., 100)
+// ARGV[4] -> current unix time in milliseconds
+var killAllEnqueuedCmd = redis.NewScript(`
+local replicate = redis.call("EXISTS", KEYS[3]) == 1
+local msgs = redis.call("LRANGE", KEYS[1], 0, -1)
+for _, msg in ipairs(msgs) do
+	redis.call("ZADD", KEYS[2], ARGV[1], msg)
+	redis.call("ZREMRANGEBYSCORE", KEYS[2], "-inf", ARGV[2])
+	redis.call("ZREMRANGEBYRANK", KEYS[2], 0, -ARGV[3])
+	if replicate then
+		redis.call("RPUSH", KEYS[4],
+			'{"op":"del","id":"' .. cjson.decode(msg)["ID"] .. '","time":' .. ARGV[4] .. '}')
+	end
+end
+redis.call("DEL", KEYS[1])
+return table.getn(msgs)`)
+
 // KEYS[1] -> ZSET to move task from (e.g., retry queue)
 // KEYS[2] -> asynq:dead
+// KEYS[3] -> asynq:replication
+// KEYS[4] -> asynq:replication:log
 // ARGV[1] -> score of the task to kill
 // ARGV[2] -> id of the task to kill
 // ARGV[3] -> current timestamp
 // ARGV[4] -> cutoff timestamp (e.g., 90 days ago)
 // ARGV[5] -> max number of tasks in dead queue (e.g., 100)
+// ARGV[6] -> current unix time in milliseconds
 var removeAndKillCmd = redis.NewScript(`
 local msgs = redis.call("ZRANGEBYSCORE", KEYS[1], ARGV[1], ARGV[1])
 for _, msg in ipairs(msgs) do
@@ -577,6 +1139,9 @@ for _, msg in ipairs(msgs) do
 		redis.call("ZADD", KEYS[2], ARGV[3], msg)
 		redis.call("ZREMRANGEBYSCORE", KEYS[2], "-inf", ARGV[4])
 		redis.call("ZREMRANGEBYRANK", KEYS[2], 0, -ARGV[5])
+		if redis.call("EXISTS", KEYS[3]) == 1 then
+			redis.call("RPUSH", KEYS[4], '{"op":"del","id":"' .. ARGV[2] .. '","time":' .. ARGV[6] .. '}')
+		end
 		return 1
 	end
 end
@@ -586,8 +1151,8 @@ func (r *RDB) removeAndKill(zset, id string, score float64) (int64, error) {
 	now := time.Now()
 	limit := now.AddDate(0, 0, -deadExpirationInDays).Unix() // 90 days ago
 	res, err := removeAndKillCmd.Run(r.client,
-		[]string{zset, base.DeadQueue},
-		score, id, now.Unix(), limit, maxDeadTasks).Result()
+		[]string{zset, base.DeadQueue, base.ReplicationEnabled, base.ReplicationLog},
+		score, id, now.Unix(), limit, maxDeadTasks, unixMilli(now)).Result()
 	if err != nil {
 		return 0, err
 	}
@@ -600,24 +1165,33 @@ func (r *RDB) removeAndKill(zset, id string, score float64) (int64, error) {
 
 // KEYS[1] -> ZSET to move task from (e.g., retry queue)
 // KEYS[2] -> asynq:dead
+// KEYS[3] -> asynq:replication
+// KEYS[4] -> asynq:replication:log
 // ARGV[1] -> current timestamp
 // ARGV[2] -> cutoff timestamp (e.g., 90 days ago)
 // ARGV[3] -> max number of tasks in dead queue (e.g., 100)
+// ARGV[4] -> current unix time in milliseconds
 var removeAndKillAllCmd = redis.NewScript(`
+local replicate = redis.call("EXISTS", KEYS[3]) == 1
 local msgs = redis.call("ZRANGE", KEYS[1], 0, -1)
 for _, msg in ipairs(msgs) do
 	redis.call("ZADD", KEYS[2], ARGV[1], msg)
 	redis.call("ZREM", KEYS[1], msg)
 	redis.call("ZREMRANGEBYSCORE", KEYS[2], "-inf", ARGV[2])
 	redis.call("ZREMRANGEBYRANK", KEYS[2], 0, -ARGV[3])
+	if replicate then
+		redis.call("RPUSH", KEYS[4],
+			'{"op":"del","id":"' .. cjson.decode(msg)["ID"] .. '","time":' .. ARGV[4] .. '}')
+	end
 end
 return table.getn(msgs)`)
 
 func (r *RDB) removeAndKillAll(zset string) (int64, error) {
 	now := time.Now()
 	limit := now.AddDate(0, 0, -deadExpirationInDays).Unix() // 90 days ago
-	res, err := removeAndKillAllCmd.Run(r.client, []string{zset, base.DeadQueue},
-		now.Unix(), limit, maxDeadTasks).Result()
+	res, err := removeAndKillAllCmd.Run(r.client,
+		[]string{zset, base.DeadQueue, base.ReplicationEnabled, base.ReplicationLog},
+		now.Unix(), limit, maxDeadTasks, unixMilli(now)).Result()
 	if err != nil {
 		return 0, err
 	}
@@ -649,19 +1223,30 @@ func (r *RDB) DeleteScheduledTask(id xid.ID, score int64) error {
 	return r.deleteTask(base.ScheduledQueue, id.String(), float64(score))
 }
 
+// KEYS[1] -> ZSET to delete task from (e.g., retry queue)
+// KEYS[2] -> asynq:replication
+// KEYS[3] -> asynq:replication:log
+// ARGV[1] -> score of the task to delete
+// ARGV[2] -> id of the task to delete
+// ARGV[3] -> current unix time in milliseconds
 var deleteTaskCmd = redis.NewScript(`
 local msgs = redis.call("ZRANGEBYSCORE", KEYS[1], ARGV[1], ARGV[1])
 for _, msg in ipairs(msgs) do
 	local decoded = cjson.decode(msg)
 	if decoded["ID"] == ARGV[2] then
 		redis.call("ZREM", KEYS[1], msg)
+		if redis.call("EXISTS", KEYS[2]) == 1 then
+			redis.call("RPUSH", KEYS[3], '{"op":"del","id":"' .. ARGV[2] .. '","time":' .. ARGV[3] .. '}')
+		end
 		return 1
 	end
 end
 return 0`)
 
 func (r *RDB) deleteTask(zset, id string, score float64) error {
-	res, err := deleteTaskCmd.Run(r.client, []string{zset}, score, id).Result()
+	res, err := deleteTaskCmd.Run(r.client,
+		[]string{zset, base.ReplicationEnabled, base.ReplicationLog},
+		score, id, unixMilli(time.Now())).Result()
 	if err != nil {
 		return err
 	}
@@ -677,17 +1262,74 @@ func (r *RDB) deleteTask(zset, id string, score float64) error {
 
 // DeleteAllDeadTasks deletes all tasks from the dead queue.
 func (r *RDB) DeleteAllDeadTasks() error {
-	return r.client.Del(base.DeadQueue).Err()
+	return r.deleteAll(base.DeadQueue)
 }
 
 // DeleteAllRetryTasks deletes all tasks from the dead queue.
 func (r *RDB) DeleteAllRetryTasks() error {
-	return r.client.Del(base.RetryQueue).Err()
+	return r.deleteAll(base.RetryQueue)
 }
 
 // DeleteAllScheduledTasks deletes all tasks from the dead queue.
 func (r *RDB) DeleteAllScheduledTasks() error {
-	return r.client.Del(base.ScheduledQueue).Err()
+	return r.deleteAll(base.ScheduledQueue)
+}
+
+// KEYS[1] -> ZSET to delete tasks from (e.g., retry queue)
+// KEYS[2] -> asynq:replication
+// KEYS[3] -> asynq:replication:log
+// ARGV[1] -> current unix time in milliseconds
+var deleteAllCmd = redis.NewScript(`
+if redis.call("EXISTS", KEYS[2]) == 1 then
+	for _, msg in ipairs(redis.call("ZRANGE", KEYS[1], 0, -1)) do
+		redis.call("RPUSH", KEYS[3],
+			'{"op":"del","id":"' .. cjson.decode(msg)["ID"] .. '","time":' .. ARGV[1] .. '}')
+	end
+end
+redis.call("DEL", KEYS[1])
+return redis.status_reply("OK")`)
+
+func (r *RDB) deleteAll(zset string) error {
+	return deleteAllCmd.Run(r.client,
+		[]string{zset, base.ReplicationEnabled, base.ReplicationLog},
+		unixMilli(time.Now())).Err()
+}
+
+// KEYS[1] -> asynq:scheduled
+// ARGV[1] -> task ID
+// ARGV[2] -> queue name, or empty to match any queue
+// ARGV[3] -> new score
+var rescheduleCmd = redis.NewScript(`
+for _, msg in ipairs(redis.call("ZRANGE", KEYS[1], 0, -1)) do
+	local decoded = cjson.decode(msg)
+	local q = decoded["Queue"]
+	if decoded["ID"] == ARGV[1] and
+		(ARGV[2] == "" or q == ARGV[2] or string.sub(q, 1, #ARGV[2] + 1) == ARGV[2] .. "#") then
+		redis.call("ZADD", KEYS[1], "XX", ARGV[3], msg)
+		return 1
+	end
+end
+return 0`)
+
+// RescheduleTask finds a scheduled task that matches the given queue and id,
+// and changes the time to process the task to processAt, leaving the task
+// message intact. Tasks in the shards of the queue match, and if qname is
+// empty, a task of any queue matches.
+// If a task that matches the queue and id does not exist, it returns ErrTaskNotFound.
+func (r *RDB) RescheduleTask(qname string, id xid.ID, processAt time.Time) error {
+	res, err := rescheduleCmd.Run(r.client, []string{base.ScheduledQueue},
+		id.String(), strings.ToLower(qname), processAt.Unix()).Result()
+	if err != nil {
+		return err
+	}
+	n, ok := res.(int64)
+	if !ok {
+		return fmt.Errorf("could not cast %v to int64", res)
+	}
+	if n == 0 {
+		return ErrTaskNotFound
+	}
+	return nil
 }
 
 // ErrQueueNotFound indicates specified queue does not exist.
@@ -708,43 +1350,355 @@ func (e *ErrQueueNotEmpty) Error() string {
 	return fmt.Sprintf("queue %q is not empty", e.qname)
 }
 
-// Skip checking whether queue is empty before removing.
-var removeQueueForceCmd = redis.NewScript(`
-local n = redis.call("SREM", KEYS[1], KEYS[2])
-if n == 0 then
-	return redis.error_reply("LIST NOT FOUND")
+// Task states reported by GetTaskInfo.
+const (
+	TaskStateEnqueued   = "enqueued"
+	TaskStateInProgress = "in_progress"
+	TaskStateScheduled  = "scheduled"
+	TaskStateRetry      = "retry"
+	TaskStateDead       = "dead"
+	TaskStateCompleted  = "completed"
+)
+
+// TaskInfo describes a task and its current state.
+type TaskInfo struct {
+	// Msg is the task message. Nil if the task is completed.
+	Msg *base.TaskMessage
+
+	// State is one of the TaskState constants.
+	State string
+
+	// Score is the zset score of the task if the task is
+	// in scheduled, retry or dead state, otherwise zero.
+	Score int64
+}
+
+// KEYS[1] -> asynq:queues:<qname>
+// KEYS[2] -> asynq:in_progress
+// KEYS[3] -> asynq:scheduled
+// KEYS[4] -> asynq:retry
+// KEYS[5] -> asynq:dead
+// KEYS[6:] -> asynq:queues:<qname>#<i> for each shard of the queue
+// ARGV[1] -> task ID
+// ARGV[2] -> queue name
+var getTaskInfoCmd = redis.NewScript(`
+local function matches(msg)
+	local decoded = cjson.decode(msg)
+	if decoded["ID"] ~= ARGV[1] then
+		return false
+	end
+	local q = decoded["Queue"]
+	return q == ARGV[2] or string.sub(q, 1, #ARGV[2] + 1) == ARGV[2] .. "#"
+end
+local lists = {{KEYS[1], "enqueued"}}
+for i = 6, #KEYS do
+	table.insert(lists, {KEYS[i], "enqueued"})
+end
+table.insert(lists, {KEYS[2], "in_progress"})
+for _, l in ipairs(lists) do
+	for _, msg in ipairs(redis.call("LRANGE", l[1], 0, -1)) do
+		if matches(msg) then
+			return {l[2], msg, "0"}
+		end
+	end
+end
+local zsets = {{KEYS[3], "scheduled"}, {KEYS[4], "retry"}, {KEYS[5], "dead"}}
+for _, z in ipairs(zsets) do
+	local entries = redis.call("ZRANGE", z[1], 0, -1, "WITHSCORES")
+	for i = 1, #entries, 2 do
+		if matches(entries[i]) then
+			return {z[2], entries[i], entries[i+1]}
+		end
+	end
+end
+return {}`)
+
+// GetTaskInfo finds a task that matches the given queue and id, and returns
+// the task and its current state. Tasks in the shards of the queue match.
+//
+// Since tasks are deleted once processed successfully, a task is reported as
+// completed only if its execution history is recorded and the last attempt
+// succeeded. If a task is not found, it returns ErrTaskNotFound.
+func (r *RDB) GetTaskInfo(qname string, id xid.ID) (*TaskInfo, error) {
+	qkeys, _, err := r.queueKeys(qname)
+	if err != nil {
+		return nil, err
+	}
+	keys := append([]string{qkeys[0], base.InProgressQueue, base.ScheduledQueue, base.RetryQueue, base.DeadQueue},
+		qkeys[1:]...)
+	res, err := getTaskInfoCmd.Run(r.client, keys, id.String(), strings.ToLower(qname)).Result()
+	if err != nil {
+		return nil, err
+	}
+	data, err := cast.ToStringSliceE(res)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return r.completedTaskInfo(id)
+	}
+	var msg base.TaskMessage
+	if err := json.Unmarshal([]byte(data[1]), &msg); err != nil {
+		return nil, err
+	}
+	score, err := strconv.ParseFloat(data[2], 64)
+	if err != nil {
+		return nil, err
+	}
+	return &TaskInfo{Msg: &msg, State: data[0], Score: int64(score)}, nil
+}
+
+func (r *RDB) completedTaskInfo(id xid.ID) (*TaskInfo, error) {
+	history, err := r.TaskHistory(id.String())
+	if err != nil {
+		return nil, err
+	}
+	if len(history) == 0 || history[len(history)-1].ErrorMsg != "" {
+		return nil, ErrTaskNotFound
+	}
+	return &TaskInfo{State: TaskStateCompleted}, nil
+}
+
+// ScanTasks returns up to count tasks in the given state, starting from the
+// task at the given offset, and the offset of the task to scan next.
+// The next offset is zero if there are no more tasks to scan.
+//
+// Enqueued and in-progress tasks are scanned from the oldest one, and scheduled,
+// retry and dead tasks in the order of their scores. The queue name is used
+// only for enqueued tasks, since the tasks in the other states of all queues
+// are stored together. Enqueued tasks of a sharded queue are scanned shard
+// after shard.
+//
+// Since tasks are not locked while scanning, a task which changes its
+// state between two scans may be skipped or returned twice.
+func (r *RDB) ScanTasks(state, qname string, offset, count int64) ([]*TaskInfo, int64, error) {
+	if count <= 0 {
+		return nil, 0, fmt.Errorf("count must be positive: %d", count)
+	}
+	var entries []redis.Z
+	switch state {
+	case TaskStateEnqueued, TaskStateInProgress:
+		var (
+			data []string
+			err  error
+		)
+		if state == TaskStateEnqueued {
+			data, err = r.scanQueue(qname, offset, count)
+		} else {
+			data, err = r.scanList(base.InProgressQueue, offset, count)
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		for _, s := range data {
+			entries = append(entries, redis.Z{Member: s})
+		}
+	case TaskStateScheduled, TaskStateRetry, TaskStateDead:
+		key := map[string]string{
+			TaskStateScheduled: base.ScheduledQueue,
+			TaskStateRetry:     base.RetryQueue,
+			TaskStateDead:      base.DeadQueue,
+		}[state]
+		var err error
+		entries, err = r.client.ZRangeWithScores(key, offset, offset+count-1).Result()
+		if err != nil {
+			return nil, 0, err
+		}
+	default:
+		return nil, 0, fmt.Errorf("cannot scan tasks in %q state", state)
+	}
+	var tasks []*TaskInfo
+	for _, z := range entries {
+		s, ok := z.Member.(string)
+		if !ok {
+			continue // bad data, ignore and continue
+		}
+		var msg base.TaskMessage
+		if err := json.Unmarshal([]byte(s), &msg); err != nil {
+			continue // bad data, ignore and continue
+		}
+		tasks = append(tasks, &TaskInfo{Msg: &msg, State: state, Score: int64(z.Score)})
+	}
+	next := offset + int64(len(entries))
+	if int64(len(entries)) < count {
+		next = 0
+	}
+	return tasks, next, nil
+}
+
+// scanList returns up to count tasks of the list from the given offset,
+// starting from the oldest task.
+func (r *RDB) scanList(key string, offset, count int64) ([]string, error) {
+	// Note: Because we use LPUSH to redis list, the oldest task is the last one.
+	data, err := r.client.LRange(key, -offset-count, -offset-1).Result()
+	if err != nil {
+		return nil, err
+	}
+	reverse(data)
+	return data, nil
+}
+
+// scanQueue returns up to count tasks of the queue and its shards from the
+// given offset, as if the queue and its shards were a single list.
+func (r *RDB) scanQueue(qname string, offset, count int64) ([]string, error) {
+	qkeys, _, err := r.queueKeys(qname)
+	if err != nil {
+		return nil, err
+	}
+	if len(qkeys) == 1 {
+		return r.scanList(qkeys[0], offset, count)
+	}
+	pipe := r.client.Pipeline()
+	var lens []*redis.IntCmd
+	for _, qkey := range qkeys {
+		lens = append(lens, pipe.LLen(qkey))
+	}
+	if _, err := pipe.Exec(); err != nil {
+		return nil, err
+	}
+	var res []string
+	for i, qkey := range qkeys {
+		if n := lens[i].Val(); offset >= n {
+			offset -= n
+			continue
+		}
+		data, err := r.scanList(qkey, offset, count-int64(len(res)))
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, data...)
+		if int64(len(res)) >= count {
+			break
+		}
+		offset = 0
+	}
+	return res, nil
+}
+
+// KEYS[1] -> asynq:queues
+// KEYS[2] -> asynq:scheduled
+// KEYS[3] -> asynq:retry
+// KEYS[4] -> asynq:dead
+// ARGV -> state, score, queue key and encoded message of each task
+var importTasksCmd = redis.NewScript(`
+local zsets = {scheduled = KEYS[2], retry = KEYS[3], dead = KEYS[4]}
+for i = 1, #ARGV, 4 do
+	local state, score, qkey, msg = ARGV[i], ARGV[i+1], ARGV[i+2], ARGV[i+3]
+	if state == "enqueued" then
+		redis.call("LPUSH", qkey, msg)
+		redis.call("SADD", KEYS[1], qkey)
+	else
+		redis.call("ZADD", zsets[state], score, msg)
+	end
 end
-redis.call("DEL", KEYS[2])
 return redis.status_reply("OK")`)
 
-// Checks whether queue is empty before removing.
+// ImportTasks adds the tasks in the states given by TaskInfo.State,
+// which should be one of enqueued, scheduled, retry or dead, atomically.
+// Enqueued tasks are added to the queue of the task in the given order,
+// so that the first one is processed first, and the others with the score
+// given by TaskInfo.Score.
+func (r *RDB) ImportTasks(tasks []*TaskInfo) error {
+	var args []interface{}
+	for _, t := range tasks {
+		switch t.State {
+		case TaskStateEnqueued, TaskStateScheduled, TaskStateRetry, TaskStateDead:
+		default:
+			return fmt.Errorf("cannot import tasks in %q state", t.State)
+		}
+		bytes, err := json.Marshal(t.Msg)
+		if err != nil {
+			return err
+		}
+		args = append(args, t.State, t.Score, base.QueueKey(t.Msg.Queue), bytes)
+	}
+	if len(args) == 0 {
+		return nil
+	}
+	return importTasksCmd.Run(r.client,
+		[]string{base.AllQueues, base.ScheduledQueue, base.RetryQueue, base.DeadQueue},
+		args...).Err()
+}
+
+// KEYS[1] -> asynq:queues
+// KEYS[2] -> asynq:scheduled
+// KEYS[3] -> asynq:retry
+// KEYS[4] -> asynq:dead
+// KEYS[5] -> asynq:replication
+// KEYS[6] -> asynq:replication:log
+// KEYS[7:] -> asynq:queues:<qname> followed by the keys of its shards
+// ARGV[1] -> queue name
+// ARGV[2] -> whether to remove the queue regardless of whether it's empty
+// ARGV[3] -> current unix time in milliseconds
 var removeQueueCmd = redis.NewScript(`
-local l = redis.call("LLEN", KEYS[2]) if l > 0 then
-	return redis.error_reply("LIST NOT EMPTY")
+local exists = false
+local count = 0
+for i = 7, #KEYS do
+	if redis.call("SISMEMBER", KEYS[1], KEYS[i]) == 1 then
+		exists = true
+	end
+	count = count + redis.call("LLEN", KEYS[i])
 end
-local n = redis.call("SREM", KEYS[1], KEYS[2])
-if n == 0 then
+if not exists then
 	return redis.error_reply("LIST NOT FOUND")
 end
-redis.call("DEL", KEYS[2])
+local found = {}
+for i = 2, 4 do
+	found[i] = {}
+	for _, msg in ipairs(redis.call("ZRANGE", KEYS[i], 0, -1)) do
+		local q = cjson.decode(msg)["Queue"]
+		if q == ARGV[1] or string.sub(q, 1, #ARGV[1] + 1) == ARGV[1] .. "#" then
+			table.insert(found[i], msg)
+			count = count + 1
+		end
+	end
+end
+if count > 0 and ARGV[2] ~= "1" then
+	return redis.error_reply("LIST NOT EMPTY")
+end
+local replicate = redis.call("EXISTS", KEYS[5]) == 1
+local function del(msg)
+	if replicate then
+		redis.call("RPUSH", KEYS[6],
+			'{"op":"del","id":"' .. cjson.decode(msg)["ID"] .. '","time":' .. ARGV[3] .. '}')
+	end
+end
+for i = 2, 4 do
+	for _, msg in ipairs(found[i]) do
+		redis.call("ZREM", KEYS[i], msg)
+		del(msg)
+	end
+end
+for i = 7, #KEYS do
+	if replicate then
+		for _, msg in ipairs(redis.call("LRANGE", KEYS[i], 0, -1)) do
+			del(msg)
+		end
+	end
+	redis.call("SREM", KEYS[1], KEYS[i])
+	redis.call("DEL", KEYS[i])
+end
 return redis.status_reply("OK")`)
 
-// RemoveQueue removes the specified queue.
+// RemoveQueue removes the specified queue and its shards along with the
+// scheduled, retry and dead tasks which belong to the queue.
 //
 // If force is set to true, it will remove the queue regardless
-// of whether the queue is empty.
+// of whether the queue has any tasks.
 // If force is set to false, it will only remove the queue if
-// it is empty.
+// it has no tasks.
 func (r *RDB) RemoveQueue(qname string, force bool) error {
-	var script *redis.Script
+	forceArg := "0"
 	if force {
-		script = removeQueueForceCmd
-	} else {
-		script = removeQueueCmd
+		forceArg = "1"
+	}
+	qkeys, _, err := r.queueKeys(qname)
+	if err != nil {
+		return err
 	}
-	err := script.Run(r.client,
-		[]string{base.AllQueues, base.QueueKey(qname)},
-		force).Err()
+	keys := append([]string{base.AllQueues, base.ScheduledQueue, base.RetryQueue, base.DeadQueue,
+		base.ReplicationEnabled, base.ReplicationLog}, qkeys...)
+	err = removeQueueCmd.Run(r.client, keys, strings.ToLower(qname), forceArg, unixMilli(time.Now())).Err()
 	if err != nil {
 		switch err.Error() {
 		case "LIST NOT FOUND":
@@ -758,6 +1712,182 @@ func (r *RDB) RemoveQueue(qname string, force bool) error {
 	return nil
 }
 
+// KEYS[1] -> asynq:queues:<from>
+// KEYS[2] -> asynq:queues:<to>
+// KEYS[3] -> asynq:queues
+// KEYS[4] -> asynq:replication
+// KEYS[5] -> asynq:replication:log
+// ARGV[1] -> current unix time in milliseconds
+// ARGV[2:] -> pairs of task message to remove from the source queue and
+// task message to add to the destination queue
+var moveTasksCmd = redis.NewScript(`
+local replicate = redis.call("EXISTS", KEYS[4]) == 1
+local n = 0
+for i = 2, #ARGV, 2 do
+	if redis.call("LREM", KEYS[1], 1, ARGV[i]) > 0 then
+		redis.call("LPUSH", KEYS[2], ARGV[i+1])
+		n = n + 1
+		if replicate then
+			-- replace the task in the replica with the moved one.
+			redis.call("RPUSH", KEYS[5],
+				'{"op":"add","state":"enqueued","time":' .. ARGV[1] .. ',"msg":' .. ARGV[i+1] .. '}')
+		end
+	end
+end
+if n > 0 then
+	redis.call("SADD", KEYS[3], KEYS[2])
+end
+return n`)
+
+// max number of tasks to move in a single script call.
+const moveBatchSize = 1000
+
+// MoveTasks moves enqueued tasks whose type matches the given pattern
+// from one queue and its shards to another, and returns the number of
+// tasks moved.
+//
+// The pattern syntax is the same as path.Match (e.g. "export:*").
+// Empty pattern matches all tasks.
+//
+// Each task is moved atomically. Tasks which are dequeued while
+// moving the tasks are not moved.
+func (r *RDB) MoveTasks(from, to, pattern string) (int64, error) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return 0, err
+	}
+	if base.QueueKey(from) == base.QueueKey(to) {
+		return 0, fmt.Errorf("cannot move tasks to the same queue %q", from)
+	}
+	qkeys, exists, err := r.queueKeys(from)
+	if err != nil {
+		return 0, err
+	}
+	if !exists {
+		return 0, &ErrQueueNotFound{from}
+	}
+	var total int64
+	for _, qkey := range qkeys {
+		n, err := r.moveTasks(qkey, to, pattern)
+		total += n
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// moveTasks moves the tasks whose type matches the pattern from the
+// list at the given key to the queue, preserving the order of tasks.
+func (r *RDB) moveTasks(from, to, pattern string) (int64, error) {
+	data, err := r.client.LRange(from, 0, -1).Result()
+	if err != nil {
+		return 0, err
+	}
+	keys := []string{from, base.QueueKey(to), base.AllQueues, base.ReplicationEnabled, base.ReplicationLog}
+	var (
+		total int64
+		args  []interface{}
+	)
+	flush := func() error {
+		n, err := moveTasksCmd.Run(r.client, keys,
+			append([]interface{}{unixMilli(time.Now())}, args...)...).Int64()
+		total += n
+		args = args[:0]
+		return err
+	}
+	// move the oldest task first to preserve the order of tasks.
+	for i := len(data) - 1; i >= 0; i-- {
+		var msg base.TaskMessage
+		if err := json.Unmarshal([]byte(data[i]), &msg); err != nil {
+			return total, err
+		}
+		if pattern != "" {
+			if ok, _ := path.Match(pattern, msg.Type); !ok {
+				continue
+			}
+		}
+		msg.Queue = strings.ToLower(to)
+		bytes, err := json.Marshal(&msg)
+		if err != nil {
+			return total, err
+		}
+		args = append(args, data[i], string(bytes))
+		if len(args) >= 2*moveBatchSize {
+			if err := flush(); err != nil {
+				return total, err
+			}
+		}
+	}
+	if len(args) > 0 {
+		if err := flush(); err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// queueKeys returns the key of the queue followed by the keys of its shards
+// sorted by name, and whether any of them is in the set of all queues.
+// The queue key is returned even if there's no such queue.
+func (r *RDB) queueKeys(qname string) (keys []string, exists bool, err error) {
+	all, err := r.client.SMembers(base.AllQueues).Result()
+	if err != nil {
+		return nil, false, err
+	}
+	qkey := base.QueueKey(qname)
+	var shards []string
+	for _, key := range all {
+		switch {
+		case key == qkey:
+			exists = true
+		case base.UnshardQueue(key) == qkey:
+			shards = append(shards, key)
+		}
+	}
+	sort.Strings(shards)
+	return append([]string{qkey}, shards...), exists || len(shards) > 0, nil
+}
+
+// CancelTasksByType publishes cancelation messages for the in-progress
+// tasks whose type matches the given pattern, and returns the number of
+// tasks for which the message was published.
+//
+// The pattern syntax is the same as path.Match (e.g. "export:*").
+// Unlike MoveTasks, empty pattern matches no task; use "*" to cancel
+// all in-progress tasks.
+func (r *RDB) CancelTasksByType(pattern string) (int64, error) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return 0, err
+	}
+	if pattern == "" {
+		return 0, errors.New("pattern is required to cancel tasks")
+	}
+	data, err := r.client.LRange(base.InProgressQueue, 0, -1).Result()
+	if err != nil {
+		return 0, err
+	}
+	pipe := r.client.Pipeline()
+	var n int64
+	for _, s := range data {
+		var msg base.TaskMessage
+		if err := json.Unmarshal([]byte(s), &msg); err != nil {
+			continue // bad data, ignore and continue
+		}
+		if ok, _ := path.Match(pattern, msg.Type); !ok {
+			continue
+		}
+		pipe.Publish(base.CancelChannel, msg.ID.String())
+		n++
+	}
+	if n == 0 {
+		return 0, nil
+	}
+	if _, err := pipe.Exec(); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
 // Note: Script also removes stale keys.
 var listProcessesCmd = redis.NewScript(`
 local res = {}
@@ -10,6 +10,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/go-redis/redis/v7"
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
 	h "github.com/hibiken/asynq/internal/asynqtest"
@@ -149,6 +150,67 @@ func TestCurrentStatsWithoutData(t *testing.T) {
 	}
 }
 
+func TestLatencies(t *testing.T) {
+	r := setup(t)
+	now := time.Now()
+	m1 := h.NewTaskMessage("send_email", nil)
+	m1.EnqueuedAt = now.Add(-10 * time.Minute).Unix()
+	m2 := h.NewTaskMessage("reindex", nil)
+	m2.EnqueuedAt = now.Add(-time.Minute).Unix()
+	m3 := h.NewTaskMessageWithQueue("important_notification", nil, "critical")
+	m3.EnqueuedAt = now.Add(-30 * time.Second).Unix()
+	m4 := h.NewTaskMessageWithQueue("minor_notification", nil, "low")
+	m4.EnqueuedAt = now.Add(time.Minute).Unix() // enqueued before its scheduled time
+	m5 := h.NewTaskMessageWithQueue("sync", nil, "legacy")
+
+	tests := []struct {
+		enqueued map[string][]*base.TaskMessage
+		want     map[string]time.Duration
+	}{
+		{
+			enqueued: map[string][]*base.TaskMessage{
+				base.DefaultQueueName: {m1, m2}, // m1 is the oldest
+				"critical":            {m3},
+				"low":                 {m4},
+				"legacy":              {m5},
+				"empty":               {},
+			},
+			want: map[string]time.Duration{
+				base.DefaultQueueName: 10 * time.Minute,
+				"critical":            30 * time.Second,
+				"low":                 0,
+				"legacy":              0, // unknown
+				"empty":               0,
+			},
+		},
+		{
+			enqueued: map[string][]*base.TaskMessage{},
+			want:     map[string]time.Duration{},
+		},
+	}
+
+	for _, tc := range tests {
+		h.FlushDB(t, r.client)
+		for qname, msgs := range tc.enqueued {
+			h.SeedEnqueuedQueue(t, r.client, msgs, qname)
+		}
+
+		got, err := r.Latencies()
+		if err != nil {
+			t.Errorf("r.Latencies() returned error: %v", err)
+			continue
+		}
+		// allow up to a second difference since EnqueuedAt is in Unix time.
+		durCmpOpt := cmp.Comparer(func(x, y time.Duration) bool {
+			d := x - y
+			return -time.Second <= d && d <= time.Second
+		})
+		if diff := cmp.Diff(tc.want, got, durCmpOpt); diff != "" {
+			t.Errorf("r.Latencies() = %v, want %v; (-want, +got)\n%s", got, tc.want, diff)
+		}
+	}
+}
+
 func TestHistoricalStats(t *testing.T) {
 	r := setup(t)
 	now := time.Now().UTC()
@@ -1335,6 +1397,166 @@ func TestEnqueueAllDeadTasks(t *testing.T) {
 	}
 }
 
+func TestKillEnqueuedTask(t *testing.T) {
+	r := setup(t)
+	m1 := h.NewTaskMessage("send_email", nil)
+	m2 := h.NewTaskMessage("reindex", nil)
+	m3 := h.NewTaskMessageWithQueue("gen_thumbnail", nil, "low")
+	t1 := time.Now().Add(-time.Hour)
+
+	tests := []struct {
+		enqueued     map[string][]*base.TaskMessage
+		dead         []h.ZSetEntry
+		qname        string
+		id           xid.ID
+		want         error
+		wantEnqueued map[string][]*base.TaskMessage
+		wantDead     []h.ZSetEntry
+	}{
+		{
+			enqueued: map[string][]*base.TaskMessage{
+				"default": {m1, m2},
+				"low":     {m3},
+			},
+			dead:  []h.ZSetEntry{},
+			qname: "default",
+			id:    m1.ID,
+			want:  nil,
+			wantEnqueued: map[string][]*base.TaskMessage{
+				"default": {m2},
+				"low":     {m3},
+			},
+			wantDead: []h.ZSetEntry{
+				{Msg: m1, Score: float64(time.Now().Unix())},
+			},
+		},
+		{
+			enqueued: map[string][]*base.TaskMessage{
+				"default": {m1, m2},
+				"low":     {m3},
+			},
+			dead: []h.ZSetEntry{
+				{Msg: m3, Score: float64(t1.Unix())},
+			},
+			qname: "default",
+			id:    m3.ID, // task in another queue
+			want:  ErrTaskNotFound,
+			wantEnqueued: map[string][]*base.TaskMessage{
+				"default": {m1, m2},
+				"low":     {m3},
+			},
+			wantDead: []h.ZSetEntry{
+				{Msg: m3, Score: float64(t1.Unix())},
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		h.FlushDB(t, r.client)
+		for qname, msgs := range tc.enqueued {
+			h.SeedEnqueuedQueue(t, r.client, msgs, qname)
+		}
+		h.SeedDeadQueue(t, r.client, tc.dead)
+
+		got := r.KillEnqueuedTask(tc.qname, tc.id)
+		if got != tc.want {
+			t.Errorf("(*RDB).KillEnqueuedTask(%q, %v) = %v, want %v",
+				tc.qname, tc.id, got, tc.want)
+			continue
+		}
+
+		for qname, want := range tc.wantEnqueued {
+			gotEnqueued := h.GetEnqueuedMessages(t, r.client, qname)
+			if diff := cmp.Diff(want, gotEnqueued, h.SortMsgOpt); diff != "" {
+				t.Errorf("mismatch found in %q; (-want,+got)\n%s", base.QueueKey(qname), diff)
+			}
+		}
+
+		gotDead := h.GetDeadEntries(t, r.client)
+		if diff := cmp.Diff(tc.wantDead, gotDead, h.SortZSetEntryOpt, timeCmpOpt); diff != "" {
+			t.Errorf("mismatch found in %q; (-want,+got)\n%s",
+				base.DeadQueue, diff)
+		}
+	}
+}
+
+func TestKillAllEnqueuedTasks(t *testing.T) {
+	r := setup(t)
+	m1 := h.NewTaskMessage("send_email", nil)
+	m2 := h.NewTaskMessage("reindex", nil)
+	m3 := h.NewTaskMessageWithQueue("gen_thumbnail", nil, "low")
+	t1 := time.Now().Add(-time.Hour)
+
+	tests := []struct {
+		enqueued     map[string][]*base.TaskMessage
+		dead         []h.ZSetEntry
+		qname        string
+		want         int64
+		wantEnqueued map[string][]*base.TaskMessage
+		wantDead     []h.ZSetEntry
+	}{
+		{
+			enqueued: map[string][]*base.TaskMessage{
+				"default": {m1, m2},
+				"low":     {m3},
+			},
+			dead: []h.ZSetEntry{
+				{Msg: m3, Score: float64(t1.Unix())},
+			},
+			qname: "default",
+			want:  2,
+			wantEnqueued: map[string][]*base.TaskMessage{
+				"default": {},
+				"low":     {m3},
+			},
+			wantDead: []h.ZSetEntry{
+				{Msg: m1, Score: float64(time.Now().Unix())},
+				{Msg: m2, Score: float64(time.Now().Unix())},
+				{Msg: m3, Score: float64(t1.Unix())},
+			},
+		},
+		{
+			enqueued: map[string][]*base.TaskMessage{
+				"default": {},
+			},
+			dead:  []h.ZSetEntry{},
+			qname: "default",
+			want:  0,
+			wantEnqueued: map[string][]*base.TaskMessage{
+				"default": {},
+			},
+			wantDead: []h.ZSetEntry{},
+		},
+	}
+
+	for _, tc := range tests {
+		h.FlushDB(t, r.client)
+		for qname, msgs := range tc.enqueued {
+			h.SeedEnqueuedQueue(t, r.client, msgs, qname)
+		}
+		h.SeedDeadQueue(t, r.client, tc.dead)
+
+		got, err := r.KillAllEnqueuedTasks(tc.qname)
+		if got != tc.want || err != nil {
+			t.Errorf("(*RDB).KillAllEnqueuedTasks(%q) = %v, %v, want %v, nil", tc.qname, got, err, tc.want)
+			continue
+		}
+
+		for qname, want := range tc.wantEnqueued {
+			gotEnqueued := h.GetEnqueuedMessages(t, r.client, qname)
+			if diff := cmp.Diff(want, gotEnqueued, h.SortMsgOpt); diff != "" {
+				t.Errorf("mismatch found in %q; (-want,+got)\n%s", base.QueueKey(qname), diff)
+			}
+		}
+
+		gotDead := h.GetDeadEntries(t, r.client)
+		if diff := cmp.Diff(tc.wantDead, gotDead, h.SortZSetEntryOpt, timeCmpOpt); diff != "" {
+			t.Errorf("mismatch found in %q; (-want,+got)\n%s",
+				base.DeadQueue, diff)
+		}
+	}
+}
+
 func TestKillRetryTask(t *testing.T) {
 	r := setup(t)
 	m1 := h.NewTaskMessage("send_email", nil)
@@ -1925,6 +2147,216 @@ func TestDeleteAllScheduledTasks(t *testing.T) {
 	}
 }
 
+func TestGetTaskInfo(t *testing.T) {
+	r := setup(t)
+	m1 := h.NewTaskMessage("send_email", nil)
+	m2 := h.NewTaskMessage("reindex", nil)
+	m3 := h.NewTaskMessageWithQueue("gen_thumbnail", nil, "low")
+	m4 := h.NewTaskMessage("sync", nil)
+	m4.ErrorMsg = "something went wrong"
+	m5 := h.NewTaskMessage("export_csv", nil)
+	m6 := h.NewTaskMessage("completed", nil)
+	m7 := h.NewTaskMessage("failed_and_deleted", nil)
+	t1 := time.Now().Add(time.Hour).Unix()
+	t2 := time.Now().Add(-time.Hour).Unix()
+
+	h.SeedEnqueuedQueue(t, r.client, []*base.TaskMessage{m1})
+	h.SeedInProgressQueue(t, r.client, []*base.TaskMessage{m2})
+	h.SeedScheduledQueue(t, r.client, []h.ZSetEntry{{Msg: m3, Score: float64(t1)}})
+	h.SeedRetryQueue(t, r.client, []h.ZSetEntry{{Msg: m4, Score: float64(t1)}})
+	h.SeedDeadQueue(t, r.client, []h.ZSetEntry{{Msg: m5, Score: float64(t2)}})
+	if err := r.RecordAttempt(m6.ID.String(), &base.TaskAttempt{}, 10); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.RecordAttempt(m7.ID.String(), &base.TaskAttempt{ErrorMsg: "failed"}, 10); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		qname string
+		id    xid.ID
+		want  *TaskInfo
+		err   error
+	}{
+		{"default", m1.ID, &TaskInfo{Msg: m1, State: TaskStateEnqueued}, nil},
+		{"default", m2.ID, &TaskInfo{Msg: m2, State: TaskStateInProgress}, nil},
+		{"low", m3.ID, &TaskInfo{Msg: m3, State: TaskStateScheduled, Score: t1}, nil},
+		{"default", m4.ID, &TaskInfo{Msg: m4, State: TaskStateRetry, Score: t1}, nil},
+		{"default", m5.ID, &TaskInfo{Msg: m5, State: TaskStateDead, Score: t2}, nil},
+		{"default", m6.ID, &TaskInfo{State: TaskStateCompleted}, nil},
+		{"default", m3.ID, nil, ErrTaskNotFound}, // wrong queue
+		{"default", m7.ID, nil, ErrTaskNotFound},
+		{"default", xid.New(), nil, ErrTaskNotFound},
+	}
+
+	for _, tc := range tests {
+		got, err := r.GetTaskInfo(tc.qname, tc.id)
+		if err != tc.err {
+			t.Errorf("(*RDB).GetTaskInfo(%q, %v) returned error %v, want %v", tc.qname, tc.id, err, tc.err)
+			continue
+		}
+		if diff := cmp.Diff(tc.want, got); diff != "" {
+			t.Errorf("(*RDB).GetTaskInfo(%q, %v) = %+v, want %+v; (-want,+got)\n%s",
+				tc.qname, tc.id, got, tc.want, diff)
+		}
+	}
+}
+
+func TestScanTasks(t *testing.T) {
+	r := setup(t)
+	m1 := h.NewTaskMessage("send_email", nil)
+	m2 := h.NewTaskMessage("reindex", nil)
+	m3 := h.NewTaskMessage("gen_thumbnail", nil)
+	m4 := h.NewTaskMessageWithQueue("sync", nil, "low")
+	m5 := h.NewTaskMessage("export_csv", nil)
+	t1 := time.Now().Add(time.Hour).Unix()
+	t2 := time.Now().Add(2 * time.Hour).Unix()
+
+	s1 := h.NewTaskMessageWithQueue("sync", nil, base.ShardQueue("low", 0))
+	s2 := h.NewTaskMessageWithQueue("sync", nil, base.ShardQueue("low", 1))
+	s3 := h.NewTaskMessageWithQueue("sync", nil, base.ShardQueue("low", 1))
+
+	// m1 is the oldest enqueued task.
+	h.SeedEnqueuedQueue(t, r.client, []*base.TaskMessage{m1, m2, m3})
+	h.SeedScheduledQueue(t, r.client, []h.ZSetEntry{{Msg: m5, Score: float64(t2)}, {Msg: m4, Score: float64(t1)}})
+	h.SeedEnqueuedQueue(t, r.client, []*base.TaskMessage{s1}, s1.Queue)
+	h.SeedEnqueuedQueue(t, r.client, []*base.TaskMessage{s2, s3}, s2.Queue)
+
+	tests := []struct {
+		state    string
+		qname    string
+		offset   int64
+		count    int64
+		want     []*TaskInfo
+		wantNext int64
+	}{
+		{
+			state:  TaskStateEnqueued,
+			qname:  "default",
+			offset: 0,
+			count:  2,
+			want: []*TaskInfo{
+				{Msg: m1, State: TaskStateEnqueued},
+				{Msg: m2, State: TaskStateEnqueued},
+			},
+			wantNext: 2,
+		},
+		{
+			state:    TaskStateEnqueued,
+			qname:    "default",
+			offset:   2,
+			count:    2,
+			want:     []*TaskInfo{{Msg: m3, State: TaskStateEnqueued}},
+			wantNext: 0,
+		},
+		{
+			state:  TaskStateEnqueued,
+			qname:  "low", // sharded queue
+			offset: 0,
+			count:  2,
+			want: []*TaskInfo{
+				{Msg: s1, State: TaskStateEnqueued},
+				{Msg: s2, State: TaskStateEnqueued},
+			},
+			wantNext: 2,
+		},
+		{
+			state:    TaskStateEnqueued,
+			qname:    "low",
+			offset:   2,
+			count:    2,
+			want:     []*TaskInfo{{Msg: s3, State: TaskStateEnqueued}},
+			wantNext: 0,
+		},
+		{
+			state:  TaskStateScheduled,
+			offset: 0,
+			count:  2,
+			want: []*TaskInfo{
+				{Msg: m4, State: TaskStateScheduled, Score: t1},
+				{Msg: m5, State: TaskStateScheduled, Score: t2},
+			},
+			wantNext: 2,
+		},
+		{
+			state:    TaskStateDead,
+			offset:   0,
+			count:    2,
+			want:     nil,
+			wantNext: 0,
+		},
+	}
+
+	for _, tc := range tests {
+		got, next, err := r.ScanTasks(tc.state, tc.qname, tc.offset, tc.count)
+		if err != nil {
+			t.Errorf("(*RDB).ScanTasks(%q, %q, %d, %d) returned error: %v", tc.state, tc.qname, tc.offset, tc.count, err)
+			continue
+		}
+		if diff := cmp.Diff(tc.want, got); diff != "" {
+			t.Errorf("(*RDB).ScanTasks(%q, %q, %d, %d) = %+v, want %+v; (-want,+got)\n%s",
+				tc.state, tc.qname, tc.offset, tc.count, got, tc.want, diff)
+		}
+		if next != tc.wantNext {
+			t.Errorf("(*RDB).ScanTasks(%q, %q, %d, %d) returned next offset %d, want %d",
+				tc.state, tc.qname, tc.offset, tc.count, next, tc.wantNext)
+		}
+	}
+
+	if _, _, err := r.ScanTasks(TaskStateCompleted, "default", 0, 10); err == nil {
+		t.Errorf("(*RDB).ScanTasks(%q, ...) returned nil error, want non-nil", TaskStateCompleted)
+	}
+}
+
+func TestImportTasks(t *testing.T) {
+	r := setup(t)
+	m1 := h.NewTaskMessage("send_email", nil)
+	m2 := h.NewTaskMessage("reindex", nil)
+	m3 := h.NewTaskMessageWithQueue("gen_thumbnail", nil, "low")
+	m4 := h.NewTaskMessage("sync", nil)
+	m5 := h.NewTaskMessage("export_csv", nil)
+	t1 := time.Now().Add(time.Hour).Unix()
+	t2 := time.Now().Add(-time.Hour).Unix()
+
+	err := r.ImportTasks([]*TaskInfo{
+		{Msg: m1, State: TaskStateEnqueued},
+		{Msg: m2, State: TaskStateEnqueued},
+		{Msg: m3, State: TaskStateScheduled, Score: t1},
+		{Msg: m4, State: TaskStateRetry, Score: t1},
+		{Msg: m5, State: TaskStateDead, Score: t2},
+	})
+	if err != nil {
+		t.Fatalf("(*RDB).ImportTasks returned error: %v", err)
+	}
+
+	// m1 is processed first.
+	if diff := cmp.Diff([]*base.TaskMessage{m2, m1}, h.GetEnqueuedMessages(t, r.client)); diff != "" {
+		t.Errorf("mismatch found in %q; (-want,+got)\n%s", base.DefaultQueue, diff)
+	}
+	if !r.client.SIsMember(base.AllQueues, base.DefaultQueue).Val() {
+		t.Errorf("%q is not a member of %q", base.DefaultQueue, base.AllQueues)
+	}
+	wantZSets := map[string][]h.ZSetEntry{
+		base.ScheduledQueue: {{Msg: m3, Score: float64(t1)}},
+		base.RetryQueue:     {{Msg: m4, Score: float64(t1)}},
+		base.DeadQueue:      {{Msg: m5, Score: float64(t2)}},
+	}
+	gotZSets := map[string][]h.ZSetEntry{
+		base.ScheduledQueue: h.GetScheduledEntries(t, r.client),
+		base.RetryQueue:     h.GetRetryEntries(t, r.client),
+		base.DeadQueue:      h.GetDeadEntries(t, r.client),
+	}
+	for key, want := range wantZSets {
+		if diff := cmp.Diff(want, gotZSets[key], h.SortZSetEntryOpt); diff != "" {
+			t.Errorf("mismatch found in %q; (-want,+got)\n%s", key, diff)
+		}
+	}
+
+	if err := r.ImportTasks([]*TaskInfo{{Msg: m1, State: TaskStateInProgress}}); err == nil {
+		t.Errorf("(*RDB).ImportTasks with in-progress task returned nil error, want non-nil")
+	}
+}
+
 func TestRemoveQueue(t *testing.T) {
 	r := setup(t)
 	m1 := h.NewTaskMessage("send_email", nil)
@@ -2051,6 +2483,218 @@ func TestRemoveQueueError(t *testing.T) {
 	}
 }
 
+func TestRemoveQueueWithNonEnqueuedTasks(t *testing.T) {
+	r := setup(t)
+	m1 := h.NewTaskMessageWithQueue("send_email", nil, "low")
+	m2 := h.NewTaskMessageWithQueue("reindex", nil, "low")
+	m3 := h.NewTaskMessageWithQueue("gen_thumbnail", nil, "low")
+	m4 := h.NewTaskMessage("sync", nil)
+	now := time.Now()
+
+	tests := []struct {
+		desc          string
+		scheduled     []h.ZSetEntry
+		retry         []h.ZSetEntry
+		dead          []h.ZSetEntry
+		force         bool
+		wantErr       bool
+		wantScheduled []h.ZSetEntry
+		wantRetry     []h.ZSetEntry
+		wantDead      []h.ZSetEntry
+	}{
+		{
+			desc:          "queue with scheduled, retry and dead tasks without force",
+			scheduled:     []h.ZSetEntry{{Msg: m1, Score: float64(now.Add(time.Hour).Unix())}},
+			retry:         []h.ZSetEntry{{Msg: m2, Score: float64(now.Add(time.Hour).Unix())}},
+			dead:          []h.ZSetEntry{{Msg: m3, Score: float64(now.Unix())}, {Msg: m4, Score: float64(now.Unix())}},
+			force:         false,
+			wantErr:       true,
+			wantScheduled: []h.ZSetEntry{{Msg: m1, Score: float64(now.Add(time.Hour).Unix())}},
+			wantRetry:     []h.ZSetEntry{{Msg: m2, Score: float64(now.Add(time.Hour).Unix())}},
+			wantDead:      []h.ZSetEntry{{Msg: m3, Score: float64(now.Unix())}, {Msg: m4, Score: float64(now.Unix())}},
+		},
+		{
+			desc:          "queue with scheduled, retry and dead tasks with force",
+			scheduled:     []h.ZSetEntry{{Msg: m1, Score: float64(now.Add(time.Hour).Unix())}},
+			retry:         []h.ZSetEntry{{Msg: m2, Score: float64(now.Add(time.Hour).Unix())}},
+			dead:          []h.ZSetEntry{{Msg: m3, Score: float64(now.Unix())}, {Msg: m4, Score: float64(now.Unix())}},
+			force:         true,
+			wantErr:       false,
+			wantScheduled: []h.ZSetEntry{},
+			wantRetry:     []h.ZSetEntry{},
+			wantDead:      []h.ZSetEntry{{Msg: m4, Score: float64(now.Unix())}}, // task in other queue is kept
+		},
+	}
+
+	for _, tc := range tests {
+		h.FlushDB(t, r.client)
+		h.SeedEnqueuedQueue(t, r.client, []*base.TaskMessage{}, "low")
+		h.SeedScheduledQueue(t, r.client, tc.scheduled)
+		h.SeedRetryQueue(t, r.client, tc.retry)
+		h.SeedDeadQueue(t, r.client, tc.dead)
+
+		err := r.RemoveQueue("low", tc.force)
+		if gotErr := err != nil; gotErr != tc.wantErr {
+			t.Errorf("%s; (*RDB).RemoveQueue(%q, %t) = %v, want error %t", tc.desc, "low", tc.force, err, tc.wantErr)
+			continue
+		}
+
+		gotScheduled := h.GetScheduledEntries(t, r.client)
+		if diff := cmp.Diff(tc.wantScheduled, gotScheduled, h.SortZSetEntryOpt); diff != "" {
+			t.Errorf("%s; mismatch found in %q; (-want,+got)\n%s", tc.desc, base.ScheduledQueue, diff)
+		}
+		gotRetry := h.GetRetryEntries(t, r.client)
+		if diff := cmp.Diff(tc.wantRetry, gotRetry, h.SortZSetEntryOpt); diff != "" {
+			t.Errorf("%s; mismatch found in %q; (-want,+got)\n%s", tc.desc, base.RetryQueue, diff)
+		}
+		gotDead := h.GetDeadEntries(t, r.client)
+		if diff := cmp.Diff(tc.wantDead, gotDead, h.SortZSetEntryOpt); diff != "" {
+			t.Errorf("%s; mismatch found in %q; (-want,+got)\n%s", tc.desc, base.DeadQueue, diff)
+		}
+	}
+}
+
+func TestMoveTasks(t *testing.T) {
+	r := setup(t)
+	m1 := h.NewTaskMessageWithQueue("export:csv", nil, "low")
+	m2 := h.NewTaskMessageWithQueue("export:pdf", nil, "low")
+	m3 := h.NewTaskMessageWithQueue("reindex", nil, "low")
+	m4 := h.NewTaskMessageWithQueue("send_email", nil, "critical")
+	moved := func(msg *base.TaskMessage, qname string) *base.TaskMessage {
+		m := *msg
+		m.Queue = qname
+		return &m
+	}
+
+	tests := []struct {
+		enqueued     map[string][]*base.TaskMessage
+		from         string
+		to           string
+		pattern      string
+		want         int64
+		wantEnqueued map[string][]*base.TaskMessage
+	}{
+		{
+			enqueued: map[string][]*base.TaskMessage{
+				"low":      {m1, m2, m3},
+				"critical": {m4},
+			},
+			from:    "low",
+			to:      "critical",
+			pattern: "export:*",
+			want:    2,
+			wantEnqueued: map[string][]*base.TaskMessage{
+				"low":      {m3},
+				"critical": {m4, moved(m1, "critical"), moved(m2, "critical")},
+			},
+		},
+		{
+			enqueued: map[string][]*base.TaskMessage{
+				"low":      {m1, m2, m3},
+				"critical": {m4},
+			},
+			from:    "low",
+			to:      "new",
+			pattern: "",
+			want:    3,
+			wantEnqueued: map[string][]*base.TaskMessage{
+				"low":      {},
+				"critical": {m4},
+				"new":      {moved(m1, "new"), moved(m2, "new"), moved(m3, "new")},
+			},
+		},
+		{
+			enqueued: map[string][]*base.TaskMessage{
+				"low":      {m1, m2, m3},
+				"critical": {m4},
+			},
+			from:    "low",
+			to:      "critical",
+			pattern: "no_match",
+			want:    0,
+			wantEnqueued: map[string][]*base.TaskMessage{
+				"low":      {m1, m2, m3},
+				"critical": {m4},
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		h.FlushDB(t, r.client)
+		for qname, msgs := range tc.enqueued {
+			h.SeedEnqueuedQueue(t, r.client, msgs, qname)
+		}
+
+		got, err := r.MoveTasks(tc.from, tc.to, tc.pattern)
+		if err != nil || got != tc.want {
+			t.Errorf("(*RDB).MoveTasks(%q, %q, %q) = %d, %v, want %d, nil",
+				tc.from, tc.to, tc.pattern, got, err, tc.want)
+			continue
+		}
+
+		for qname, want := range tc.wantEnqueued {
+			gotEnqueued := h.GetEnqueuedMessages(t, r.client, qname)
+			if diff := cmp.Diff(want, gotEnqueued, h.SortMsgOpt); diff != "" {
+				t.Errorf("mismatch found in %q; (-want,+got):\n%s", base.QueueKey(qname), diff)
+			}
+		}
+		if tc.want > 0 && !r.client.SIsMember(base.AllQueues, base.QueueKey(tc.to)).Val() {
+			t.Errorf("%q is not a member of %q", base.QueueKey(tc.to), base.AllQueues)
+		}
+	}
+}
+
+func TestMoveTasksPreservesOrder(t *testing.T) {
+	r := setup(t)
+	m1 := h.NewTaskMessageWithQueue("export", nil, "low")
+	m2 := h.NewTaskMessageWithQueue("export", nil, "low")
+	m3 := h.NewTaskMessageWithQueue("export", nil, "low")
+	h.SeedEnqueuedQueue(t, r.client, []*base.TaskMessage{m1, m2, m3}, "low")
+
+	if _, err := r.MoveTasks("low", "critical", ""); err != nil {
+		t.Fatalf("(*RDB).MoveTasks returned error: %v", err)
+	}
+
+	// tasks should be dequeued in the order they were enqueued.
+	for _, want := range []*base.TaskMessage{m1, m2, m3} {
+		got, err := r.Dequeue("critical")
+		if err != nil {
+			t.Fatalf("(*RDB).Dequeue returned error: %v", err)
+		}
+		if got.ID != want.ID {
+			t.Errorf("(*RDB).Dequeue returned task %v, want %v", got.ID, want.ID)
+		}
+	}
+}
+
+func TestMoveTasksError(t *testing.T) {
+	r := setup(t)
+	m1 := h.NewTaskMessageWithQueue("export", nil, "low")
+	h.SeedEnqueuedQueue(t, r.client, []*base.TaskMessage{m1}, "low")
+
+	tests := []struct {
+		desc    string
+		from    string
+		to      string
+		pattern string
+	}{
+		{"non-existent source queue", "nonexistent", "critical", ""},
+		{"same source and destination", "low", "LOW", ""},
+		{"malformed pattern", "low", "critical", "[export"},
+	}
+
+	for _, tc := range tests {
+		if _, err := r.MoveTasks(tc.from, tc.to, tc.pattern); err == nil {
+			t.Errorf("%s; (*RDB).MoveTasks(%q, %q, %q) returned nil error, want non-nil",
+				tc.desc, tc.from, tc.to, tc.pattern)
+		}
+		gotEnqueued := h.GetEnqueuedMessages(t, r.client, "low")
+		if diff := cmp.Diff([]*base.TaskMessage{m1}, gotEnqueued); diff != "" {
+			t.Errorf("%s; mismatch found in %q; (-want,+got):\n%s", tc.desc, base.QueueKey("low"), diff)
+		}
+	}
+}
+
 func TestListProcesses(t *testing.T) {
 	r := setup(t)
 
@@ -2187,3 +2831,398 @@ func TestListWorkers(t *testing.T) {
 		}
 	}
 }
+
+func TestRescheduleTask(t *testing.T) {
+	r := setup(t)
+	m1 := h.NewTaskMessage("send_email", nil)
+	m2 := h.NewTaskMessageWithQueue("reindex", nil, "low")
+	t1 := time.Now().Add(time.Hour)
+	t2 := time.Now().Add(2 * time.Hour)
+	later := time.Now().Add(24 * time.Hour)
+
+	tests := []struct {
+		scheduled []h.ZSetEntry
+		qname     string
+		id        xid.ID
+		processAt time.Time
+		want      error
+		wantSched []h.ZSetEntry
+	}{
+		{
+			scheduled: []h.ZSetEntry{
+				{Msg: m1, Score: float64(t1.Unix())},
+				{Msg: m2, Score: float64(t2.Unix())},
+			},
+			qname:     "default",
+			id:        m1.ID,
+			processAt: later,
+			want:      nil,
+			wantSched: []h.ZSetEntry{
+				{Msg: m1, Score: float64(later.Unix())},
+				{Msg: m2, Score: float64(t2.Unix())},
+			},
+		},
+		{
+			scheduled: []h.ZSetEntry{
+				{Msg: m1, Score: float64(t1.Unix())},
+				{Msg: m2, Score: float64(t2.Unix())},
+			},
+			qname:     "",
+			id:        m2.ID,
+			processAt: t1,
+			want:      nil,
+			wantSched: []h.ZSetEntry{
+				{Msg: m1, Score: float64(t1.Unix())},
+				{Msg: m2, Score: float64(t1.Unix())},
+			},
+		},
+		{
+			scheduled: []h.ZSetEntry{
+				{Msg: m1, Score: float64(t1.Unix())},
+				{Msg: m2, Score: float64(t2.Unix())},
+			},
+			qname:     "default",
+			id:        m2.ID, // task in another queue
+			processAt: later,
+			want:      ErrTaskNotFound,
+			wantSched: []h.ZSetEntry{
+				{Msg: m1, Score: float64(t1.Unix())},
+				{Msg: m2, Score: float64(t2.Unix())},
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		h.FlushDB(t, r.client)
+		h.SeedScheduledQueue(t, r.client, tc.scheduled)
+
+		got := r.RescheduleTask(tc.qname, tc.id, tc.processAt)
+		if got != tc.want {
+			t.Errorf("(*RDB).RescheduleTask(%q, %v, %v) = %v, want %v",
+				tc.qname, tc.id, tc.processAt, got, tc.want)
+			continue
+		}
+
+		gotSched := h.GetScheduledEntries(t, r.client)
+		if diff := cmp.Diff(tc.wantSched, gotSched, h.SortZSetEntryOpt); diff != "" {
+			t.Errorf("mismatch found in %q; (-want,+got)\n%s", base.ScheduledQueue, diff)
+		}
+	}
+}
+
+func TestQueueMemoryUsage(t *testing.T) {
+	r := setup(t)
+	m1 := h.NewTaskMessage("send_email", nil)
+	m2 := h.NewTaskMessage("reindex", nil)
+	m3 := h.NewTaskMessageWithQueue("gen_thumbnail", nil, "low")
+	m4 := h.NewTaskMessageWithQueue("sync", nil, "low")
+	now := time.Now()
+	h.SeedEnqueuedQueue(t, r.client, []*base.TaskMessage{m1}, "default")
+	h.SeedScheduledQueue(t, r.client, []h.ZSetEntry{
+		{Msg: m2, Score: float64(now.Add(time.Hour).Unix())},
+		{Msg: m3, Score: float64(now.Add(time.Hour).Unix())},
+	})
+	h.SeedRetryQueue(t, r.client, []h.ZSetEntry{{Msg: m4, Score: float64(now.Add(time.Hour).Unix())}})
+
+	got, err := r.QueueMemoryUsage("default")
+	if err != nil {
+		t.Fatalf("r.QueueMemoryUsage(%q) returned error: %v", "default", err)
+	}
+	if got.Enqueued <= 0 || got.Scheduled <= 0 {
+		t.Errorf("r.QueueMemoryUsage(%q) = %+v; want positive enqueued and scheduled usage", "default", got)
+	}
+	if got.Retry != 0 || got.Dead != 0 {
+		t.Errorf("r.QueueMemoryUsage(%q) = %+v; want zero retry and dead usage", "default", got)
+	}
+	scheduled, err := r.client.MemoryUsage(base.ScheduledQueue).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Scheduled >= scheduled {
+		t.Errorf("r.QueueMemoryUsage(%q).Scheduled = %d; want less than the usage of the zset %d",
+			"default", got.Scheduled, scheduled)
+	}
+
+	got, err = r.QueueMemoryUsage("nonexistent")
+	if err != nil {
+		t.Fatalf("r.QueueMemoryUsage(%q) returned error: %v", "nonexistent", err)
+	}
+	if diff := cmp.Diff(&MemoryUsage{}, got); diff != "" {
+		t.Errorf("r.QueueMemoryUsage(%q) returned non-zero usage; (-want,+got)\n%s", "nonexistent", diff)
+	}
+}
+
+func TestSampleZSet(t *testing.T) {
+	r := setup(t)
+	now := time.Now()
+	var entries []h.ZSetEntry
+	for i := 0; i < 150; i++ {
+		entries = append(entries, h.ZSetEntry{Msg: h.NewTaskMessage("sync", nil), Score: float64(now.Unix() + int64(i))})
+	}
+	h.SeedScheduledQueue(t, r.client, entries[:50])
+
+	got, err := r.sampleZSet(base.ScheduledQueue, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 50 {
+		t.Errorf("r.sampleZSet(%q, 100) returned %d entries of a zset of 50, want all 50", base.ScheduledQueue, len(got))
+	}
+
+	h.SeedScheduledQueue(t, r.client, entries)
+	got, err = r.sampleZSet(base.ScheduledQueue, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 100 {
+		t.Errorf("r.sampleZSet(%q, 100) returned %d entries of a zset of 150, want 100", base.ScheduledQueue, len(got))
+	}
+}
+
+func TestCancelTasksByType(t *testing.T) {
+	r := setup(t)
+	m1 := h.NewTaskMessage("export:csv", nil)
+	m2 := h.NewTaskMessage("export:pdf", nil)
+	m3 := h.NewTaskMessage("reindex", nil)
+	m4 := h.NewTaskMessage("export:csv", nil)
+	h.SeedInProgressQueue(t, r.client, []*base.TaskMessage{m1, m2, m3})
+	h.SeedEnqueuedQueue(t, r.client, []*base.TaskMessage{m4})
+
+	pubsub, err := r.CancelationPubSub()
+	if err != nil {
+		t.Fatalf("(*RDB).CancelationPubSub() returned an error: %v", err)
+	}
+	defer pubsub.Close()
+
+	n, err := r.CancelTasksByType("export:*")
+	if err != nil {
+		t.Fatalf("(*RDB).CancelTasksByType(%q) returned error: %v", "export:*", err)
+	}
+	if n != 2 {
+		t.Errorf("(*RDB).CancelTasksByType(%q) = %d, want 2", "export:*", n)
+	}
+	var received []string
+	for {
+		msg, err := pubsub.ReceiveTimeout(time.Second)
+		if err != nil {
+			break
+		}
+		if m, ok := msg.(*redis.Message); ok {
+			received = append(received, m.Payload)
+		}
+	}
+	want := []string{m1.ID.String(), m2.ID.String()}
+	if diff := cmp.Diff(want, received, h.SortStringSliceOpt); diff != "" {
+		t.Errorf("canceled tasks mismatch; (-want,+got)\n%s", diff)
+	}
+
+	for _, pattern := range []string{"", "[export"} {
+		if _, err := r.CancelTasksByType(pattern); err == nil {
+			t.Errorf("(*RDB).CancelTasksByType(%q) returned nil error, want non-nil", pattern)
+		}
+	}
+}
+
+func TestFailureAges(t *testing.T) {
+	r := setup(t)
+	m1 := h.NewTaskMessage("send_email", nil) // fails for the first time
+	m2 := h.NewTaskMessage("send_email", nil)
+	m2.Retried = 5
+	m2.FailedAt = time.Now().Add(-3 * time.Hour).Unix()
+	m3 := h.NewTaskMessage("reindex", nil)
+	m4 := h.NewTaskMessage("reindex", nil)
+	m5 := h.NewTaskMessage("reindex", nil)
+	m6 := h.NewTaskMessage("generate_csv", nil)
+	h.SeedInProgressQueue(t, r.client, []*base.TaskMessage{m1, m2})
+	for _, msg := range []*base.TaskMessage{m1, m2} {
+		if err := r.Retry(msg, time.Now().Add(time.Hour), "error"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	now := time.Now()
+	// retry task written by an older version, without FailedAt.
+	m3.ID = xid.NewWithTime(now.Add(-2 * time.Hour))
+	m3.Retried = 1
+	m3.EnqueuedAt = now.Add(time.Minute).Unix()
+	h.SeedRetryQueue(t, r.client, []h.ZSetEntry{{Msg: m3, Score: float64(m3.EnqueuedAt)}})
+	h.SeedDeadQueue(t, r.client, []h.ZSetEntry{
+		{Msg: m4, Score: float64(now.Add(-10 * time.Second).Unix())},
+		{Msg: m5, Score: float64(now.Add(-2 * time.Hour).Unix())},
+		{Msg: m6, Score: float64(now.Add(-48 * time.Hour).Unix())},
+	})
+
+	got, err := r.FailureAges(now, []time.Duration{time.Minute, time.Hour, 24 * time.Hour})
+	if err != nil {
+		t.Fatalf("(*RDB).FailureAges returned error: %v", err)
+	}
+	want := &FailureAges{
+		RetryCounts: []int64{1, 0, 2, 0},
+		DeadCounts:  []int64{1, 0, 1, 1},
+		OldestRetry: time.Unix(m2.FailedAt, 0),
+		OldestDead:  time.Unix(now.Add(-48*time.Hour).Unix(), 0),
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("(*RDB).FailureAges() = %+v, want %+v; (-want,+got)\n%s", got, want, diff)
+	}
+
+	h.FlushDB(t, r.client)
+	got, err = r.FailureAges(now, []time.Duration{time.Minute})
+	if err != nil {
+		t.Fatalf("(*RDB).FailureAges returned error: %v", err)
+	}
+	want = &FailureAges{RetryCounts: []int64{0, 0}, DeadCounts: []int64{0, 0}}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("(*RDB).FailureAges() with no tasks = %+v, want %+v; (-want,+got)\n%s", got, want, diff)
+	}
+}
+
+func TestFailureAgesSamplesRetryTasks(t *testing.T) {
+	r := setup(t)
+	var msgs []*base.TaskMessage
+	for i := 0; i < 1500; i++ {
+		msg := h.NewTaskMessage("send_email", nil)
+		if i%3 == 0 {
+			msg.Retried = 5
+			msg.FailedAt = time.Now().Add(-3 * time.Hour).Unix()
+		}
+		msgs = append(msgs, msg)
+	}
+	h.SeedInProgressQueue(t, r.client, msgs)
+	for _, msg := range msgs {
+		if err := r.Retry(msg, time.Now().Add(time.Hour), "error"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	now := time.Now()
+
+	got, err := r.FailureAges(now, []time.Duration{time.Minute})
+	if err != nil {
+		t.Fatalf("(*RDB).FailureAges returned error: %v", err)
+	}
+	if n := got.RetryCounts[0] + got.RetryCounts[1]; n != 1500 {
+		t.Errorf("(*RDB).FailureAges().RetryCounts = %v, want counts summing up to 1500", got.RetryCounts)
+	}
+	// 1000 tasks are younger than a minute, the estimate is within 10%.
+	if n := got.RetryCounts[0]; n < 900 || n > 1100 {
+		t.Errorf("(*RDB).FailureAges().RetryCounts[0] = %d, want about 1000", n)
+	}
+}
+
+func TestScaleCounts(t *testing.T) {
+	tests := []struct {
+		counts []int64
+		total  int64
+		want   []int64
+	}{
+		{[]int64{1, 1, 1}, 10, []int64{4, 3, 3}},
+		{[]int64{2, 0, 8}, 100, []int64{20, 0, 80}},
+		{[]int64{0, 0}, 10, []int64{0, 0}},
+	}
+	for _, tc := range tests {
+		got := scaleCounts(tc.counts, tc.total)
+		if diff := cmp.Diff(tc.want, got); diff != "" {
+			t.Errorf("scaleCounts(%v, %d) = %v, want %v", tc.counts, tc.total, got, tc.want)
+		}
+	}
+}
+
+func TestFailureCheckLock(t *testing.T) {
+	r := setup(t)
+
+	if ok, err := r.AcquireFailureCheckLock("host1:1", time.Minute); !ok || err != nil {
+		t.Fatalf("AcquireFailureCheckLock(host1) = %t, %v; want true, nil", ok, err)
+	}
+	if ok, err := r.AcquireFailureCheckLock("host2:2", time.Minute); ok || err != nil {
+		t.Errorf("AcquireFailureCheckLock(host2) while host1 holds the lock = %t, %v; want false, nil", ok, err)
+	}
+	if ok, err := r.AcquireFailureCheckLock("host1:1", time.Minute); !ok || err != nil {
+		t.Errorf("AcquireFailureCheckLock(host1) again = %t, %v; want true, nil", ok, err)
+	}
+	// only the owner releases the lock.
+	if err := r.ReleaseFailureCheckLock("host2:2"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := r.AcquireFailureCheckLock("host2:2", time.Minute); ok {
+		t.Error("AcquireFailureCheckLock(host2) after host2 released = true, want false")
+	}
+	if err := r.ReleaseFailureCheckLock("host1:1"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := r.AcquireFailureCheckLock("host2:2", time.Minute); !ok || err != nil {
+		t.Errorf("AcquireFailureCheckLock(host2) after host1 released = %t, %v; want true, nil", ok, err)
+	}
+}
+
+func TestInspectShardedQueue(t *testing.T) {
+	r := setup(t)
+	m1 := h.NewTaskMessageWithQueue("send_email", nil, "email")
+	m2 := h.NewTaskMessageWithQueue("send_email", nil, base.ShardQueue("email", 0))
+	m3 := h.NewTaskMessageWithQueue("send_email", nil, base.ShardQueue("email", 1))
+	m4 := h.NewTaskMessageWithQueue("send_email", nil, base.ShardQueue("email", 1))
+	m5 := h.NewTaskMessageWithQueue("reindex", nil, "emails") // not a shard
+	seed := func() {
+		h.FlushDB(t, r.client)
+		h.SeedEnqueuedQueue(t, r.client, []*base.TaskMessage{m1}, "email")
+		h.SeedEnqueuedQueue(t, r.client, []*base.TaskMessage{m2}, m2.Queue)
+		h.SeedEnqueuedQueue(t, r.client, []*base.TaskMessage{m3}, m3.Queue)
+		h.SeedEnqueuedQueue(t, r.client, []*base.TaskMessage{m5}, "emails")
+		h.SeedRetryQueue(t, r.client, []h.ZSetEntry{{Msg: m4, Score: float64(time.Now().Unix())}})
+	}
+	enqueuedCount := func() int {
+		n, err := r.EnqueuedCount("email", m2.Queue, m3.Queue)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+
+	seed()
+	for _, msg := range []*base.TaskMessage{m1, m3, m4} {
+		info, err := r.GetTaskInfo("email", msg.ID)
+		if err != nil || info.Msg.ID != msg.ID {
+			t.Errorf("(*RDB).GetTaskInfo(%q, %v) = %v, %v, want the task", "email", msg.ID, info, err)
+		}
+	}
+
+	seed()
+	if err := r.KillEnqueuedTask("email", m3.ID); err != nil {
+		t.Errorf("(*RDB).KillEnqueuedTask(%q, %v) = %v, want nil", "email", m3.ID, err)
+	}
+	if n, err := r.KillAllEnqueuedTasks("email"); n != 2 || err != nil {
+		t.Errorf("(*RDB).KillAllEnqueuedTasks(%q) = %d, %v, want 2, nil", "email", n, err)
+	}
+	if n := enqueuedCount(); n != 0 {
+		t.Errorf("%d tasks left in the queue and its shards after killing them, want 0", n)
+	}
+
+	seed()
+	if n, err := r.MoveTasks("email", "default", ""); n != 3 || err != nil {
+		t.Errorf("(*RDB).MoveTasks(%q, %q) = %d, %v, want 3, nil", "email", "default", n, err)
+	}
+	for _, msg := range h.GetEnqueuedMessages(t, r.client, "default") {
+		if msg.Queue != "default" {
+			t.Errorf("queue of the moved task = %q, want %q", msg.Queue, "default")
+		}
+	}
+
+	seed()
+	if err := r.RemoveQueue("email", false); err == nil {
+		t.Errorf("(*RDB).RemoveQueue(%q, false) = nil, want *ErrQueueNotEmpty", "email")
+	}
+	if err := r.RemoveQueue("email", true); err != nil {
+		t.Errorf("(*RDB).RemoveQueue(%q, true) = %v, want nil", "email", err)
+	}
+	if n := enqueuedCount(); n != 0 {
+		t.Errorf("%d tasks left in the queue and its shards after removing it, want 0", n)
+	}
+	if got := h.GetRetryMessages(t, r.client); len(got) != 0 {
+		t.Errorf("retry task of the removed queue was not removed: %v", got)
+	}
+	qnames, err := r.QueueNames()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"emails"}; !cmp.Equal(want, qnames) {
+		t.Errorf("(*RDB).QueueNames() = %v after removing the queue, want %v", qnames, want)
+	}
+}
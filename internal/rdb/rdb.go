@@ -9,6 +9,8 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/go-redis/redis/v7"
@@ -22,18 +24,29 @@ var (
 
 	// ErrTaskNotFound indicates that a task that matches the given identifier was not found.
 	ErrTaskNotFound = errors.New("could not find a task")
+
+	// ErrProcessNotFound indicates that a process that matches the given host and pid was not found.
+	ErrProcessNotFound = errors.New("could not find a process")
 )
 
 const statsTTL = 90 * 24 * time.Hour // 90 days
 
 // RDB is a client interface to query and mutate task queues.
 type RDB struct {
-	client *redis.Client
+	client   redis.UniversalClient
+	commands *commandStats
 }
 
 // NewRDB returns a new instance of RDB.
-func NewRDB(client *redis.Client) *RDB {
-	return &RDB{client}
+//
+// The statistics of the commands are reported by ConnStats only if the
+// client was returned by WithCommandStats.
+func NewRDB(client redis.UniversalClient) *RDB {
+	r := &RDB{client: client}
+	if c, ok := client.(*statsClient); ok {
+		r.commands = c.commands
+	}
+	return r
 }
 
 // Close closes the connection with redis server.
@@ -41,12 +54,24 @@ func (r *RDB) Close() error {
 	return r.client.Close()
 }
 
+// Ping checks the connection with redis server.
+func (r *RDB) Ping() error {
+	return r.client.Ping().Err()
+}
+
 // KEYS[1] -> asynq:queues:<qname>
 // KEYS[2] -> asynq:queues
+// KEYS[3] -> asynq:replication
+// KEYS[4] -> asynq:replication:log
 // ARGV[1] -> task message data
+// ARGV[2] -> current unix time in milliseconds
 var enqueueCmd = redis.NewScript(`
 redis.call("LPUSH", KEYS[1], ARGV[1])
 redis.call("SADD", KEYS[2], KEYS[1])
+if redis.call("EXISTS", KEYS[3]) == 1 then
+	redis.call("RPUSH", KEYS[4],
+		'{"op":"add","state":"enqueued","time":' .. ARGV[2] .. ',"msg":' .. ARGV[1] .. '}')
+end
 return 1`)
 
 // Enqueue inserts the given task to the tail of the queue.
@@ -56,7 +81,9 @@ func (r *RDB) Enqueue(msg *base.TaskMessage) error {
 		return err
 	}
 	key := base.QueueKey(msg.Queue)
-	return enqueueCmd.Run(r.client, []string{key, base.AllQueues}, bytes).Err()
+	return enqueueCmd.Run(r.client,
+		[]string{key, base.AllQueues, base.ReplicationEnabled, base.ReplicationLog},
+		bytes, unixMilli(time.Now())).Err()
 }
 
 // Dequeue queries given queues in order and pops a task message if there is one and returns it.
@@ -84,14 +111,63 @@ func (r *RDB) Dequeue(qnames ...string) (*base.TaskMessage, error) {
 	if err != nil {
 		return nil, err
 	}
+	msg.Encoded = data
 	return &msg, nil
 }
 
+// encoding returns the encoding of the message to find it in redis lists,
+// which is the encoding it was read with if it was read from redis.
+func encoding(msg *base.TaskMessage) (string, error) {
+	if msg.Encoded != "" {
+		return msg.Encoded, nil
+	}
+	bytes, err := json.Marshal(msg)
+	if err != nil {
+		return "", err
+	}
+	return string(bytes), nil
+}
+
 func (r *RDB) dequeueSingle(queue string) (data string, err error) {
 	// timeout needed to avoid blocking forever
 	return r.client.BRPopLPush(queue, base.InProgressQueue, time.Second).Result()
 }
 
+// EnqueuedCount returns the total number of tasks enqueued in the given queues.
+func (r *RDB) EnqueuedCount(qnames ...string) (int, error) {
+	if len(qnames) == 0 {
+		return 0, nil
+	}
+	pipe := r.client.Pipeline()
+	var cmds []*redis.IntCmd
+	for _, qname := range qnames {
+		cmds = append(cmds, pipe.LLen(base.QueueKey(qname)))
+	}
+	if _, err := pipe.Exec(); err != nil {
+		return 0, err
+	}
+	total := 0
+	for _, cmd := range cmds {
+		total += int(cmd.Val())
+	}
+	return total, nil
+}
+
+// QueueNames returns the names of all queues tasks have been enqueued to,
+// sorted by name.
+func (r *RDB) QueueNames() ([]string, error) {
+	qkeys, err := r.client.SMembers(base.AllQueues).Result()
+	if err != nil {
+		return nil, err
+	}
+	var res []string
+	for _, qkey := range qkeys {
+		res = append(res, strings.TrimPrefix(qkey, base.QueuePrefix))
+	}
+	sort.Strings(res)
+	return res, nil
+}
+
 // KEYS[1] -> asynq:in_progress
 // ARGV    -> List of queues to query in order
 var dequeueCmd = redis.NewScript(`
@@ -118,30 +194,54 @@ func (r *RDB) dequeue(queues ...string) (data string, err error) {
 
 // KEYS[1] -> asynq:in_progress
 // KEYS[2] -> asynq:processed:<yyyy-mm-dd>
+// KEYS[3] -> asynq:replication
+// KEYS[4] -> asynq:replication:log
 // ARGV[1] -> base.TaskMessage value
 // ARGV[2] -> stats expiration timestamp
+// ARGV[3] -> task ID
+// ARGV[4] -> current unix time in milliseconds
+// ARGV[5] -> "1" to count the task as processed, "0" otherwise
 // Note: LREM count ZERO means "remove all elements equal to val"
 var doneCmd = redis.NewScript(`
 redis.call("LREM", KEYS[1], 0, ARGV[1]) 
-local n = redis.call("INCR", KEYS[2])
-if tonumber(n) == 1 then
-	redis.call("EXPIREAT", KEYS[2], ARGV[2])
+if ARGV[5] == "1" then
+	local n = redis.call("INCR", KEYS[2])
+	if tonumber(n) == 1 then
+		redis.call("EXPIREAT", KEYS[2], ARGV[2])
+	end
+end
+if redis.call("EXISTS", KEYS[3]) == 1 then
+	redis.call("RPUSH", KEYS[4], '{"op":"del","id":"' .. ARGV[3] .. '","time":' .. ARGV[4] .. '}')
 end
 return redis.status_reply("OK")
 `)
 
 // Done removes the task from in-progress queue to mark the task as done.
 func (r *RDB) Done(msg *base.TaskMessage) error {
-	bytes, err := json.Marshal(msg)
+	return r.done(msg, true)
+}
+
+// Skip removes the task from in-progress queue without counting it as
+// processed, e.g. for a task which was already completed.
+func (r *RDB) Skip(msg *base.TaskMessage) error {
+	return r.done(msg, false)
+}
+
+func (r *RDB) done(msg *base.TaskMessage, count bool) error {
+	bytes, err := encoding(msg)
 	if err != nil {
 		return err
 	}
 	now := time.Now()
 	processedKey := base.ProcessedKey(now)
 	expireAt := now.Add(statsTTL)
+	countArg := "0"
+	if count {
+		countArg = "1"
+	}
 	return doneCmd.Run(r.client,
-		[]string{base.InProgressQueue, processedKey},
-		bytes, expireAt.Unix()).Err()
+		[]string{base.InProgressQueue, processedKey, base.ReplicationEnabled, base.ReplicationLog},
+		bytes, expireAt.Unix(), msg.ID.String(), unixMilli(now), countArg).Err()
 }
 
 // KEYS[1] -> asynq:in_progress
@@ -155,24 +255,119 @@ return redis.status_reply("OK")`)
 
 // Requeue moves the task from in-progress queue to the specified queue.
 func (r *RDB) Requeue(msg *base.TaskMessage) error {
-	bytes, err := json.Marshal(msg)
+	bytes, err := encoding(msg)
 	if err != nil {
 		return err
 	}
 	return requeueCmd.Run(r.client,
 		[]string{base.InProgressQueue, base.QueueKey(msg.Queue)},
-		string(bytes)).Err()
+		bytes).Err()
 }
 
+// KEYS[1] -> asynq:in_progress
+// KEYS[2] -> asynq:queues:<qname>
+// KEYS[3] -> asynq:queues
+// ARGV[1] -> base.TaskMessage value to remove from base.InProgressQueue queue
+// ARGV[2] -> base.TaskMessage value to add to the queue
+var requeueToCmd = redis.NewScript(`
+redis.call("LREM", KEYS[1], 0, ARGV[1])
+redis.call("LPUSH", KEYS[2], ARGV[2])
+redis.call("SADD", KEYS[3], KEYS[2])
+return redis.status_reply("OK")`)
+
+// RequeueTo moves the task from in-progress queue to the tail of the given
+// queue, changing the queue of the task.
+func (r *RDB) RequeueTo(msg *base.TaskMessage, qname string) error {
+	bytesToRemove, err := encoding(msg)
+	if err != nil {
+		return err
+	}
+	modified := *msg
+	modified.Queue = qname
+	bytesToAdd, err := json.Marshal(&modified)
+	if err != nil {
+		return err
+	}
+	qkey := base.QueueKey(qname)
+	return requeueToCmd.Run(r.client,
+		[]string{base.InProgressQueue, qkey, base.AllQueues},
+		bytesToRemove, string(bytesToAdd)).Err()
+}
+
+// KEYS[1] -> asynq:in_progress
+// KEYS[2] -> asynq:scheduled
+// ARGV[1] -> base.TaskMessage value
+// ARGV[2] -> process_at UNIX timestamp
+var deferCmd = redis.NewScript(`
+redis.call("LREM", KEYS[1], 0, ARGV[1])
+redis.call("ZADD", KEYS[2], ARGV[2], ARGV[1])
+return redis.status_reply("OK")`)
+
+// Defer moves the task from in-progress queue to scheduled queue
+// to be processed at the given time, leaving the task message intact.
+func (r *RDB) Defer(msg *base.TaskMessage, processAt time.Time) error {
+	bytes, err := encoding(msg)
+	if err != nil {
+		return err
+	}
+	return deferCmd.Run(r.client,
+		[]string{base.InProgressQueue, base.ScheduledQueue},
+		bytes, processAt.Unix()).Err()
+}
+
+// KEYS[1] -> asynq:in_progress
+// KEYS[2] -> asynq:scheduled
+// ARGV[1] -> base.TaskMessage value to remove from base.InProgressQueue queue
+// ARGV[2] -> base.TaskMessage value to add to Scheduled queue
+// ARGV[3] -> process_at UNIX timestamp
+var reprocessCmd = redis.NewScript(`
+redis.call("LREM", KEYS[1], 0, ARGV[1])
+redis.call("ZADD", KEYS[2], ARGV[3], ARGV[2])
+return redis.status_reply("OK")`)
+
+// Reprocess moves the task from in-progress queue to scheduled queue
+// to be processed again at the given time with the given payload.
+// Unlike Retry, the task is not counted as retried or failed.
+func (r *RDB) Reprocess(msg *base.TaskMessage, payload map[string]interface{}, processAt time.Time) error {
+	bytesToRemove, err := encoding(msg)
+	if err != nil {
+		return err
+	}
+	modified := *msg
+	modified.Payload = payload
+	modified.EnqueuedAt = processAt.Unix()
+	bytesToAdd, err := json.Marshal(&modified)
+	if err != nil {
+		return err
+	}
+	return reprocessCmd.Run(r.client,
+		[]string{base.InProgressQueue, base.ScheduledQueue},
+		bytesToRemove, string(bytesToAdd), processAt.Unix()).Err()
+}
+
+// KEYS[1] -> asynq:scheduled
+// KEYS[2] -> asynq:replication
+// KEYS[3] -> asynq:replication:log
+// ARGV[1] -> task message data
+// ARGV[2] -> process_at UNIX timestamp
+// ARGV[3] -> current unix time in milliseconds
+var scheduleCmd = redis.NewScript(`
+redis.call("ZADD", KEYS[1], ARGV[2], ARGV[1])
+if redis.call("EXISTS", KEYS[2]) == 1 then
+	redis.call("RPUSH", KEYS[3],
+		'{"op":"add","state":"scheduled","score":' .. ARGV[2] .. ',"time":' .. ARGV[3] .. ',"msg":' .. ARGV[1] .. '}')
+end
+return 1`)
+
 // Schedule adds the task to the backlog queue to be processed in the future.
 func (r *RDB) Schedule(msg *base.TaskMessage, processAt time.Time) error {
 	bytes, err := json.Marshal(msg)
 	if err != nil {
 		return err
 	}
-	score := float64(processAt.Unix())
-	return r.client.ZAdd(base.ScheduledQueue,
-		&redis.Z{Member: string(bytes), Score: score}).Err()
+	return scheduleCmd.Run(r.client,
+		[]string{base.ScheduledQueue, base.ReplicationEnabled, base.ReplicationLog},
+		bytes, processAt.Unix(), unixMilli(time.Now())).Err()
 }
 
 // KEYS[1] -> asynq:in_progress
@@ -199,24 +394,28 @@ return redis.status_reply("OK")`)
 // Retry moves the task from in-progress to retry queue, incrementing retry count
 // and assigning error message to the task message.
 func (r *RDB) Retry(msg *base.TaskMessage, processAt time.Time, errMsg string) error {
-	bytesToRemove, err := json.Marshal(msg)
+	bytesToRemove, err := encoding(msg)
 	if err != nil {
 		return err
 	}
 	modified := *msg
 	modified.Retried++
 	modified.ErrorMsg = errMsg
+	modified.EnqueuedAt = processAt.Unix()
+	now := time.Now()
+	if modified.FailedAt == 0 {
+		modified.FailedAt = now.Unix()
+	}
 	bytesToAdd, err := json.Marshal(&modified)
 	if err != nil {
 		return err
 	}
-	now := time.Now()
 	processedKey := base.ProcessedKey(now)
 	failureKey := base.FailureKey(now)
 	expireAt := now.Add(statsTTL)
 	return retryCmd.Run(r.client,
 		[]string{base.InProgressQueue, base.RetryQueue, processedKey, failureKey},
-		string(bytesToRemove), string(bytesToAdd), processAt.Unix(), expireAt.Unix()).Err()
+		bytesToRemove, string(bytesToAdd), processAt.Unix(), expireAt.Unix()).Err()
 }
 
 const (
@@ -228,12 +427,16 @@ const (
 // KEYS[2] -> asynq:dead
 // KEYS[3] -> asynq:processed:<yyyy-mm-dd>
 // KEYS[4] -> asynq.failure:<yyyy-mm-dd>
+// KEYS[5] -> asynq:replication
+// KEYS[6] -> asynq:replication:log
 // ARGV[1] -> base.TaskMessage value to remove from base.InProgressQueue queue
 // ARGV[2] -> base.TaskMessage value to add to Dead queue
 // ARGV[3] -> died_at UNIX timestamp
 // ARGV[4] -> cutoff timestamp (e.g., 90 days ago)
 // ARGV[5] -> max number of tasks in dead queue (e.g., 100)
 // ARGV[6] -> stats expiration timestamp
+// ARGV[7] -> task ID
+// ARGV[8] -> current unix time in milliseconds
 var killCmd = redis.NewScript(`
 redis.call("LREM", KEYS[1], 0, ARGV[1])
 redis.call("ZADD", KEYS[2], ARGV[3], ARGV[2])
@@ -247,13 +450,16 @@ local m = redis.call("INCR", KEYS[4])
 if tonumber(m) == 1 then
 	redis.call("EXPIREAT", KEYS[4], ARGV[6])
 end
+if redis.call("EXISTS", KEYS[5]) == 1 then
+	redis.call("RPUSH", KEYS[6], '{"op":"del","id":"' .. ARGV[7] .. '","time":' .. ARGV[8] .. '}')
+end
 return redis.status_reply("OK")`)
 
 // Kill sends the task to "dead" queue from in-progress queue, assigning
 // the error message to the task.
 // It also trims the set by timestamp and set size.
 func (r *RDB) Kill(msg *base.TaskMessage, errMsg string) error {
-	bytesToRemove, err := json.Marshal(msg)
+	bytesToRemove, err := encoding(msg)
 	if err != nil {
 		return err
 	}
@@ -269,93 +475,263 @@ func (r *RDB) Kill(msg *base.TaskMessage, errMsg string) error {
 	failureKey := base.FailureKey(now)
 	expireAt := now.Add(statsTTL)
 	return killCmd.Run(r.client,
-		[]string{base.InProgressQueue, base.DeadQueue, processedKey, failureKey},
-		string(bytesToRemove), string(bytesToAdd), now.Unix(), limit, maxDeadTasks, expireAt.Unix()).Err()
+		[]string{base.InProgressQueue, base.DeadQueue, processedKey, failureKey,
+			base.ReplicationEnabled, base.ReplicationLog},
+		bytesToRemove, string(bytesToAdd), now.Unix(), limit, maxDeadTasks, expireAt.Unix(),
+		msg.ID.String(), unixMilli(now)).Err()
+}
+
+// historyTTL is how long the execution history of a task is kept
+// after its last attempt.
+const historyTTL = deadExpirationInDays * 24 * time.Hour
+
+// KEYS[1] -> asynq:history:<task_id>
+// ARGV[1] -> base.TaskAttempt value
+// ARGV[2] -> max number of attempts to keep
+// ARGV[3] -> history expiration in seconds
+var recordAttemptCmd = redis.NewScript(`
+redis.call("LPUSH", KEYS[1], ARGV[1])
+redis.call("LTRIM", KEYS[1], 0, tonumber(ARGV[2]) - 1)
+redis.call("EXPIRE", KEYS[1], ARGV[3])
+return redis.status_reply("OK")`)
+
+// RecordAttempt adds the attempt to the execution history of the task
+// given its ID, keeping at most limit number of the latest attempts.
+func (r *RDB) RecordAttempt(id string, attempt *base.TaskAttempt, limit int) error {
+	bytes, err := json.Marshal(attempt)
+	if err != nil {
+		return err
+	}
+	return recordAttemptCmd.Run(r.client, []string{base.HistoryKey(id)},
+		string(bytes), limit, int64(historyTTL.Seconds())).Err()
+}
+
+// KEYS[1] -> asynq:children:<parent_id>
+// ARGV[1] -> child task reference "<child_id>:<qname>"
+// ARGV[2] -> expiration in seconds
+var addChildCmd = redis.NewScript(`
+redis.call("RPUSH", KEYS[1], ARGV[1])
+redis.call("EXPIRE", KEYS[1], ARGV[2])
+return redis.status_reply("OK")`)
+
+// AddChild records that the task given its ID spawned the child task.
+// Children are kept as long as the execution history of tasks.
+func (r *RDB) AddChild(parentID string, child *base.TaskMessage) error {
+	ref := child.ID.String() + ":" + child.Queue
+	return addChildCmd.Run(r.client, []string{base.ChildrenKey(parentID)},
+		ref, int64(historyTTL.Seconds())).Err()
+}
+
+// RecordCompletion records the completion of a task with the given
+// idempotency key, which is remembered for the duration of ttl.
+func (r *RDB) RecordCompletion(key string, ttl time.Duration) error {
+	return r.client.Set(base.CompletedKey(key), time.Now().Unix(), ttl).Err()
+}
+
+// IsCompleted reports whether the completion of a task with the given
+// idempotency key is recorded.
+func (r *RDB) IsCompleted(key string) (bool, error) {
+	n, err := r.client.Exists(base.CompletedKey(key)).Result()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// progressTTL is how long the progress of a task is kept after it's reported.
+const progressTTL = 24 * time.Hour
+
+// SetProgress sets the progress of the task given its ID,
+// overwriting the previous progress.
+func (r *RDB) SetProgress(id string, progress *base.TaskProgress) error {
+	bytes, err := json.Marshal(progress)
+	if err != nil {
+		return err
+	}
+	return r.client.Set(base.ProgressKey(id), string(bytes), progressTTL).Err()
 }
 
 // KEYS[1] -> asynq:in_progress
-// ARGV[1] -> queue prefix
-var requeueAllCmd = redis.NewScript(`
-local msgs = redis.call("LRANGE", KEYS[1], 0, -1)
-for _, msg in ipairs(msgs) do
-	local decoded = cjson.decode(msg)
-	local qkey = ARGV[1] .. decoded["Queue"]
-	redis.call("RPUSH", qkey, msg)
-	redis.call("LREM", KEYS[1], 0, msg)
+// ARGV    -> triples of task message to remove from the in-progress list,
+// key of the queue to add the task to and task message to add to the queue
+// Note: Use RPUSH to push to the head of the queue.
+var restoreCmd = redis.NewScript(`
+local n = 0
+for i = 1, #ARGV, 3 do
+	if redis.call("LREM", KEYS[1], 0, ARGV[i]) > 0 then
+		redis.call("RPUSH", ARGV[i+1], ARGV[i+2])
+		n = n + 1
+	end
 end
-return table.getn(msgs)`)
+return n`)
 
 // RequeueAll moves all tasks from in-progress list to the queue
-// and reports the number of tasks restored.
-func (r *RDB) RequeueAll() (int64, error) {
-	res, err := requeueAllCmd.Run(r.client, []string{base.InProgressQueue}, base.QueuePrefix).Result()
+// and reports the number of tasks requeued, e.g. when the tasks
+// are interrupted by a graceful shutdown.
+//
+// Tasks with the given IDs are left in the in-progress list.
+func (r *RDB) RequeueAll(exceptIDs ...string) (int64, error) {
+	return r.restoreAll(nil, exceptIDs)
+}
+
+// RestoreAll moves all tasks from in-progress list to the queue
+// and reports the number of tasks restored, e.g. when the tasks
+// were left unfinished by a crash.
+//
+// The restore count of each task is incremented, so that a task which
+// is never finished can be moved to the dead queue once it reaches its
+// max attempts. Tasks being processed by other live processes, as reported
+// by their workers, are not counted since they were not left unfinished.
+// A task dequeued by another process since its last heartbeat is not
+// reported yet, and is counted.
+//
+// Tasks with the given IDs are left in the in-progress list.
+func (r *RDB) RestoreAll(exceptIDs ...string) (int64, error) {
+	workers, err := r.ListWorkers()
 	if err != nil {
 		return 0, err
 	}
-	n, ok := res.(int64)
-	if !ok {
-		return 0, fmt.Errorf("could not cast %v to int64", res)
+	running := make(map[string]bool)
+	for _, w := range workers {
+		running[w.ID.String()] = true
 	}
-	return n, nil
+	return r.restoreAll(func(msg *base.TaskMessage) bool { return !running[msg.ID.String()] }, exceptIDs)
 }
 
-// CheckAndEnqueue checks for all scheduled tasks and enqueues any tasks that
-// have to be processed.
+// restoreAll moves the tasks from in-progress list to their queues
+// atomically, incrementing the restore count of the tasks for which
+// incr returns true, if incr is not nil.
+// A task is not moved if it was removed from in-progress list
+// since the list was read.
+func (r *RDB) restoreAll(incr func(*base.TaskMessage) bool, exceptIDs []string) (int64, error) {
+	except := make(map[string]bool)
+	for _, id := range exceptIDs {
+		except[id] = true
+	}
+	data, err := r.client.LRange(base.InProgressQueue, 0, -1).Result()
+	if err != nil {
+		return 0, err
+	}
+	var args []interface{}
+	for _, s := range data {
+		var msg base.TaskMessage
+		if err := json.Unmarshal([]byte(s), &msg); err != nil {
+			continue // bad data, ignore and continue
+		}
+		if except[msg.ID.String()] {
+			continue
+		}
+		restored := s
+		if incr != nil && incr(&msg) {
+			msg.Restored++
+			bytes, err := json.Marshal(&msg)
+			if err != nil {
+				return 0, err
+			}
+			restored = string(bytes)
+		}
+		args = append(args, s, base.QueueKey(msg.Queue), restored)
+	}
+	if len(args) == 0 {
+		return 0, nil
+	}
+	return restoreCmd.Run(r.client, []string{base.InProgressQueue}, args...).Int64()
+}
+
+// forwardBatchSize is the max number of tasks moved by a single run of
+// forwardCmd, so that each run is short and redis serves other clients
+// in between the runs.
+const forwardBatchSize = 100
+
+// CheckAndEnqueue moves the scheduled and retry tasks which are due to
+// their queues, and returns the number of tasks moved.
+//
+// Tasks are moved in the order they are due across all queues, in batches
+// of a limited size, so that due tasks of a queue are not delayed behind
+// a large number of due tasks of another queue.
 //
-// qnames specifies to which queues to send tasks.
-func (r *RDB) CheckAndEnqueue(qnames ...string) error {
-	delayed := []string{base.ScheduledQueue, base.RetryQueue}
-	for _, zset := range delayed {
-		var err error
-		if len(qnames) == 1 {
-			err = r.forwardSingle(zset, base.QueueKey(qnames[0]))
-		} else {
-			err = r.forward(zset)
+// If retryLimit is positive, at most retryLimit retry tasks are moved,
+// so that the retries of a large number of tasks which failed at once
+// are spread over multiple calls.
+func (r *RDB) CheckAndEnqueue(retryLimit int) (int64, error) {
+	if retryLimit <= 0 {
+		retryLimit = -1 // no limit
+	}
+	var total int64
+	for {
+		n, retried, err := r.forward(forwardBatchSize, retryLimit)
+		total += n
+		if err != nil || n < forwardBatchSize {
+			return total, err
 		}
-		if err != nil {
-			return err
+		if retryLimit > 0 {
+			retryLimit -= int(retried)
 		}
 	}
-	return nil
 }
 
-// KEYS[1] -> source queue (e.g. scheduled or retry queue)
+// KEYS[1] -> asynq:scheduled
+// KEYS[2] -> asynq:retry
+// KEYS[3] -> asynq:queues
 // ARGV[1] -> current unix time
 // ARGV[2] -> queue prefix
+// ARGV[3] -> max number of tasks to move
+// ARGV[4] -> max number of retry tasks to move, or -1 for no limit
+// Note: Up to ARGV[3] due tasks of each set are merged in the order of their
+// scores, so that the tasks which are due first are moved first.
+// Returns the number of tasks moved and the number of retry tasks among them.
 var forwardCmd = redis.NewScript(`
-local msgs = redis.call("ZRANGEBYSCORE", KEYS[1], "-inf", ARGV[1])
-for _, msg in ipairs(msgs) do
-	local decoded = cjson.decode(msg)
-	local qkey = ARGV[2] .. decoded["Queue"]
-	redis.call("LPUSH", qkey, msg)
-	redis.call("ZREM", KEYS[1], msg)
+local limits = {tonumber(ARGV[3]), tonumber(ARGV[3])}
+local rlimit = tonumber(ARGV[4])
+if rlimit >= 0 and rlimit < limits[2] then
+	limits[2] = rlimit
 end
-return msgs`)
-
-// forward moves all tasks with a score less than the current unix time
-// from the src zset.
-func (r *RDB) forward(src string) error {
-	now := float64(time.Now().Unix())
-	return forwardCmd.Run(r.client,
-		[]string{src}, now, base.QueuePrefix).Err()
-}
-
-// KEYS[1] -> source queue (e.g. scheduled or retry queue)
-// KEYS[2] -> destination queue
-var forwardSingleCmd = redis.NewScript(`
-local msgs = redis.call("ZRANGEBYSCORE", KEYS[1], "-inf", ARGV[1])
-for _, msg in ipairs(msgs) do
-	redis.call("LPUSH", KEYS[2], msg)
-	redis.call("ZREM", KEYS[1], msg)
+local due = {}
+for i = 1, 2 do
+	if limits[i] > 0 then
+		local res = redis.call("ZRANGEBYSCORE", KEYS[i], "-inf", ARGV[1], "WITHSCORES", "LIMIT", 0, limits[i])
+		for j = 1, #res, 2 do
+			table.insert(due, {KEYS[i], res[j], tonumber(res[j+1])})
+		end
+	end
 end
-return msgs`)
-
-// forwardSingle moves all tasks with a score less than the current unix time
-// from the src zset to dst list.
-func (r *RDB) forwardSingle(src, dst string) error {
-	now := float64(time.Now().Unix())
-	return forwardSingleCmd.Run(r.client,
-		[]string{src, dst}, now).Err()
+table.sort(due, function(a, b) return a[3] < b[3] end)
+local n = math.min(#due, tonumber(ARGV[3]))
+local retried = 0
+for i = 1, n do
+	local zset, msg = due[i][1], due[i][2]
+	local qkey = ARGV[2] .. cjson.decode(msg)["Queue"]
+	redis.call("LPUSH", qkey, msg)
+	redis.call("SADD", KEYS[3], qkey)
+	redis.call("ZREM", zset, msg)
+	if zset == KEYS[2] then
+		retried = retried + 1
+	end
+end
+return {n, retried}`)
+
+// forward moves up to limit scheduled and retry tasks which are due
+// to their queues, including up to retryLimit retry tasks unless
+// retryLimit is negative, and returns the number of tasks moved and
+// the number of retry tasks among them.
+func (r *RDB) forward(limit, retryLimit int) (n, retried int64, err error) {
+	now := time.Now().Unix()
+	res, err := forwardCmd.Run(r.client,
+		[]string{base.ScheduledQueue, base.RetryQueue, base.AllQueues},
+		now, base.QueuePrefix, limit, retryLimit).Result()
+	if err != nil {
+		return 0, 0, err
+	}
+	counts, ok := res.([]interface{})
+	if !ok || len(counts) != 2 {
+		return 0, 0, fmt.Errorf("could not cast %v to []int64", res)
+	}
+	n, ok1 := counts[0].(int64)
+	retried, ok2 := counts[1].(int64)
+	if !ok1 || !ok2 {
+		return 0, 0, fmt.Errorf("could not cast %v to []int64", res)
+	}
+	return n, retried, nil
 }
 
 // KEYS[1]  -> asynq:ps:<host:pid>
@@ -425,6 +801,35 @@ func (r *RDB) ClearProcessState(ps *base.ProcessState) error {
 		[]string{base.AllProcesses, pkey, base.AllWorkers, wkey}).Err()
 }
 
+// KEYS    -> asynq:dequeue:<qname> for each queue
+// ARGV    -> attempts, hits and time last queried in unix ms for each queue
+var recordDequeueStatsCmd = redis.NewScript(`
+for i, key in ipairs(KEYS) do
+	local n = (i - 1) * 3
+	redis.call("HINCRBY", key, "attempts", ARGV[n+1])
+	redis.call("HINCRBY", key, "hits", ARGV[n+2])
+	local last = tonumber(redis.call("HGET", key, "queried"))
+	if last == nil or tonumber(ARGV[n+3]) > last then
+		redis.call("HSET", key, "queried", ARGV[n+3])
+	end
+end
+return redis.status_reply("OK")`)
+
+// RecordDequeueStats adds the statistics of the queries of the queues
+// by Dequeue, keyed by queue name, to the statistics of all processes.
+func (r *RDB) RecordDequeueStats(stats map[string]*base.DequeueStats) error {
+	if len(stats) == 0 {
+		return nil
+	}
+	var keys []string
+	var args []interface{}
+	for qname, s := range stats {
+		keys = append(keys, base.DequeueStatsKey(qname))
+		args = append(args, s.Attempts, s.Hits, unixMilli(s.LastQueried))
+	}
+	return recordDequeueStatsCmd.Run(r.client, keys, args...).Err()
+}
+
 // CancelationPubSub returns a pubsub for cancelation messages.
 func (r *RDB) CancelationPubSub() (*redis.PubSub, error) {
 	pubsub := r.client.Subscribe(base.CancelChannel)
@@ -440,3 +845,27 @@ func (r *RDB) CancelationPubSub() (*redis.PubSub, error) {
 func (r *RDB) PublishCancelation(id string) error {
 	return r.client.Publish(base.CancelChannel, id).Err()
 }
+
+// ControlPubSub returns a pubsub for commands sent to the process
+// given hostname and pid.
+func (r *RDB) ControlPubSub(host string, pid int) (*redis.PubSub, error) {
+	pubsub := r.client.Subscribe(base.ControlChannel(host, pid))
+	_, err := pubsub.Receive()
+	if err != nil {
+		return nil, err
+	}
+	return pubsub, nil
+}
+
+// PublishControl publishes a command to the process given hostname and pid.
+// If no process is subscribed to the control channel, it returns ErrProcessNotFound.
+func (r *RDB) PublishControl(host string, pid int, cmd string) error {
+	n, err := r.client.Publish(base.ControlChannel(host, pid), cmd).Result()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrProcessNotFound
+	}
+	return nil
+}
@@ -16,6 +16,7 @@ import (
 	"github.com/google/go-cmp/cmp/cmpopts"
 	h "github.com/hibiken/asynq/internal/asynqtest"
 	"github.com/hibiken/asynq/internal/base"
+	"github.com/rs/xid"
 )
 
 // TODO(hibiken): Get Redis address and db number from ENV variables.
@@ -164,7 +165,7 @@ func TestDequeue(t *testing.T) {
 		}
 
 		got, err := r.Dequeue(tc.args...)
-		if !cmp.Equal(got, tc.want) || err != tc.err {
+		if !cmp.Equal(got, tc.want, h.IgnoreEncodedOpt) || err != tc.err {
 			t.Errorf("(*RDB).Dequeue(%v) = %v, %v; want %v, %v",
 				tc.args, got, err, tc.want, tc.err)
 			continue
@@ -184,6 +185,82 @@ func TestDequeue(t *testing.T) {
 	}
 }
 
+func TestEnqueuedCount(t *testing.T) {
+	r := setup(t)
+	t1 := h.NewTaskMessage("send_email", nil)
+	t2 := h.NewTaskMessage("export_csv", nil)
+	t3 := h.NewTaskMessageWithQueue("reindex", nil, "low")
+
+	tests := []struct {
+		enqueued map[string][]*base.TaskMessage
+		qnames   []string
+		want     int
+	}{
+		{
+			enqueued: map[string][]*base.TaskMessage{
+				"default": {t1, t2},
+				"low":     {t3},
+			},
+			qnames: []string{"default", "low"},
+			want:   3,
+		},
+		{
+			enqueued: map[string][]*base.TaskMessage{
+				"default": {t1, t2},
+				"low":     {t3},
+			},
+			qnames: []string{"low", "critical"},
+			want:   1,
+		},
+		{
+			enqueued: map[string][]*base.TaskMessage{
+				"default": {},
+			},
+			qnames: []string{"default"},
+			want:   0,
+		},
+	}
+
+	for _, tc := range tests {
+		h.FlushDB(t, r.client) // clean up db before each test case
+		for queue, msgs := range tc.enqueued {
+			h.SeedEnqueuedQueue(t, r.client, msgs, queue)
+		}
+
+		got, err := r.EnqueuedCount(tc.qnames...)
+		if err != nil || got != tc.want {
+			t.Errorf("(*RDB).EnqueuedCount(%v) = %d, %v, want %d, nil", tc.qnames, got, err, tc.want)
+		}
+	}
+}
+
+func TestDoneWithOlderEncoding(t *testing.T) {
+	r := setup(t)
+	// message written by a version without the fields added since.
+	const data = `{"Type":"send_email","Payload":{"to":"user@example.com"},"ID":"db7mpdr8di1dke2c0lsg",` +
+		`"Queue":"default","Retry":25,"Retried":0,"ErrorMsg":"","Timeout":"","Deadline":""}`
+	for _, remove := range []func(*base.TaskMessage) error{
+		r.Done,
+		func(msg *base.TaskMessage) error { return r.Retry(msg, time.Now().Add(time.Minute), "error") },
+		func(msg *base.TaskMessage) error { return r.Kill(msg, "error") },
+	} {
+		h.FlushDB(t, r.client)
+		if err := r.client.LPush(base.QueueKey("default"), data).Err(); err != nil {
+			t.Fatal(err)
+		}
+		msg, err := r.Dequeue("default")
+		if err != nil {
+			t.Fatalf("(*RDB).Dequeue returned error: %v", err)
+		}
+		if err := remove(msg); err != nil {
+			t.Fatalf("removing the task from in-progress list returned error: %v", err)
+		}
+		if n := r.client.LLen(base.InProgressQueue).Val(); n != 0 {
+			t.Errorf("%q has %d messages, want 0", base.InProgressQueue, n)
+		}
+	}
+}
+
 func TestDone(t *testing.T) {
 	r := setup(t)
 	t1 := h.NewTaskMessage("send_email", nil)
@@ -235,6 +312,26 @@ func TestDone(t *testing.T) {
 	}
 }
 
+func TestSkip(t *testing.T) {
+	r := setup(t)
+	t1 := h.NewTaskMessage("send_email", nil)
+	t2 := h.NewTaskMessage("export_csv", nil)
+	h.SeedInProgressQueue(t, r.client, []*base.TaskMessage{t1, t2})
+
+	if err := r.Skip(t1); err != nil {
+		t.Fatalf("(*RDB).Skip(task) = %v, want nil", err)
+	}
+
+	gotInProgress := h.GetInProgressMessages(t, r.client)
+	if diff := cmp.Diff([]*base.TaskMessage{t2}, gotInProgress, h.SortMsgOpt); diff != "" {
+		t.Errorf("mismatch found in %q: (-want, +got):\n%s", base.InProgressQueue, diff)
+	}
+	processedKey := base.ProcessedKey(time.Now())
+	if n := r.client.Exists(processedKey).Val(); n != 0 {
+		t.Errorf("%q exists after Skip, want the task not counted as processed", processedKey)
+	}
+}
+
 func TestRequeue(t *testing.T) {
 	r := setup(t)
 	t1 := h.NewTaskMessage("send_email", nil)
@@ -344,12 +441,91 @@ func TestSchedule(t *testing.T) {
 	}
 }
 
+func TestDefer(t *testing.T) {
+	r := setup(t)
+	t1 := h.NewTaskMessage("send_email", nil)
+	t2 := h.NewTaskMessage("export_csv", nil)
+	t1.Retried = 3
+	processAt := time.Now().Add(time.Minute)
+
+	tests := []struct {
+		inProgress     []*base.TaskMessage
+		scheduled      []h.ZSetEntry
+		msg            *base.TaskMessage
+		processAt      time.Time
+		wantInProgress []*base.TaskMessage
+		wantScheduled  []h.ZSetEntry
+	}{
+		{
+			inProgress:     []*base.TaskMessage{t1, t2},
+			scheduled:      []h.ZSetEntry{},
+			msg:            t1,
+			processAt:      processAt,
+			wantInProgress: []*base.TaskMessage{t2},
+			wantScheduled: []h.ZSetEntry{
+				{Msg: t1, Score: float64(processAt.Unix())},
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		h.FlushDB(t, r.client) // clean up db before each test case
+		h.SeedInProgressQueue(t, r.client, tc.inProgress)
+		h.SeedScheduledQueue(t, r.client, tc.scheduled)
+
+		err := r.Defer(tc.msg, tc.processAt)
+		if err != nil {
+			t.Errorf("(*RDB).Defer(%v, %v) = %v, want nil", tc.msg, tc.processAt, err)
+			continue
+		}
+
+		gotInProgress := h.GetInProgressMessages(t, r.client)
+		if diff := cmp.Diff(tc.wantInProgress, gotInProgress, h.SortMsgOpt); diff != "" {
+			t.Errorf("mismatch found in %q; (-want, +got)\n%s", base.InProgressQueue, diff)
+		}
+		gotScheduled := h.GetScheduledEntries(t, r.client)
+		if diff := cmp.Diff(tc.wantScheduled, gotScheduled, h.SortZSetEntryOpt); diff != "" {
+			t.Errorf("mismatch found in %q; (-want, +got)\n%s", base.ScheduledQueue, diff)
+		}
+	}
+}
+
+func TestReprocess(t *testing.T) {
+	r := setup(t)
+	t1 := h.NewTaskMessage("export_csv", map[string]interface{}{"cursor": 0.0})
+	t2 := h.NewTaskMessage("send_email", nil)
+	t1.Retried = 2
+	processAt := time.Now().Add(time.Minute)
+	payload := map[string]interface{}{"cursor": 100.0}
+	t1AfterReprocess := *t1
+	t1AfterReprocess.Payload = payload
+	t1AfterReprocess.EnqueuedAt = processAt.Unix()
+
+	h.SeedInProgressQueue(t, r.client, []*base.TaskMessage{t1, t2})
+
+	if err := r.Reprocess(t1, payload, processAt); err != nil {
+		t.Fatalf("(*RDB).Reprocess(%v, %v, %v) = %v, want nil", t1, payload, processAt, err)
+	}
+
+	gotInProgress := h.GetInProgressMessages(t, r.client)
+	if diff := cmp.Diff([]*base.TaskMessage{t2}, gotInProgress); diff != "" {
+		t.Errorf("mismatch found in %q; (-want, +got)\n%s", base.InProgressQueue, diff)
+	}
+	gotScheduled := h.GetScheduledEntries(t, r.client)
+	wantScheduled := []h.ZSetEntry{{Msg: &t1AfterReprocess, Score: float64(processAt.Unix())}}
+	if diff := cmp.Diff(wantScheduled, gotScheduled); diff != "" {
+		t.Errorf("mismatch found in %q; (-want, +got)\n%s", base.ScheduledQueue, diff)
+	}
+}
+
 func TestRetry(t *testing.T) {
 	r := setup(t)
 	t1 := h.NewTaskMessage("send_email", map[string]interface{}{"subject": "Hola!"})
 	t2 := h.NewTaskMessage("gen_thumbnail", map[string]interface{}{"path": "some/path/to/image.jpg"})
 	t3 := h.NewTaskMessage("reindex", nil)
+	now := time.Now()
 	t1.Retried = 10
+	t1.FailedAt = now.Add(-time.Hour).Unix()
 	errMsg := "SMTP server is not responding"
 	t1AfterRetry := &base.TaskMessage{
 		ID:       t1.ID,
@@ -359,8 +535,9 @@ func TestRetry(t *testing.T) {
 		Retry:    t1.Retry,
 		Retried:  t1.Retried + 1,
 		ErrorMsg: errMsg,
+		FailedAt: t1.FailedAt,
 	}
-	now := time.Now()
+	t1AfterRetry.EnqueuedAt = now.Add(5 * time.Minute).Unix()
 
 	tests := []struct {
 		inProgress     []*base.TaskMessage
@@ -439,6 +616,24 @@ func TestRetry(t *testing.T) {
 	}
 }
 
+func TestRetryRecordsFirstFailure(t *testing.T) {
+	r := setup(t)
+	msg := h.NewTaskMessage("send_email", nil)
+	h.SeedInProgressQueue(t, r.client, []*base.TaskMessage{msg})
+
+	now := time.Now()
+	if err := r.Retry(msg, now.Add(time.Hour), "error"); err != nil {
+		t.Fatalf("(*RDB).Retry = %v, want nil", err)
+	}
+	got := h.GetRetryMessages(t, r.client)
+	if len(got) != 1 {
+		t.Fatalf("%q has %d tasks, want 1", base.RetryQueue, len(got))
+	}
+	if d := got[0].FailedAt - now.Unix(); d < -1 || d > 1 {
+		t.Errorf("FailedAt = %d after the first failure, want about %d", got[0].FailedAt, now.Unix())
+	}
+}
+
 func TestKill(t *testing.T) {
 	r := setup(t)
 	t1 := h.NewTaskMessage("send_email", nil)
@@ -542,13 +737,45 @@ func TestKill(t *testing.T) {
 	}
 }
 
-func TestRequeueAll(t *testing.T) {
+func TestRequeueTo(t *testing.T) {
+	r := setup(t)
+	t1 := h.NewTaskMessage("send_email", nil)
+	t2 := h.NewTaskMessage("export_csv", nil)
+	t1InUnhandled := *t1
+	t1InUnhandled.Queue = "unhandled"
+
+	h.SeedInProgressQueue(t, r.client, []*base.TaskMessage{t1, t2})
+
+	if err := r.RequeueTo(t1, "unhandled"); err != nil {
+		t.Fatalf("(*RDB).RequeueTo(%v, %q) = %v, want nil", t1, "unhandled", err)
+	}
+
+	gotInProgress := h.GetInProgressMessages(t, r.client)
+	if diff := cmp.Diff([]*base.TaskMessage{t2}, gotInProgress); diff != "" {
+		t.Errorf("mismatch found in %q; (-want, +got)\n%s", base.InProgressQueue, diff)
+	}
+	gotEnqueued := h.GetEnqueuedMessages(t, r.client, "unhandled")
+	if diff := cmp.Diff([]*base.TaskMessage{&t1InUnhandled}, gotEnqueued); diff != "" {
+		t.Errorf("mismatch found in %q; (-want, +got)\n%s", base.QueueKey("unhandled"), diff)
+	}
+	if !r.client.SIsMember(base.AllQueues, base.QueueKey("unhandled")).Val() {
+		t.Errorf("%q is not a member of %q", base.QueueKey("unhandled"), base.AllQueues)
+	}
+}
+
+func TestRestoreAll(t *testing.T) {
 	r := setup(t)
 	t1 := h.NewTaskMessage("send_email", nil)
 	t2 := h.NewTaskMessage("export_csv", nil)
 	t3 := h.NewTaskMessage("sync_stuff", nil)
 	t4 := h.NewTaskMessageWithQueue("important", nil, "critical")
 	t5 := h.NewTaskMessageWithQueue("minor", nil, "low")
+	// restored returns a copy of the message restored once more.
+	restored := func(msg *base.TaskMessage) *base.TaskMessage {
+		m := *msg
+		m.Restored++
+		return &m
+	}
 
 	tests := []struct {
 		inProgress     []*base.TaskMessage
@@ -565,7 +792,7 @@ func TestRequeueAll(t *testing.T) {
 			want:           3,
 			wantInProgress: []*base.TaskMessage{},
 			wantEnqueued: map[string][]*base.TaskMessage{
-				base.DefaultQueueName: {t1, t2, t3},
+				base.DefaultQueueName: {restored(t1), restored(t2), restored(t3)},
 			},
 		},
 		{
@@ -587,7 +814,7 @@ func TestRequeueAll(t *testing.T) {
 			want:           2,
 			wantInProgress: []*base.TaskMessage{},
 			wantEnqueued: map[string][]*base.TaskMessage{
-				base.DefaultQueueName: {t1, t2, t3},
+				base.DefaultQueueName: {t1, restored(t2), restored(t3)},
 			},
 		},
 		{
@@ -600,9 +827,9 @@ func TestRequeueAll(t *testing.T) {
 			want:           4,
 			wantInProgress: []*base.TaskMessage{},
 			wantEnqueued: map[string][]*base.TaskMessage{
-				base.DefaultQueueName: {t1, t2, t3},
-				"critical":            {t4},
-				"low":                 {t5},
+				base.DefaultQueueName: {t1, restored(t2), restored(t3)},
+				"critical":            {restored(t4)},
+				"low":                 {restored(t5)},
 			},
 		},
 	}
@@ -614,9 +841,9 @@ func TestRequeueAll(t *testing.T) {
 			h.SeedEnqueuedQueue(t, r.client, msgs, qname)
 		}
 
-		got, err := r.RequeueAll()
+		got, err := r.RestoreAll()
 		if got != tc.want || err != nil {
-			t.Errorf("(*RDB).RequeueAll() = %v %v, want %v nil", got, err, tc.want)
+			t.Errorf("(*RDB).RestoreAll() = %v %v, want %v nil", got, err, tc.want)
 			continue
 		}
 
@@ -634,6 +861,64 @@ func TestRequeueAll(t *testing.T) {
 	}
 }
 
+func TestRestoreAllWithTasksOfLiveProcess(t *testing.T) {
+	r := setup(t)
+	t1 := h.NewTaskMessage("send_email", nil)
+	t2 := h.NewTaskMessage("export_csv", nil)
+	h.SeedInProgressQueue(t, r.client, []*base.TaskMessage{t1, t2})
+	// another process is alive and processing t2.
+	ps := base.NewProcessState("localhost", 9876, 10, map[string]int{"default": 1}, false)
+	ps.AddWorkerStats(t2, time.Now())
+	if err := r.WriteProcessState(ps, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := r.RestoreAll()
+	if got != 2 || err != nil {
+		t.Fatalf("(*RDB).RestoreAll() = %v %v, want 2 nil", got, err)
+	}
+	restored := *t1
+	restored.Restored++
+	want := []*base.TaskMessage{&restored, t2}
+	if diff := cmp.Diff(want, h.GetEnqueuedMessages(t, r.client), h.SortMsgOpt); diff != "" {
+		t.Errorf("mismatch found in %q: (-want, +got):\n%s", base.DefaultQueue, diff)
+	}
+}
+
+func TestRequeueAll(t *testing.T) {
+	r := setup(t)
+	t1 := h.NewTaskMessage("send_email", nil)
+	t2 := h.NewTaskMessageWithQueue("important", nil, "critical")
+	t2.Restored = 1
+	t3 := h.NewTaskMessage("sync_stuff", nil)
+	h.SeedInProgressQueue(t, r.client, []*base.TaskMessage{t1, t2, t3})
+	// written by an older version, to be moved as it is.
+	old := `{"Type":"reindex","Payload":{},"ID":"` + xid.New().String() +
+		`","Queue":"default","Retry":25,"Retried":0,"ErrorMsg":"","Timeout":"","Deadline":""}`
+	if err := r.client.LPush(base.InProgressQueue, old).Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := r.RequeueAll(t3.ID.String())
+	if got != 3 || err != nil {
+		t.Fatalf("(*RDB).RequeueAll(%v) = %v %v, want 3 nil", t3.ID, got, err)
+	}
+	if diff := cmp.Diff([]*base.TaskMessage{t3}, h.GetInProgressMessages(t, r.client)); diff != "" {
+		t.Errorf("mismatch found in %q: (-want, +got):\n%s", base.InProgressQueue, diff)
+	}
+	// restore counts are not incremented.
+	if diff := cmp.Diff([]*base.TaskMessage{t2}, h.GetEnqueuedMessages(t, r.client, "critical")); diff != "" {
+		t.Errorf("mismatch found in %q: (-want, +got):\n%s", base.QueueKey("critical"), diff)
+	}
+	data, err := r.client.LRange(base.DefaultQueue, 0, -1).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := h.MustMarshal(t, t1); len(data) != 2 || data[0] != old || data[1] != want {
+		t.Errorf("%q = %v, want %v", base.DefaultQueue, data, []string{old, want})
+	}
+}
+
 func TestCheckAndEnqueue(t *testing.T) {
 	r := setup(t)
 	t1 := h.NewTaskMessage("send_email", nil)
@@ -649,7 +934,7 @@ func TestCheckAndEnqueue(t *testing.T) {
 	tests := []struct {
 		scheduled     []h.ZSetEntry
 		retry         []h.ZSetEntry
-		qnames        []string
+		want          int64
 		wantEnqueued  map[string][]*base.TaskMessage
 		wantScheduled []*base.TaskMessage
 		wantRetry     []*base.TaskMessage
@@ -661,7 +946,7 @@ func TestCheckAndEnqueue(t *testing.T) {
 			},
 			retry: []h.ZSetEntry{
 				{Msg: t3, Score: float64(secondAgo.Unix())}},
-			qnames: []string{"default"},
+			want: 3,
 			wantEnqueued: map[string][]*base.TaskMessage{
 				"default": {t1, t2, t3},
 			},
@@ -674,7 +959,7 @@ func TestCheckAndEnqueue(t *testing.T) {
 				{Msg: t2, Score: float64(secondAgo.Unix())}},
 			retry: []h.ZSetEntry{
 				{Msg: t3, Score: float64(secondAgo.Unix())}},
-			qnames: []string{"default"},
+			want: 2,
 			wantEnqueued: map[string][]*base.TaskMessage{
 				"default": {t2, t3},
 			},
@@ -687,7 +972,7 @@ func TestCheckAndEnqueue(t *testing.T) {
 				{Msg: t2, Score: float64(hourFromNow.Unix())}},
 			retry: []h.ZSetEntry{
 				{Msg: t3, Score: float64(hourFromNow.Unix())}},
-			qnames: []string{"default"},
+			want: 0,
 			wantEnqueued: map[string][]*base.TaskMessage{
 				"default": {},
 			},
@@ -701,7 +986,7 @@ func TestCheckAndEnqueue(t *testing.T) {
 			},
 			retry: []h.ZSetEntry{
 				{Msg: t5, Score: float64(secondAgo.Unix())}},
-			qnames: []string{"default", "critical", "low"},
+			want: 3,
 			wantEnqueued: map[string][]*base.TaskMessage{
 				"default":  {t1},
 				"critical": {t4},
@@ -717,11 +1002,14 @@ func TestCheckAndEnqueue(t *testing.T) {
 		h.SeedScheduledQueue(t, r.client, tc.scheduled)
 		h.SeedRetryQueue(t, r.client, tc.retry)
 
-		err := r.CheckAndEnqueue(tc.qnames...)
+		got, err := r.CheckAndEnqueue(0)
 		if err != nil {
-			t.Errorf("(*RDB).CheckScheduled() = %v, want nil", err)
+			t.Errorf("(*RDB).CheckAndEnqueue() returned error: %v", err)
 			continue
 		}
+		if got != tc.want {
+			t.Errorf("(*RDB).CheckAndEnqueue() = %d, want %d", got, tc.want)
+		}
 
 		for qname, want := range tc.wantEnqueued {
 			gotEnqueued := h.GetEnqueuedMessages(t, r.client, qname)
@@ -742,6 +1030,101 @@ func TestCheckAndEnqueue(t *testing.T) {
 	}
 }
 
+func TestCheckAndEnqueueInBatches(t *testing.T) {
+	r := setup(t)
+	now := time.Now()
+	// more due tasks than the batch size in a low priority queue,
+	// and a task which is due first in another queue.
+	var scheduled []h.ZSetEntry
+	for i := 0; i < forwardBatchSize+10; i++ {
+		msg := h.NewTaskMessageWithQueue("export_csv", nil, "low")
+		scheduled = append(scheduled, h.ZSetEntry{Msg: msg, Score: float64(now.Add(-time.Minute).Unix())})
+	}
+	t1 := h.NewTaskMessageWithQueue("send_email", nil, "critical")
+	h.SeedScheduledQueue(t, r.client, scheduled)
+	h.SeedRetryQueue(t, r.client, []h.ZSetEntry{{Msg: t1, Score: float64(now.Add(-time.Hour).Unix())}})
+
+	// a single batch moves the tasks which are due first, across both sets.
+	n, retried, err := r.forward(2, -1)
+	if err != nil {
+		t.Fatalf("(*RDB).forward(2, -1) returned error: %v", err)
+	}
+	if n != 2 || retried != 1 {
+		t.Errorf("(*RDB).forward(2, -1) = %d, %d; want 2, 1", n, retried)
+	}
+	if got := h.GetEnqueuedMessages(t, r.client, "critical"); len(got) != 1 || got[0].ID != t1.ID {
+		t.Errorf("%q = %v, want only task %v", base.QueueKey("critical"), got, t1.ID)
+	}
+	if got := len(h.GetEnqueuedMessages(t, r.client, "low")); got != 1 {
+		t.Errorf("%q has %d tasks, want 1", base.QueueKey("low"), got)
+	}
+
+	// the rest of the tasks are moved in multiple batches.
+	n, err = r.CheckAndEnqueue(0)
+	if err != nil {
+		t.Fatalf("(*RDB).CheckAndEnqueue() returned error: %v", err)
+	}
+	if want := int64(forwardBatchSize + 9); n != want {
+		t.Errorf("(*RDB).CheckAndEnqueue() = %d, want %d", n, want)
+	}
+	if got := len(h.GetEnqueuedMessages(t, r.client, "low")); got != forwardBatchSize+10 {
+		t.Errorf("%q has %d tasks, want %d", base.QueueKey("low"), got, forwardBatchSize+10)
+	}
+	if got := len(h.GetScheduledMessages(t, r.client)); got != 0 {
+		t.Errorf("%q has %d tasks, want 0", base.ScheduledQueue, got)
+	}
+	for _, qname := range []string{"critical", "low"} {
+		if !r.client.SIsMember(base.AllQueues, base.QueueKey(qname)).Val() {
+			t.Errorf("%q is not a member of %q", base.QueueKey(qname), base.AllQueues)
+		}
+	}
+}
+
+func TestCheckAndEnqueueWithRetryLimit(t *testing.T) {
+	r := setup(t)
+	now := time.Now()
+	// more due retry tasks than the batch size, and scheduled tasks due later.
+	var retry, scheduled []h.ZSetEntry
+	for i := 0; i < forwardBatchSize+10; i++ {
+		msg := h.NewTaskMessage("send_email", nil)
+		retry = append(retry, h.ZSetEntry{Msg: msg, Score: float64(now.Add(-time.Hour).Unix())})
+	}
+	for i := 0; i < 5; i++ {
+		msg := h.NewTaskMessage("export_csv", nil)
+		scheduled = append(scheduled, h.ZSetEntry{Msg: msg, Score: float64(now.Add(-time.Minute).Unix())})
+	}
+	h.SeedRetryQueue(t, r.client, retry)
+	h.SeedScheduledQueue(t, r.client, scheduled)
+
+	tests := []struct {
+		retryLimit    int
+		want          int64
+		wantRetryLeft int
+	}{
+		// scheduled tasks are moved although more retry tasks were due first.
+		{retryLimit: 3, want: 8, wantRetryLeft: forwardBatchSize + 7},
+		// the limit is counted across batches.
+		{retryLimit: forwardBatchSize + 2, want: forwardBatchSize + 2, wantRetryLeft: 5},
+		{retryLimit: 0, want: 5, wantRetryLeft: 0},
+	}
+
+	for _, tc := range tests {
+		n, err := r.CheckAndEnqueue(tc.retryLimit)
+		if err != nil {
+			t.Fatalf("(*RDB).CheckAndEnqueue(%d) returned error: %v", tc.retryLimit, err)
+		}
+		if n != tc.want {
+			t.Errorf("(*RDB).CheckAndEnqueue(%d) = %d, want %d", tc.retryLimit, n, tc.want)
+		}
+		if got := len(h.GetRetryMessages(t, r.client)); got != tc.wantRetryLeft {
+			t.Errorf("%q has %d tasks after CheckAndEnqueue(%d), want %d", base.RetryQueue, got, tc.retryLimit, tc.wantRetryLeft)
+		}
+		if got := len(h.GetScheduledMessages(t, r.client)); got != 0 {
+			t.Errorf("%q has %d tasks after CheckAndEnqueue(%d), want 0", base.ScheduledQueue, got, tc.retryLimit)
+		}
+	}
+}
+
 func TestWriteProcessState(t *testing.T) {
 	r := setup(t)
 	host, pid := "localhost", 98765
@@ -1017,3 +1400,238 @@ func TestCancelationPubSub(t *testing.T) {
 	}
 	mu.Unlock()
 }
+
+func TestControlPubSub(t *testing.T) {
+	r := setup(t)
+	host, pid := "localhost", 1234
+
+	pubsub, err := r.ControlPubSub(host, pid)
+	if err != nil {
+		t.Fatalf("(*RDB).ControlPubSub(%q, %d) returned an error: %v", host, pid, err)
+	}
+
+	cmdCh := pubsub.Channel()
+
+	var (
+		mu       sync.Mutex
+		received []string
+	)
+
+	go func() {
+		for msg := range cmdCh {
+			mu.Lock()
+			received = append(received, msg.Payload)
+			mu.Unlock()
+		}
+	}()
+
+	publish := []string{base.PauseCommand, base.ResumeCommand}
+
+	for _, cmd := range publish {
+		if err := r.PublishControl(host, pid, cmd); err != nil {
+			t.Errorf("(*RDB).PublishControl(%q, %d, %q) = %v, want nil", host, pid, cmd, err)
+		}
+	}
+
+	// allow for message to reach subscribers.
+	time.Sleep(time.Second)
+
+	pubsub.Close()
+
+	mu.Lock()
+	if diff := cmp.Diff(publish, received); diff != "" {
+		t.Errorf("subscriber received %v, want %v; (-want,+got)\n%s", received, publish, diff)
+	}
+	mu.Unlock()
+}
+
+func TestPublishControlWithoutSubscriber(t *testing.T) {
+	r := setup(t)
+
+	err := r.PublishControl("otherhost", 9876, base.PauseCommand)
+	if err != ErrProcessNotFound {
+		t.Errorf("(*RDB).PublishControl() = %v, want %v", err, ErrProcessNotFound)
+	}
+}
+
+func TestRecordAttempt(t *testing.T) {
+	r := setup(t)
+	id := xid.New().String()
+	now := time.Now().UTC()
+	var attempts []*base.TaskAttempt
+	for i := 0; i < 5; i++ {
+		attempts = append(attempts, &base.TaskAttempt{
+			Host:     "localhost",
+			PID:      1234,
+			Started:  now.Add(time.Duration(i) * time.Minute),
+			Duration: time.Duration(i+1) * time.Second,
+			ErrorMsg: fmt.Sprintf("error %d", i),
+		})
+	}
+
+	tests := []struct {
+		attempts []*base.TaskAttempt
+		limit    int
+		want     []*base.TaskAttempt
+	}{
+		{
+			attempts: attempts[:2],
+			limit:    10,
+			want:     attempts[:2],
+		},
+		{
+			attempts: attempts,
+			limit:    3,
+			want:     attempts[2:], // only the latest attempts are kept
+		},
+	}
+
+	for _, tc := range tests {
+		h.FlushDB(t, r.client) // clean up db before each test case
+
+		for _, a := range tc.attempts {
+			if err := r.RecordAttempt(id, a, tc.limit); err != nil {
+				t.Fatalf("(*RDB).RecordAttempt(%q, %+v, %d) = %v, want nil", id, a, tc.limit, err)
+			}
+		}
+
+		got, err := r.TaskHistory(id)
+		if err != nil {
+			t.Errorf("(*RDB).TaskHistory(%q) returned error: %v", id, err)
+			continue
+		}
+		if diff := cmp.Diff(tc.want, got); diff != "" {
+			t.Errorf("(*RDB).TaskHistory(%q) = %v, want %v; (-want,+got)\n%s", id, got, tc.want, diff)
+		}
+		if ttl := r.client.TTL(base.HistoryKey(id)).Val(); ttl <= 0 || ttl > historyTTL {
+			t.Errorf("TTL of %q = %v, want in (0, %v]", base.HistoryKey(id), ttl, historyTTL)
+		}
+	}
+}
+
+func TestAddChild(t *testing.T) {
+	r := setup(t)
+	parentID := xid.New().String()
+	c1 := h.NewTaskMessageWithQueue("report:user", nil, "reports")
+	c2 := h.NewTaskMessageWithQueue("cleanup", nil, "tenant:acme")
+
+	for _, c := range []*base.TaskMessage{c1, c2} {
+		if err := r.AddChild(parentID, c); err != nil {
+			t.Fatalf("(*RDB).AddChild(%q, %v) = %v, want nil", parentID, c, err)
+		}
+	}
+
+	got, err := r.Children(parentID)
+	if err != nil {
+		t.Fatalf("(*RDB).Children(%q) returned error: %v", parentID, err)
+	}
+	want := []*TaskRef{
+		{ID: c1.ID.String(), Queue: "reports"},
+		{ID: c2.ID.String(), Queue: "tenant:acme"},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("(*RDB).Children(%q) = %v, want %v; (-want,+got)\n%s", parentID, got, want, diff)
+	}
+	if ttl := r.client.TTL(base.ChildrenKey(parentID)).Val(); ttl <= 0 || ttl > historyTTL {
+		t.Errorf("TTL of %q = %v, want in (0, %v]", base.ChildrenKey(parentID), ttl, historyTTL)
+	}
+}
+
+func TestSetProgress(t *testing.T) {
+	r := setup(t)
+	id := xid.New().String()
+
+	got, err := r.Progress(id)
+	if err != nil || got != nil {
+		t.Fatalf("(*RDB).Progress(%q) = %v, %v; want nil, nil", id, got, err)
+	}
+
+	progresses := []*base.TaskProgress{
+		{Retried: 0, Data: json.RawMessage(`{"percent":10}`)},
+		{Retried: 1, Data: json.RawMessage(`"encoding"`)},
+	}
+	for _, p := range progresses {
+		if err := r.SetProgress(id, p); err != nil {
+			t.Fatalf("(*RDB).SetProgress(%q, %v) = %v, want nil", id, p, err)
+		}
+		got, err := r.Progress(id)
+		if err != nil {
+			t.Fatalf("(*RDB).Progress(%q) returned error: %v", id, err)
+		}
+		if diff := cmp.Diff(p, got); diff != "" {
+			t.Errorf("(*RDB).Progress(%q) = %v, want %v; (-want, +got)\n%s", id, got, p, diff)
+		}
+	}
+
+	if ttl := r.client.TTL(base.ProgressKey(id)).Val(); ttl <= 0 || ttl > progressTTL {
+		t.Errorf("TTL of %q = %v, want in (0, %v]", base.ProgressKey(id), ttl, progressTTL)
+	}
+}
+
+func TestQueueNames(t *testing.T) {
+	r := setup(t)
+	h.SeedEnqueuedQueue(t, r.client, []*base.TaskMessage{h.NewTaskMessage("send_email", nil)})
+	h.SeedEnqueuedQueue(t, r.client, []*base.TaskMessage{}, "tenant:b")
+	h.SeedEnqueuedQueue(t, r.client, []*base.TaskMessage{}, "tenant:a")
+
+	got, err := r.QueueNames()
+	if err != nil {
+		t.Fatalf("(*RDB).QueueNames() returned error: %v", err)
+	}
+	want := []string{"default", "tenant:a", "tenant:b"}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("(*RDB).QueueNames() = %v, want %v; (-want, +got)\n%s", got, want, diff)
+	}
+}
+
+func TestRecordCompletion(t *testing.T) {
+	r := setup(t)
+	key := "payment:123"
+
+	completed, err := r.IsCompleted(key)
+	if err != nil || completed {
+		t.Fatalf("(*RDB).IsCompleted(%q) = %t, %v; want false, nil", key, completed, err)
+	}
+	if err := r.RecordCompletion(key, time.Hour); err != nil {
+		t.Fatalf("(*RDB).RecordCompletion(%q, 1h) = %v, want nil", key, err)
+	}
+	completed, err = r.IsCompleted(key)
+	if err != nil || !completed {
+		t.Fatalf("(*RDB).IsCompleted(%q) = %t, %v; want true, nil", key, completed, err)
+	}
+	if ttl := r.client.TTL(base.CompletedKey(key)).Val(); ttl <= 0 || ttl > time.Hour {
+		t.Errorf("TTL of %q = %v, want in (0, 1h]", base.CompletedKey(key), ttl)
+	}
+}
+
+func TestDequeueStats(t *testing.T) {
+	r := setup(t)
+	now := time.Now().Truncate(time.Millisecond)
+
+	// stats of processes are added up.
+	if err := r.RecordDequeueStats(map[string]*base.DequeueStats{
+		"default": {Attempts: 10, Hits: 4, LastQueried: now},
+		"low":     {Attempts: 2, Hits: 0, LastQueried: now.Add(-time.Minute)},
+	}); err != nil {
+		t.Fatalf("(*RDB).RecordDequeueStats returned error: %v", err)
+	}
+	if err := r.RecordDequeueStats(map[string]*base.DequeueStats{
+		"default": {Attempts: 5, Hits: 1, LastQueried: now.Add(-time.Second)},
+	}); err != nil {
+		t.Fatalf("(*RDB).RecordDequeueStats returned error: %v", err)
+	}
+
+	got, err := r.DequeueStats("default", "low", "critical")
+	if err != nil {
+		t.Fatalf("(*RDB).DequeueStats returned error: %v", err)
+	}
+	want := map[string]*base.DequeueStats{
+		"default":  {Attempts: 15, Hits: 5, LastQueried: now},
+		"low":      {Attempts: 2, Hits: 0, LastQueried: now.Add(-time.Minute)},
+		"critical": {},
+	}
+	opt := cmp.Comparer(func(x, y time.Time) bool { return x.Equal(y) })
+	if diff := cmp.Diff(want, got, opt); diff != "" {
+		t.Errorf("(*RDB).DequeueStats() = %v, want %v; (-want,+got)\n%s", got, want, diff)
+	}
+}
@@ -0,0 +1,249 @@
+// Copyright 2020 Kentaro Hibino. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package rdb
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+	"github.com/hibiken/asynq/internal/base"
+	"github.com/spf13/cast"
+)
+
+// While base.ReplicationEnabled is set, Enqueue and Schedule append an "add"
+// change with the task to the replication log, which is copied to the replica
+// by ApplyReplication. Done, Kill and the removal of tasks by the inspector
+// append a "del" change with the task ID, and enqueuing a dead task or moving
+// a task to another queue appends an "add" change replacing the task.
+// The replica keeps the tasks added and not deleted yet, to be added to
+// their queues by PromoteReplica.
+
+// replicationChange is a change written to the replication log.
+type replicationChange struct {
+	Op    string          `json:"op"`
+	State string          `json:"state,omitempty"`
+	Score int64           `json:"score,omitempty"`
+	Time  int64           `json:"time"` // unix time in milliseconds
+	ID    string          `json:"id,omitempty"`
+	Msg   json.RawMessage `json:"msg,omitempty"`
+}
+
+func unixMilli(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+func fromUnixMilli(ms int64) time.Time {
+	return time.Unix(0, ms*int64(time.Millisecond))
+}
+
+// KEYS[1] -> asynq:replication:lock
+// KEYS[2] -> asynq:replication
+// KEYS[3] -> asynq:replication:log
+// ARGV[1] -> owner of the lock
+// ARGV[2] -> TTL of the lock in milliseconds
+// ARGV[3] -> max number of changes to read
+var readReplicationLogCmd = redis.NewScript(`
+local owner = redis.call("GET", KEYS[1])
+if owner and owner ~= ARGV[1] then
+	return {}
+end
+redis.call("SET", KEYS[1], ARGV[1], "PX", ARGV[2])
+redis.call("SET", KEYS[2], "1", "PX", ARGV[2])
+return redis.call("LRANGE", KEYS[3], 0, tonumber(ARGV[3]) - 1)`)
+
+// ReadReplicationLog returns up to n changes in the replication log from the
+// oldest one if the lock to replicate is not held by another owner.
+// It takes or extends the lock for the owner for the given TTL, and enables
+// the replication for the same TTL.
+//
+// The changes should be removed from the log with TrimReplicationLog
+// once they are applied to the replica.
+func (r *RDB) ReadReplicationLog(owner string, n int, ttl time.Duration) ([]string, error) {
+	res, err := readReplicationLogCmd.Run(r.client,
+		[]string{base.ReplicationLock, base.ReplicationEnabled, base.ReplicationLog},
+		owner, ttl.Milliseconds(), n).Result()
+	if err != nil {
+		return nil, err
+	}
+	return cast.ToStringSliceE(res)
+}
+
+// KEYS[1] -> asynq:replication:lock
+// KEYS[2] -> asynq:replication:log
+// ARGV[1] -> owner of the lock
+// ARGV[2] -> number of changes to remove
+var trimReplicationLogCmd = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	redis.call("LTRIM", KEYS[2], ARGV[2], -1)
+end
+return redis.status_reply("OK")`)
+
+// TrimReplicationLog removes the oldest n changes from the replication log
+// if the lock to replicate is held by the owner.
+func (r *RDB) TrimReplicationLog(owner string, n int) error {
+	return trimReplicationLogCmd.Run(r.client,
+		[]string{base.ReplicationLock, base.ReplicationLog}, owner, n).Err()
+}
+
+// KEYS[1] -> asynq:replication:lock
+// ARGV[1] -> owner of the lock
+var releaseReplicationLockCmd = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	redis.call("DEL", KEYS[1])
+end
+return redis.status_reply("OK")`)
+
+// ReleaseReplicationLock releases the lock to replicate if held by the owner,
+// so that another owner can take it over right away.
+func (r *RDB) ReleaseReplicationLock(owner string) error {
+	return releaseReplicationLockCmd.Run(r.client, []string{base.ReplicationLock}, owner).Err()
+}
+
+// ReplicationInfo reports whether the replication is enabled, the number of
+// changes not replicated yet and the time the oldest one was made.
+// The time is zero if all changes are replicated.
+func (r *RDB) ReplicationInfo() (enabled bool, backlog int64, oldest time.Time, err error) {
+	n, err := r.client.Exists(base.ReplicationEnabled).Result()
+	if err != nil {
+		return false, 0, time.Time{}, err
+	}
+	backlog, err = r.client.LLen(base.ReplicationLog).Result()
+	if err != nil {
+		return false, 0, time.Time{}, err
+	}
+	data, err := r.client.LIndex(base.ReplicationLog, 0).Result()
+	if err == redis.Nil {
+		return n == 1, backlog, time.Time{}, nil
+	}
+	if err != nil {
+		return false, 0, time.Time{}, err
+	}
+	var c replicationChange
+	if err := json.Unmarshal([]byte(data), &c); err != nil {
+		return false, 0, time.Time{}, err
+	}
+	return n == 1, backlog, fromUnixMilli(c.Time), nil
+}
+
+// KEYS[1] -> asynq:replica:tasks
+// KEYS[2] -> asynq:replica:order
+// KEYS[3] -> asynq:replica:synced
+// ARGV    -> changes from the replication log
+var applyReplicationCmd = redis.NewScript(`
+local last = 0
+for _, data in ipairs(ARGV) do
+	local c = cjson.decode(data)
+	if c["op"] == "add" then
+		redis.call("HSET", KEYS[1], c["msg"]["ID"], data)
+		redis.call("ZADD", KEYS[2], c["time"], c["msg"]["ID"])
+	elseif c["op"] == "del" then
+		redis.call("HDEL", KEYS[1], c["id"])
+		redis.call("ZREM", KEYS[2], c["id"])
+	end
+	if c["time"] > last then
+		last = c["time"]
+	end
+end
+local synced = tonumber(redis.call("GET", KEYS[3]))
+if synced == nil or last > synced then
+	redis.call("SET", KEYS[3], string.format("%d", last))
+end
+return redis.status_reply("OK")`)
+
+// ApplyReplication applies the changes read from the replication log of
+// another redis, which is the primary of this replica.
+//
+// Applying the same changes again has no effect.
+func (r *RDB) ApplyReplication(changes []string) error {
+	if len(changes) == 0 {
+		return nil
+	}
+	args := make([]interface{}, len(changes))
+	for i, c := range changes {
+		args[i] = c
+	}
+	return applyReplicationCmd.Run(r.client,
+		[]string{base.ReplicaTasks, base.ReplicaOrder, base.ReplicaSynced}, args...).Err()
+}
+
+// ReplicaInfo returns the number of tasks kept by the replica and the time
+// the last change replicated to it was made. The time is zero if no changes
+// were replicated.
+func (r *RDB) ReplicaInfo() (tasks int64, synced time.Time, err error) {
+	tasks, err = r.client.HLen(base.ReplicaTasks).Result()
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	data, err := r.client.Get(base.ReplicaSynced).Result()
+	if err == redis.Nil {
+		return tasks, time.Time{}, nil
+	}
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	ms, err := strconv.ParseInt(data, 10, 64)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	return tasks, fromUnixMilli(ms), nil
+}
+
+// promoteBatchSize is the number of tasks added to their queues at a time by PromoteReplica.
+const promoteBatchSize = 1000
+
+// PromoteReplica adds the tasks kept by the replica to their queues, in the
+// order they were enqueued to the primary, and returns the number of tasks added.
+//
+// Tasks are added in batches, and a task is deleted from the replica once
+// added to its queue. If an error occurs, calling PromoteReplica again adds
+// the rest of the tasks, but may add the last batch of tasks twice.
+func (r *RDB) PromoteReplica() (int64, error) {
+	var n int64
+	for {
+		ids, err := r.client.ZRange(base.ReplicaOrder, 0, promoteBatchSize-1).Result()
+		if err != nil {
+			return n, err
+		}
+		if len(ids) == 0 {
+			return n, nil
+		}
+		res, err := r.client.HMGet(base.ReplicaTasks, ids...).Result()
+		if err != nil {
+			return n, err
+		}
+		var tasks []*TaskInfo
+		for _, v := range res {
+			data, ok := v.(string)
+			if !ok {
+				continue // deleted since read, ignore and continue
+			}
+			var c replicationChange
+			if err := json.Unmarshal([]byte(data), &c); err != nil {
+				continue // bad data, ignore and continue
+			}
+			var msg base.TaskMessage
+			if err := json.Unmarshal(c.Msg, &msg); err != nil {
+				continue // bad data, ignore and continue
+			}
+			tasks = append(tasks, &TaskInfo{Msg: &msg, State: c.State, Score: c.Score})
+		}
+		if err := r.ImportTasks(tasks); err != nil {
+			return n, err
+		}
+		members := make([]interface{}, len(ids))
+		for i, id := range ids {
+			members[i] = id
+		}
+		if err := r.client.HDel(base.ReplicaTasks, ids...).Err(); err != nil {
+			return n, err
+		}
+		if err := r.client.ZRem(base.ReplicaOrder, members...).Err(); err != nil {
+			return n, err
+		}
+		n += int64(len(tasks))
+	}
+}
@@ -0,0 +1,302 @@
+// Copyright 2020 Kentaro Hibino. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package rdb
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+	"github.com/google/go-cmp/cmp"
+	h "github.com/hibiken/asynq/internal/asynqtest"
+	"github.com/hibiken/asynq/internal/base"
+)
+
+func setupReplica(t *testing.T) *RDB {
+	t.Helper()
+	r := NewRDB(redis.NewClient(&redis.Options{
+		Addr: "localhost:6379",
+		DB:   12,
+	}))
+	h.FlushDB(t, r.client)
+	return r
+}
+
+func TestReplication(t *testing.T) {
+	r := setup(t)
+	replica := setupReplica(t)
+	t1 := h.NewTaskMessage("send_email", nil)
+	t2 := h.NewTaskMessage("generate_csv", nil)
+	t3 := h.NewTaskMessageWithQueue("reindex", nil, "low")
+	t4 := h.NewTaskMessage("not_replicated", nil)
+	processAt := time.Now().Add(time.Hour)
+
+	// changes are not logged until the replication is enabled.
+	if err := r.Enqueue(t4); err != nil {
+		t.Fatal(err)
+	}
+	changes, err := r.ReadReplicationLog("host:1", 10, time.Minute)
+	if err != nil {
+		t.Fatalf("(*RDB).ReadReplicationLog returned error: %v", err)
+	}
+	if len(changes) != 0 {
+		t.Errorf("(*RDB).ReadReplicationLog returned %d changes before replication is enabled, want 0", len(changes))
+	}
+
+	if err := r.Enqueue(t1); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Enqueue(t2); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Schedule(t3, processAt); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Done(t2); err != nil {
+		t.Fatal(err)
+	}
+
+	enabled, backlog, oldest, err := r.ReplicationInfo()
+	if err != nil {
+		t.Fatalf("(*RDB).ReplicationInfo returned error: %v", err)
+	}
+	if !enabled || backlog != 4 || time.Since(oldest) > time.Minute {
+		t.Errorf("(*RDB).ReplicationInfo() = %t, %d, %v; want true, 4, within a minute", enabled, backlog, oldest)
+	}
+
+	// another owner can't read the log while the lock is held.
+	changes, err = r.ReadReplicationLog("host:2", 10, time.Minute)
+	if err != nil {
+		t.Fatalf("(*RDB).ReadReplicationLog returned error: %v", err)
+	}
+	if len(changes) != 0 {
+		t.Errorf("(*RDB).ReadReplicationLog by another owner returned %d changes, want 0", len(changes))
+	}
+
+	changes, err = r.ReadReplicationLog("host:1", 10, time.Minute)
+	if err != nil {
+		t.Fatalf("(*RDB).ReadReplicationLog returned error: %v", err)
+	}
+	if len(changes) != 4 {
+		t.Fatalf("(*RDB).ReadReplicationLog returned %d changes, want 4", len(changes))
+	}
+	// applying the changes twice has no effect.
+	for i := 0; i < 2; i++ {
+		if err := replica.ApplyReplication(changes); err != nil {
+			t.Fatalf("(*RDB).ApplyReplication returned error: %v", err)
+		}
+	}
+	if err := r.TrimReplicationLog("host:1", len(changes)); err != nil {
+		t.Fatalf("(*RDB).TrimReplicationLog returned error: %v", err)
+	}
+	if _, backlog, _, _ := r.ReplicationInfo(); backlog != 0 {
+		t.Errorf("backlog after TrimReplicationLog = %d, want 0", backlog)
+	}
+
+	tasks, synced, err := replica.ReplicaInfo()
+	if err != nil {
+		t.Fatalf("(*RDB).ReplicaInfo returned error: %v", err)
+	}
+	if tasks != 2 || time.Since(synced) > time.Minute {
+		t.Errorf("(*RDB).ReplicaInfo() = %d, %v; want 2, within a minute", tasks, synced)
+	}
+
+	n, err := replica.PromoteReplica()
+	if err != nil {
+		t.Fatalf("(*RDB).PromoteReplica returned error: %v", err)
+	}
+	if n != 2 {
+		t.Errorf("(*RDB).PromoteReplica() = %d, want 2", n)
+	}
+	if diff := cmp.Diff([]*base.TaskMessage{t1}, h.GetEnqueuedMessages(t, replica.client)); diff != "" {
+		t.Errorf("mismatch found in %q of replica; (-want,+got)\n%s", base.DefaultQueue, diff)
+	}
+	wantScheduled := []h.ZSetEntry{{Msg: t3, Score: float64(processAt.Unix())}}
+	if diff := cmp.Diff(wantScheduled, h.GetScheduledEntries(t, replica.client)); diff != "" {
+		t.Errorf("mismatch found in %q of replica; (-want,+got)\n%s", base.ScheduledQueue, diff)
+	}
+	if tasks, _, _ := replica.ReplicaInfo(); tasks != 0 {
+		t.Errorf("replica has %d tasks after PromoteReplica, want 0", tasks)
+	}
+
+	// another owner takes over once the lock is released.
+	if err := r.ReleaseReplicationLock("host:1"); err != nil {
+		t.Fatalf("(*RDB).ReleaseReplicationLock returned error: %v", err)
+	}
+	if err := r.Enqueue(t4); err != nil {
+		t.Fatal(err)
+	}
+	changes, err = r.ReadReplicationLog("host:2", 10, time.Minute)
+	if err != nil {
+		t.Fatalf("(*RDB).ReadReplicationLog returned error: %v", err)
+	}
+	if len(changes) != 1 {
+		t.Errorf("(*RDB).ReadReplicationLog by new owner returned %d changes, want 1", len(changes))
+	}
+}
+
+func TestReplicationOfRemovedTasks(t *testing.T) {
+	r := setup(t)
+	replica := setupReplica(t)
+	t1 := h.NewTaskMessage("send_email", nil)
+	t2 := h.NewTaskMessage("generate_csv", nil)
+	processAt := time.Now().Add(time.Hour)
+
+	// replicate applies the changes in the replication log to the replica.
+	replicate := func() {
+		t.Helper()
+		changes, err := r.ReadReplicationLog("host:1", 100, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := replica.ApplyReplication(changes); err != nil {
+			t.Fatal(err)
+		}
+		if err := r.TrimReplicationLog("host:1", len(changes)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// kill moves t1 to the dead queue as the processor does.
+	kill := func() error {
+		if _, err := r.Dequeue(base.DefaultQueueName); err != nil {
+			return err
+		}
+		return r.Kill(t1, "error")
+	}
+
+	tests := []struct {
+		desc      string
+		scheduled bool // t1 is scheduled instead of enqueued
+		op        func() error
+		want      map[string]string // task ID to queue in replica
+	}{
+		{
+			desc: "Kill",
+			op:   kill,
+			want: map[string]string{t2.ID.String(): "default"},
+		},
+		{
+			desc: "KillEnqueuedTask",
+			op:   func() error { return r.KillEnqueuedTask("default", t1.ID) },
+			want: map[string]string{t2.ID.String(): "default"},
+		},
+		{
+			desc: "KillAllEnqueuedTasks",
+			op: func() error {
+				_, err := r.KillAllEnqueuedTasks("default")
+				return err
+			},
+			want: map[string]string{},
+		},
+		{
+			desc:      "KillScheduledTask",
+			scheduled: true,
+			op:        func() error { return r.KillScheduledTask(t1.ID, processAt.Unix()) },
+			want:      map[string]string{t2.ID.String(): "default"},
+		},
+		{
+			desc:      "KillAllScheduledTasks",
+			scheduled: true,
+			op: func() error {
+				_, err := r.KillAllScheduledTasks()
+				return err
+			},
+			want: map[string]string{t2.ID.String(): "default"},
+		},
+		{
+			desc:      "DeleteScheduledTask",
+			scheduled: true,
+			op:        func() error { return r.DeleteScheduledTask(t1.ID, processAt.Unix()) },
+			want:      map[string]string{t2.ID.String(): "default"},
+		},
+		{
+			desc:      "DeleteAllScheduledTasks",
+			scheduled: true,
+			op:        r.DeleteAllScheduledTasks,
+			want:      map[string]string{t2.ID.String(): "default"},
+		},
+		{
+			desc:      "RemoveQueue",
+			scheduled: true,
+			op:        func() error { return r.RemoveQueue("default", true) },
+			want:      map[string]string{},
+		},
+		{
+			desc: "MoveTasks",
+			op: func() error {
+				_, err := r.MoveTasks("default", "low", "send_email")
+				return err
+			},
+			want: map[string]string{t1.ID.String(): "low", t2.ID.String(): "default"},
+		},
+		{
+			desc: "EnqueueDeadTask",
+			op: func() error {
+				if err := kill(); err != nil {
+					return err
+				}
+				replicate()
+				dead := h.GetDeadEntries(t, r.client)
+				return r.EnqueueDeadTask(t1.ID, int64(dead[0].Score))
+			},
+			want: map[string]string{t1.ID.String(): "default", t2.ID.String(): "default"},
+		},
+		{
+			desc: "EnqueueAllDeadTasks",
+			op: func() error {
+				if err := kill(); err != nil {
+					return err
+				}
+				replicate()
+				_, err := r.EnqueueAllDeadTasks()
+				return err
+			},
+			want: map[string]string{t1.ID.String(): "default", t2.ID.String(): "default"},
+		},
+	}
+
+	for _, tc := range tests {
+		h.FlushDB(t, r.client)
+		h.FlushDB(t, replica.client)
+		replicate() // enable the replication.
+		if tc.scheduled {
+			if err := r.Schedule(t1, processAt); err != nil {
+				t.Fatal(err)
+			}
+		} else if err := r.Enqueue(t1); err != nil {
+			t.Fatal(err)
+		}
+		if err := r.Enqueue(t2); err != nil {
+			t.Fatal(err)
+		}
+		replicate()
+
+		if err := tc.op(); err != nil {
+			t.Errorf("%s: returned error: %v", tc.desc, err)
+			continue
+		}
+		replicate()
+
+		got := make(map[string]string)
+		for id, data := range replica.client.HGetAll(base.ReplicaTasks).Val() {
+			var c replicationChange
+			var msg base.TaskMessage
+			if err := json.Unmarshal([]byte(data), &c); err != nil {
+				t.Fatal(err)
+			}
+			if err := json.Unmarshal(c.Msg, &msg); err != nil {
+				t.Fatal(err)
+			}
+			got[id] = msg.Queue
+		}
+		if diff := cmp.Diff(tc.want, got); diff != "" {
+			t.Errorf("%s: tasks in replica mismatch; (-want,+got)\n%s", tc.desc, diff)
+		}
+		if n := replica.client.ZCard(base.ReplicaOrder).Val(); n != int64(len(tc.want)) {
+			t.Errorf("%s: ZCARD %q = %d, want %d", tc.desc, base.ReplicaOrder, n, len(tc.want))
+		}
+	}
+}
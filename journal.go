@@ -0,0 +1,97 @@
+// Copyright 2020 Kentaro Hibino. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package asynq
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+
+	"github.com/hibiken/asynq/internal/rdb"
+)
+
+// syncJournal persists sync requests in a local file, so that requests
+// which could not be synced are not lost if the process dies while redis
+// is unreachable.
+//
+// The file holds one JSON object per line for each request,
+// and is removed when there are no requests to persist.
+type syncJournal struct {
+	path string
+	rdb  *rdb.RDB
+}
+
+// journalEntry is the persisted form of a sync request.
+type journalEntry struct {
+	Op     *syncOp `json:"op"`
+	ErrMsg string  `json:"err_msg"`
+
+	// Encoded is the encoding the message of the operation was read with,
+	// to find the message in redis. See base.TaskMessage.Encoded.
+	Encoded string `json:"encoded,omitempty"`
+}
+
+func newSyncJournal(path string, r *rdb.RDB) *syncJournal {
+	return &syncJournal{path: path, rdb: r}
+}
+
+// load returns the requests persisted in the journal.
+func (j *syncJournal) load() ([]*syncRequest, error) {
+	data, err := ioutil.ReadFile(j.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var requests []*syncRequest
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	sc.Buffer(nil, len(data)+1)
+	for sc.Scan() {
+		if len(bytes.TrimSpace(sc.Bytes())) == 0 {
+			continue
+		}
+		var e journalEntry
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			return nil, err
+		}
+		if e.Op == nil || e.Op.Msg == nil {
+			continue
+		}
+		e.Op.Msg.Encoded = e.Encoded
+		requests = append(requests, newSyncRequest(j.rdb, e.Op, e.ErrMsg))
+	}
+	return requests, sc.Err()
+}
+
+// save replaces the contents of the journal with the given requests.
+// Requests without a sync operation are not persisted.
+func (j *syncJournal) save(requests []*syncRequest) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, req := range requests {
+		if req.op == nil {
+			continue
+		}
+		if err := enc.Encode(journalEntry{Op: req.op, ErrMsg: req.errMsg, Encoded: req.op.Msg.Encoded}); err != nil {
+			return err
+		}
+	}
+	if buf.Len() == 0 {
+		if err := os.Remove(j.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	// Write to a temporary file first so that the journal is not
+	// left half written if the process dies while writing.
+	tmp := j.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, buf.Bytes(), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, j.path)
+}
@@ -0,0 +1,21 @@
+// Copyright 2020 Kentaro Hibino. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package asynq
+
+import "context"
+
+// LabelsFromContext returns the labels of the task being processed,
+// given the context passed to the handler. See Labels.
+//
+// The returned map is a copy, and is nil if the task has no labels.
+// It returns false if ctx is not a context passed to the handler by the
+// background.
+func LabelsFromContext(ctx context.Context) (map[string]string, bool) {
+	p, ok := ctx.Value(parentKey).(*parentTask)
+	if !ok {
+		return nil, false
+	}
+	return copyLabels(p.msg.Labels), true
+}
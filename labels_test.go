@@ -0,0 +1,36 @@
+// Copyright 2020 Kentaro Hibino. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package asynq
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	h "github.com/hibiken/asynq/internal/asynqtest"
+)
+
+func TestLabelsFromContext(t *testing.T) {
+	msg := h.NewTaskMessage("send_email", nil)
+	msg.Labels = map[string]string{"tenant": "acme"}
+	ctx := withParentTask(context.Background(), nil, msg)
+
+	got, ok := LabelsFromContext(ctx)
+	if !ok {
+		t.Fatal("LabelsFromContext returned false with a task context")
+	}
+	if diff := cmp.Diff(msg.Labels, got); diff != "" {
+		t.Errorf("LabelsFromContext returned %v; (-want,+got)\n%s", got, diff)
+	}
+	// the returned labels are a copy.
+	got["tenant"] = "initech"
+	if msg.Labels["tenant"] != "acme" {
+		t.Errorf("modifying the labels returned by LabelsFromContext changed the task labels to %v", msg.Labels)
+	}
+
+	if _, ok := LabelsFromContext(context.Background()); ok {
+		t.Error("LabelsFromContext returned true with a context not passed to a handler")
+	}
+}
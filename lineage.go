@@ -0,0 +1,80 @@
+// Copyright 2020 Kentaro Hibino. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package asynq
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/hibiken/asynq/internal/base"
+)
+
+// parentKey is the context key for the parentTask of the task.
+const parentKey ctxKey = 1
+
+// parentTask holds the task being processed, to enqueue its child tasks.
+type parentTask struct {
+	client *Client
+	msg    *base.TaskMessage
+}
+
+// withParentTask returns a copy of ctx from which EnqueueFromHandler
+// enqueues the child tasks of the task with the client.
+func withParentTask(ctx context.Context, c *Client, msg *base.TaskMessage) context.Context {
+	return context.WithValue(ctx, parentKey, &parentTask{client: c, msg: msg})
+}
+
+// EnqueueFromHandler enqueues a child task of the task being processed,
+// given the context passed to the handler, to be processed immediately.
+//
+// The child task is enqueued to the queue of the parent task unless the
+// Queue option is given, and is linked to the parent task: it records the
+// ID of the parent task and the ID of the first task of the lineage, which
+// are reported by Inspector.GetTaskInfo and written to the logs of the task,
+// and it's listed among the children of the parent task by Inspector.GetTaskTree.
+//
+// Only the queue and the lineage IDs are carried over from the parent task;
+// neither its labels nor any trace context are propagated. To carry a trace
+// ID to the child task, pass it with the Labels option, e.g. from the labels
+// returned by LabelsFromContext.
+//
+// It returns an error if ctx is not a context passed to the handler by the
+// background, or if the task could not be enqueued.
+//
+// Example:
+//
+//	func (h *reportHandler) ProcessTask(ctx context.Context, t *asynq.Task) error {
+//	    for _, id := range userIDs {
+//	        task := asynq.NewTask("report:user", map[string]interface{}{"user_id": id})
+//	        if err := asynq.EnqueueFromHandler(ctx, task); err != nil {
+//	            return err
+//	        }
+//	    }
+//	    return nil
+//	}
+func EnqueueFromHandler(ctx context.Context, task *Task, opts ...Option) error {
+	p, ok := ctx.Value(parentKey).(*parentTask)
+	if !ok {
+		return errors.New("asynq: context is not passed to a task handler")
+	}
+	// the queue of the parent is overridden by the Queue option, if given.
+	opts = append([]Option{Queue(base.UnshardQueue(p.msg.Queue))}, opts...)
+	now := time.Now()
+	msg, err := p.client.newMessage(now, task, opts...)
+	if err != nil {
+		return err
+	}
+	msg.ParentID = p.msg.ID.String()
+	msg.RootID = p.msg.RootID
+	if msg.RootID == "" {
+		msg.RootID = msg.ParentID
+	}
+	// link the child first, so that every enqueued child is listed.
+	if err := p.client.rdb.AddChild(msg.ParentID, msg); err != nil {
+		return err
+	}
+	return p.client.enqueue(msg, now)
+}
@@ -0,0 +1,123 @@
+// Copyright 2020 Kentaro Hibino. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package asynq
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	h "github.com/hibiken/asynq/internal/asynqtest"
+	"github.com/hibiken/asynq/internal/base"
+	"github.com/hibiken/asynq/internal/rdb"
+)
+
+func TestEnqueueFromHandler(t *testing.T) {
+	r := setup(t)
+	rdbClient := rdb.NewRDB(r)
+	inspector := NewInspector(RedisClientOpt{
+		Addr: redisAddr,
+		DB:   redisDB,
+	})
+	defer inspector.Close()
+
+	m1 := h.NewTaskMessageWithQueue("report", nil, base.ShardQueue("reports", 1))
+	h.SeedEnqueuedQueue(t, r, []*base.TaskMessage{m1}, m1.Queue)
+
+	spawned := make(chan error, 3)
+	finish := make(chan struct{})
+	handler := func(ctx context.Context, task *Task) error {
+		switch task.Type {
+		case "report":
+			spawned <- EnqueueFromHandler(ctx, NewTask("report:user", nil))
+			spawned <- EnqueueFromHandler(ctx, NewTask("cleanup", nil), Queue("low"))
+		case "report:user":
+			spawned <- EnqueueFromHandler(ctx, NewTask("notify", nil), Queue("low"))
+			<-finish
+		}
+		return nil
+	}
+	ps := base.NewProcessState("localhost", 1234, 10, map[string]int{"reports": 1, base.ShardQueue("reports", 1): 1}, false)
+	p := newProcessor(processorParams{
+		logger:         testLogger,
+		rdb:            rdbClient,
+		ps:             ps,
+		retryDelayFunc: defaultDelayFunc,
+		baseCtxFn:      context.Background,
+		cancelations:   base.NewCancelations(),
+	})
+	p.handler = HandlerFunc(handler)
+
+	var wg sync.WaitGroup
+	p.start(&wg)
+	defer p.terminate()
+	defer close(finish)
+
+	for i := 0; i < 3; i++ {
+		select {
+		case err := <-spawned:
+			if err != nil {
+				t.Fatalf("EnqueueFromHandler returned error: %v", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("tasks were not spawned")
+		}
+	}
+
+	tree, err := inspector.GetTaskTree(m1.Queue, m1.ID.String())
+	if err != nil {
+		t.Fatalf("GetTaskTree returned error: %v", err)
+	}
+	if tree.Task.ID != m1.ID.String() || len(tree.Children) != 2 {
+		t.Fatalf("GetTaskTree returned task %+v with %d children, want task %v with 2 children",
+			tree.Task, len(tree.Children), m1.ID)
+	}
+	user, cleanup := tree.Children[0], tree.Children[1]
+	tests := []struct {
+		task      *TaskInfo
+		wantType  string
+		wantQueue string
+	}{
+		// the child is enqueued to the parent queue, without the shard.
+		{user.Task, "report:user", "reports"},
+		{cleanup.Task, "cleanup", "low"},
+	}
+	for _, tc := range tests {
+		if tc.task.Type != tc.wantType || tc.task.Queue != tc.wantQueue {
+			t.Errorf("child task is %q in queue %q, want %q in queue %q", tc.task.Type, tc.task.Queue, tc.wantType, tc.wantQueue)
+		}
+		if tc.task.ParentID != m1.ID.String() || tc.task.RootID != m1.ID.String() {
+			t.Errorf("child task %q has ParentID %q and RootID %q, want both %q",
+				tc.task.Type, tc.task.ParentID, tc.task.RootID, m1.ID)
+		}
+	}
+	if len(user.Children) != 1 {
+		t.Fatalf("task %q has %d children, want 1", user.Task.Type, len(user.Children))
+	}
+	notify := user.Children[0].Task
+	if notify.Type != "notify" || notify.ParentID != user.Task.ID || notify.RootID != m1.ID.String() {
+		t.Errorf("grandchild task = %+v, want notify task with ParentID %q and RootID %q", notify, user.Task.ID, m1.ID)
+	}
+}
+
+func TestEnqueueFromHandlerWithoutTaskContext(t *testing.T) {
+	if err := EnqueueFromHandler(context.Background(), NewTask("notify", nil)); err == nil {
+		t.Error("EnqueueFromHandler with a context not passed to a handler returned nil error")
+	}
+}
+
+func TestGetTaskTreeNotFound(t *testing.T) {
+	setup(t)
+	inspector := NewInspector(RedisClientOpt{
+		Addr: redisAddr,
+		DB:   redisDB,
+	})
+	defer inspector.Close()
+
+	if _, err := inspector.GetTaskTree("default", h.NewTaskMessage("report", nil).ID.String()); err != ErrTaskNotFound {
+		t.Errorf("GetTaskTree for unknown task returned error %v, want %v", err, ErrTaskNotFound)
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright 2020 Kentaro Hibino. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package asynq
+
+// weightedPriority orders queues for each dequeue using the smooth weighted
+// round-robin algorithm (the one used by nginx for upstream selection).
+//
+// Given queues with priorities p1, p2, ..., pn, every window of p1+p2+...+pn
+// consecutive calls to next selects each queue i as the first queue to query
+// exactly pi times, and the selections are spread as evenly as possible.
+// This guarantees that a low priority queue is queried first at least once
+// in every window and is never starved by higher priority queues.
+//
+// weightedPriority is not safe for concurrent use.
+type weightedPriority struct {
+	weights []int
+	current []int
+	total   int
+
+	// orders[i] is the list of queue names to query when the i-th queue
+	// is selected: the selected queue first, after the strict queues if any,
+	// followed by the rest of the queues sorted by priority. Lists are computed once so that next
+	// does not allocate.
+	orders [][]string
+}
+
+// newWeightedPriority returns a weightedPriority for the given queue config.
+// Queues with zero or negative priority should be removed before calling.
+//
+// If strict queues are given, every list starts with them in the given order,
+// so that they are always queried before the weighted queues.
+// The strict queues should not be in the queue config.
+func newWeightedPriority(qcfg map[string]int, strict ...string) *weightedPriority {
+	names := sortByPriority(qcfg)
+	wp := &weightedPriority{
+		weights: make([]int, len(names)),
+		current: make([]int, len(names)),
+		orders:  make([][]string, len(names)),
+	}
+	for i, qname := range names {
+		wp.weights[i] = qcfg[qname]
+		wp.total += qcfg[qname]
+		order := make([]string, 0, len(strict)+len(names))
+		order = append(order, strict...)
+		order = append(order, qname)
+		for _, other := range names {
+			if other != qname {
+				order = append(order, other)
+			}
+		}
+		wp.orders[i] = order
+	}
+	return wp
+}
+
+// next returns the list of queue names to query in order for the next dequeue.
+//
+// The returned slice is shared between calls and must not be modified.
+func (wp *weightedPriority) next() []string {
+	best := 0
+	for i := range wp.weights {
+		wp.current[i] += wp.weights[i]
+		if wp.current[i] > wp.current[best] {
+			best = i
+		}
+	}
+	wp.current[best] -= wp.total
+	return wp.orders[best]
+}
@@ -0,0 +1,103 @@
+// Copyright 2020 Kentaro Hibino. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package asynq
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	h "github.com/hibiken/asynq/internal/asynqtest"
+)
+
+func TestWeightedPriority(t *testing.T) {
+	tests := []struct {
+		queueCfg map[string]int
+	}{
+		{map[string]int{"high": 6, "default": 3, "low": 1}},
+		{map[string]int{"critical": 5, "default": 1}},
+		{map[string]int{"a": 1, "b": 1, "c": 1, "d": 1}},
+		{map[string]int{"high": 10, "low": 1}},
+	}
+
+	for _, tc := range tests {
+		wp := newWeightedPriority(tc.queueCfg)
+		total := 0
+		for _, p := range tc.queueCfg {
+			total += p
+		}
+
+		// Check a few windows to make sure the distribution holds over time.
+		for window := 0; window < 3; window++ {
+			counts := make(map[string]int)
+			for i := 0; i < total; i++ {
+				qnames := wp.next()
+				if len(qnames) != len(tc.queueCfg) {
+					t.Fatalf("with queue config %v, next() returned %v; want all %d queues",
+						tc.queueCfg, qnames, len(tc.queueCfg))
+				}
+				counts[qnames[0]]++
+			}
+			if diff := cmp.Diff(tc.queueCfg, counts); diff != "" {
+				t.Errorf("with queue config %v, queues selected first in window %d = %v, want %v; (-want,+got)\n%s",
+					tc.queueCfg, window, counts, tc.queueCfg, diff)
+			}
+		}
+	}
+}
+
+func TestWeightedPriorityOrder(t *testing.T) {
+	queueCfg := map[string]int{"high": 6, "default": 3, "low": 1}
+	wp := newWeightedPriority(queueCfg)
+
+	for i := 0; i < 10; i++ {
+		qnames := wp.next()
+		want := []string{"high", "default", "low"}
+		if diff := cmp.Diff(want, qnames, h.SortStringSliceOpt); diff != "" {
+			t.Errorf("next() = %v, want permutation of %v", qnames, want)
+			continue
+		}
+		// Queues after the first one should be sorted by priority.
+		rest := qnames[1:]
+		for j := 0; j+1 < len(rest); j++ {
+			if queueCfg[rest[j]] < queueCfg[rest[j+1]] {
+				t.Errorf("next() = %v, want queues after the first one sorted by priority", qnames)
+			}
+		}
+	}
+}
+
+func TestWeightedPriorityWithStrictQueues(t *testing.T) {
+	queueCfg := map[string]int{"default": 2, "low": 1}
+	wp := newWeightedPriority(queueCfg, "critical", "high")
+
+	counts := make(map[string]int)
+	for i := 0; i < 3; i++ {
+		qnames := wp.next()
+		if len(qnames) != 4 || qnames[0] != "critical" || qnames[1] != "high" {
+			t.Fatalf("next() = %v, want strict queues [critical high] first, followed by the others", qnames)
+		}
+		counts[qnames[2]]++
+	}
+	if diff := cmp.Diff(queueCfg, counts); diff != "" {
+		t.Errorf("queues selected first after the strict queues = %v, want %v; (-want,+got)\n%s", counts, queueCfg, diff)
+	}
+}
+
+func TestSortByPriority(t *testing.T) {
+	tests := []struct {
+		queueCfg map[string]int
+		want     []string
+	}{
+		{map[string]int{"high": 6, "default": 3, "low": 1}, []string{"high", "default", "low"}},
+		{map[string]int{"b": 1, "a": 1, "c": 2}, []string{"c", "a", "b"}},
+	}
+
+	for _, tc := range tests {
+		got := sortByPriority(tc.queueCfg)
+		if diff := cmp.Diff(tc.want, got); diff != "" {
+			t.Errorf("sortByPriority(%v) = %v, want %v; (-want,+got)\n%s", tc.queueCfg, got, tc.want, diff)
+		}
+	}
+}
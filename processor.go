@@ -6,8 +6,9 @@ package asynq
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"math/rand"
+	"runtime/debug"
 	"sort"
 	"sync"
 	"time"
@@ -15,7 +16,6 @@ import (
 	"github.com/hibiken/asynq/internal/base"
 	"github.com/hibiken/asynq/internal/log"
 	"github.com/hibiken/asynq/internal/rdb"
-	"golang.org/x/time/rate"
 )
 
 type processor struct {
@@ -24,6 +24,9 @@ type processor struct {
 
 	ps *base.ProcessState
 
+	// client to enqueue the child tasks spawned by handlers.
+	client *Client
+
 	handler Handler
 
 	queueConfig map[string]int
@@ -31,20 +34,109 @@ type processor struct {
 	// orderedQueues is set only in strict-priority mode.
 	orderedQueues []string
 
+	// priorityAging is the latency after which a queue is boosted in
+	// strict-priority mode. Zero means no aging.
+	priorityAging time.Duration
+
+	// latencies records the latency of each queue, i.e. how long its oldest
+	// task has been waiting, as of latenciesUpdated.
+	// Used only in strict-priority mode with aging enabled.
+	// Accessed only by the "processor" goroutine.
+	latencies        map[string]time.Duration
+	latenciesUpdated time.Time
+
+	// weighted is used to order queues if strict-priority is false,
+	// unless all queues are listed in the strict queues.
+	weighted *weightedPriority
+
 	retryDelayFunc retryDelayFunc
 
+	// baseCtxFn returns the context from which the context passed to
+	// the handler is derived.
+	baseCtxFn func() context.Context
+
 	errHandler ErrorHandler
 
+	// deadHandler is called with tasks moved to the dead queue, if set.
+	deadHandler DeadTaskHandler
+
+	// unhandled specifies what to do with tasks for which no handler is found,
+	// and unhandledQueue is the queue to move them to with UnhandledTaskRequeue.
+	unhandled      UnhandledTaskPolicy
+	unhandledQueue string
+
+	// slowThreshold is the processing time above which a task is
+	// reported as slow. Zero means slow tasks are not reported.
+	slowThreshold time.Duration
+
+	// onSlowTask is called when a task is reported as slow, if set.
+	onSlowTask func(task *Task, d time.Duration)
+
+	// historySize is the max number of attempts to keep in the
+	// execution history of each task. Zero means no history is recorded.
+	historySize int
+
+	// idempotencyWindow is how long to remember the completion of tasks
+	// with an idempotency key. Zero means tasks are not deduplicated.
+	idempotencyWindow time.Duration
+
+	// explicitAck requires handlers to acknowledge tasks with an Acker
+	// for the tasks to be marked as done.
+	explicitAck bool
+
+	// breaker pauses processing of task types which keep failing.
+	// Set only if the circuit breaker is enabled.
+	breaker *circuitBreaker
+
+	// panics counts the panics of the handler by task type, and
+	// quarantines the task types which keep panicking if enabled.
+	panics *panicMonitor
+
+	// host and pid of the process, recorded in the execution history.
+	host string
+	pid  int
+
 	// channel via which to send sync requests to syncer.
 	syncRequestCh chan<- *syncRequest
 
-	// rate limiter to prevent spamming logs with a bunch of errors.
-	errLogLimiter *rate.Limiter
+	// returns the IDs of the tasks with sync requests not synced yet.
+	pendingSyncs func() map[string]bool
+
+	// rate limiters to prevent spamming logs with a bunch of errors or warnings.
+	errLogLimiter  *log.Limiter
+	warnLogLimiter *log.Limiter
+
+	// loggers for messages about dequeuing tasks and processing tasks.
+	dequeueLog *log.Logger
+	taskLog    *log.Logger
 
 	// sema is a counting semaphore to ensure the number of active workers
 	// does not exceed the limit.
 	sema chan struct{}
 
+	// slots keeps track of workers reserved for queues.
+	// Set only if queue reservations are configured.
+	slots *workerSlots
+
+	// groups keeps track of the queues discovered for queue prefixes.
+	// Set only if queue prefixes are configured.
+	groups *queueGroups
+
+	// activeHours restricts processing of queues to certain hours of the day.
+	// Queues without active hours are processed all day.
+	activeHours map[string]ActiveHours
+
+	// parkMu guards parked.
+	parkMu sync.Mutex
+	// parked is the number of tokens held in sema to keep the number of
+	// active workers below the capacity of sema.
+	parked int
+
+	// durMu guards avgDur.
+	durMu sync.Mutex
+	// avgDur is an exponentially weighted moving average of task processing times.
+	avgDur time.Duration
+
 	// channel to communicate back to the long running "processor" goroutine.
 	// once is used to send value to the channel only once.
 	done chan struct{}
@@ -62,31 +154,130 @@ type processor struct {
 
 type retryDelayFunc func(n int, err error, task *Task) time.Duration
 
+type processorParams struct {
+	logger         *log.Logger
+	rdb            *rdb.RDB
+	ps             *base.ProcessState
+	retryDelayFunc retryDelayFunc
+	baseCtxFn      func() context.Context
+	priorityAging  time.Duration
+	strictQueues   []string
+	reservations   map[string]int
+	activeHours    map[string]ActiveHours
+	syncCh         chan<- *syncRequest
+	pendingSyncs   func() map[string]bool // if nil, no sync request is pending.
+	cancelations   *base.Cancelations
+	errHandler     ErrorHandler
+	slowThreshold  time.Duration
+	onSlowTask     func(task *Task, d time.Duration)
+	historySize    int
+	breaker        *circuitBreaker
+	panics         *panicMonitor // if nil, panics are counted without quarantine.
+	deadHandler    DeadTaskHandler
+	groups         *queueGroups
+	unhandled      UnhandledTaskPolicy
+	unhandledQueue string
+
+	idempotencyWindow time.Duration
+	explicitAck       bool
+
+	// loggers by category of messages. If nil, logger is used.
+	dequeueLog *log.Logger
+	taskLog    *log.Logger
+
+	// minimum interval between error logs. If zero, errors are not rate-limited.
+	errLogInterval time.Duration
+}
+
 // newProcessor constructs a new processor.
-func newProcessor(l *log.Logger, r *rdb.RDB, ps *base.ProcessState, fn retryDelayFunc,
-	syncCh chan<- *syncRequest, c *base.Cancelations, errHandler ErrorHandler) *processor {
-	info := ps.Get()
+func newProcessor(params processorParams) *processor {
+	info := params.ps.Get()
 	qcfg := normalizeQueueCfg(info.Queues)
 	orderedQueues := []string(nil)
-	if info.StrictPriority {
+	var latencies map[string]time.Duration
+	var weighted *weightedPriority
+	switch {
+	case info.StrictPriority:
 		orderedQueues = sortByPriority(qcfg)
+		if params.priorityAging > 0 {
+			latencies = make(map[string]time.Duration)
+		}
+	case len(params.strictQueues) > 0:
+		// the strict queues are queried first, followed by the others by weight.
+		strict, rest := make(map[string]int), make(map[string]int)
+		for qname, n := range qcfg {
+			rest[qname] = n
+		}
+		for _, qname := range params.strictQueues {
+			if n, ok := rest[qname]; ok {
+				strict[qname] = n
+				delete(rest, qname)
+			}
+		}
+		if len(rest) == 0 {
+			orderedQueues = sortByPriority(qcfg)
+		} else {
+			weighted = newWeightedPriority(rest, sortByPriority(strict)...)
+		}
+	default:
+		weighted = newWeightedPriority(qcfg)
+	}
+	var slots *workerSlots
+	if len(params.reservations) > 0 {
+		slots = newWorkerSlots(info.Concurrency, params.reservations)
+	}
+	dequeueLog, taskLog := params.dequeueLog, params.taskLog
+	if dequeueLog == nil {
+		dequeueLog = params.logger
+	}
+	if taskLog == nil {
+		taskLog = params.logger
+	}
+	panics := params.panics
+	if panics == nil {
+		panics = newPanicMonitor(0, 0, 0)
 	}
 	return &processor{
-		logger:         l,
-		rdb:            r,
-		ps:             ps,
+		logger:         params.logger,
+		rdb:            params.rdb,
+		client:         &Client{rdb: params.rdb},
+		ps:             params.ps,
 		queueConfig:    qcfg,
 		orderedQueues:  orderedQueues,
-		retryDelayFunc: fn,
-		syncRequestCh:  syncCh,
-		cancelations:   c,
-		errLogLimiter:  rate.NewLimiter(rate.Every(3*time.Second), 1),
+		priorityAging:  params.priorityAging,
+		latencies:      latencies,
+		weighted:       weighted,
+		retryDelayFunc: params.retryDelayFunc,
+		baseCtxFn:      params.baseCtxFn,
+		syncRequestCh:  params.syncCh,
+		pendingSyncs:   params.pendingSyncs,
+		cancelations:   params.cancelations,
+		errLogLimiter:  log.NewLimiter(params.errLogInterval),
+		warnLogLimiter: log.NewLimiter(params.errLogInterval),
 		sema:           make(chan struct{}, info.Concurrency),
+		slots:          slots,
+		activeHours:    params.activeHours,
 		done:           make(chan struct{}),
 		abort:          make(chan struct{}),
 		quit:           make(chan struct{}),
-		errHandler:     errHandler,
+		errHandler:     params.errHandler,
+		slowThreshold:  params.slowThreshold,
+		onSlowTask:     params.onSlowTask,
+		historySize:    params.historySize,
+		breaker:        params.breaker,
+		panics:         panics,
+		deadHandler:    params.deadHandler,
+		unhandled:      params.unhandled,
+		unhandledQueue: params.unhandledQueue,
+		groups:         params.groups,
+		host:           info.Host,
+		pid:            info.PID,
 		handler:        HandlerFunc(func(ctx context.Context, t *Task) error { return fmt.Errorf("handler not set") }),
+
+		idempotencyWindow: params.idempotencyWindow,
+		explicitAck:       params.explicitAck,
+		dequeueLog:        dequeueLog,
+		taskLog:           taskLog,
 	}
 }
 
@@ -117,18 +308,26 @@ func (p *processor) terminate() {
 		cancel()
 	}
 
+	// release tokens held to limit the number of workers, otherwise
+	// we would block forever waiting for them.
+	p.setConcurrency(cap(p.sema))
+
 	// block until all workers have released the token
 	for i := 0; i < cap(p.sema); i++ {
 		p.sema <- struct{}{}
 	}
 	p.logger.Info("All workers have finished")
-	p.restore() // move any unfinished tasks back to the queue.
+	p.restore(false) // move any unfinished tasks back to the queue.
+
+	// report the errors and warnings suppressed since the last ones logged.
+	p.errLogLimiter.Flush()
+	p.warnLogLimiter.Flush()
 }
 
 func (p *processor) start(wg *sync.WaitGroup) {
 	// NOTE: The call to "restore" needs to complete before starting
 	// the processor goroutine.
-	p.restore()
+	p.restore(true)
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
@@ -147,11 +346,46 @@ func (p *processor) start(wg *sync.WaitGroup) {
 // exec pulls a task out of the queue and starts a worker goroutine to
 // process the task.
 func (p *processor) exec() {
+	if p.ps.Status() == base.StatusPaused {
+		// sleep to avoid busy looping while processing is paused.
+		time.Sleep(time.Second)
+		return
+	}
 	qnames := p.queues()
-	msg, err := p.rdb.Dequeue(qnames...)
+	if len(p.activeHours) > 0 {
+		qnames = p.activeQueues(qnames, time.Now())
+		if len(qnames) == 0 {
+			// sleep to avoid busy looping while all queues are inactive.
+			time.Sleep(time.Second)
+			return
+		}
+	}
+	if p.slots != nil {
+		qnames = p.slots.available(qnames)
+		if len(qnames) == 0 {
+			// all workers that can process tasks from the queues are busy,
+			// wait for one of them to finish.
+			select {
+			case <-p.abort:
+			case <-p.slots.released():
+			}
+			return
+		}
+	}
+	dqnames := qnames
+	if p.groups != nil {
+		dqnames = p.groups.next(qnames)
+		if len(dqnames) == 0 {
+			// sleep to avoid busy looping until queues are discovered.
+			time.Sleep(time.Second)
+			return
+		}
+	}
+	msg, err := p.rdb.Dequeue(dqnames...)
+	p.recordQueried(dqnames, msg, err)
 	if err == rdb.ErrNoProcessableTask {
 		// queues are empty, this is a normal behavior.
-		if len(p.queueConfig) > 1 {
+		if len(dqnames) > 1 {
 			// sleep to avoid slamming redis and let scheduler move tasks into queues.
 			// Note: With multiple queues, we are not using blocking pop operation and
 			// polling queues instead. This adds significant load to redis.
@@ -160,9 +394,33 @@ func (p *processor) exec() {
 		return
 	}
 	if err != nil {
-		if p.errLogLimiter.Allow() {
-			p.logger.Error("Dequeue error: %v", err)
-		}
+		p.errLogLimiter.Error(p.dequeueLog, "Dequeue error: %v", err)
+		return
+	}
+	if msg.Version > base.MessageVersion {
+		p.postponeUnsupported(msg)
+		return
+	}
+	if len(msg.Unknown) > 0 {
+		p.warnLogLimiter.Warn(p.taskLogger(msg), "Task id=%s has fields unknown to this version: %s; Processing it without them",
+			msg.ID, msg.Unknown)
+	}
+	// StartBy limits only the first attempt; retried or restored tasks
+	// have already started processing.
+	if msg.StartBy > 0 && msg.Retried == 0 && msg.Restored == 0 && time.Now().Unix() > msg.StartBy {
+		p.taskLogger(msg).Warn("Task id=%s was not started by %v; Moving it to dead queue", msg.ID, time.Unix(msg.StartBy, 0))
+		p.killOnWorker(msg, errTaskExpired)
+		return
+	}
+	if msg.MaxAttempts > 0 && msg.Retried+msg.Restored >= msg.MaxAttempts {
+		p.taskLogger(msg).Warn("Task id=%s was attempted %d times without success (restored %d times); Moving it to dead queue",
+			msg.ID, msg.Retried+msg.Restored, msg.Restored)
+		p.killOnWorker(msg, errAttemptsExhausted)
+		return
+	}
+	if p.idempotencyWindow > 0 && msg.IdempotencyKey != "" && p.isCompleted(msg) {
+		p.taskLogger(msg).Info("Task id=%s with idempotency key %q is already completed; Skipping", msg.ID, msg.IdempotencyKey)
+		p.skip(msg)
 		return
 	}
 
@@ -172,28 +430,102 @@ func (p *processor) exec() {
 		p.requeue(msg)
 		return
 	case p.sema <- struct{}{}: // acquire token
+		// check the circuit after acquiring the token, so that the results
+		// of the tasks processed so far are taken into account.
+		if p.breaker != nil {
+			if until, open := p.breaker.open(msg.Type, time.Now()); open {
+				<-p.sema // release token
+				p.postpone(msg, until)
+				return
+			}
+		}
+		if until, ok := p.panics.quarantinedUntil(msg.Type, time.Now()); ok {
+			<-p.sema // release token
+			p.postpone(msg, until)
+			return
+		}
 		p.ps.AddWorkerStats(msg, time.Now())
+		releaseSlot := func() {}
+		if p.slots != nil {
+			releaseSlot = p.slots.acquire(p.configuredQueue(msg.Queue))
+		}
 		go func() {
 			defer func() {
 				p.ps.DeleteWorkerStats(msg)
+				releaseSlot()
 				<-p.sema /* release token */
 			}()
 
 			resCh := make(chan error, 1)
 			task := NewTask(msg.Type, msg.Payload)
-			ctx, cancel := createContext(msg)
+			ctx := withParentTask(withProgressReporter(p.baseCtxFn(), p.rdb, msg), p.client, msg)
+			var ack *Acker
+			if p.explicitAck {
+				ack = &Acker{rdb: p.rdb, msg: msg}
+				if p.idempotencyWindow > 0 && msg.IdempotencyKey != "" {
+					ack.beforeAck = func() { p.recordCompletion(msg) }
+				}
+				ctx = withAcker(ctx, ack)
+			}
+			ctx, cancel := createContext(ctx, msg)
 			p.cancelations.Add(msg.ID.String(), cancel)
+			start := time.Now()
+			var slowTimer *time.Timer
+			if p.slowThreshold > 0 {
+				// report the task while it's still running, so that a task
+				// which never returns is reported too.
+				slowTimer = time.AfterFunc(p.slowThreshold, func() {
+					p.reportSlowTask(task, msg, time.Since(start))
+				})
+			}
 			go func() {
 				resCh <- perform(ctx, task, p.handler)
+				if slowTimer != nil {
+					slowTimer.Stop()
+				}
+				p.recordDuration(time.Since(start))
 				p.cancelations.Delete(msg.ID.String())
 			}()
 
 			select {
 			case <-p.quit:
 				// time is up, quit this worker goroutine.
-				p.logger.Warn("Quitting worker. task id=%s", msg.ID)
+				p.taskLogger(msg).Warn("Quitting worker. task id=%s", msg.ID)
 				return
 			case resErr := <-resCh:
+				if pe, ok := resErr.(*panicError); ok {
+					if p.panics.logStack(msg.Type, time.Now()) {
+						p.taskLogger(msg).With("error", resErr).Error("Handler panicked while processing task id=%s: %v\n%s", msg.ID, pe.value, pe.stack)
+					} else {
+						p.taskLogger(msg).With("error", resErr).Error("Handler panicked while processing task id=%s: %v", msg.ID, pe.value)
+					}
+					if p.panics.record(msg.Type, time.Now()) {
+						p.taskLog.With("task_type", msg.Type).Warn("Handler of task type=%s panicked %d times within %v; Quarantining the type for %v",
+							msg.Type, p.panics.threshold, p.panics.window, p.panics.duration)
+					}
+				}
+				// with explicit ack, the task is done only if acknowledged,
+				// otherwise it's processed as failed.
+				acked := false
+				if ack != nil {
+					acked, resErr = ack.finish(resErr)
+				}
+				// a reprocess directive is not a failure of the task.
+				rp, reprocess := reprocessOf(resErr)
+				if reprocess {
+					resErr = nil
+				}
+				if p.historySize > 0 {
+					p.recordAttempt(msg, start, resErr)
+				}
+				if p.breaker != nil && p.breaker.record(msg.Type, resErr != nil, time.Now()) {
+					p.taskLog.With("task_type", msg.Type).Warn("Task type=%s failed %d times in a row; Pausing processing of the type for %v",
+						msg.Type, p.breaker.threshold, p.breaker.cooldown)
+				}
+				if reprocess {
+					p.reprocess(msg, rp.payload, time.Now().Add(rp.delay))
+					return
+				}
 				// Note: One of three things should happen.
 				// 1) Done  -> Removes the message from InProgress
 				// 2) Retry -> Removes the message from InProgress & Adds the message to Retry
@@ -202,23 +534,156 @@ func (p *processor) exec() {
 					if p.errHandler != nil {
 						p.errHandler.HandleError(task, resErr, msg.Retried, msg.Retry)
 					}
-					if msg.Retried >= msg.Retry {
+					if isInvalidTask(resErr) {
+						p.taskLogger(msg).Warn("Task id=%s failed validation; Moving it to dead queue", msg.ID)
+						p.kill(msg, resErr)
+					} else if isNotFound(resErr) && p.unhandled == UnhandledTaskKill {
+						p.taskLogger(msg).Warn("No handler found for task id=%s; Moving it to dead queue", msg.ID)
+						p.kill(msg, resErr)
+					} else if isNotFound(resErr) && p.unhandled == UnhandledTaskRequeue {
+						p.taskLogger(msg).Warn("No handler found for task id=%s; Moving it to queue %q", msg.ID, p.unhandledQueue)
+						p.requeueTo(msg, p.unhandledQueue)
+					} else if msg.Retried >= msg.Retry {
+						p.taskLogger(msg).With("error", resErr).Warn("Retry exhausted for task id=%s", msg.ID)
+						p.kill(msg, resErr)
+					} else if msg.MaxAttempts > 0 && msg.Retried+msg.Restored+1 >= msg.MaxAttempts {
+						p.taskLogger(msg).With("error", resErr).Warn("Max attempts exhausted for task id=%s", msg.ID)
 						p.kill(msg, resErr)
 					} else {
 						p.retry(msg, resErr)
 					}
 					return
 				}
-				p.markAsDone(msg)
+				if !acked {
+					if p.idempotencyWindow > 0 && msg.IdempotencyKey != "" {
+						p.recordCompletion(msg)
+					}
+					p.markAsDone(msg)
+				}
 			}
 		}()
 	}
 }
 
+// recordAttempt adds the attempt started at the given time to process
+// the task to its execution history.
+func (p *processor) recordAttempt(msg *base.TaskMessage, started time.Time, resErr error) {
+	attempt := &base.TaskAttempt{
+		Host:     p.host,
+		PID:      p.pid,
+		Started:  started,
+		Duration: time.Since(started),
+	}
+	if resErr != nil {
+		attempt.ErrorMsg = resErr.Error()
+	}
+	if err := p.rdb.RecordAttempt(msg.ID.String(), attempt, p.historySize); err != nil {
+		p.errLogLimiter.Error(p.taskLogger(msg).With("error", err), "Could not record attempt for task id=%s: %v", msg.ID, err)
+	}
+}
+
+// taskLogger returns a logger which adds the fields describing the task
+// to JSON messages.
+func (p *processor) taskLogger(msg *base.TaskMessage) *log.Logger {
+	l := p.taskLog.With("task_id", msg.ID.String(), "task_type", msg.Type, "queue", msg.Queue)
+	if msg.ParentID != "" {
+		l = l.With("parent_id", msg.ParentID, "root_id", msg.RootID)
+	}
+	return l
+}
+
+// reportSlowTask logs the task which has been processed for d and
+// calls onSlowTask if set.
+func (p *processor) reportSlowTask(task *Task, msg *base.TaskMessage, d time.Duration) {
+	p.taskLogger(msg).With("duration", d).Warn("Slow task: type=%s id=%s running for %v", msg.Type, msg.ID, d)
+	if p.onSlowTask != nil {
+		p.onSlowTask(task, d)
+	}
+}
+
+// setConcurrency limits the number of active workers to n, and
+// returns the resulting limit.
+//
+// The limit cannot be lowered below the number of currently active workers,
+// in which case the limit is lowered as much as possible.
+// n is capped to the configured concurrency.
+//
+// If workers are reserved for queues, only the workers which are not reserved
+// are parked, so that tasks from the queues can always use their reserved workers.
+func (p *processor) setConcurrency(n int) int {
+	p.parkMu.Lock()
+	defer p.parkMu.Unlock()
+	if n > cap(p.sema) {
+		n = cap(p.sema)
+	}
+	want := cap(p.sema) - n
+	for p.parked > want {
+		<-p.sema // parked tokens are always in sema, this never blocks.
+		p.parked--
+	}
+park:
+	for p.parked < want {
+		select {
+		case p.sema <- struct{}{}:
+			p.parked++
+		default:
+			// all remaining tokens are held by active workers.
+			break park
+		}
+	}
+	res := cap(p.sema) - p.parked
+	if p.slots != nil {
+		p.slots.limit(res)
+	}
+	return res
+}
+
+// concurrency returns the current limit of active workers.
+func (p *processor) concurrency() int {
+	p.parkMu.Lock()
+	defer p.parkMu.Unlock()
+	return cap(p.sema) - p.parked
+}
+
+// recordDuration records the time it took to process a task.
+func (p *processor) recordDuration(d time.Duration) {
+	p.durMu.Lock()
+	defer p.durMu.Unlock()
+	if p.avgDur == 0 {
+		p.avgDur = d
+		return
+	}
+	// weight recent tasks the most.
+	const alpha = 0.2
+	p.avgDur = time.Duration(alpha*float64(d) + (1-alpha)*float64(p.avgDur))
+}
+
+// averageDuration returns the moving average of task processing times.
+// It returns zero if no task has been processed yet.
+func (p *processor) averageDuration() time.Duration {
+	p.durMu.Lock()
+	defer p.durMu.Unlock()
+	return p.avgDur
+}
+
 // restore moves all tasks from "in-progress" back to queue
-// to restore all unfinished tasks.
-func (p *processor) restore() {
-	n, err := p.rdb.RequeueAll()
+// to restore all unfinished tasks. If crashed is true, the tasks were
+// left unfinished by a crash and their restore count is incremented.
+//
+// Tasks with sync requests to be synced are left in progress, since the
+// requests remove them from "in-progress" once synced.
+func (p *processor) restore(crashed bool) {
+	var pending []string
+	if p.pendingSyncs != nil {
+		for id := range p.pendingSyncs() {
+			pending = append(pending, id)
+		}
+	}
+	restore := p.rdb.RequeueAll
+	if crashed {
+		restore = p.rdb.RestoreAll
+	}
+	n, err := restore(pending...)
 	if err != nil {
 		p.logger.Error("Could not restore unfinished tasks: %v", err)
 	}
@@ -230,7 +695,89 @@ func (p *processor) restore() {
 func (p *processor) requeue(msg *base.TaskMessage) {
 	err := p.rdb.Requeue(msg)
 	if err != nil {
-		p.logger.Error("Could not push task id=%s back to queue: %v", msg.ID, err)
+		p.taskLogger(msg).With("error", err).Error("Could not push task id=%s back to queue: %v", msg.ID, err)
+	}
+}
+
+// requeueTo moves the task to the given queue to be processed from there.
+func (p *processor) requeueTo(msg *base.TaskMessage, qname string) {
+	err := p.rdb.RequeueTo(msg, qname)
+	if err != nil {
+		errMsg := fmt.Sprintf("Could not move task id=%s from %q to %q", msg.ID, base.InProgressQueue, base.QueueKey(qname))
+		p.taskLogger(msg).With("error", err).Warn("%s; Will retry syncing", errMsg)
+		p.syncRequestCh <- newSyncRequest(p.rdb, &syncOp{Kind: syncRequeueTo, Msg: msg, Queue: qname}, errMsg)
+	}
+}
+
+// postpone moves the task to the scheduled queue to be processed at the given time.
+func (p *processor) postpone(msg *base.TaskMessage, processAt time.Time) {
+	err := p.rdb.Defer(msg, processAt)
+	if err != nil {
+		errMsg := fmt.Sprintf("Could not move task id=%s from %q to %q", msg.ID, base.InProgressQueue, base.ScheduledQueue)
+		p.taskLogger(msg).With("error", err).Warn("%s; Will retry syncing", errMsg)
+		p.syncRequestCh <- newSyncRequest(p.rdb, &syncOp{Kind: syncDefer, Msg: msg, ProcessAt: processAt}, errMsg)
+	}
+}
+
+// postponeUnsupported moves the task with an unsupported message version
+// to the scheduled queue, recording the time it was first postponed, or to
+// the dead queue once it has been postponed for unsupportedVersionTTL.
+func (p *processor) postponeUnsupported(msg *base.TaskMessage) {
+	now := time.Now()
+	if msg.PostponedAt > 0 {
+		if d := now.Sub(time.Unix(msg.PostponedAt, 0)); d > unsupportedVersionTTL {
+			p.taskLogger(msg).Warn("Task id=%s with unsupported message version %d was postponed for %v; Moving it to dead queue",
+				msg.ID, msg.Version, d.Round(time.Second))
+			if p.errHandler != nil {
+				p.errHandler.HandleError(NewTask(msg.Type, msg.Payload), ErrUnsupportedVersion, msg.Retried, msg.Retry)
+			}
+			p.killOnWorker(msg, ErrUnsupportedVersion)
+			return
+		}
+	}
+	logged := p.warnLogLimiter.Warn(p.taskLogger(msg), "Task id=%s was enqueued with unsupported message version %d; Postponing it for %v",
+		msg.ID, msg.Version, unsupportedVersionDelay)
+	if logged && p.errHandler != nil {
+		p.errHandler.HandleError(NewTask(msg.Type, msg.Payload), ErrUnsupportedVersion, msg.Retried, msg.Retry)
+	}
+	postponed := *msg // keeps the encoding of msg to remove it from in-progress queue.
+	if postponed.PostponedAt == 0 {
+		postponed.PostponedAt = now.Unix()
+	}
+	p.reprocess(&postponed, msg.Payload, now.Add(unsupportedVersionDelay))
+}
+
+// reprocess moves the task to the scheduled queue to be processed again
+// at the given time with the given payload.
+func (p *processor) reprocess(msg *base.TaskMessage, payload map[string]interface{}, processAt time.Time) {
+	err := p.rdb.Reprocess(msg, payload, processAt)
+	if err != nil {
+		errMsg := fmt.Sprintf("Could not move task id=%s from %q to %q", msg.ID, base.InProgressQueue, base.ScheduledQueue)
+		p.taskLogger(msg).With("error", err).Warn("%s; Will retry syncing", errMsg)
+		p.syncRequestCh <- newSyncRequest(p.rdb, &syncOp{Kind: syncReprocess, Msg: msg, ProcessAt: processAt, Payload: payload}, errMsg)
+	}
+}
+
+// isCompleted reports whether a task with the same idempotency key
+// as the given task has been completed.
+// If it could not be checked, it reports false to process the task.
+func (p *processor) isCompleted(msg *base.TaskMessage) bool {
+	completed, err := p.rdb.IsCompleted(msg.IdempotencyKey)
+	if err != nil {
+		p.errLogLimiter.Error(p.taskLogger(msg).With("error", err), "Could not check completion of task id=%s: %v", msg.ID, err)
+		return false
+	}
+	return completed
+}
+
+// recordCompletion records the completion of the task under its idempotency key.
+//
+// The completion is recorded before the task is removed from the in-progress
+// queue, either by markAsDone or, with explicit ack, by Acker.Ack, so that
+// the task is not processed again if it is restored before being removed.
+func (p *processor) recordCompletion(msg *base.TaskMessage) {
+	if err := p.rdb.RecordCompletion(msg.IdempotencyKey, p.idempotencyWindow); err != nil {
+		p.taskLogger(msg).With("error", err).Warn("Could not record completion of task id=%s: %v", msg.ID, err)
 	}
 }
 
@@ -238,52 +785,126 @@ func (p *processor) markAsDone(msg *base.TaskMessage) {
 	err := p.rdb.Done(msg)
 	if err != nil {
 		errMsg := fmt.Sprintf("Could not remove task id=%s from %q", msg.ID, base.InProgressQueue)
-		p.logger.Warn("%s; Will retry syncing", errMsg)
-		p.syncRequestCh <- &syncRequest{
-			fn: func() error {
-				return p.rdb.Done(msg)
-			},
-			errMsg: errMsg,
-		}
+		p.taskLogger(msg).With("error", err).Warn("%s; Will retry syncing", errMsg)
+		p.syncRequestCh <- newSyncRequest(p.rdb, &syncOp{Kind: syncDone, Msg: msg}, errMsg)
+	}
+}
+
+// skip removes the task from the in-progress queue without counting it
+// as processed.
+func (p *processor) skip(msg *base.TaskMessage) {
+	err := p.rdb.Skip(msg)
+	if err != nil {
+		errMsg := fmt.Sprintf("Could not remove task id=%s from %q", msg.ID, base.InProgressQueue)
+		p.taskLogger(msg).With("error", err).Warn("%s; Will retry syncing", errMsg)
+		p.syncRequestCh <- newSyncRequest(p.rdb, &syncOp{Kind: syncSkip, Msg: msg}, errMsg)
 	}
 }
 
 func (p *processor) retry(msg *base.TaskMessage, e error) {
-	d := p.retryDelayFunc(msg.Retried, e, NewTask(msg.Type, msg.Payload))
+	d, ok := retryDelayOf(e)
+	if !ok {
+		d = p.retryDelayFunc(msg.Retried, e, NewTask(msg.Type, msg.Payload))
+	}
 	retryAt := time.Now().Add(d)
 	err := p.rdb.Retry(msg, retryAt, e.Error())
 	if err != nil {
 		errMsg := fmt.Sprintf("Could not move task id=%s from %q to %q", msg.ID, base.InProgressQueue, base.RetryQueue)
-		p.logger.Warn("%s; Will retry syncing", errMsg)
-		p.syncRequestCh <- &syncRequest{
-			fn: func() error {
-				return p.rdb.Retry(msg, retryAt, e.Error())
-			},
-			errMsg: errMsg,
-		}
+		p.taskLogger(msg).With("error", err).Warn("%s; Will retry syncing", errMsg)
+		p.syncRequestCh <- newSyncRequest(p.rdb, &syncOp{Kind: syncRetry, Msg: msg, ProcessAt: retryAt, ErrMsg: e.Error()}, errMsg)
 	}
 }
 
+// ErrUnsupportedVersion is passed to the ErrorHandler for a task which was
+// enqueued by a newer version of the package, with a message encoding which
+// this version cannot process.
+//
+// Such a task is not processed, and is moved to the scheduled queue to be
+// processed later, e.g. by a process running the newer version during
+// a rolling upgrade. Once the task has been postponed for more than a day,
+// it is moved to the dead queue instead, so that it is not postponed forever
+// if no process runs the newer version.
+//
+// The ErrorHandler is called for the postponed tasks at most once per
+// Config.ErrorLogInterval, along with the warning logged.
+var ErrUnsupportedVersion = errors.New("asynq: unsupported task message version")
+
+// unsupportedVersionDelay is how long a task with an unsupported message
+// version is postponed for.
+const unsupportedVersionDelay = time.Minute
+
+// unsupportedVersionTTL is how long a task with an unsupported message version
+// is postponed since it was first postponed, before it's moved to the dead queue.
+const unsupportedVersionTTL = 24 * time.Hour
+
+// errTaskExpired is recorded as the error of a task which
+// was not started by its StartBy time.
+var errTaskExpired = errors.New("task was not started by its start-by time")
+
+// errAttemptsExhausted is recorded as the error of a task which was
+// attempted its max number of times, e.g. because it crashed the
+// processes processing it.
+var errAttemptsExhausted = errors.New("task was not finished in its max attempts")
+
 func (p *processor) kill(msg *base.TaskMessage, e error) {
-	p.logger.Warn("Retry exhausted for task id=%s", msg.ID)
 	err := p.rdb.Kill(msg, e.Error())
 	if err != nil {
 		errMsg := fmt.Sprintf("Could not move task id=%s from %q to %q", msg.ID, base.InProgressQueue, base.DeadQueue)
-		p.logger.Warn("%s; Will retry syncing", errMsg)
-		p.syncRequestCh <- &syncRequest{
-			fn: func() error {
-				return p.rdb.Kill(msg, e.Error())
-			},
-			errMsg: errMsg,
+		p.taskLogger(msg).With("error", err).Warn("%s; Will retry syncing", errMsg)
+		p.syncRequestCh <- newSyncRequest(p.rdb, &syncOp{Kind: syncKill, Msg: msg, ErrMsg: e.Error()}, errMsg)
+	}
+	if p.deadHandler != nil {
+		p.handleDeadTask(msg, e)
+	}
+}
+
+// killOnWorker moves the task to the dead queue from the "processor" goroutine,
+// calling the dead task handler on a worker goroutine so that a slow handler
+// doesn't block dequeueing tasks. The number of handlers running at the same
+// time is limited by the concurrency.
+func (p *processor) killOnWorker(msg *base.TaskMessage, e error) {
+	if p.deadHandler == nil {
+		p.kill(msg, e)
+		return
+	}
+	select {
+	case <-p.abort:
+		// shutdown is starting, the task is killed at the next start.
+		p.requeue(msg)
+	case p.sema <- struct{}{}: // acquire token
+		go func() {
+			defer func() { <-p.sema /* release token */ }()
+			p.kill(msg, e)
+		}()
+	}
+}
+
+// handleDeadTask calls the dead task handler with the task moved
+// to the dead queue due to the error e.
+func (p *processor) handleDeadTask(msg *base.TaskMessage, e error) {
+	info := &DeadTaskInfo{
+		ID:       msg.ID.String(),
+		Queue:    base.UnshardQueue(msg.Queue),
+		Retried:  msg.Retried,
+		MaxRetry: msg.Retry,
+		ErrorMsg: e.Error(),
+	}
+	if p.historySize > 0 {
+		attempts, err := p.rdb.TaskHistory(msg.ID.String())
+		if err != nil {
+			p.taskLogger(msg).With("error", err).Warn("Could not get history of dead task id=%s: %v", msg.ID, err)
 		}
+		info.History = newTaskAttempts(attempts)
 	}
+	p.deadHandler.HandleDeadTask(NewTask(msg.Type, msg.Payload), info)
 }
 
 // queues returns a list of queues to query.
 // Order of the queue names is based on the priority of each queue.
 // Queue names is sorted by their priority level if strict-priority is true.
-// If strict-priority is false, then the order of queue names are roughly based on
-// the priority level but randomized in order to avoid starving low priority queues.
+// If strict-priority is false, then the first queue in the list is chosen by
+// weighted round-robin so that each queue is queried first in proportion to its
+// priority level, and low priority queues are never starved.
 func (p *processor) queues() []string {
 	// skip the overhead of generating a list of queue names
 	// if we are processing one queue.
@@ -293,17 +914,118 @@ func (p *processor) queues() []string {
 		}
 	}
 	if p.orderedQueues != nil {
+		return p.agedQueues()
+	}
+	return p.weighted.next()
+}
+
+// agedQueues returns the list of queues sorted by priority,
+// except for the queues whose latency is longer than the aging duration
+// which are moved to the front of the list.
+func (p *processor) agedQueues() []string {
+	if p.latencies == nil {
 		return p.orderedQueues
 	}
-	var names []string
-	for qname, priority := range p.queueConfig {
-		for i := 0; i < int(priority); i++ {
-			names = append(names, qname)
+	if now := time.Now(); now.Sub(p.latenciesUpdated) >= latencyCheckInterval {
+		p.updateLatencies()
+		p.latenciesUpdated = now
+	}
+	var starved []string
+	for _, qname := range p.orderedQueues {
+		if p.latencies[qname] > p.priorityAging {
+			starved = append(starved, qname)
+		}
+	}
+	if len(starved) == 0 {
+		return p.orderedQueues
+	}
+	res := append([]string(nil), starved...)
+	for _, qname := range p.orderedQueues {
+		if p.latencies[qname] <= p.priorityAging {
+			res = append(res, qname)
+		}
+	}
+	return res
+}
+
+// latencyCheckInterval is the minimum interval between the checks of
+// the queue latencies for priority aging.
+const latencyCheckInterval = time.Second
+
+// updateLatencies reads the latency of each queue from redis.
+// The latency of a queue prefix or a sharded queue is the longest latency
+// of its queues. Latencies are left unchanged on error.
+func (p *processor) updateLatencies() {
+	qnames := p.orderedQueues
+	if p.groups != nil {
+		qnames = p.groups.expand(qnames)
+	}
+	latencies, err := p.rdb.QueueLatencies(qnames...)
+	if err != nil {
+		p.errLogLimiter.Error(p.dequeueLog, "Could not get queue latencies: %v", err)
+		return
+	}
+	res := make(map[string]time.Duration)
+	for qname, d := range latencies {
+		qname = p.configuredQueue(qname)
+		if d > res[qname] {
+			res[qname] = d
+		}
+	}
+	p.latencies = res
+}
+
+// configuredQueue returns the name of the queue or the queue prefix
+// in the config which the given queue belongs to.
+func (p *processor) configuredQueue(qname string) string {
+	if p.groups == nil {
+		return qname
+	}
+	return p.groups.configured(qname)
+}
+
+// activeQueues returns the subset of the given queues which are
+// within their active hours at the given time, preserving the order.
+func (p *processor) activeQueues(qnames []string, now time.Time) []string {
+	if len(p.activeHours) == 0 {
+		return qnames
+	}
+	var res []string
+	for _, qname := range qnames {
+		if h, ok := p.activeHours[qname]; !ok || h.contains(now) {
+			res = append(res, qname)
+		}
+	}
+	return res
+}
+
+// recordQueried records that each queue was queried and whether a task
+// was taken from it, given the list of queues passed to Dequeue and its result.
+// Queues are queried in order, so the queues after the one the message was
+// taken from were not queried.
+//
+// Queries are recorded under the names of the queues, which are read by
+// Inspector.DequeueStats, with the shards of a queue recorded as the queue.
+func (p *processor) recordQueried(dqnames []string, msg *base.TaskMessage, err error) {
+	if err != nil && err != rdb.ErrNoProcessableTask {
+		return
+	}
+	var qnames []string
+	seen := make(map[string]bool)
+	for _, dqname := range dqnames {
+		if qname := base.UnshardQueue(dqname); !seen[qname] {
+			seen[qname] = true
+			qnames = append(qnames, qname)
+		}
+	}
+	now := time.Now()
+	for _, qname := range qnames {
+		hit := msg != nil && base.UnshardQueue(msg.Queue) == qname
+		p.ps.RecordDequeue(qname, hit, now)
+		if hit {
+			return
 		}
 	}
-	r := rand.New(rand.NewSource(time.Now().UnixNano()))
-	r.Shuffle(len(names), func(i, j int) { names[i], names[j] = names[j], names[i] })
-	return uniq(names, len(p.queueConfig))
 }
 
 // perform calls the handler with the given task.
@@ -312,37 +1034,27 @@ func (p *processor) queues() []string {
 func perform(ctx context.Context, task *Task, h Handler) (err error) {
 	defer func() {
 		if x := recover(); x != nil {
-			err = fmt.Errorf("panic: %v", x)
+			err = &panicError{value: x, stack: debug.Stack()}
 		}
 	}()
 	return h.ProcessTask(ctx, task)
 }
 
-// uniq dedupes elements and returns a slice of unique names of length l.
-// Order of the output slice is based on the input list.
-func uniq(names []string, l int) []string {
-	var res []string
-	seen := make(map[string]struct{})
-	for _, s := range names {
-		if _, ok := seen[s]; !ok {
-			seen[s] = struct{}{}
-			res = append(res, s)
-		}
-		if len(res) == l {
-			break
-		}
-	}
-	return res
-}
-
 // sortByPriority returns a list of queue names sorted by
 // their priority level in descending order.
+// Queues with the same priority level are sorted by name.
 func sortByPriority(qcfg map[string]int) []string {
 	var queues []*queue
 	for qname, n := range qcfg {
 		queues = append(queues, &queue{qname, n})
 	}
-	sort.Sort(sort.Reverse(byPriority(queues)))
+	sort.Slice(queues, func(i, j int) bool {
+		x, y := queues[i], queues[j]
+		if x.priority != y.priority {
+			return x.priority > y.priority
+		}
+		return x.name < y.name
+	})
 	var res []string
 	for _, q := range queues {
 		res = append(res, q.name)
@@ -355,12 +1067,6 @@ type queue struct {
 	priority int
 }
 
-type byPriority []*queue
-
-func (x byPriority) Len() int           { return len(x) }
-func (x byPriority) Less(i, j int) bool { return x[i].priority < x[j].priority }
-func (x byPriority) Swap(i, j int)      { x[i], x[j] = x[j], x[i] }
-
 // normalizeQueueCfg divides priority numbers by their
 // greatest common divisor.
 func normalizeQueueCfg(queueCfg map[string]int) map[string]int {
@@ -393,9 +1099,9 @@ func gcd(xs ...int) int {
 	return res
 }
 
-// createContext returns a context and cancel function for a given task message.
-func createContext(msg *base.TaskMessage) (ctx context.Context, cancel context.CancelFunc) {
-	ctx = context.Background()
+// createContext returns a context derived from baseCtx and cancel function for a given task message.
+func createContext(baseCtx context.Context, msg *base.TaskMessage) (ctx context.Context, cancel context.CancelFunc) {
+	ctx = baseCtx
 	timeout, err := time.ParseDuration(msg.Timeout)
 	if err == nil && timeout != 0 {
 		ctx, cancel = context.WithTimeout(ctx, timeout)
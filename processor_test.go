@@ -69,7 +69,14 @@ func TestProcessorSuccess(t *testing.T) {
 		}
 		ps := base.NewProcessState("localhost", 1234, 10, defaultQueueConfig, false)
 		cancelations := base.NewCancelations()
-		p := newProcessor(testLogger, rdbClient, ps, defaultDelayFunc, nil, cancelations, nil)
+		p := newProcessor(processorParams{
+			logger:         testLogger,
+			rdb:            rdbClient,
+			ps:             ps,
+			retryDelayFunc: defaultDelayFunc,
+			baseCtxFn:      context.Background,
+			cancelations:   cancelations,
+		})
 		p.handler = HandlerFunc(handler)
 
 		var wg sync.WaitGroup
@@ -94,6 +101,55 @@ func TestProcessorSuccess(t *testing.T) {
 	}
 }
 
+func TestProcessorPaused(t *testing.T) {
+	r := setup(t)
+	rdbClient := rdb.NewRDB(r)
+
+	m1 := h.NewTaskMessage("send_email", nil)
+	m2 := h.NewTaskMessage("gen_thumbnail", nil)
+	h.SeedEnqueuedQueue(t, r, []*base.TaskMessage{m1, m2})
+
+	var mu sync.Mutex
+	var processed []*Task
+	handler := func(ctx context.Context, task *Task) error {
+		mu.Lock()
+		defer mu.Unlock()
+		processed = append(processed, task)
+		return nil
+	}
+	ps := base.NewProcessState("localhost", 1234, 10, defaultQueueConfig, false)
+	ps.SetStatus(base.StatusPaused)
+	cancelations := base.NewCancelations()
+	p := newProcessor(processorParams{
+		logger:         testLogger,
+		rdb:            rdbClient,
+		ps:             ps,
+		retryDelayFunc: defaultDelayFunc,
+		baseCtxFn:      context.Background,
+		cancelations:   cancelations,
+	})
+	p.handler = HandlerFunc(handler)
+
+	var wg sync.WaitGroup
+	p.start(&wg)
+	time.Sleep(2 * time.Second)
+
+	mu.Lock()
+	if len(processed) != 0 {
+		t.Errorf("paused processor processed %d tasks, want 0", len(processed))
+	}
+	mu.Unlock()
+
+	ps.SetStatus(base.StatusRunning)
+	time.Sleep(2 * time.Second)
+	p.terminate()
+
+	want := []*Task{NewTask(m1.Type, m1.Payload), NewTask(m2.Type, m2.Payload)}
+	if diff := cmp.Diff(want, processed, sortTaskOpt, cmp.AllowUnexported(Payload{})); diff != "" {
+		t.Errorf("mismatch found in processed tasks after resume; (-want, +got)\n%s", diff)
+	}
+}
+
 func TestProcessorRetry(t *testing.T) {
 	r := setup(t)
 	rdbClient := rdb.NewRDB(r)
@@ -146,6 +202,22 @@ func TestProcessorRetry(t *testing.T) {
 			wantDead:     []*base.TaskMessage{&r1},
 			wantErrCount: 4,
 		},
+		{
+			enqueued: []*base.TaskMessage{m1, m2},
+			incoming: []*base.TaskMessage{m3, m4},
+			delay:    time.Minute,
+			handler: HandlerFunc(func(ctx context.Context, task *Task) error {
+				return RetryIn(time.Hour, fmt.Errorf(errMsg))
+			}),
+			wait: time.Second,
+			wantRetry: []h.ZSetEntry{
+				{Msg: &r2, Score: float64(now.Add(time.Hour).Unix())},
+				{Msg: &r3, Score: float64(now.Add(time.Hour).Unix())},
+				{Msg: &r4, Score: float64(now.Add(time.Hour).Unix())},
+			},
+			wantDead:     []*base.TaskMessage{&r1},
+			wantErrCount: 4,
+		},
 	}
 
 	for _, tc := range tests {
@@ -167,7 +239,15 @@ func TestProcessorRetry(t *testing.T) {
 		}
 		ps := base.NewProcessState("localhost", 1234, 10, defaultQueueConfig, false)
 		cancelations := base.NewCancelations()
-		p := newProcessor(testLogger, rdbClient, ps, delayFunc, nil, cancelations, ErrorHandlerFunc(errHandler))
+		p := newProcessor(processorParams{
+			logger:         testLogger,
+			rdb:            rdbClient,
+			ps:             ps,
+			retryDelayFunc: delayFunc,
+			baseCtxFn:      context.Background,
+			cancelations:   cancelations,
+			errHandler:     ErrorHandlerFunc(errHandler),
+		})
 		p.handler = tc.handler
 
 		var wg sync.WaitGroup
@@ -183,8 +263,10 @@ func TestProcessorRetry(t *testing.T) {
 		p.terminate()
 
 		cmpOpt := cmpopts.EquateApprox(0, float64(time.Second)) // allow up to second difference in zset score
+		// EnqueuedAt is the same as zset score, FailedAt is the time of the failure.
+		ignoreOpt := cmpopts.IgnoreFields(base.TaskMessage{}, "EnqueuedAt", "FailedAt")
 		gotRetry := h.GetRetryEntries(t, r)
-		if diff := cmp.Diff(tc.wantRetry, gotRetry, h.SortZSetEntryOpt, cmpOpt); diff != "" {
+		if diff := cmp.Diff(tc.wantRetry, gotRetry, h.SortZSetEntryOpt, cmpOpt, ignoreOpt); diff != "" {
 			t.Errorf("mismatch found in %q after running processor; (-want, +got)\n%s", base.RetryQueue, diff)
 		}
 
@@ -233,7 +315,14 @@ func TestProcessorQueues(t *testing.T) {
 	for _, tc := range tests {
 		cancelations := base.NewCancelations()
 		ps := base.NewProcessState("localhost", 1234, 10, tc.queueCfg, false)
-		p := newProcessor(testLogger, nil, ps, defaultDelayFunc, nil, cancelations, nil)
+		p := newProcessor(processorParams{
+			logger:         testLogger,
+			rdb:            nil,
+			ps:             ps,
+			retryDelayFunc: defaultDelayFunc,
+			baseCtxFn:      context.Background,
+			cancelations:   cancelations,
+		})
 		got := p.queues()
 		if diff := cmp.Diff(tc.want, got, sortOpt); diff != "" {
 			t.Errorf("with queue config: %v\n(*processor).queues() = %v, want %v\n(-want,+got):\n%s",
@@ -301,7 +390,14 @@ func TestProcessorWithStrictPriority(t *testing.T) {
 		// Note: Set concurrency to 1 to make sure tasks are processed one at a time.
 		cancelations := base.NewCancelations()
 		ps := base.NewProcessState("localhost", 1234, 1 /* concurrency */, queueCfg, true /*strict*/)
-		p := newProcessor(testLogger, rdbClient, ps, defaultDelayFunc, nil, cancelations, nil)
+		p := newProcessor(processorParams{
+			logger:         testLogger,
+			rdb:            rdbClient,
+			ps:             ps,
+			retryDelayFunc: defaultDelayFunc,
+			baseCtxFn:      context.Background,
+			cancelations:   cancelations,
+		})
 		p.handler = HandlerFunc(handler)
 
 		var wg sync.WaitGroup
@@ -391,7 +487,7 @@ func TestCreateContextWithTimeRestrictions(t *testing.T) {
 			Deadline: tc.deadline.Format(time.RFC3339),
 		}
 
-		ctx, cancel := createContext(msg)
+		ctx, cancel := createContext(context.Background(), msg)
 
 		select {
 		case x := <-ctx.Done():
@@ -425,7 +521,7 @@ func TestCreateContextWithoutTimeRestrictions(t *testing.T) {
 		Deadline: time.Time{}.Format(time.RFC3339), // zero value to indicate no deadline
 	}
 
-	ctx, cancel := createContext(msg)
+	ctx, cancel := createContext(context.Background(), msg)
 
 	select {
 	case x := <-ctx.Done():
@@ -446,3 +542,1481 @@ func TestCreateContextWithoutTimeRestrictions(t *testing.T) {
 		t.Error("ctx.Done() blocked, want it to be non-blocking")
 	}
 }
+
+func TestCreateContextWithBaseContext(t *testing.T) {
+	type ctxKey string
+	const key ctxKey = "request_id"
+
+	baseCtx, baseCancel := context.WithCancel(context.WithValue(context.Background(), key, "abc123"))
+	msg := &base.TaskMessage{
+		Type:     "something",
+		ID:       xid.New(),
+		Timeout:  time.Duration(0).String(),
+		Deadline: time.Time{}.Format(time.RFC3339),
+	}
+
+	ctx, cancel := createContext(baseCtx, msg)
+	defer cancel()
+
+	if got, _ := ctx.Value(key).(string); got != "abc123" {
+		t.Errorf("ctx.Value(%q) = %q, want %q", key, got, "abc123")
+	}
+
+	select {
+	case x := <-ctx.Done():
+		t.Errorf("<-ctx.Done() == %v, want nothing (it should block)", x)
+	default:
+	}
+
+	baseCancel()
+
+	select {
+	case <-ctx.Done():
+	default:
+		t.Error("ctx.Done() blocked after canceling base context, want it to be non-blocking")
+	}
+}
+
+func TestProcessorQueuesWithStrictQueues(t *testing.T) {
+	queueCfg := map[string]int{
+		"critical": 6,
+		"high":     4,
+		"default":  2,
+		"low":      1,
+	}
+
+	tests := []struct {
+		desc         string
+		strictQueues []string
+		wantFirst    []string // queues which are always queried first, in order
+	}{
+		{
+			desc:         "single strict queue",
+			strictQueues: []string{"critical"},
+			wantFirst:    []string{"critical"},
+		},
+		{
+			desc:         "strict queues ordered by priority",
+			strictQueues: []string{"high", "critical", "unknown"},
+			wantFirst:    []string{"critical", "high"},
+		},
+		{
+			desc:         "all queues strict",
+			strictQueues: []string{"critical", "high", "default", "low"},
+			wantFirst:    []string{"critical", "high", "default", "low"},
+		},
+	}
+
+	for _, tc := range tests {
+		ps := base.NewProcessState("localhost", 1234, 10, queueCfg, false)
+		p := newProcessor(processorParams{
+			logger:         testLogger,
+			ps:             ps,
+			retryDelayFunc: defaultDelayFunc,
+			baseCtxFn:      context.Background,
+			strictQueues:   tc.strictQueues,
+			cancelations:   base.NewCancelations(),
+		})
+		// the other queues are weighted, so the queue after the strict queues varies.
+		seen := make(map[string]bool)
+		for i := 0; i < 10; i++ {
+			got := p.queues()
+			if len(got) != len(queueCfg) {
+				t.Fatalf("%s: (*processor).queues() = %v, want all %d queues", tc.desc, got, len(queueCfg))
+			}
+			if diff := cmp.Diff(tc.wantFirst, got[:len(tc.wantFirst)]); diff != "" {
+				t.Errorf("%s: (*processor).queues() = %v, want %v first; (-want,+got)\n%s",
+					tc.desc, got, tc.wantFirst, diff)
+			}
+			if len(tc.wantFirst) < len(got) {
+				seen[got[len(tc.wantFirst)]] = true
+			}
+		}
+		if want := len(queueCfg) - len(tc.wantFirst); len(seen) != want {
+			t.Errorf("%s: %d queues selected after the strict queues, want %d", tc.desc, len(seen), want)
+		}
+	}
+}
+
+func TestProcessorQueuesWithPriorityAging(t *testing.T) {
+	queueCfg := map[string]int{
+		"critical": 6,
+		"default":  3,
+		"low":      1,
+	}
+	const aging = time.Minute
+
+	tests := []struct {
+		desc      string
+		latencies map[string]time.Duration
+		want      []string
+	}{
+		{
+			desc: "no queues starved",
+			latencies: map[string]time.Duration{
+				"critical": 0,
+				"default":  30 * time.Second,
+				"low":      59 * time.Second,
+			},
+			want: []string{"critical", "default", "low"},
+		},
+		{
+			desc: "low priority queue starved",
+			latencies: map[string]time.Duration{
+				"critical": 0,
+				"default":  0,
+				"low":      2 * time.Minute,
+			},
+			want: []string{"low", "critical", "default"},
+		},
+		{
+			desc: "multiple queues starved",
+			latencies: map[string]time.Duration{
+				"critical": 0,
+				"default":  5 * time.Minute,
+				"low":      2 * time.Minute,
+			},
+			want: []string{"default", "low", "critical"},
+		},
+	}
+
+	for _, tc := range tests {
+		cancelations := base.NewCancelations()
+		ps := base.NewProcessState("localhost", 1234, 10, queueCfg, true /*strict*/)
+		p := newProcessor(processorParams{
+			logger:         testLogger,
+			ps:             ps,
+			retryDelayFunc: defaultDelayFunc,
+			baseCtxFn:      context.Background,
+			priorityAging:  aging,
+			cancelations:   cancelations,
+		})
+		p.latencies = tc.latencies
+		p.latenciesUpdated = time.Now()
+		got := p.queues()
+		if diff := cmp.Diff(tc.want, got); diff != "" {
+			t.Errorf("%s: (*processor).queues() = %v, want %v; (-want,+got)\n%s",
+				tc.desc, got, tc.want, diff)
+		}
+	}
+}
+
+func TestProcessorPriorityAgingWithQueriedQueue(t *testing.T) {
+	r := setup(t)
+	queueCfg := map[string]int{
+		"critical": 6,
+		"low":      1,
+	}
+	critical := h.NewTaskMessageWithQueue("send_email", nil, "critical")
+	low := h.NewTaskMessageWithQueue("export", nil, "low")
+	low.EnqueuedAt = time.Now().Add(-2 * time.Minute).Unix()
+	h.SeedEnqueuedQueue(t, r, []*base.TaskMessage{critical}, "critical")
+	h.SeedEnqueuedQueue(t, r, []*base.TaskMessage{low}, "low")
+
+	ps := base.NewProcessState("localhost", 1234, 10, queueCfg, true /*strict*/)
+	p := newProcessor(processorParams{
+		logger:         testLogger,
+		rdb:            rdb.NewRDB(r),
+		ps:             ps,
+		retryDelayFunc: defaultDelayFunc,
+		baseCtxFn:      context.Background,
+		priorityAging:  time.Minute,
+		cancelations:   base.NewCancelations(),
+	})
+	// the low priority queue keeps being queried, but its task is waiting
+	// for longer than the aging duration.
+	p.recordQueried([]string{"critical", "low"}, nil, rdb.ErrNoProcessableTask)
+
+	want := []string{"low", "critical"}
+	if got := p.queues(); !cmp.Equal(want, got) {
+		t.Errorf("(*processor).queues() = %v, want %v", got, want)
+	}
+}
+
+func TestProcessorRecordQueried(t *testing.T) {
+	queueCfg := map[string]int{
+		"critical": 6,
+		"default":  3,
+		"low":      1,
+	}
+	critical := h.NewTaskMessageWithQueue("send_email", nil, "critical")
+	deflt := h.NewTaskMessageWithQueue("send_email", nil, "default")
+
+	tests := []struct {
+		desc        string
+		msg         *base.TaskMessage
+		err         error
+		wantQueried []string // queues that should be recorded as queried
+	}{
+		{"task taken from first queue", critical, nil, []string{"critical"}},
+		{"task taken from second queue", deflt, nil, []string{"critical", "default"}},
+		{"all queues empty", nil, rdb.ErrNoProcessableTask, []string{"critical", "default", "low"}},
+		{"dequeue error", nil, fmt.Errorf("redis error"), []string{}},
+	}
+
+	for _, tc := range tests {
+		cancelations := base.NewCancelations()
+		ps := base.NewProcessState("localhost", 1234, 10, queueCfg, true /*strict*/)
+		p := newProcessor(processorParams{
+			logger:         testLogger,
+			ps:             ps,
+			retryDelayFunc: defaultDelayFunc,
+			baseCtxFn:      context.Background,
+			priorityAging:  time.Minute,
+			cancelations:   cancelations,
+		})
+
+		p.recordQueried([]string{"critical", "default", "low"}, tc.msg, tc.err)
+
+		got := []string{}
+		for qname, stats := range ps.TakeDequeueStats() {
+			if stats.Attempts > 0 {
+				got = append(got, qname)
+			}
+		}
+		if diff := cmp.Diff(tc.wantQueried, got, h.SortStringSliceOpt); diff != "" {
+			t.Errorf("%s: queried queues = %v, want %v; (-want,+got)\n%s",
+				tc.desc, got, tc.wantQueried, diff)
+		}
+	}
+}
+
+func TestProcessorRecordQueriedByQueueName(t *testing.T) {
+	queueCfg := map[string]int{
+		"tenant:": 2,
+		"email":   1,
+	}
+	ps := base.NewProcessState("localhost", 1234, 10, queueCfg, true /*strict*/)
+	p := newProcessor(processorParams{
+		logger:         testLogger,
+		ps:             ps,
+		retryDelayFunc: defaultDelayFunc,
+		baseCtxFn:      context.Background,
+		cancelations:   base.NewCancelations(),
+	})
+	msg := h.NewTaskMessageWithQueue("send_email", nil, base.ShardQueue("email", 1))
+
+	// the queues of the prefix and the shards of the queue, as passed to Dequeue.
+	dqnames := []string{"tenant:acme", "tenant:globex", "email", base.ShardQueue("email", 0), base.ShardQueue("email", 1)}
+	p.recordQueried(dqnames, msg, nil)
+
+	got := make(map[string][2]int64) // attempts and hits by queue
+	for qname, stats := range ps.TakeDequeueStats() {
+		got[qname] = [2]int64{stats.Attempts, stats.Hits}
+	}
+	want := map[string][2]int64{
+		"tenant:acme":   {1, 0},
+		"tenant:globex": {1, 0},
+		"email":         {1, 1},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("recorded queries (attempts, hits) = %v, want %v; (-want,+got)\n%s", got, want, diff)
+	}
+}
+
+func TestProcessorWithQueueReservations(t *testing.T) {
+	r := setup(t)
+	rdbClient := rdb.NewRDB(r)
+
+	var lowMsgs []*base.TaskMessage
+	for i := 0; i < 3; i++ {
+		lowMsgs = append(lowMsgs, h.NewTaskMessageWithQueue("export", nil, "low"))
+	}
+	h.SeedEnqueuedQueue(t, r, lowMsgs, "low")
+
+	var (
+		mu        sync.Mutex
+		processed []string // task types in the order they started processing
+	)
+	handler := func(ctx context.Context, task *Task) error {
+		mu.Lock()
+		processed = append(processed, task.Type)
+		mu.Unlock()
+		if task.Type == "export" {
+			time.Sleep(3 * time.Second) // long running task
+		}
+		return nil
+	}
+	queueCfg := map[string]int{"critical": 1, "low": 1}
+	ps := base.NewProcessState("localhost", 1234, 2, queueCfg, false)
+	cancelations := base.NewCancelations()
+	p := newProcessor(processorParams{
+		logger:         testLogger,
+		rdb:            rdbClient,
+		ps:             ps,
+		retryDelayFunc: defaultDelayFunc,
+		baseCtxFn:      context.Background,
+		reservations:   map[string]int{"critical": 1},
+		cancelations:   cancelations,
+	})
+	p.handler = HandlerFunc(handler)
+
+	var wg sync.WaitGroup
+	p.start(&wg)
+	time.Sleep(time.Second) // let "low" tasks occupy the shared worker.
+
+	if err := rdbClient.Enqueue(h.NewTaskMessageWithQueue("send_email", nil, "critical")); err != nil {
+		p.terminate()
+		t.Fatal(err)
+	}
+	time.Sleep(time.Second) // "critical" task should be processed by the reserved worker.
+
+	mu.Lock()
+	want := []string{"export", "send_email"}
+	if diff := cmp.Diff(want, processed); diff != "" {
+		t.Errorf("processed tasks = %v, want %v; (-want,+got)\n%s", processed, want, diff)
+	}
+	mu.Unlock()
+	p.terminate()
+}
+
+func TestProcessorSetConcurrency(t *testing.T) {
+	ps := base.NewProcessState("localhost", 1234, 10, defaultQueueConfig, false)
+	p := newProcessor(processorParams{
+		logger:         testLogger,
+		ps:             ps,
+		retryDelayFunc: defaultDelayFunc,
+		baseCtxFn:      context.Background,
+		cancelations:   base.NewCancelations(),
+	})
+
+	// simulate three active workers.
+	for i := 0; i < 3; i++ {
+		p.sema <- struct{}{}
+	}
+
+	tests := []struct {
+		n    int
+		want int
+	}{
+		{5, 5},
+		{1, 3}, // cannot go below the number of active workers
+		{8, 8},
+		{20, 10}, // capped to the configured concurrency
+		{3, 3},
+	}
+
+	for _, tc := range tests {
+		if got := p.setConcurrency(tc.n); got != tc.want {
+			t.Errorf("setConcurrency(%d) = %d, want %d", tc.n, got, tc.want)
+		}
+		if got := p.concurrency(); got != tc.want {
+			t.Errorf("concurrency() = %d after setConcurrency(%d), want %d", got, tc.n, tc.want)
+		}
+		if got, want := len(p.sema), 3+p.parked; got != want {
+			t.Errorf("len(sema) = %d after setConcurrency(%d), want %d", got, tc.n, want)
+		}
+	}
+}
+
+func TestProcessorRecordDuration(t *testing.T) {
+	ps := base.NewProcessState("localhost", 1234, 10, defaultQueueConfig, false)
+	p := newProcessor(processorParams{
+		logger:         testLogger,
+		ps:             ps,
+		retryDelayFunc: defaultDelayFunc,
+		baseCtxFn:      context.Background,
+		cancelations:   base.NewCancelations(),
+	})
+
+	if got := p.averageDuration(); got != 0 {
+		t.Errorf("averageDuration() = %v before any task is processed, want 0", got)
+	}
+	p.recordDuration(10 * time.Second)
+	if got, want := p.averageDuration(), 10*time.Second; got != want {
+		t.Errorf("averageDuration() = %v, want %v", got, want)
+	}
+	p.recordDuration(20 * time.Second)
+	if got, want := p.averageDuration(), 12*time.Second; got != want {
+		t.Errorf("averageDuration() = %v, want %v", got, want)
+	}
+}
+
+func TestProcessorReportsSlowTask(t *testing.T) {
+	r := setup(t)
+	rdbClient := rdb.NewRDB(r)
+
+	m1 := h.NewTaskMessage("fast", nil)
+	m2 := h.NewTaskMessage("slow", nil)
+	h.SeedEnqueuedQueue(t, r, []*base.TaskMessage{m1, m2})
+
+	var (
+		mu       sync.Mutex
+		slow     []string // types of tasks reported as slow
+		finished bool     // whether the slow task's handler returned
+	)
+	onSlowTask := func(task *Task, d time.Duration) {
+		mu.Lock()
+		defer mu.Unlock()
+		if d < 200*time.Millisecond {
+			t.Errorf("OnSlowTask called with duration %v for %q, want at least 200ms", d, task.Type)
+		}
+		if finished {
+			t.Errorf("OnSlowTask called for %q after the handler returned, want while it's running", task.Type)
+		}
+		slow = append(slow, task.Type)
+	}
+	handler := func(ctx context.Context, task *Task) error {
+		if task.Type == "slow" {
+			time.Sleep(time.Second)
+			mu.Lock()
+			finished = true
+			mu.Unlock()
+		}
+		return nil
+	}
+	ps := base.NewProcessState("localhost", 1234, 10, defaultQueueConfig, false)
+	p := newProcessor(processorParams{
+		logger:         testLogger,
+		rdb:            rdbClient,
+		ps:             ps,
+		retryDelayFunc: defaultDelayFunc,
+		baseCtxFn:      context.Background,
+		cancelations:   base.NewCancelations(),
+		slowThreshold:  200 * time.Millisecond,
+		onSlowTask:     onSlowTask,
+	})
+	p.handler = HandlerFunc(handler)
+
+	var wg sync.WaitGroup
+	p.start(&wg)
+	time.Sleep(2 * time.Second) // wait for two tasks to be processed.
+	p.terminate()
+
+	mu.Lock()
+	want := []string{"slow"} // reported once.
+	if diff := cmp.Diff(want, slow); diff != "" {
+		t.Errorf("tasks reported as slow = %v, want %v; (-want,+got)\n%s", slow, want, diff)
+	}
+	mu.Unlock()
+}
+
+func TestProcessorRecordsAttempts(t *testing.T) {
+	r := setup(t)
+	rdbClient := rdb.NewRDB(r)
+
+	m1 := h.NewTaskMessage("send_email", nil)
+	m2 := h.NewTaskMessage("reindex", nil)
+	h.SeedEnqueuedQueue(t, r, []*base.TaskMessage{m1, m2})
+
+	errMsg := "something went wrong"
+	handler := func(ctx context.Context, task *Task) error {
+		if task.Type == "reindex" {
+			return fmt.Errorf(errMsg)
+		}
+		return nil
+	}
+	ps := base.NewProcessState("localhost", 1234, 10, defaultQueueConfig, false)
+	p := newProcessor(processorParams{
+		logger:         testLogger,
+		rdb:            rdbClient,
+		ps:             ps,
+		retryDelayFunc: func(n int, err error, t *Task) time.Duration { return time.Hour },
+		baseCtxFn:      context.Background,
+		cancelations:   base.NewCancelations(),
+		historySize:    10,
+	})
+	p.handler = HandlerFunc(handler)
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	p.start(&wg)
+	time.Sleep(time.Second) // wait for two tasks to be processed.
+	p.terminate()
+
+	tests := []struct {
+		msg     *base.TaskMessage
+		wantErr string
+	}{
+		{m1, ""},
+		{m2, errMsg},
+	}
+	for _, tc := range tests {
+		history, err := rdbClient.TaskHistory(tc.msg.ID.String())
+		if err != nil {
+			t.Errorf("TaskHistory(%q) returned error: %v", tc.msg.ID, err)
+			continue
+		}
+		if len(history) != 1 {
+			t.Errorf("TaskHistory(%q) returned %d attempts, want 1", tc.msg.ID, len(history))
+			continue
+		}
+		got := history[0]
+		if got.Host != "localhost" || got.PID != 1234 || got.ErrorMsg != tc.wantErr {
+			t.Errorf("recorded attempt for %q = %+v, want Host=localhost PID=1234 ErrorMsg=%q",
+				tc.msg.Type, got, tc.wantErr)
+		}
+		if got.Started.Before(start) || got.Started.After(time.Now()) {
+			t.Errorf("recorded attempt for %q started at %v, want between %v and now", tc.msg.Type, got.Started, start)
+		}
+	}
+}
+
+func TestProcessorKillsTaskNotStartedByStartBy(t *testing.T) {
+	r := setup(t)
+	rdbClient := rdb.NewRDB(r)
+
+	m1 := h.NewTaskMessage("send_push", nil)
+	m1.StartBy = time.Now().Add(-time.Minute).Unix() // expired
+	m2 := h.NewTaskMessage("send_push", nil)
+	m2.StartBy = time.Now().Add(time.Hour).Unix()
+	// retried after the time, having started before it.
+	m3 := h.NewTaskMessage("send_push", nil)
+	m3.StartBy = time.Now().Add(-time.Minute).Unix()
+	m3.Retried = 1
+	h.SeedEnqueuedQueue(t, r, []*base.TaskMessage{m1, m2, m3})
+
+	var (
+		mu        sync.Mutex
+		processed []*Task
+	)
+	handler := func(ctx context.Context, task *Task) error {
+		mu.Lock()
+		defer mu.Unlock()
+		processed = append(processed, task)
+		return nil
+	}
+	ps := base.NewProcessState("localhost", 1234, 10, defaultQueueConfig, false)
+	p := newProcessor(processorParams{
+		logger:         testLogger,
+		rdb:            rdbClient,
+		ps:             ps,
+		retryDelayFunc: defaultDelayFunc,
+		baseCtxFn:      context.Background,
+		cancelations:   base.NewCancelations(),
+	})
+	p.handler = HandlerFunc(handler)
+
+	var wg sync.WaitGroup
+	p.start(&wg)
+	time.Sleep(time.Second) // wait for all tasks to be dequeued.
+	p.terminate()
+
+	mu.Lock()
+	if len(processed) != 2 {
+		t.Errorf("processed %d tasks, want 2", len(processed))
+	}
+	mu.Unlock()
+
+	gotDead := h.GetDeadMessages(t, r)
+	if len(gotDead) != 1 || gotDead[0].ID != m1.ID {
+		t.Fatalf("dead queue = %v, want only task %v", gotDead, m1.ID)
+	}
+	if gotDead[0].ErrorMsg != errTaskExpired.Error() {
+		t.Errorf("ErrorMsg of dead task = %q, want %q", gotDead[0].ErrorMsg, errTaskExpired.Error())
+	}
+}
+
+func TestProcessorKillsTaskExhaustingMaxAttempts(t *testing.T) {
+	r := setup(t)
+	rdbClient := rdb.NewRDB(r)
+
+	// restored after crashes until it reached its max attempts.
+	m1 := h.NewTaskMessage("render_video", nil)
+	m1.MaxAttempts = 3
+	m1.Retried = 1
+	m1.Restored = 2
+	// failing for the last of its max attempts, with retries left.
+	m2 := h.NewTaskMessage("render_video", nil)
+	m2.MaxAttempts = 3
+	m2.Retry = 10
+	m2.Restored = 2
+	// failing with attempts left.
+	m3 := h.NewTaskMessage("render_video", nil)
+	m3.MaxAttempts = 3
+	m3.Retry = 10
+	m3.Restored = 1
+	h.SeedEnqueuedQueue(t, r, []*base.TaskMessage{m1, m2, m3})
+
+	var (
+		mu        sync.Mutex
+		processed int
+	)
+	handler := func(ctx context.Context, task *Task) error {
+		mu.Lock()
+		defer mu.Unlock()
+		processed++
+		return fmt.Errorf("render failed")
+	}
+	ps := base.NewProcessState("localhost", 1234, 10, defaultQueueConfig, false)
+	p := newProcessor(processorParams{
+		logger:         testLogger,
+		rdb:            rdbClient,
+		ps:             ps,
+		retryDelayFunc: defaultDelayFunc,
+		baseCtxFn:      context.Background,
+		cancelations:   base.NewCancelations(),
+	})
+	p.handler = HandlerFunc(handler)
+
+	var wg sync.WaitGroup
+	p.start(&wg)
+	time.Sleep(time.Second) // wait for the tasks to be dequeued.
+	p.terminate()
+
+	mu.Lock()
+	if processed != 2 {
+		t.Errorf("processed %d tasks, want 2", processed)
+	}
+	mu.Unlock()
+
+	wantErrs := map[xid.ID]string{
+		m1.ID: errAttemptsExhausted.Error(),
+		m2.ID: "render failed",
+	}
+	gotDead := h.GetDeadMessages(t, r)
+	if len(gotDead) != len(wantErrs) {
+		t.Fatalf("dead queue = %v, want tasks %v and %v", gotDead, m1.ID, m2.ID)
+	}
+	for _, msg := range gotDead {
+		if want, ok := wantErrs[msg.ID]; !ok || msg.ErrorMsg != want {
+			t.Errorf("dead task %v has ErrorMsg %q, want one of %v", msg.ID, msg.ErrorMsg, wantErrs)
+		}
+	}
+	gotRetry := h.GetRetryMessages(t, r)
+	if len(gotRetry) != 1 || gotRetry[0].ID != m3.ID || gotRetry[0].Restored != 1 {
+		t.Errorf("retry queue = %v, want only task %v restored once", gotRetry, m3.ID)
+	}
+}
+
+func TestProcessorWithExplicitAck(t *testing.T) {
+	r := setup(t)
+	rdbClient := rdb.NewRDB(r)
+
+	m1 := h.NewTaskMessage("ack", nil)
+	m2 := h.NewTaskMessage("ack_then_fail", nil)
+	m3 := h.NewTaskMessage("no_ack", nil)
+	m4 := h.NewTaskMessage("nack", nil)
+	for _, msg := range []*base.TaskMessage{m1, m2, m3, m4} {
+		msg.Retry = 10
+	}
+	h.SeedEnqueuedQueue(t, r, []*base.TaskMessage{m1, m2, m3, m4})
+
+	var (
+		mu         sync.Mutex
+		inProgress = make(map[string]int) // in-progress tasks of the type after ack
+		acks       = make(map[string]*Acker)
+	)
+	handler := func(ctx context.Context, task *Task) error {
+		ack, ok := AckerFromContext(ctx)
+		if !ok {
+			return fmt.Errorf("no acker")
+		}
+		mu.Lock()
+		acks[task.Type] = ack
+		mu.Unlock()
+		switch task.Type {
+		case "ack", "ack_then_fail":
+			if err := ack.Ack(); err != nil {
+				return err
+			}
+			mu.Lock()
+			for _, msg := range h.GetInProgressMessages(t, r) {
+				if msg.Type == task.Type {
+					inProgress[task.Type]++
+				}
+			}
+			mu.Unlock()
+			if task.Type == "ack_then_fail" {
+				return fmt.Errorf("cleanup failed")
+			}
+		case "nack":
+			if err := ack.Nack(fmt.Errorf("rolled back")); err != nil {
+				return err
+			}
+			if err := ack.Ack(); err == nil {
+				return fmt.Errorf("Ack after Nack returned no error")
+			}
+		}
+		return nil
+	}
+	ps := base.NewProcessState("localhost", 1234, 1, defaultQueueConfig, false)
+	p := newProcessor(processorParams{
+		logger:         testLogger,
+		rdb:            rdbClient,
+		ps:             ps,
+		retryDelayFunc: defaultDelayFunc,
+		baseCtxFn:      context.Background,
+		cancelations:   base.NewCancelations(),
+		explicitAck:    true,
+	})
+	p.handler = HandlerFunc(handler)
+
+	var wg sync.WaitGroup
+	p.start(&wg)
+	time.Sleep(time.Second) // wait for the tasks to be processed.
+	p.terminate()
+
+	for _, typ := range []string{"ack", "ack_then_fail"} {
+		if n := inProgress[typ]; n != 0 {
+			t.Errorf("%d tasks in progress after %q task was acknowledged, want 0", n, typ)
+		}
+	}
+	if got := h.GetInProgressMessages(t, r); len(got) != 0 {
+		t.Errorf("%q has %d tasks, want 0", base.InProgressQueue, len(got))
+	}
+	wantErrs := map[xid.ID]string{
+		m3.ID: errNotAcked.Error(),
+		m4.ID: "rolled back",
+	}
+	gotRetry := h.GetRetryMessages(t, r)
+	if len(gotRetry) != len(wantErrs) {
+		t.Fatalf("retry queue = %v, want tasks %v and %v", gotRetry, m3.ID, m4.ID)
+	}
+	for _, msg := range gotRetry {
+		if want, ok := wantErrs[msg.ID]; !ok || msg.ErrorMsg != want {
+			t.Errorf("retry task %v has ErrorMsg %q, want one of %v", msg.ID, msg.ErrorMsg, wantErrs)
+		}
+	}
+	if err := acks["no_ack"].Ack(); err == nil {
+		t.Errorf("Ack after the handler returned returned no error")
+	}
+}
+
+func TestProcessorWithExplicitAckRecordsCompletionBeforeAck(t *testing.T) {
+	r := setup(t)
+	rdbClient := rdb.NewRDB(r)
+
+	m1 := h.NewTaskMessage("charge", nil)
+	m1.IdempotencyKey = "charge:1"
+	h.SeedEnqueuedQueue(t, r, []*base.TaskMessage{m1})
+
+	var (
+		mu        sync.Mutex
+		completed bool // whether the completion was recorded once acknowledged
+	)
+	handler := func(ctx context.Context, task *Task) error {
+		ack, _ := AckerFromContext(ctx)
+		if err := ack.Ack(); err != nil {
+			return err
+		}
+		ok, err := rdbClient.IsCompleted(m1.IdempotencyKey)
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		completed = ok
+		mu.Unlock()
+		return nil
+	}
+	ps := base.NewProcessState("localhost", 1234, 1, defaultQueueConfig, false)
+	p := newProcessor(processorParams{
+		logger:            testLogger,
+		rdb:               rdbClient,
+		ps:                ps,
+		retryDelayFunc:    defaultDelayFunc,
+		baseCtxFn:         context.Background,
+		cancelations:      base.NewCancelations(),
+		explicitAck:       true,
+		idempotencyWindow: time.Hour,
+	})
+	p.handler = HandlerFunc(handler)
+
+	var wg sync.WaitGroup
+	p.start(&wg)
+	time.Sleep(time.Second) // wait for the task to be processed.
+	p.terminate()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if !completed {
+		t.Error("completion of the task was not recorded when the task was acknowledged")
+	}
+}
+
+func TestProcessorQuarantinesPanickingTaskType(t *testing.T) {
+	r := setup(t)
+	rdbClient := rdb.NewRDB(r)
+
+	var msgs []*base.TaskMessage
+	for i := 0; i < 4; i++ {
+		msgs = append(msgs, h.NewTaskMessage("render_video", nil))
+	}
+	msgs = append(msgs, h.NewTaskMessage("send_email", nil))
+	h.SeedEnqueuedQueue(t, r, msgs)
+
+	var (
+		mu        sync.Mutex
+		processed = make(map[string]int)
+	)
+	handler := func(ctx context.Context, task *Task) error {
+		mu.Lock()
+		processed[task.Type]++
+		mu.Unlock()
+		if task.Type == "render_video" {
+			panic("bad frame")
+		}
+		return nil
+	}
+	ps := base.NewProcessState("localhost", 1234, 1, defaultQueueConfig, false)
+	p := newProcessor(processorParams{
+		logger:         testLogger,
+		rdb:            rdbClient,
+		ps:             ps,
+		retryDelayFunc: defaultDelayFunc,
+		baseCtxFn:      context.Background,
+		cancelations:   base.NewCancelations(),
+		panics:         newPanicMonitor(2, time.Minute, time.Hour),
+	})
+	p.handler = HandlerFunc(handler)
+
+	var wg sync.WaitGroup
+	p.start(&wg)
+	time.Sleep(time.Second) // wait for the tasks to be dequeued.
+	p.terminate()
+
+	mu.Lock()
+	if processed["render_video"] != 2 || processed["send_email"] != 1 {
+		t.Errorf("processed %v, want 2 render_video and 1 send_email tasks", processed)
+	}
+	mu.Unlock()
+	if got := p.panics.panicCounts()["render_video"]; got != 2 {
+		t.Errorf("panic count of render_video = %d, want 2", got)
+	}
+	gotRetry := h.GetRetryMessages(t, r)
+	if len(gotRetry) != 2 {
+		t.Errorf("retry queue has %d tasks, want 2", len(gotRetry))
+	}
+	for _, msg := range gotRetry {
+		if msg.ErrorMsg != "panic: bad frame" {
+			t.Errorf("ErrorMsg of retry task = %q, want %q", msg.ErrorMsg, "panic: bad frame")
+		}
+	}
+	// quarantined tasks are postponed without counting as a retry.
+	gotScheduled := h.GetScheduledEntries(t, r)
+	if len(gotScheduled) != 2 {
+		t.Fatalf("scheduled queue has %d tasks, want 2", len(gotScheduled))
+	}
+	for _, e := range gotScheduled {
+		if e.Msg.Retried != 0 || e.Score < float64(time.Now().Add(50*time.Minute).Unix()) {
+			t.Errorf("scheduled task = %+v, want postponed by the quarantine without a retry", e)
+		}
+	}
+}
+
+func TestProcessorRecordsDequeueStats(t *testing.T) {
+	r := setup(t)
+	rdbClient := rdb.NewRDB(r)
+
+	m1 := h.NewTaskMessage("send_email", nil)
+	m2 := h.NewTaskMessage("send_email", nil)
+	h.SeedEnqueuedQueue(t, r, []*base.TaskMessage{m1, m2})
+
+	queues := map[string]int{"critical": 6, base.DefaultQueueName: 3}
+	ps := base.NewProcessState("localhost", 1234, 10, queues, true)
+	p := newProcessor(processorParams{
+		logger:         testLogger,
+		rdb:            rdbClient,
+		ps:             ps,
+		retryDelayFunc: defaultDelayFunc,
+		baseCtxFn:      context.Background,
+		cancelations:   base.NewCancelations(),
+	})
+	p.handler = HandlerFunc(func(ctx context.Context, task *Task) error { return nil })
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	p.start(&wg)
+	time.Sleep(100 * time.Millisecond) // wait for the tasks to be dequeued.
+	p.terminate()
+
+	// critical is queried first, and default is queried only if critical is empty.
+	stats := ps.TakeDequeueStats()
+	crit, def := stats["critical"], stats[base.DefaultQueueName]
+	if crit == nil || def == nil {
+		t.Fatalf("dequeue stats = %v, want stats of both queues", stats)
+	}
+	if crit.Hits != 0 || def.Hits != 2 || crit.Attempts < def.Attempts || def.Attempts < 2 {
+		t.Errorf("dequeue stats of critical = %+v, default = %+v; want 2 hits in default and no fewer attempts in critical",
+			crit, def)
+	}
+	if def.LastQueried.Before(start) {
+		t.Errorf("default was last queried at %v, want after %v", def.LastQueried, start)
+	}
+}
+
+func TestProcessorWithQueueActiveHours(t *testing.T) {
+	r := setup(t)
+	rdbClient := rdb.NewRDB(r)
+
+	m1 := h.NewTaskMessage("send_email", nil)
+	m2 := h.NewTaskMessageWithQueue("gen_report", nil, "reports")
+	h.SeedEnqueuedQueue(t, r, []*base.TaskMessage{m1})
+	h.SeedEnqueuedQueue(t, r, []*base.TaskMessage{m2}, "reports")
+
+	// window which doesn't contain the current time.
+	hour, _, _ := time.Now().Clock()
+	inactive := ActiveHours{
+		Start: time.Duration((hour+2)%24) * time.Hour,
+		End:   time.Duration((hour+3)%24) * time.Hour,
+	}
+
+	var (
+		mu        sync.Mutex
+		processed []string
+	)
+	handler := func(ctx context.Context, task *Task) error {
+		mu.Lock()
+		defer mu.Unlock()
+		processed = append(processed, task.Type)
+		return nil
+	}
+	queueCfg := map[string]int{"default": 1, "reports": 1}
+	ps := base.NewProcessState("localhost", 1234, 10, queueCfg, false)
+	p := newProcessor(processorParams{
+		logger:         testLogger,
+		rdb:            rdbClient,
+		ps:             ps,
+		retryDelayFunc: defaultDelayFunc,
+		baseCtxFn:      context.Background,
+		activeHours:    map[string]ActiveHours{"reports": inactive},
+		cancelations:   base.NewCancelations(),
+	})
+	p.handler = HandlerFunc(handler)
+
+	var wg sync.WaitGroup
+	p.start(&wg)
+	time.Sleep(2 * time.Second)
+	p.terminate()
+
+	mu.Lock()
+	want := []string{"send_email"}
+	if diff := cmp.Diff(want, processed); diff != "" {
+		t.Errorf("processed tasks = %v, want %v; (-want,+got)\n%s", processed, want, diff)
+	}
+	mu.Unlock()
+
+	if got := h.GetEnqueuedMessages(t, r, "reports"); len(got) != 1 {
+		t.Errorf("%q has %d tasks, want 1", base.QueueKey("reports"), len(got))
+	}
+}
+
+func TestProcessorWithCircuitBreaker(t *testing.T) {
+	r := setup(t)
+	rdbClient := rdb.NewRDB(r)
+
+	var msgs []*base.TaskMessage
+	for i := 0; i < 5; i++ {
+		msgs = append(msgs, h.NewTaskMessage("sync", nil))
+	}
+	h.SeedEnqueuedQueue(t, r, msgs)
+
+	var (
+		mu sync.Mutex // guards n
+		n  int        // number of times handler is called
+	)
+	handler := func(ctx context.Context, task *Task) error {
+		mu.Lock()
+		defer mu.Unlock()
+		n++
+		return fmt.Errorf("service unavailable")
+	}
+	const cooldown = time.Hour
+	// process one task at a time so that each result is recorded before the next task starts.
+	ps := base.NewProcessState("localhost", 1234, 1, defaultQueueConfig, false)
+	p := newProcessor(processorParams{
+		logger:         testLogger,
+		rdb:            rdbClient,
+		ps:             ps,
+		retryDelayFunc: func(n int, err error, t *Task) time.Duration { return time.Minute },
+		baseCtxFn:      context.Background,
+		cancelations:   base.NewCancelations(),
+		breaker:        newCircuitBreaker(2, cooldown),
+	})
+	p.handler = HandlerFunc(handler)
+	now := time.Now()
+
+	var wg sync.WaitGroup
+	p.start(&wg)
+	time.Sleep(time.Second) // wait for all tasks to be dequeued.
+	p.terminate()
+
+	mu.Lock()
+	if n != 2 {
+		t.Errorf("handler was called %d times, want 2", n)
+	}
+	mu.Unlock()
+
+	if got := len(h.GetRetryEntries(t, r)); got != 2 {
+		t.Errorf("%q has %d tasks, want 2", base.RetryQueue, got)
+	}
+	scheduled := h.GetScheduledEntries(t, r)
+	if len(scheduled) != 3 {
+		t.Fatalf("%q has %d tasks, want 3", base.ScheduledQueue, len(scheduled))
+	}
+	for _, e := range scheduled {
+		if e.Msg.Retried != 0 {
+			t.Errorf("postponed task has Retried=%d, want 0", e.Msg.Retried)
+		}
+		if want := now.Add(cooldown).Unix(); int64(e.Score) < want-1 || int64(e.Score) > want+1 {
+			t.Errorf("postponed task is scheduled at %v, want %v", time.Unix(int64(e.Score), 0), time.Unix(want, 0))
+		}
+	}
+}
+
+func TestProcessorCallsDeadTaskHandler(t *testing.T) {
+	r := setup(t)
+	rdbClient := rdb.NewRDB(r)
+
+	m1 := h.NewTaskMessage("send_email", map[string]interface{}{"to": "user@example.com"})
+	m1.Retried = m1.Retry // m1 has reached its max retry count
+	m2 := h.NewTaskMessage("reindex", nil)
+	h.SeedEnqueuedQueue(t, r, []*base.TaskMessage{m1, m2})
+
+	errMsg := "something went wrong"
+	handler := func(ctx context.Context, task *Task) error {
+		return fmt.Errorf(errMsg)
+	}
+	var (
+		mu    sync.Mutex // guards tasks and infos
+		tasks []*Task
+		infos []*DeadTaskInfo
+	)
+	deadHandler := func(task *Task, info *DeadTaskInfo) {
+		mu.Lock()
+		defer mu.Unlock()
+		tasks = append(tasks, task)
+		infos = append(infos, info)
+	}
+	ps := base.NewProcessState("localhost", 1234, 10, defaultQueueConfig, false)
+	p := newProcessor(processorParams{
+		logger:         testLogger,
+		rdb:            rdbClient,
+		ps:             ps,
+		retryDelayFunc: func(n int, err error, t *Task) time.Duration { return time.Hour },
+		baseCtxFn:      context.Background,
+		cancelations:   base.NewCancelations(),
+		historySize:    10,
+		deadHandler:    DeadTaskHandlerFunc(deadHandler),
+	})
+	p.handler = HandlerFunc(handler)
+
+	var wg sync.WaitGroup
+	p.start(&wg)
+	time.Sleep(time.Second) // wait for two tasks to be processed.
+	p.terminate()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(infos) != 1 {
+		t.Fatalf("dead task handler was called %d times, want 1", len(infos))
+	}
+	wantTask := NewTask(m1.Type, m1.Payload)
+	if diff := cmp.Diff(wantTask, tasks[0], cmp.AllowUnexported(Payload{})); diff != "" {
+		t.Errorf("dead task handler was called with unexpected task; (-want, +got)\n%s", diff)
+	}
+	got := infos[0]
+	if got.ID != m1.ID.String() || got.Queue != m1.Queue || got.Retried != m1.Retried ||
+		got.MaxRetry != m1.Retry || got.ErrorMsg != errMsg {
+		t.Errorf("dead task handler was called with info %+v, want ID=%s Queue=%s Retried=%d MaxRetry=%d ErrorMsg=%q",
+			got, m1.ID, m1.Queue, m1.Retried, m1.Retry, errMsg)
+	}
+	if len(got.History) != 1 || got.History[0].ErrorMsg != errMsg {
+		t.Errorf("dead task handler was called with history %v, want one failed attempt", got.History)
+	}
+}
+
+func TestProcessorDeadTaskHandlerDoesNotBlockDequeue(t *testing.T) {
+	r := setup(t)
+	rdbClient := rdb.NewRDB(r)
+
+	m1 := h.NewTaskMessage("send_push", nil)
+	m1.StartBy = time.Now().Add(-time.Minute).Unix() // expired
+	m2 := h.NewTaskMessage("send_push", nil)
+	h.SeedEnqueuedQueue(t, r, []*base.TaskMessage{m1, m2})
+
+	processed := make(chan *Task, 1)
+	handler := func(ctx context.Context, task *Task) error {
+		processed <- task
+		return nil
+	}
+	unblock := make(chan struct{})
+	deadHandler := func(task *Task, info *DeadTaskInfo) {
+		<-unblock
+	}
+	ps := base.NewProcessState("localhost", 1234, 10, defaultQueueConfig, false)
+	p := newProcessor(processorParams{
+		logger:         testLogger,
+		rdb:            rdbClient,
+		ps:             ps,
+		retryDelayFunc: defaultDelayFunc,
+		baseCtxFn:      context.Background,
+		cancelations:   base.NewCancelations(),
+		deadHandler:    DeadTaskHandlerFunc(deadHandler),
+	})
+	p.handler = HandlerFunc(handler)
+
+	var wg sync.WaitGroup
+	p.start(&wg)
+	select {
+	case <-processed:
+	case <-time.After(3 * time.Second):
+		t.Errorf("task was not processed while the dead task handler was running")
+	}
+	close(unblock)
+	p.terminate()
+
+	if gotDead := h.GetDeadMessages(t, r); len(gotDead) != 1 || gotDead[0].ID != m1.ID {
+		t.Errorf("dead queue = %v, want only task %v", gotDead, m1.ID)
+	}
+}
+
+func TestProcessorKillsInvalidTask(t *testing.T) {
+	r := setup(t)
+	rdbClient := rdb.NewRDB(r)
+
+	m1 := h.NewTaskMessage("order:process", nil)
+	h.SeedEnqueuedQueue(t, r, []*base.TaskMessage{m1})
+
+	mux := NewServeMux()
+	mux.HandleFunc("order:process", func(ctx context.Context, t *Task) error {
+		return nil
+	})
+	mux.Validate("order:process", func(t *Task) error {
+		return fmt.Errorf("order_id is missing")
+	})
+	ps := base.NewProcessState("localhost", 1234, 10, defaultQueueConfig, false)
+	p := newProcessor(processorParams{
+		logger:         testLogger,
+		rdb:            rdbClient,
+		ps:             ps,
+		retryDelayFunc: defaultDelayFunc,
+		baseCtxFn:      context.Background,
+		cancelations:   base.NewCancelations(),
+	})
+	p.handler = mux
+
+	var wg sync.WaitGroup
+	p.start(&wg)
+	time.Sleep(time.Second) // wait for the task to be processed.
+	p.terminate()
+
+	if got := len(h.GetRetryEntries(t, r)); got != 0 {
+		t.Errorf("%q has %d tasks, want 0", base.RetryQueue, got)
+	}
+	gotDead := h.GetDeadMessages(t, r)
+	if len(gotDead) != 1 || gotDead[0].ID != m1.ID {
+		t.Fatalf("dead queue = %v, want only task %v", gotDead, m1.ID)
+	}
+	wantErr := `invalid task "order:process": order_id is missing`
+	if gotDead[0].ErrorMsg != wantErr {
+		t.Errorf("ErrorMsg of dead task = %q, want %q", gotDead[0].ErrorMsg, wantErr)
+	}
+}
+
+func TestProcessorSkipsCompletedTasks(t *testing.T) {
+	r := setup(t)
+	rdbClient := rdb.NewRDB(r)
+
+	m1 := h.NewTaskMessage("charge", nil)
+	m1.IdempotencyKey = "charge:1"
+	m2 := h.NewTaskMessage("charge", nil)
+	m2.IdempotencyKey = "charge:2" // completed before processing
+	m3 := h.NewTaskMessage("charge", nil)
+	h.SeedEnqueuedQueue(t, r, []*base.TaskMessage{m1, m2, m3})
+	if err := rdbClient.RecordCompletion(m2.IdempotencyKey, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		mu sync.Mutex // guards n
+		n  int        // number of times handler is called
+	)
+	handler := func(ctx context.Context, task *Task) error {
+		mu.Lock()
+		defer mu.Unlock()
+		n++
+		return nil
+	}
+	ps := base.NewProcessState("localhost", 1234, 10, defaultQueueConfig, false)
+	p := newProcessor(processorParams{
+		logger:            testLogger,
+		rdb:               rdbClient,
+		ps:                ps,
+		retryDelayFunc:    defaultDelayFunc,
+		baseCtxFn:         context.Background,
+		cancelations:      base.NewCancelations(),
+		idempotencyWindow: time.Hour,
+	})
+	p.handler = HandlerFunc(handler)
+
+	var wg sync.WaitGroup
+	p.start(&wg)
+	time.Sleep(time.Second) // wait for all tasks to be dequeued.
+	p.terminate()
+
+	mu.Lock()
+	if n != 2 {
+		t.Errorf("handler was called %d times, want 2", n)
+	}
+	mu.Unlock()
+	if l := r.LLen(base.DefaultQueue).Val(); l != 0 {
+		t.Errorf("%q has %d tasks, want 0", base.DefaultQueue, l)
+	}
+	completed, err := rdbClient.IsCompleted(m1.IdempotencyKey)
+	if err != nil || !completed {
+		t.Errorf("IsCompleted(%q) = %t, %v; want true, nil", m1.IdempotencyKey, completed, err)
+	}
+	// the skipped task is not counted as processed.
+	processedKey := base.ProcessedKey(time.Now())
+	if got := r.Get(processedKey).Val(); got != "2" {
+		t.Errorf("GET %q = %q, want 2", processedKey, got)
+	}
+}
+
+func TestProcessorWithNewerMessages(t *testing.T) {
+	r := setup(t)
+	rdbClient := rdb.NewRDB(r)
+
+	m1 := h.NewTaskMessage("send_push", nil)
+	m1.Version = base.MessageVersion + 1
+	m2 := h.NewTaskMessage("send_push", nil)
+	m2.Unknown = []byte(`{"Annotations":{"tenant":"acme"}}`)
+	// postponed for longer than unsupportedVersionTTL.
+	m3 := h.NewTaskMessage("send_push", nil)
+	m3.Version = base.MessageVersion + 1
+	m3.PostponedAt = time.Now().Add(-2 * unsupportedVersionTTL).Unix()
+	// created long ago, e.g. scheduled a few days ahead, but not postponed yet.
+	m4 := h.NewTaskMessage("send_push", nil)
+	m4.ID = xid.NewWithTime(time.Now().Add(-2 * unsupportedVersionTTL))
+	m4.Version = base.MessageVersion + 1
+	h.SeedEnqueuedQueue(t, r, []*base.TaskMessage{m1, m2, m3, m4})
+
+	var (
+		mu        sync.Mutex
+		processed []*Task
+		errs      []error
+	)
+	handler := func(ctx context.Context, task *Task) error {
+		mu.Lock()
+		defer mu.Unlock()
+		processed = append(processed, task)
+		return nil
+	}
+	errHandler := func(task *Task, err error, retried, maxRetry int) {
+		mu.Lock()
+		defer mu.Unlock()
+		errs = append(errs, err)
+	}
+	ps := base.NewProcessState("localhost", 1234, 10, defaultQueueConfig, false)
+	p := newProcessor(processorParams{
+		logger:         testLogger,
+		rdb:            rdbClient,
+		ps:             ps,
+		retryDelayFunc: defaultDelayFunc,
+		baseCtxFn:      context.Background,
+		errHandler:     ErrorHandlerFunc(errHandler),
+		cancelations:   base.NewCancelations(),
+	})
+	p.handler = HandlerFunc(handler)
+
+	var wg sync.WaitGroup
+	p.start(&wg)
+	time.Sleep(time.Second) // wait for all tasks to be dequeued.
+	p.terminate()
+
+	mu.Lock()
+	if len(processed) != 1 {
+		t.Errorf("processed %d tasks, want 1", len(processed))
+	}
+	if len(errs) != 3 {
+		t.Errorf("ErrorHandler was called with %v, want %v three times", errs, ErrUnsupportedVersion)
+	}
+	for _, err := range errs {
+		if err != ErrUnsupportedVersion {
+			t.Errorf("ErrorHandler was called with %v, want %v", err, ErrUnsupportedVersion)
+		}
+	}
+	mu.Unlock()
+
+	if got := h.GetInProgressMessages(t, r); len(got) != 0 {
+		t.Errorf("in-progress queue = %v, want empty", got)
+	}
+	// the postponed tasks record the time they were first postponed.
+	gotScheduled := h.GetScheduledMessages(t, r)
+	ignoreOpt := cmpopts.IgnoreFields(base.TaskMessage{}, "EnqueuedAt", "PostponedAt")
+	if diff := cmp.Diff([]*base.TaskMessage{m1, m4}, gotScheduled, h.SortMsgOpt, ignoreOpt); diff != "" {
+		t.Errorf("scheduled queue mismatch (-want,+got):\n%s", diff)
+	}
+	for _, msg := range gotScheduled {
+		if d := time.Now().Unix() - msg.PostponedAt; d < 0 || d > 5 {
+			t.Errorf("task %v PostponedAt = %d, want about now", msg.ID, msg.PostponedAt)
+		}
+	}
+	gotDead := h.GetDeadMessages(t, r)
+	if len(gotDead) != 1 || gotDead[0].ID != m3.ID || gotDead[0].ErrorMsg != ErrUnsupportedVersion.Error() {
+		t.Errorf("dead queue = %v, want only task %v with error %q", gotDead, m3.ID, ErrUnsupportedVersion)
+	}
+}
+
+func TestProcessorLimitsUnsupportedVersionReports(t *testing.T) {
+	r := setup(t)
+	var msgs []*base.TaskMessage
+	for i := 0; i < 3; i++ {
+		msg := h.NewTaskMessage("send_push", nil)
+		msg.Version = base.MessageVersion + 1
+		msgs = append(msgs, msg)
+	}
+	h.SeedEnqueuedQueue(t, r, msgs)
+
+	var (
+		mu sync.Mutex
+		n  int // number of times error handler is called
+	)
+	errHandler := func(task *Task, err error, retried, maxRetry int) {
+		mu.Lock()
+		defer mu.Unlock()
+		n++
+	}
+	p := newProcessor(processorParams{
+		logger:         testLogger,
+		rdb:            rdb.NewRDB(r),
+		ps:             base.NewProcessState("localhost", 1234, 10, defaultQueueConfig, false),
+		retryDelayFunc: defaultDelayFunc,
+		baseCtxFn:      context.Background,
+		errHandler:     ErrorHandlerFunc(errHandler),
+		cancelations:   base.NewCancelations(),
+		errLogInterval: time.Hour,
+	})
+	p.handler = HandlerFunc(func(ctx context.Context, task *Task) error { return nil })
+
+	var wg sync.WaitGroup
+	p.start(&wg)
+	time.Sleep(time.Second) // wait for all tasks to be dequeued.
+	p.terminate()
+
+	if got := len(h.GetScheduledMessages(t, r)); got != 3 {
+		t.Errorf("scheduled queue has %d tasks, want 3", got)
+	}
+	mu.Lock()
+	if n != 1 {
+		t.Errorf("ErrorHandler was called %d times within the error log interval, want 1", n)
+	}
+	mu.Unlock()
+}
+
+func TestProcessorReprocessesTask(t *testing.T) {
+	r := setup(t)
+	rdbClient := rdb.NewRDB(r)
+
+	m1 := h.NewTaskMessage("export_csv", map[string]interface{}{"page": 1})
+	h.SeedEnqueuedQueue(t, r, []*base.TaskMessage{m1})
+
+	var (
+		mu   sync.Mutex
+		errs []error
+	)
+	handler := func(ctx context.Context, task *Task) error {
+		page, err := task.Payload.GetInt("page")
+		if err != nil {
+			return err
+		}
+		return Reprocess(map[string]interface{}{"page": page + 1}, time.Minute)
+	}
+	errHandler := func(task *Task, err error, retried, maxRetry int) {
+		mu.Lock()
+		defer mu.Unlock()
+		errs = append(errs, err)
+	}
+	ps := base.NewProcessState("localhost", 1234, 10, defaultQueueConfig, false)
+	p := newProcessor(processorParams{
+		logger:         testLogger,
+		rdb:            rdbClient,
+		ps:             ps,
+		retryDelayFunc: defaultDelayFunc,
+		baseCtxFn:      context.Background,
+		errHandler:     ErrorHandlerFunc(errHandler),
+		cancelations:   base.NewCancelations(),
+	})
+	p.handler = HandlerFunc(handler)
+
+	var wg sync.WaitGroup
+	p.start(&wg)
+	start := time.Now()
+	time.Sleep(time.Second) // wait for the task to be processed.
+	p.terminate()
+
+	mu.Lock()
+	if len(errs) != 0 {
+		t.Errorf("ErrorHandler was called with %v, want not to be called", errs)
+	}
+	mu.Unlock()
+
+	if got := h.GetRetryMessages(t, r); len(got) != 0 {
+		t.Errorf("retry queue = %v, want empty", got)
+	}
+	gotScheduled := h.GetScheduledEntries(t, r)
+	if len(gotScheduled) != 1 {
+		t.Fatalf("scheduled queue = %v, want one task", gotScheduled)
+	}
+	got := gotScheduled[0]
+	if got.Msg.ID != m1.ID || got.Msg.Retried != 0 {
+		t.Errorf("scheduled task = %+v, want task id=%v with Retried 0", got.Msg, m1.ID)
+	}
+	if page := got.Msg.Payload["page"]; page != 2.0 {
+		t.Errorf("scheduled task has page %v, want 2", page)
+	}
+	if want := start.Add(time.Minute).Unix(); got.Score < float64(want-2) || got.Score > float64(want+2) {
+		t.Errorf("scheduled task is processed at %v, want about %v", int64(got.Score), want)
+	}
+}
+
+func TestProcessorWithUnhandledTaskPolicy(t *testing.T) {
+	r := setup(t)
+	rdbClient := rdb.NewRDB(r)
+
+	tests := []struct {
+		policy        UnhandledTaskPolicy
+		wantRetry     int
+		wantDead      int
+		wantUnhandled int
+	}{
+		{UnhandledTaskRetry, 1, 0, 0},
+		{UnhandledTaskKill, 0, 1, 0},
+		{UnhandledTaskRequeue, 0, 0, 1},
+	}
+
+	for _, tc := range tests {
+		h.FlushDB(t, r)
+		m1 := h.NewTaskMessage("order:proccess", nil) // misspelled type
+		h.SeedEnqueuedQueue(t, r, []*base.TaskMessage{m1})
+
+		mux := NewServeMux()
+		mux.HandleFunc("order:process", func(ctx context.Context, t *Task) error {
+			return nil
+		})
+		ps := base.NewProcessState("localhost", 1234, 10, defaultQueueConfig, false)
+		p := newProcessor(processorParams{
+			logger:         testLogger,
+			rdb:            rdbClient,
+			ps:             ps,
+			retryDelayFunc: defaultDelayFunc,
+			baseCtxFn:      context.Background,
+			cancelations:   base.NewCancelations(),
+			unhandled:      tc.policy,
+			unhandledQueue: "unhandled",
+		})
+		p.handler = mux
+
+		var wg sync.WaitGroup
+		p.start(&wg)
+		time.Sleep(time.Second) // wait for the task to be processed.
+		p.terminate()
+
+		if got := len(h.GetRetryEntries(t, r)); got != tc.wantRetry {
+			t.Errorf("with policy %d, %q has %d tasks, want %d", tc.policy, base.RetryQueue, got, tc.wantRetry)
+		}
+		if got := len(h.GetDeadEntries(t, r)); got != tc.wantDead {
+			t.Errorf("with policy %d, %q has %d tasks, want %d", tc.policy, base.DeadQueue, got, tc.wantDead)
+		}
+		gotUnhandled := h.GetEnqueuedMessages(t, r, "unhandled")
+		if len(gotUnhandled) != tc.wantUnhandled {
+			t.Errorf("with policy %d, %q has %d tasks, want %d", tc.policy, base.QueueKey("unhandled"), len(gotUnhandled), tc.wantUnhandled)
+		}
+		for _, msg := range gotUnhandled {
+			if msg.ID != m1.ID || msg.Retried != 0 {
+				t.Errorf("with policy %d, %q has task %+v, want task id=%v with Retried 0", tc.policy, base.QueueKey("unhandled"), msg, m1.ID)
+			}
+		}
+	}
+}
@@ -0,0 +1,68 @@
+// Copyright 2020 Kentaro Hibino. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package asynq
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"github.com/hibiken/asynq/internal/base"
+	"github.com/hibiken/asynq/internal/rdb"
+)
+
+// ctxKey is the type of keys for values in the context passed to the handler.
+type ctxKey int
+
+// progressKey is the context key for the progressReporter of the task.
+const progressKey ctxKey = 0
+
+// progressReporter records the progress of a task in redis.
+type progressReporter struct {
+	rdb *rdb.RDB
+	msg *base.TaskMessage
+}
+
+// withProgressReporter returns a copy of ctx from which ReportProgress
+// records the progress of the task.
+func withProgressReporter(ctx context.Context, r *rdb.RDB, msg *base.TaskMessage) context.Context {
+	return context.WithValue(ctx, progressKey, &progressReporter{rdb: r, msg: msg})
+}
+
+// ReportProgress records the progress of the task being processed,
+// given the context passed to the handler.
+//
+// progress can be any JSON serializable value, e.g. a percentage or
+// a map describing the current step of the task. Each call overwrites the
+// progress previously reported for the task.
+// The latest progress of a task in progress can be retrieved with
+// Inspector.GetTaskInfo.
+//
+// It returns an error if ctx is not a context passed to the handler by the
+// background, or if the progress could not be recorded.
+//
+// Example:
+//
+//	func (h *encodeHandler) ProcessTask(ctx context.Context, t *asynq.Task) error {
+//	    for i, chunk := range chunks {
+//	        encode(chunk)
+//	        asynq.ReportProgress(ctx, map[string]interface{}{"percent": (i + 1) * 100 / len(chunks)})
+//	    }
+//	    return nil
+//	}
+func ReportProgress(ctx context.Context, progress interface{}) error {
+	r, ok := ctx.Value(progressKey).(*progressReporter)
+	if !ok {
+		return errors.New("asynq: context is not passed to a task handler")
+	}
+	data, err := json.Marshal(progress)
+	if err != nil {
+		return err
+	}
+	return r.rdb.SetProgress(r.msg.ID.String(), &base.TaskProgress{
+		Retried: r.msg.Retried,
+		Data:    data,
+	})
+}
@@ -0,0 +1,101 @@
+// Copyright 2020 Kentaro Hibino. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package asynq
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	h "github.com/hibiken/asynq/internal/asynqtest"
+	"github.com/hibiken/asynq/internal/base"
+	"github.com/hibiken/asynq/internal/rdb"
+)
+
+func TestReportProgress(t *testing.T) {
+	r := setup(t)
+	rdbClient := rdb.NewRDB(r)
+	inspector := NewInspector(RedisClientOpt{
+		Addr: redisAddr,
+		DB:   redisDB,
+	})
+	defer inspector.Close()
+
+	m1 := h.NewTaskMessage("encode_video", nil)
+	h.SeedEnqueuedQueue(t, r, []*base.TaskMessage{m1})
+
+	reported := make(chan error)
+	finish := make(chan struct{})
+	handler := func(ctx context.Context, task *Task) error {
+		reported <- ReportProgress(ctx, map[string]interface{}{"percent": 45})
+		<-finish
+		return nil
+	}
+	ps := base.NewProcessState("localhost", 1234, 10, defaultQueueConfig, false)
+	p := newProcessor(processorParams{
+		logger:         testLogger,
+		rdb:            rdbClient,
+		ps:             ps,
+		retryDelayFunc: defaultDelayFunc,
+		baseCtxFn:      context.Background,
+		cancelations:   base.NewCancelations(),
+	})
+	p.handler = HandlerFunc(handler)
+
+	var wg sync.WaitGroup
+	p.start(&wg)
+	defer p.terminate()
+	defer close(finish)
+
+	select {
+	case err := <-reported:
+		if err != nil {
+			t.Fatalf("ReportProgress returned error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("handler was not called")
+	}
+
+	info, err := inspector.GetTaskInfo("default", m1.ID.String())
+	if err != nil {
+		t.Fatalf("GetTaskInfo returned error: %v", err)
+	}
+	if want := `{"percent":45}`; string(info.Progress) != want {
+		t.Errorf("GetTaskInfo returned progress %s, want %s", info.Progress, want)
+	}
+}
+
+func TestReportProgressIgnoresEarlierAttempts(t *testing.T) {
+	r := setup(t)
+	rdbClient := rdb.NewRDB(r)
+	inspector := NewInspector(RedisClientOpt{
+		Addr: redisAddr,
+		DB:   redisDB,
+	})
+	defer inspector.Close()
+
+	m1 := h.NewTaskMessage("encode_video", nil)
+	m1.Retried = 1
+	h.SeedInProgressQueue(t, r, []*base.TaskMessage{m1})
+	// progress reported by the first attempt.
+	if err := rdbClient.SetProgress(m1.ID.String(), &base.TaskProgress{Retried: 0, Data: []byte("90")}); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := inspector.GetTaskInfo("default", m1.ID.String())
+	if err != nil {
+		t.Fatalf("GetTaskInfo returned error: %v", err)
+	}
+	if info.Progress != nil {
+		t.Errorf("GetTaskInfo returned progress %s, want nil", info.Progress)
+	}
+}
+
+func TestReportProgressWithoutTaskContext(t *testing.T) {
+	if err := ReportProgress(context.Background(), 50); err == nil {
+		t.Error("ReportProgress with a context not passed to a handler returned nil error")
+	}
+}
@@ -0,0 +1,160 @@
+// Copyright 2020 Kentaro Hibino. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package asynq
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// panicError is the error of a task whose handler panicked.
+type panicError struct {
+	value interface{}
+	stack []byte
+}
+
+func (e *panicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.value)
+}
+
+// panicMonitor counts the panics recovered from the handler by task type,
+// and quarantines a task type once its handler panics threshold times
+// within the window.
+//
+// While a type is quarantined, tasks of the type should not be processed.
+//
+// panicMonitor is safe for concurrent use by multiple goroutines.
+type panicMonitor struct {
+	threshold int // zero means task types are never quarantined.
+	window    time.Duration
+	duration  time.Duration
+
+	mu          sync.Mutex             // guards fields below
+	counts      map[string]int64       // total number of panics by task type
+	recent      map[string][]time.Time // times of the panics within the window by task type
+	quarantined map[string]time.Time   // end of the quarantine by task type
+	stackLogged map[string]time.Time   // last time the stack of a panic was logged by task type
+}
+
+// defaultStackLogWindow is the period within which the stack of a panic is
+// logged once per task type, if the monitor has no window.
+const defaultStackLogWindow = time.Minute
+
+// newPanicMonitor returns a panicMonitor which quarantines a task type for
+// the duration once the handler panics threshold times within the window.
+// If threshold is zero, panics are counted but no type is quarantined.
+func newPanicMonitor(threshold int, window, duration time.Duration) *panicMonitor {
+	return &panicMonitor{
+		threshold:   threshold,
+		window:      window,
+		duration:    duration,
+		counts:      make(map[string]int64),
+		recent:      make(map[string][]time.Time),
+		quarantined: make(map[string]time.Time),
+		stackLogged: make(map[string]time.Time),
+	}
+}
+
+// record records a panic of the handler processing a task of the type,
+// and reports whether the panic quarantined the type.
+func (m *panicMonitor) record(typename string, now time.Time) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.counts[typename]++
+	if m.threshold <= 0 {
+		return false
+	}
+	if until, ok := m.quarantined[typename]; ok && now.Before(until) {
+		// already quarantined, panic of a task which started before.
+		return false
+	}
+	recent := append(m.recent[typename], now)
+	for len(recent) > 0 && !recent[0].After(now.Add(-m.window)) {
+		recent = recent[1:]
+	}
+	if len(recent) < m.threshold {
+		m.recent[typename] = recent
+		return false
+	}
+	delete(m.recent, typename)
+	m.quarantined[typename] = now.Add(m.duration)
+	return true
+}
+
+// logStack reports whether the stack of a panic of the handler processing
+// a task of the type should be logged, i.e. whether no stack of the type was
+// logged within the window, so that a handler which keeps panicking doesn't
+// flood the logs with the same stack.
+func (m *panicMonitor) logStack(typename string, now time.Time) bool {
+	window := m.window
+	if window <= 0 {
+		window = defaultStackLogWindow
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if last, ok := m.stackLogged[typename]; ok && now.Before(last.Add(window)) {
+		return false
+	}
+	m.stackLogged[typename] = now
+	return true
+}
+
+// quarantinedUntil reports whether the task type is quarantined at the
+// given time, and if so, when the quarantine ends.
+func (m *panicMonitor) quarantinedUntil(typename string, now time.Time) (time.Time, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	until, ok := m.quarantined[typename]
+	if !ok {
+		return time.Time{}, false
+	}
+	if !now.Before(until) {
+		delete(m.quarantined, typename)
+		return time.Time{}, false
+	}
+	return until, true
+}
+
+// release ends the quarantine of the task type, if any,
+// and reports whether the type was quarantined.
+func (m *panicMonitor) release(typename string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.quarantined[typename]
+	delete(m.quarantined, typename)
+	delete(m.recent, typename)
+	return ok
+}
+
+// panicCounts returns a copy of the number of panics by task type.
+func (m *panicMonitor) panicCounts() map[string]int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	res := make(map[string]int64, len(m.counts))
+	for typename, n := range m.counts {
+		res[typename] = n
+	}
+	return res
+}
+
+// PanicCounts returns the number of panics of the handler recovered by
+// the background since it was created, by task type.
+//
+// It is safe to call PanicCounts while the background processing is running,
+// e.g. from a goroutine exporting the counts to a metrics system.
+func (bg *Background) PanicCounts() map[string]int64 {
+	return bg.processor.panics.panicCounts()
+}
+
+// ReleaseQuarantine resumes processing of the task type quarantined because
+// its handler kept panicking, and reports whether the type was quarantined.
+// See Config.PanicQuarantineThreshold.
+//
+// Tasks of the type already moved to the scheduled queue are processed
+// when they were scheduled to.
+func (bg *Background) ReleaseQuarantine(typename string) bool {
+	return bg.processor.panics.release(typename)
+}
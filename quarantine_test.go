@@ -0,0 +1,103 @@
+// Copyright 2020 Kentaro Hibino. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package asynq
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPanicMonitor(t *testing.T) {
+	now := time.Now()
+	m := newPanicMonitor(3, time.Minute, 10*time.Minute)
+
+	// panics of other types and panics out of the window don't count.
+	m.record("sync", now.Add(-2*time.Minute))
+	m.record("sync", now.Add(-90*time.Second))
+	m.record("send_email", now)
+	if m.record("sync", now) {
+		t.Fatal("record quarantined sync after 1 panic within the window, want 3")
+	}
+	if m.record("sync", now) {
+		t.Fatal("record quarantined sync after 2 panics within the window, want 3")
+	}
+	if !m.record("sync", now.Add(time.Second)) {
+		t.Fatal("record did not quarantine sync after 3 panics within the window")
+	}
+	if m.record("sync", now.Add(time.Second)) {
+		t.Error("record reported quarantining an already quarantined type")
+	}
+	until, ok := m.quarantinedUntil("sync", now.Add(time.Minute))
+	if want := now.Add(time.Second + 10*time.Minute); !ok || !until.Equal(want) {
+		t.Errorf("quarantinedUntil(sync) = %v, %t; want %v, true", until, ok, want)
+	}
+	if _, ok := m.quarantinedUntil("send_email", now); ok {
+		t.Error("send_email is quarantined, want not")
+	}
+
+	want := map[string]int64{"sync": 6, "send_email": 1}
+	got := m.panicCounts()
+	if len(got) != len(want) || got["sync"] != want["sync"] || got["send_email"] != want["send_email"] {
+		t.Errorf("panicCounts() = %v, want %v", got, want)
+	}
+
+	// the quarantine ends after its duration or once released.
+	if _, ok := m.quarantinedUntil("sync", now.Add(11*time.Minute)); ok {
+		t.Error("sync is quarantined after the quarantine duration, want not")
+	}
+	m.record("sync", now.Add(12*time.Minute))
+	m.record("sync", now.Add(12*time.Minute))
+	m.record("sync", now.Add(12*time.Minute))
+	if _, ok := m.quarantinedUntil("sync", now.Add(12*time.Minute)); !ok {
+		t.Fatal("sync is not quarantined after 3 more panics")
+	}
+	if !m.release("sync") {
+		t.Error("release(sync) = false, want true")
+	}
+	if _, ok := m.quarantinedUntil("sync", now.Add(12*time.Minute)); ok {
+		t.Error("sync is quarantined after release, want not")
+	}
+	if m.release("send_email") {
+		t.Error("release(send_email) = true, want false")
+	}
+
+	// panics are counted without quarantine if the threshold is zero.
+	m = newPanicMonitor(0, time.Minute, time.Minute)
+	for i := 0; i < 10; i++ {
+		if m.record("sync", now) {
+			t.Fatal("record quarantined a type with zero threshold")
+		}
+	}
+	if got := m.panicCounts()["sync"]; got != 10 {
+		t.Errorf("panicCounts()[sync] = %d, want 10", got)
+	}
+}
+
+func TestPanicMonitorLogStack(t *testing.T) {
+	now := time.Now()
+	m := newPanicMonitor(3, time.Minute, 10*time.Minute)
+
+	if !m.logStack("sync", now) {
+		t.Error("logStack(sync) = false for the first panic, want true")
+	}
+	if m.logStack("sync", now.Add(30*time.Second)) {
+		t.Error("logStack(sync) = true within the window, want false")
+	}
+	if !m.logStack("send_email", now.Add(30*time.Second)) {
+		t.Error("logStack(send_email) = false for the first panic of the type, want true")
+	}
+	if !m.logStack("sync", now.Add(time.Minute)) {
+		t.Error("logStack(sync) = false after the window, want true")
+	}
+
+	// the stack is logged once a minute if the monitor has no window.
+	m = newPanicMonitor(0, 0, 0)
+	if !m.logStack("sync", now) {
+		t.Error("logStack(sync) = false for the first panic, want true")
+	}
+	if m.logStack("sync", now.Add(59*time.Second)) {
+		t.Error("logStack(sync) = true within a minute, want false")
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright 2020 Kentaro Hibino. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package asynq
+
+import (
+	"time"
+
+	"github.com/hibiken/asynq/internal/rdb"
+)
+
+// RedisStats holds the statistics of the connections to redis and of the
+// commands sent to redis, to tell whether redis or the handlers are slow.
+//
+// The counts and durations are cumulative, so rates and average latencies
+// should be computed from the difference between two RedisStats.
+type RedisStats struct {
+	// Statistics of the connection pool.
+	//
+	// They are all zero if the redis client does not report them.
+	Hits       uint32 // number of times a free connection was found in the pool
+	Misses     uint32 // number of times a free connection was not found in the pool
+	Timeouts   uint32 // number of times a wait for a connection timed out
+	TotalConns uint32 // number of connections in the pool
+	IdleConns  uint32 // number of idle connections in the pool
+	StaleConns uint32 // number of stale connections removed from the pool
+
+	// Commands holds the statistics of the commands by command name,
+	// e.g. "evalsha" for the scripts. The commands sent in a pipeline
+	// are recorded together as "pipeline".
+	//
+	// Commands are not recorded if the RedisConnOpt is a redis client,
+	// so that asynq doesn't add hooks to the client shared with the rest
+	// of the application.
+	Commands map[string]CommandStats
+}
+
+// CommandStats holds the statistics of a redis command.
+type CommandStats struct {
+	// Count is the number of times the command was sent.
+	Count int64
+
+	// Errors is the number of times the command failed.
+	// A nil reply is not counted as an error.
+	Errors int64
+
+	// TotalDuration is the total time taken by the command to complete,
+	// including the time to get a connection from the pool.
+	TotalDuration time.Duration
+}
+
+// AvgDuration returns the average time taken by the command to complete.
+func (s CommandStats) AvgDuration() time.Duration {
+	if s.Count == 0 {
+		return 0
+	}
+	return s.TotalDuration / time.Duration(s.Count)
+}
+
+func newRedisStats(s *rdb.ConnStats) *RedisStats {
+	stats := &RedisStats{Commands: make(map[string]CommandStats, len(s.Commands))}
+	if s.Pool != nil {
+		stats.Hits = s.Pool.Hits
+		stats.Misses = s.Pool.Misses
+		stats.Timeouts = s.Pool.Timeouts
+		stats.TotalConns = s.Pool.TotalConns
+		stats.IdleConns = s.Pool.IdleConns
+		stats.StaleConns = s.Pool.StaleConns
+	}
+	for name, c := range s.Commands {
+		stats.Commands[name] = CommandStats{
+			Count:         c.Count,
+			Errors:        c.Errors,
+			TotalDuration: c.TotalDuration,
+		}
+	}
+	return stats
+}
+
+// RedisStats returns the statistics of the connections to redis and of the
+// commands sent to redis by the client.
+func (c *Client) RedisStats() *RedisStats {
+	return newRedisStats(c.rdb.ConnStats())
+}
+
+// RedisStats returns the statistics of the connections to redis and of the
+// commands sent to redis by the background processing.
+//
+// It is safe to call RedisStats while the background processing is running,
+// e.g. from a goroutine exporting the statistics to a metrics system.
+func (bg *Background) RedisStats() *RedisStats {
+	return newRedisStats(bg.rdb.ConnStats())
+}
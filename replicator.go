@@ -0,0 +1,113 @@
+// Copyright 2020 Kentaro Hibino. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package asynq
+
+import (
+	"sync"
+	"time"
+
+	"github.com/hibiken/asynq/internal/log"
+	"github.com/hibiken/asynq/internal/rdb"
+)
+
+// replicator is responsible for copying the tasks enqueued to redis into
+// the replica, a secondary redis, so that the replica can take over if
+// redis is lost.
+//
+// Only one replicator at a time copies the tasks, while the others wait
+// to take over in case it stops.
+type replicator struct {
+	logger  *log.Logger
+	rdb     *rdb.RDB
+	replica *rdb.RDB
+
+	// owner identifies the replicator holding the lock to replicate.
+	owner string
+
+	// channel to communicate back to the long running "replicator" goroutine.
+	done chan struct{}
+
+	// interval between replications.
+	interval time.Duration
+}
+
+const (
+	// replicationBatchSize is the number of changes copied at a time.
+	replicationBatchSize = 1000
+
+	// maxReplicationBatches is the maximum number of batches copied
+	// at each interval, so that the replicator can shut down timely.
+	maxReplicationBatches = 10
+)
+
+func newReplicator(l *log.Logger, r, replica *rdb.RDB, owner string, interval time.Duration) *replicator {
+	return &replicator{
+		logger:   l,
+		rdb:      r,
+		replica:  replica,
+		owner:    owner,
+		done:     make(chan struct{}),
+		interval: interval,
+	}
+}
+
+func (r *replicator) terminate() {
+	r.logger.Info("Replicator shutting down...")
+	// Signal the replicator goroutine to stop.
+	r.done <- struct{}{}
+}
+
+func (r *replicator) start(wg *sync.WaitGroup) {
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-r.done:
+				// let another replicator take over right away.
+				if err := r.rdb.ReleaseReplicationLock(r.owner); err != nil {
+					r.logger.Error("could not release replication lock: %v", err)
+				}
+				r.replica.Close()
+				r.logger.Info("Replicator done")
+				return
+			case <-time.After(r.interval):
+				r.exec()
+			}
+		}
+	}()
+}
+
+func (r *replicator) exec() {
+	// Note: Don't keep the replication enabled while the replica is
+	// unreachable, so that the replication log doesn't grow unbounded.
+	if err := r.replica.Ping(); err != nil {
+		r.logger.Error("could not connect to replica: %v", err)
+		return
+	}
+	for i := 0; i < maxReplicationBatches; i++ {
+		// Note: Set TTL to be long enough so that the lock and the replication
+		// don't expire before we read again.
+		changes, err := r.rdb.ReadReplicationLog(r.owner, replicationBatchSize, 10*r.interval)
+		if err != nil {
+			r.logger.Error("could not read replication log: %v", err)
+			return
+		}
+		if len(changes) == 0 {
+			return
+		}
+		if err := r.replica.ApplyReplication(changes); err != nil {
+			r.logger.Error("could not replicate tasks: %v", err)
+			return
+		}
+		if err := r.rdb.TrimReplicationLog(r.owner, len(changes)); err != nil {
+			r.logger.Error("could not trim replication log: %v", err)
+			return
+		}
+		if len(changes) < replicationBatchSize {
+			return
+		}
+	}
+}
@@ -0,0 +1,78 @@
+// Copyright 2020 Kentaro Hibino. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package asynq
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	h "github.com/hibiken/asynq/internal/asynqtest"
+	"github.com/hibiken/asynq/internal/rdb"
+)
+
+func TestReplicator(t *testing.T) {
+	r := setup(t)
+	replicaOpt := RedisClientOpt{Addr: redisAddr, DB: 11}
+	replica := createRedisClient(replicaOpt)
+	h.FlushDB(t, replica)
+	client := NewClient(RedisClientOpt{Addr: redisAddr, DB: redisDB})
+	inspector := NewInspector(RedisClientOpt{Addr: redisAddr, DB: redisDB})
+	defer inspector.Close()
+	replicaInspector := NewInspector(replicaOpt)
+	defer replicaInspector.Close()
+
+	const interval = 100 * time.Millisecond
+	rep := newReplicator(testLogger, rdb.NewRDB(r), rdb.NewRDB(replica), "localhost:1234", interval)
+	var wg sync.WaitGroup
+	rep.start(&wg)
+	defer func() {
+		rep.terminate()
+		wg.Wait()
+	}()
+
+	// wait for the replication to be enabled.
+	time.Sleep(2 * interval)
+	s, err := inspector.ReplicationStatus()
+	if err != nil {
+		t.Fatalf("ReplicationStatus returned error: %v", err)
+	}
+	if !s.Enabled {
+		t.Fatalf("ReplicationStatus().Enabled = false, want true")
+	}
+
+	if err := client.Enqueue(NewTask("send_email", nil)); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.EnqueueIn(time.Hour, NewTask("send_reminder", nil)); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(3 * interval)
+
+	rs, err := replicaInspector.ReplicaStatus()
+	if err != nil {
+		t.Fatalf("ReplicaStatus returned error: %v", err)
+	}
+	if rs.Tasks != 2 || time.Since(rs.LastSynced) > time.Minute {
+		t.Errorf("ReplicaStatus() = %+v, want 2 tasks synced within a minute", rs)
+	}
+	if s, _ := inspector.ReplicationStatus(); s.Backlog != 0 || s.Lag != 0 {
+		t.Errorf("ReplicationStatus() = %+v, want no backlog", s)
+	}
+
+	n, err := replicaInspector.PromoteReplica()
+	if err != nil {
+		t.Fatalf("PromoteReplica returned error: %v", err)
+	}
+	if n != 2 {
+		t.Errorf("PromoteReplica() = %d, want 2", n)
+	}
+	if got := len(h.GetEnqueuedMessages(t, replica)); got != 1 {
+		t.Errorf("replica has %d enqueued tasks after promotion, want 1", got)
+	}
+	if got := len(h.GetScheduledMessages(t, replica)); got != 1 {
+		t.Errorf("replica has %d scheduled tasks after promotion, want 1", got)
+	}
+}
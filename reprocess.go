@@ -0,0 +1,44 @@
+// Copyright 2020 Kentaro Hibino. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package asynq
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Reprocess returns an error which tells the background to process the task
+// again after the given delay, with the given payload.
+//
+// A handler can return it to continue a long job in chunks, e.g. to process
+// one page of a paginated API and reprocess the task with the cursor of
+// the next page in the payload. The task is not counted as failed or retried,
+// and the ErrorHandler is not called for it.
+//
+// The task keeps its ID and other options such as the queue and max retry.
+func Reprocess(payload map[string]interface{}, delay time.Duration) error {
+	return &reprocessError{payload: payload, delay: delay}
+}
+
+// reprocessError is the error returned by Reprocess.
+type reprocessError struct {
+	payload map[string]interface{}
+	delay   time.Duration
+}
+
+func (e *reprocessError) Error() string {
+	return fmt.Sprintf("reprocess in %v", e.delay)
+}
+
+// reprocessOf reports the directive given to Reprocess, if err or any
+// error it wraps was returned by Reprocess.
+func reprocessOf(err error) (*reprocessError, bool) {
+	var e *reprocessError
+	if !errors.As(err, &e) {
+		return nil, false
+	}
+	return e, true
+}
@@ -22,21 +22,18 @@ type scheduler struct {
 	// poll interval on average
 	avgInterval time.Duration
 
-	// list of queues to move the tasks into.
-	qnames []string
+	// max number of retry tasks to move to their queues at each poll.
+	// Zero means no limit.
+	retryLimit int
 }
 
-func newScheduler(l *log.Logger, r *rdb.RDB, avgInterval time.Duration, qcfg map[string]int) *scheduler {
-	var qnames []string
-	for q := range qcfg {
-		qnames = append(qnames, q)
-	}
+func newScheduler(l *log.Logger, r *rdb.RDB, avgInterval time.Duration, retryLimit int) *scheduler {
 	return &scheduler{
 		logger:      l,
 		rdb:         r,
 		done:        make(chan struct{}),
 		avgInterval: avgInterval,
-		qnames:      qnames,
+		retryLimit:  retryLimit,
 	}
 }
 
@@ -64,7 +61,7 @@ func (s *scheduler) start(wg *sync.WaitGroup) {
 }
 
 func (s *scheduler) exec() {
-	if err := s.rdb.CheckAndEnqueue(s.qnames...); err != nil {
+	if _, err := s.rdb.CheckAndEnqueue(s.retryLimit); err != nil {
 		s.logger.Error("Could not enqueue scheduled tasks: %v", err)
 	}
 }
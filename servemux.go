@@ -6,6 +6,7 @@ package asynq
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"sort"
 	"strings"
@@ -22,10 +23,19 @@ import (
 // the latter handler will be called for tasks with a type name beginning with
 // "images:thumbnails" and the former will receive tasks with type name beginning
 // with "images".
+//
+// Validators registered for a task type are called before the handler,
+// and tasks which fail validation are moved to the dead queue without
+// being retried.
+//
+// Tasks which match no pattern are passed to the handler registered with
+// HandleNotFound, or to NotFoundHandler by default.
 type ServeMux struct {
 	mu sync.RWMutex
 	m  map[string]muxEntry
 	es []muxEntry // slice of entries sorted from longest to shortest.
+	vs map[string]func(*Task) error
+	nf Handler // handler for tasks which match no pattern, nil for NotFoundHandler.
 }
 
 type muxEntry struct {
@@ -40,29 +50,68 @@ func NewServeMux() *ServeMux {
 
 // ProcessTask dispatches the task to the handler whose
 // pattern most closely matches the task type.
+//
+// If a validator is registered for the task type, ProcessTask
+// validates the task first and returns an error without calling the
+// handler if the validation fails.
 func (mux *ServeMux) ProcessTask(ctx context.Context, task *Task) error {
+	if err := mux.validate(task); err != nil {
+		return err
+	}
 	h, _ := mux.Handler(task)
 	return h.ProcessTask(ctx, task)
 }
 
+func (mux *ServeMux) validate(t *Task) error {
+	mux.mu.RLock()
+	fn, ok := mux.vs[t.Type]
+	mux.mu.RUnlock()
+	if !ok {
+		return nil
+	}
+	if err := fn(t); err != nil {
+		return &invalidTaskError{typename: t.Type, err: err}
+	}
+	return nil
+}
+
 // Handler returns the handler to use for the given task.
 // It always return a non-nil handler.
 //
 // Handler also returns the registered pattern that matches the task.
 //
 // If there is no registered handler that applies to the task,
-// handler returns a 'not found' handler which returns an error.
+// handler returns the handler registered with HandleNotFound,
+// or a 'not found' handler which returns an error.
 func (mux *ServeMux) Handler(t *Task) (h Handler, pattern string) {
 	mux.mu.RLock()
 	defer mux.mu.RUnlock()
 
 	h, pattern = mux.match(t.Type)
 	if h == nil {
-		h, pattern = NotFoundHandler(), ""
+		h, pattern = mux.nf, ""
+	}
+	if h == nil {
+		h = NotFoundHandler()
 	}
 	return h, pattern
 }
 
+// HandleNotFound registers the handler for tasks whose type matches
+// no registered pattern, e.g. to report or drop tasks of deprecated types.
+//
+// A handler which returns an error made by NotFound lets the background
+// handle the task as specified by Config.UnhandledTaskPolicy.
+func (mux *ServeMux) HandleNotFound(handler Handler) {
+	mux.mu.Lock()
+	defer mux.mu.Unlock()
+
+	if handler == nil {
+		panic("asynq: nil handler")
+	}
+	mux.nf = handler
+}
+
 // Find a handler on a handler map given a typename string.
 // Most-specific (longest) pattern wins.
 func (mux *ServeMux) match(typename string) (h Handler, pattern string) {
@@ -130,9 +179,75 @@ func (mux *ServeMux) HandleFunc(pattern string, handler func(context.Context, *T
 	mux.Handle(pattern, HandlerFunc(handler))
 }
 
+// Validate registers the validator function for the given task type.
+// If a validator already exists for the task type, Validate panics.
+//
+// The validator is called with each task of the type before the handler.
+// If it returns an error, the task is moved to the dead queue with the error
+// without being retried, since retrying a task with an invalid payload
+// can never succeed.
+//
+// Unlike patterns of handlers, typename must match the task type exactly.
+func (mux *ServeMux) Validate(typename string, fn func(*Task) error) {
+	mux.mu.Lock()
+	defer mux.mu.Unlock()
+
+	if typename == "" {
+		panic("asynq: invalid task type")
+	}
+	if fn == nil {
+		panic("asynq: nil validator")
+	}
+	if _, exist := mux.vs[typename]; exist {
+		panic("asynq: multiple validators for " + typename)
+	}
+
+	if mux.vs == nil {
+		mux.vs = make(map[string]func(*Task) error)
+	}
+	mux.vs[typename] = fn
+}
+
+// invalidTaskError is returned by ServeMux for tasks which failed validation.
+type invalidTaskError struct {
+	typename string
+	err      error
+}
+
+func (e *invalidTaskError) Error() string {
+	return fmt.Sprintf("invalid task %q: %v", e.typename, e.err)
+}
+
+func (e *invalidTaskError) Unwrap() error { return e.err }
+
+// isInvalidTask reports whether err or any error it wraps
+// is returned for a task which failed validation.
+func isInvalidTask(err error) bool {
+	var e *invalidTaskError
+	return errors.As(err, &e)
+}
+
 // NotFound returns an error indicating that the handler was not found for the given task.
+//
+// Tasks for which the handler returns the error are handled as specified
+// by Config.UnhandledTaskPolicy.
 func NotFound(ctx context.Context, task *Task) error {
-	return fmt.Errorf("handler not found for task %q", task.Type)
+	return &notFoundError{typename: task.Type}
+}
+
+// notFoundError is the error returned by NotFound.
+type notFoundError struct {
+	typename string
+}
+
+func (e *notFoundError) Error() string {
+	return fmt.Sprintf("handler not found for task %q", e.typename)
+}
+
+// isNotFound reports whether err or any error it wraps was returned by NotFound.
+func isNotFound(err error) bool {
+	var e *notFoundError
+	return errors.As(err, &e)
 }
 
 // NotFoundHandler returns a simple task handler that returns a ``not found`` error.
@@ -109,8 +109,76 @@ func TestServeMuxNotFound(t *testing.T) {
 	for _, tc := range notFoundTests {
 		task := NewTask(tc.typename, nil)
 		err := mux.ProcessTask(context.Background(), task)
-		if err == nil {
-			t.Errorf("ProcessTask did not return error for task %q, should return 'not found' error", task.Type)
+		if !isNotFound(err) {
+			t.Errorf("ProcessTask returned %v for task %q, should return 'not found' error", err, task.Type)
 		}
 	}
 }
+
+func TestServeMuxHandleNotFound(t *testing.T) {
+	mux := NewServeMux()
+	for _, e := range serveMuxRegister {
+		mux.Handle(e.pattern, e.h)
+	}
+	var called []string
+	mux.HandleNotFound(HandlerFunc(func(ctx context.Context, task *Task) error {
+		called = append(called, task.Type)
+		return nil
+	}))
+
+	for _, tc := range notFoundTests {
+		task := NewTask(tc.typename, nil)
+		if err := mux.ProcessTask(context.Background(), task); err != nil {
+			t.Errorf("ProcessTask returned error %v for task %q, want the not found handler to be called", err, task.Type)
+		}
+	}
+	if len(called) != len(notFoundTests) {
+		t.Errorf("not found handler was called %d times, want %d", len(called), len(notFoundTests))
+	}
+}
+
+func TestServeMuxValidate(t *testing.T) {
+	mux := NewServeMux()
+	for _, e := range serveMuxRegister {
+		mux.Handle(e.pattern, e.h)
+	}
+	mux.Validate("email:signup", func(t *Task) error {
+		_, err := t.Payload.GetString("to")
+		return err
+	})
+
+	tests := []struct {
+		task        *Task
+		wantCalled  string // identifier of the handler that should be called
+		wantInvalid bool   // whether the task should fail validation
+	}{
+		{NewTask("email:signup", map[string]interface{}{"to": "user@example.com"}), "signup email handler", false},
+		{NewTask("email:signup", nil), "", true},
+		{NewTask("email:daily", nil), "default email handler", false}, // validator is not applied to other types
+	}
+
+	for _, tc := range tests {
+		called = "" // reset to zero value
+
+		err := mux.ProcessTask(context.Background(), tc.task)
+		if got := isInvalidTask(err); got != tc.wantInvalid {
+			t.Errorf("ProcessTask(%q) returned %v; invalid %t, want %t", tc.task.Type, err, got, tc.wantInvalid)
+		}
+		if called != tc.wantCalled {
+			t.Errorf("%q handler was called for task %q, want %q to be called", called, tc.task.Type, tc.wantCalled)
+		}
+	}
+}
+
+func TestServeMuxRegisterDuplicateValidator(t *testing.T) {
+	defer func() {
+		if err := recover(); err == nil {
+			t.Error("expected call to mux.Validate to panic")
+		}
+	}()
+
+	mux := NewServeMux()
+	fn := func(t *Task) error { return nil }
+	mux.Validate("email:signup", fn)
+	mux.Validate("email:signup", fn)
+}
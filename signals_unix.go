@@ -0,0 +1,42 @@
+// Copyright 2020 Kentaro Hibino. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+//go:build aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+// +build aix darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package asynq
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/hibiken/asynq/internal/base"
+)
+
+// waitForSignals waits for signals and handles them.
+// It handles SIGTERM, SIGINT, and SIGTSTP.
+// SIGTERM and SIGINT will signal the process to exit.
+// SIGTSTP will signal the process to stop processing new tasks.
+// It also returns once Shutdown is called.
+func (bg *Background) waitForSignals() {
+	bg.logger.Info("Send signal TSTP to stop processing new tasks")
+	bg.logger.Info("Send signal TERM or INT to terminate the process")
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGTERM, syscall.SIGINT, syscall.SIGTSTP)
+	defer signal.Stop(sigs)
+	for {
+		select {
+		case sig := <-sigs:
+			if sig == syscall.SIGTSTP {
+				bg.processor.stop()
+				bg.ps.SetStatus(base.StatusStopped)
+				continue
+			}
+		case <-bg.shutdownCh:
+		}
+		return
+	}
+}
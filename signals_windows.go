@@ -0,0 +1,31 @@
+// Copyright 2020 Kentaro Hibino. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+//go:build windows
+// +build windows
+
+package asynq
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// waitForSignals waits for signals and handles them.
+// It handles SIGTERM and SIGINT.
+// SIGTERM and SIGINT will signal the process to exit.
+// It also returns once Shutdown is called.
+//
+// Note: Currently SIGTSTP is not supported for windows build.
+func (bg *Background) waitForSignals() {
+	bg.logger.Info("Send signal TERM or INT to terminate the process")
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGTERM, syscall.SIGINT)
+	defer signal.Stop(sigs)
+	select {
+	case <-sigs:
+	case <-bg.shutdownCh:
+	}
+}
@@ -0,0 +1,118 @@
+// Copyright 2020 Kentaro Hibino. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package asynq
+
+import "sync"
+
+// workerSlots keeps track of worker slots used by tasks from each queue
+// when some of the slots are reserved for specific queues.
+//
+// A task from a queue can occupy one of the slots reserved for the queue,
+// or one of the shared slots which are not reserved for any queue.
+//
+// workerSlots is safe for concurrent use by multiple goroutines.
+type workerSlots struct {
+	mu         sync.Mutex // guards fields below
+	reserved   map[string]int
+	used       map[string]int // number of reserved slots in use for each queue
+	shared     int
+	sharedUsed int
+	// sharedLimit is the number of shared slots which can be occupied,
+	// lowered below shared while workers are parked.
+	sharedLimit int
+
+	// releaseCh is notified when a slot is released.
+	releaseCh chan struct{}
+}
+
+// newWorkerSlots returns a workerSlots given the total number of slots
+// and the number of slots reserved for each queue.
+//
+// Sum of the reservations should not exceed the total number of slots.
+func newWorkerSlots(total int, reservations map[string]int) *workerSlots {
+	s := &workerSlots{
+		reserved:  make(map[string]int),
+		used:      make(map[string]int),
+		shared:    total,
+		releaseCh: make(chan struct{}, 1),
+	}
+	for qname, n := range reservations {
+		if n > 0 {
+			s.reserved[qname] = n
+			s.shared -= n
+		}
+	}
+	s.sharedLimit = s.shared
+	return s
+}
+
+// limit limits the number of occupied slots to n, by limiting the number of
+// shared slots which can be occupied, so that the reserved slots are always
+// available to their queues.
+func (s *workerSlots) limit(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, r := range s.reserved {
+		n -= r
+	}
+	if n < 0 {
+		n = 0
+	}
+	if n > s.shared {
+		n = s.shared
+	}
+	s.sharedLimit = n
+}
+
+// available returns the subset of the given queues whose tasks
+// can occupy a slot at the moment, preserving the order.
+func (s *workerSlots) available(qnames []string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sharedFree := s.sharedUsed < s.sharedLimit
+	var res []string
+	for _, qname := range qnames {
+		if sharedFree || s.used[qname] < s.reserved[qname] {
+			res = append(res, qname)
+		}
+	}
+	return res
+}
+
+// acquire occupies a slot for a task from the given queue and returns
+// a function to release the slot. Reserved slots are used before shared ones.
+//
+// Caller should make sure there is a slot available for the queue
+// by calling available first.
+func (s *workerSlots) acquire(qname string) (release func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.used[qname] < s.reserved[qname] {
+		s.used[qname]++
+		return func() { s.release(qname, false) }
+	}
+	s.sharedUsed++
+	return func() { s.release(qname, true) }
+}
+
+func (s *workerSlots) release(qname string, shared bool) {
+	s.mu.Lock()
+	if shared {
+		s.sharedUsed--
+	} else {
+		s.used[qname]--
+	}
+	s.mu.Unlock()
+	select {
+	case s.releaseCh <- struct{}{}:
+	default:
+		// there's already a pending notification.
+	}
+}
+
+// released returns a channel which receives a value when a slot is released.
+func (s *workerSlots) released() <-chan struct{} {
+	return s.releaseCh
+}
@@ -0,0 +1,102 @@
+// Copyright 2020 Kentaro Hibino. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package asynq
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestWorkerSlots(t *testing.T) {
+	qnames := []string{"critical", "default", "low"}
+	s := newWorkerSlots(3, map[string]int{"critical": 2})
+
+	if diff := cmp.Diff(qnames, s.available(qnames)); diff != "" {
+		t.Errorf("initially available() = %v, want %v", s.available(qnames), qnames)
+	}
+
+	// "low" occupies the only shared slot.
+	releaseLow := s.acquire("low")
+	want := []string{"critical"}
+	if diff := cmp.Diff(want, s.available(qnames)); diff != "" {
+		t.Errorf("after shared slot is taken, available() = %v, want %v; (-want,+got)\n%s",
+			s.available(qnames), want, diff)
+	}
+
+	// "critical" occupies both reserved slots.
+	releaseCritical1 := s.acquire("critical")
+	releaseCritical2 := s.acquire("critical")
+	if got := s.available(qnames); len(got) != 0 {
+		t.Errorf("after all slots are taken, available() = %v, want empty", got)
+	}
+
+	releaseCritical1()
+	select {
+	case <-s.released():
+	default:
+		t.Errorf("released() did not receive a notification after a slot was released")
+	}
+	if diff := cmp.Diff(want, s.available(qnames)); diff != "" {
+		t.Errorf("after reserved slot is released, available() = %v, want %v; (-want,+got)\n%s",
+			s.available(qnames), want, diff)
+	}
+
+	releaseLow()
+	releaseCritical2()
+	if diff := cmp.Diff(qnames, s.available(qnames)); diff != "" {
+		t.Errorf("after all slots are released, available() = %v, want %v", s.available(qnames), qnames)
+	}
+}
+
+func TestWorkerSlotsPrefersReservedSlots(t *testing.T) {
+	qnames := []string{"critical", "low"}
+	s := newWorkerSlots(2, map[string]int{"critical": 1})
+
+	// "critical" should take its reserved slot first leaving the shared slot for others.
+	s.acquire("critical")
+	want := []string{"critical", "low"}
+	if diff := cmp.Diff(want, s.available(qnames)); diff != "" {
+		t.Errorf("available() = %v, want %v; (-want,+got)\n%s", s.available(qnames), want, diff)
+	}
+
+	// second "critical" task takes the shared slot.
+	s.acquire("critical")
+	if got := s.available(qnames); len(got) != 0 {
+		t.Errorf("available() = %v, want empty", got)
+	}
+}
+
+func TestWorkerSlotsLimit(t *testing.T) {
+	qnames := []string{"critical", "low"}
+	s := newWorkerSlots(5, map[string]int{"critical": 2})
+
+	// only the shared slots are limited.
+	s.limit(3)
+	s.acquire("low")
+	want := []string{"critical"}
+	if diff := cmp.Diff(want, s.available(qnames)); diff != "" {
+		t.Errorf("after the only unlimited shared slot is taken, available() = %v, want %v; (-want,+got)\n%s",
+			s.available(qnames), want, diff)
+	}
+	s.acquire("critical")
+	s.acquire("critical")
+	if got := s.available(qnames); len(got) != 0 {
+		t.Errorf("after all slots within the limit are taken, available() = %v, want empty", got)
+	}
+
+	// the limit never takes reserved slots.
+	s = newWorkerSlots(5, map[string]int{"critical": 2})
+	s.limit(1)
+	if diff := cmp.Diff(want, s.available(qnames)); diff != "" {
+		t.Errorf("with a limit below the reservations, available() = %v, want %v; (-want,+got)\n%s",
+			s.available(qnames), want, diff)
+	}
+
+	s.limit(5)
+	if diff := cmp.Diff(qnames, s.available(qnames)); diff != "" {
+		t.Errorf("after the limit is raised, available() = %v, want %v", s.available(qnames), qnames)
+	}
+}
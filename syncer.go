@@ -5,10 +5,13 @@
 package asynq
 
 import (
+	"fmt"
 	"sync"
 	"time"
 
+	"github.com/hibiken/asynq/internal/base"
 	"github.com/hibiken/asynq/internal/log"
+	"github.com/hibiken/asynq/internal/rdb"
 )
 
 // syncer is responsible for queuing up failed requests to redis and retry
@@ -23,11 +26,77 @@ type syncer struct {
 
 	// interval between sync operations.
 	interval time.Duration
+
+	// maximum number of requests to buffer. If zero, the number is not limited.
+	maxRequests int
+
+	// journal persists failed requests. Set only if the journal is configured.
+	journal *syncJournal
+
+	// channel to query the tasks of the requests not synced yet.
+	pendingCh chan chan map[string]bool
 }
 
 type syncRequest struct {
 	fn     func() error // sync operation
 	errMsg string       // error message
+
+	// op describes the sync operation to persist it in the journal.
+	// nil if the request is not persisted.
+	op *syncOp
+}
+
+// syncKind is the kind of a sync operation.
+type syncKind string
+
+const (
+	syncDone      syncKind = "done"
+	syncSkip      syncKind = "skip"
+	syncRetry     syncKind = "retry"
+	syncKill      syncKind = "kill"
+	syncDefer     syncKind = "defer"
+	syncReprocess syncKind = "reprocess"
+	syncRequeueTo syncKind = "requeue_to"
+)
+
+// syncOp is a serializable description of a sync operation on a task.
+type syncOp struct {
+	Kind      syncKind               `json:"kind"`
+	Msg       *base.TaskMessage      `json:"msg"`
+	ProcessAt time.Time              `json:"process_at"`        // for retry, defer and reprocess
+	ErrMsg    string                 `json:"error_msg"`         // error of the task, for retry and kill
+	Payload   map[string]interface{} `json:"payload,omitempty"` // new payload of the task, for reprocess
+	Queue     string                 `json:"queue,omitempty"`   // queue to move the task to, for requeue_to
+}
+
+func (op *syncOp) run(r *rdb.RDB) error {
+	switch op.Kind {
+	case syncDone:
+		return r.Done(op.Msg)
+	case syncSkip:
+		return r.Skip(op.Msg)
+	case syncRetry:
+		return r.Retry(op.Msg, op.ProcessAt, op.ErrMsg)
+	case syncKill:
+		return r.Kill(op.Msg, op.ErrMsg)
+	case syncDefer:
+		return r.Defer(op.Msg, op.ProcessAt)
+	case syncReprocess:
+		return r.Reprocess(op.Msg, op.Payload, op.ProcessAt)
+	case syncRequeueTo:
+		return r.RequeueTo(op.Msg, op.Queue)
+	default:
+		return fmt.Errorf("unknown sync operation %q", op.Kind)
+	}
+}
+
+// newSyncRequest returns a syncRequest which runs the operation against redis.
+func newSyncRequest(r *rdb.RDB, op *syncOp, errMsg string) *syncRequest {
+	return &syncRequest{
+		fn:     func() error { return op.run(r) },
+		errMsg: errMsg,
+		op:     op,
+	}
 }
 
 func newSyncer(l *log.Logger, requestsCh <-chan *syncRequest, interval time.Duration) *syncer {
@@ -36,6 +105,7 @@ func newSyncer(l *log.Logger, requestsCh <-chan *syncRequest, interval time.Dura
 		requestsCh: requestsCh,
 		done:       make(chan struct{}),
 		interval:   interval,
+		pendingCh:  make(chan chan map[string]bool),
 	}
 }
 
@@ -45,24 +115,39 @@ func (s *syncer) terminate() {
 	s.done <- struct{}{}
 }
 
+// start starts the "syncer" goroutine.
+//
+// If the journal is configured, the requests persisted in the journal
+// are tried once before start returns, so that they are synced before
+// the processor restores unfinished tasks.
 func (s *syncer) start(wg *sync.WaitGroup) {
+	requests := s.load()
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		var requests []*syncRequest
 		for {
 			select {
 			case <-s.done:
 				// Try sync one last time before shutting down.
+				var temp []*syncRequest
 				for _, req := range requests {
 					if err := req.fn(); err != nil {
 						s.logger.Error(req.errMsg)
+						temp = append(temp, req)
 					}
 				}
+				s.save(temp)
 				s.logger.Info("Syncer done")
 				return
 			case req := <-s.requestsCh:
+				if s.maxRequests > 0 && len(requests) >= s.maxRequests {
+					s.logger.Error("Too many sync requests; Dropping the oldest request: %s", requests[0].errMsg)
+					requests = requests[1:]
+				}
 				requests = append(requests, req)
+				s.save(requests)
+			case ch := <-s.pendingCh:
+				ch <- pendingTasks(requests)
 			case <-time.After(s.interval):
 				var temp []*syncRequest
 				for _, req := range requests {
@@ -70,8 +155,68 @@ func (s *syncer) start(wg *sync.WaitGroup) {
 						temp = append(temp, req)
 					}
 				}
+				if len(temp) != len(requests) {
+					s.save(temp)
+				}
 				requests = temp
 			}
 		}
 	}()
 }
+
+// pendingTasks returns the IDs of the tasks which the requests operate on.
+func pendingTasks(requests []*syncRequest) map[string]bool {
+	res := make(map[string]bool)
+	for _, req := range requests {
+		if req.op != nil && req.op.Msg != nil {
+			res[req.op.Msg.ID.String()] = true
+		}
+	}
+	return res
+}
+
+// pendingTasks returns the IDs of the tasks whose state is not synced yet
+// by the requests received so far, including the requests loaded from the
+// journal, so that the tasks are not restored while their requests are
+// still to be synced.
+//
+// It must be called while the syncer goroutine is running.
+func (s *syncer) pendingTasks() map[string]bool {
+	ch := make(chan map[string]bool, 1)
+	s.pendingCh <- ch
+	return <-ch
+}
+
+// load returns the requests in the journal which failed again.
+func (s *syncer) load() []*syncRequest {
+	if s.journal == nil {
+		return nil
+	}
+	requests, err := s.journal.load()
+	if err != nil {
+		s.logger.Error("Could not load sync requests from %s: %v", s.journal.path, err)
+		return nil
+	}
+	if len(requests) == 0 {
+		return nil
+	}
+	s.logger.Info("Loaded %d sync requests from %s", len(requests), s.journal.path)
+	var temp []*syncRequest
+	for _, req := range requests {
+		if err := req.fn(); err != nil {
+			temp = append(temp, req)
+		}
+	}
+	s.save(temp)
+	return temp
+}
+
+// save persists the requests in the journal, if configured.
+func (s *syncer) save(requests []*syncRequest) {
+	if s.journal == nil {
+		return
+	}
+	if err := s.journal.save(requests); err != nil {
+		s.logger.Error("Could not save sync requests to %s: %v", s.journal.path, err)
+	}
+}
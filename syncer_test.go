@@ -5,11 +5,18 @@
 package asynq
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"sync"
 	"testing"
 	"time"
 
+	"github.com/go-redis/redis/v7"
+	"github.com/google/go-cmp/cmp"
 	h "github.com/hibiken/asynq/internal/asynqtest"
 	"github.com/hibiken/asynq/internal/base"
 	"github.com/hibiken/asynq/internal/rdb"
@@ -90,3 +97,167 @@ func TestSyncerRetry(t *testing.T) {
 	}
 	mu.Unlock()
 }
+
+func TestSyncerDropsOldestRequestOverLimit(t *testing.T) {
+	const interval = time.Second
+	syncRequestCh := make(chan *syncRequest)
+	syncer := newSyncer(testLogger, syncRequestCh, interval)
+	syncer.maxRequests = 2
+
+	var wg sync.WaitGroup
+	syncer.start(&wg)
+	defer syncer.terminate()
+
+	var (
+		mu     sync.Mutex
+		called []int
+	)
+	// Initial calls fail and retries succeed.
+	tried := make(map[int]bool)
+	for i := 0; i < 3; i++ {
+		i := i
+		syncRequestCh <- &syncRequest{
+			fn: func() error {
+				mu.Lock()
+				defer mu.Unlock()
+				if !tried[i] {
+					tried[i] = true
+					return fmt.Errorf("request %d failed", i)
+				}
+				called = append(called, i)
+				return nil
+			},
+			errMsg: fmt.Sprintf("request %d", i),
+		}
+	}
+	// Requests are tried only after the interval, so the first
+	// tries of all three requests fail at the same sync.
+	time.Sleep(3 * interval)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(called) != 2 || called[0] != 1 || called[1] != 2 {
+		t.Errorf("synced requests = %v, want [1 2]", called)
+	}
+	if tried[0] {
+		t.Errorf("dropped request 0 was tried")
+	}
+}
+
+func TestSyncerWithJournal(t *testing.T) {
+	msg := h.NewTaskMessage("send_email", nil)
+	r := setup(t)
+	h.SeedInProgressQueue(t, r, []*base.TaskMessage{msg})
+
+	dir, err := ioutil.TempDir("", "asynq")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "sync.journal")
+
+	// Redis is unreachable; the request is persisted when the syncer stops.
+	down := rdb.NewRDB(redis.NewClient(&redis.Options{Addr: "localhost:1"}))
+	syncRequestCh := make(chan *syncRequest)
+	syncer := newSyncer(testLogger, syncRequestCh, time.Hour)
+	syncer.journal = newSyncJournal(path, down)
+	var wg sync.WaitGroup
+	syncer.start(&wg)
+	syncRequestCh <- newSyncRequest(down, &syncOp{Kind: syncDone, Msg: msg}, "Could not mark as done")
+	syncer.terminate()
+	wg.Wait()
+
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("journal was not written: %v", err)
+	}
+
+	// Redis is reachable again; the request is synced when the syncer starts.
+	syncer = newSyncer(testLogger, syncRequestCh, time.Hour)
+	syncer.journal = newSyncJournal(path, rdb.NewRDB(r))
+	syncer.start(&wg)
+	defer syncer.terminate()
+
+	if got := h.GetInProgressMessages(t, r); len(got) != 0 {
+		t.Errorf("%q has %d tasks after loading the journal; want 0", base.InProgressQueue, len(got))
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("journal was not removed after syncing all requests: %v", err)
+	}
+}
+
+func TestProcessorRestoreSkipsPendingSyncRequests(t *testing.T) {
+	m1 := h.NewTaskMessage("send_email", nil)
+	m2 := h.NewTaskMessage("reindex", nil)
+	r := setup(t)
+	h.SeedInProgressQueue(t, r, []*base.TaskMessage{m1, m2})
+
+	dir, err := ioutil.TempDir("", "asynq")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "sync.journal")
+
+	// the request to mark m1 as done is persisted in the journal,
+	// and fails again when it's loaded at the next start.
+	down := rdb.NewRDB(redis.NewClient(&redis.Options{Addr: "localhost:1"}))
+	syncRequestCh := make(chan *syncRequest)
+	syncer := newSyncer(testLogger, syncRequestCh, time.Hour)
+	syncer.journal = newSyncJournal(path, down)
+	var wg sync.WaitGroup
+	syncer.start(&wg)
+	syncRequestCh <- newSyncRequest(down, &syncOp{Kind: syncDone, Msg: m1}, "Could not mark as done")
+	syncer.terminate()
+	wg.Wait()
+
+	syncer = newSyncer(testLogger, syncRequestCh, time.Hour)
+	syncer.journal = newSyncJournal(path, down)
+	syncer.start(&wg)
+	defer syncer.terminate()
+
+	p := newProcessor(processorParams{
+		logger:         testLogger,
+		rdb:            rdb.NewRDB(r),
+		ps:             base.NewProcessState("localhost", 1234, 10, defaultQueueConfig, false),
+		retryDelayFunc: defaultDelayFunc,
+		baseCtxFn:      context.Background,
+		syncCh:         syncRequestCh,
+		pendingSyncs:   syncer.pendingTasks,
+		cancelations:   base.NewCancelations(),
+	})
+	p.restore(true)
+
+	// m1 is left in progress to be marked as done by the journaled request.
+	if diff := cmp.Diff([]*base.TaskMessage{m1}, h.GetInProgressMessages(t, r)); diff != "" {
+		t.Errorf("mismatch found in %q after restore; (-want,+got)\n%s", base.InProgressQueue, diff)
+	}
+	if got := h.GetEnqueuedMessages(t, r); len(got) != 1 || got[0].ID != m2.ID {
+		t.Errorf("enqueued tasks after restore = %v, want %v", got, m2)
+	}
+}
+
+func TestSyncOpRequeueTo(t *testing.T) {
+	r := setup(t)
+	msg := h.NewTaskMessage("send_email", nil)
+	h.SeedInProgressQueue(t, r, []*base.TaskMessage{msg})
+
+	// the operation is run as it is loaded from the journal.
+	data, err := json.Marshal(&syncOp{Kind: syncRequeueTo, Msg: msg, Queue: "unhandled"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var op syncOp
+	if err := json.Unmarshal(data, &op); err != nil {
+		t.Fatal(err)
+	}
+	if err := op.run(rdb.NewRDB(r)); err != nil {
+		t.Fatalf("(*syncOp).run() returned error: %v", err)
+	}
+
+	if got := h.GetInProgressMessages(t, r); len(got) != 0 {
+		t.Errorf("%q has %d tasks, want 0", base.InProgressQueue, len(got))
+	}
+	if got := h.GetEnqueuedMessages(t, r, "unhandled"); len(got) != 1 || got[0].ID != msg.ID {
+		t.Errorf("%q = %v, want only task %v", base.QueueKey("unhandled"), got, msg.ID)
+	}
+}
@@ -0,0 +1,86 @@
+// Copyright 2020 Kentaro Hibino. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+	"github.com/hibiken/asynq/internal/rdb"
+	"github.com/rs/xid"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// attemptsCmd represents the attempts command
+var attemptsCmd = &cobra.Command{
+	Use:   "attempts [task id]",
+	Short: "Shows the execution history of the specified task",
+	Long: `Attempts (asynqmon attempts) will show the recorded attempts to process
+the specified task, in the order they happened.
+
+The command takes one argument which specifies the task to inspect.
+The task ID can be obtained by running "asynqmon ls" command.
+
+Attempts are recorded only if background processes are configured
+with a positive TaskHistorySize.
+
+Example: asynqmon attempts bnogo8gt6toe23vhef0g`,
+	Args: cobra.ExactArgs(1),
+	Run:  attempts,
+}
+
+func init() {
+	rootCmd.AddCommand(attemptsCmd)
+}
+
+func attempts(cmd *cobra.Command, args []string) {
+	id, err := parseTaskID(args[0])
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	c := redis.NewClient(&redis.Options{
+		Addr:     viper.GetString("uri"),
+		DB:       viper.GetInt("db"),
+		Password: viper.GetString("password"),
+	})
+	r := rdb.NewRDB(c)
+
+	history, err := r.TaskHistory(id.String())
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if len(history) == 0 {
+		fmt.Printf("No attempts recorded for task %v\n", id)
+		return
+	}
+	cols := []string{"Started", "Host", "PID", "Duration", "Error Message"}
+	printRows := func(w io.Writer, tmpl string) {
+		for _, a := range history {
+			fmt.Fprintf(w, tmpl, a.Started.Format(time.RFC3339), a.Host, a.PID, a.Duration, a.ErrorMsg)
+		}
+	}
+	printTable(cols, printRows)
+}
+
+// parseTaskID parses either a task ID or an identifier
+// used for "enq" command, and returns the task ID.
+func parseTaskID(s string) (xid.ID, error) {
+	if strings.Contains(s, ":") {
+		id, _, _, err := parseQueryID(s)
+		return id, err
+	}
+	id, err := xid.FromString(s)
+	if err != nil {
+		return xid.NilID(), fmt.Errorf("invalid id")
+	}
+	return id, nil
+}
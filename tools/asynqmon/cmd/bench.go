@@ -0,0 +1,215 @@
+// Copyright 2020 Kentaro Hibino. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hibiken/asynq"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	benchTasks       int
+	benchProducers   int
+	benchConcurrency int
+	benchPayloadSize int
+	benchWork        time.Duration
+	benchQueue       string
+	benchTimeout     time.Duration
+)
+
+// benchCmd represents the bench command
+var benchCmd = &cobra.Command{
+	Use:   "bench",
+	Short: "Generates load and reports throughput and latency",
+	Long: `Bench (asynqmon bench) will enqueue tasks and process them against the redis instance,
+and report the throughput and latency percentiles of enqueueing and processing.
+
+Tasks are enqueued by --producers goroutines and processed by a background
+process with --concurrency workers at the same time. Each task takes --work
+time to process. The processing latency is measured from the time the task is
+enqueued until the handler is called.
+
+The tasks are enqueued to a dedicated queue (--queue) which should not be
+processed by any other process. Run the command against a redis instance
+of the same size as in production to size redis and Concurrency.
+
+Example: asynqmon bench --tasks=100000 --concurrency=20 --work=10ms`,
+	Args: cobra.NoArgs,
+	Run:  bench,
+}
+
+func init() {
+	rootCmd.AddCommand(benchCmd)
+	benchCmd.Flags().IntVarP(&benchTasks, "tasks", "t", 10000, "number of tasks to enqueue and process")
+	benchCmd.Flags().IntVar(&benchProducers, "producers", 1, "number of goroutines to enqueue tasks")
+	benchCmd.Flags().IntVarP(&benchConcurrency, "concurrency", "c", 10, "number of workers to process tasks")
+	benchCmd.Flags().IntVar(&benchPayloadSize, "payload-size", 100, "size of the payload of each task in bytes")
+	benchCmd.Flags().DurationVar(&benchWork, "work", 0, "time to process each task")
+	benchCmd.Flags().StringVarP(&benchQueue, "queue", "q", "asynqmon_bench", "queue to enqueue tasks to")
+	benchCmd.Flags().DurationVar(&benchTimeout, "timeout", 10*time.Minute, "maximum time to wait for the tasks to be processed")
+}
+
+func bench(cmd *cobra.Command, args []string) {
+	if benchTasks < 1 || benchProducers < 1 || benchConcurrency < 1 {
+		fmt.Println("--tasks, --producers and --concurrency must be positive")
+		os.Exit(1)
+	}
+	opt := asynq.RedisClientOpt{
+		Addr:     viper.GetString("uri"),
+		DB:       viper.GetInt("db"),
+		Password: viper.GetString("password"),
+	}
+	bg := asynq.NewBackground(opt, &asynq.Config{
+		Concurrency: benchConcurrency,
+		Queues:      map[string]int{benchQueue: 1},
+		FailFast:    true,
+		LogLevels: map[asynq.LogCategory]asynq.LogLevel{
+			asynq.LogCategoryLifecycle: asynq.LogLevelWarn,
+		},
+	})
+
+	var (
+		mu        sync.Mutex
+		latencies []time.Duration // processing latencies
+		wg        sync.WaitGroup
+	)
+	wg.Add(benchTasks)
+	handler := func(ctx context.Context, t *asynq.Task) error {
+		enqueued, err := t.Payload.GetTime("enqueued_at")
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		latencies = append(latencies, time.Since(enqueued))
+		mu.Unlock()
+		time.Sleep(benchWork)
+		wg.Done()
+		return nil
+	}
+
+	// Run stops on an interrupt signal too; catch it here to report
+	// that the benchmark was interrupted.
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt)
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- bg.Run(asynq.HandlerFunc(handler))
+	}()
+
+	start := time.Now()
+	enqLatencies, err := enqueueBenchTasks(asynq.NewClient(opt))
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	enqDur := time.Since(start)
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case err := <-runErr:
+		fmt.Println(err)
+		os.Exit(1)
+	case <-sigs:
+		fmt.Println("Interrupted")
+		os.Exit(1)
+	case <-time.After(benchTimeout):
+		fmt.Printf("Timed out waiting for the tasks to be processed after %v\n", benchTimeout)
+		os.Exit(1)
+	}
+	procDur := time.Since(start)
+
+	// stop the background process.
+	bg.Shutdown()
+	<-runErr
+
+	fmt.Printf("Tasks: %d, Producers: %d, Concurrency: %d, Payload Size: %dB, Work: %v\n\n",
+		benchTasks, benchProducers, benchConcurrency, benchPayloadSize, benchWork)
+	cols := []string{"Operation", "Duration", "Throughput", "p50", "p90", "p99", "Max"}
+	printTable(cols, func(w io.Writer, tmpl string) {
+		printBenchRow(w, tmpl, "Enqueue", enqDur, enqLatencies)
+		printBenchRow(w, tmpl, "Process", procDur, latencies)
+	})
+}
+
+// enqueueBenchTasks enqueues the tasks with the producers,
+// and returns the latencies of the enqueue calls.
+func enqueueBenchTasks(client *asynq.Client) ([]time.Duration, error) {
+	payload := strings.Repeat("x", benchPayloadSize)
+	var (
+		mu        sync.Mutex
+		latencies []time.Duration
+		firstErr  error
+		wg        sync.WaitGroup
+	)
+	for i := 0; i < benchProducers; i++ {
+		// distribute the tasks among the producers.
+		n := benchTasks / benchProducers
+		if i < benchTasks%benchProducers {
+			n++
+		}
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			lats := make([]time.Duration, 0, n)
+			for j := 0; j < n; j++ {
+				start := time.Now()
+				t := asynq.NewTask("asynqmon:bench", map[string]interface{}{
+					"enqueued_at": start,
+					"data":        payload,
+				})
+				if err := client.Enqueue(t, asynq.Queue(benchQueue)); err != nil {
+					mu.Lock()
+					if firstErr == nil {
+						firstErr = fmt.Errorf("could not enqueue a task: %v", err)
+					}
+					mu.Unlock()
+					return
+				}
+				lats = append(lats, time.Since(start))
+			}
+			mu.Lock()
+			latencies = append(latencies, lats...)
+			mu.Unlock()
+		}(n)
+	}
+	wg.Wait()
+	return latencies, firstErr
+}
+
+func printBenchRow(w io.Writer, tmpl, op string, d time.Duration, latencies []time.Duration) {
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	throughput := fmt.Sprintf("%.1f tasks/s", float64(len(latencies))/d.Seconds())
+	fmt.Fprintf(w, tmpl, op, d.Round(time.Millisecond), throughput,
+		percentile(latencies, 50), percentile(latencies, 90), percentile(latencies, 99),
+		percentile(latencies, 100))
+}
+
+// percentile returns the p-th percentile of the sorted durations.
+func percentile(sorted []time.Duration, p int) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := (len(sorted)*p+99)/100 - 1
+	if i < 0 {
+		i = 0
+	}
+	return sorted[i].Round(time.Microsecond)
+}
@@ -25,16 +25,30 @@ The command takes one argument which specifies the task to cancel.
 The task should be in in-progress state.
 Identifier for a task should be obtained by running "asynqmon ls" command.
 
+Use --type option instead of the argument to cancel all in-progress tasks
+whose type matches the given pattern, in all background processes.
+In the pattern, '*' matches any sequence of characters and '?' matches any
+single character. Tasks which start processing after the command are not canceled.
+
 Handler implementation needs to be context aware for cancelation signal to
 actually cancel the processing.
 
-Example: asynqmon cancel bnogo8gt6toe23vhef0g`,
-	Args: cobra.ExactArgs(1),
-	Run:  cancel,
+Example: asynqmon cancel bnogo8gt6toe23vhef0g
+         asynqmon cancel --type "export:*"`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if cancelType != "" {
+			return cobra.NoArgs(cmd, args)
+		}
+		return cobra.ExactArgs(1)(cmd, args)
+	},
+	Run: cancel,
 }
 
+var cancelType string
+
 func init() {
 	rootCmd.AddCommand(cancelCmd)
+	cancelCmd.Flags().StringVarP(&cancelType, "type", "t", "", "pattern of task types to cancel")
 }
 
 func cancel(cmd *cobra.Command, args []string) {
@@ -44,6 +58,15 @@ func cancel(cmd *cobra.Command, args []string) {
 		Password: viper.GetString("password"),
 	}))
 
+	if cancelType != "" {
+		n, err := r.CancelTasksByType(cancelType)
+		if err != nil {
+			fmt.Printf("could not send cancelation signal: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Successfully sent cancelation signal for %d tasks\n", n)
+		return
+	}
 	err := r.PublishCancelation(args[0])
 	if err != nil {
 		fmt.Printf("could not send cancelation signal: %v\n", err)
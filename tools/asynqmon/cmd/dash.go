@@ -0,0 +1,196 @@
+// Copyright 2020 Kentaro Hibino. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+	"github.com/hibiken/asynq/internal/base"
+	"github.com/hibiken/asynq/internal/rdb"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var dashInterval time.Duration
+
+// dashCmd represents the dash command
+var dashCmd = &cobra.Command{
+	Use:   "dash",
+	Short: "Shows a live dashboard of queues and workers",
+	Long: `Dash (asynqmon dash) will show a dashboard which refreshes periodically, similar to top.
+
+Specifically, the dashboard shows the following:
+* Number of tasks in each queue, the change per second and the latency of the queue
+* Number of tasks in each state
+* Number of processed and failed tasks for the current day, per second and the error rate
+* Running processes and their active workers
+
+Press Ctrl-C to quit.
+
+Example: asynqmon dash --interval=5s -> Refreshes the dashboard every five seconds`,
+	Args: cobra.NoArgs,
+	Run:  dash,
+}
+
+func init() {
+	rootCmd.AddCommand(dashCmd)
+	dashCmd.Flags().DurationVarP(&dashInterval, "interval", "i", 2*time.Second, "interval between refreshes")
+}
+
+// dashSnapshot holds the data shown by the dashboard at a point in time.
+type dashSnapshot struct {
+	stats     *rdb.Stats
+	latencies map[string]time.Duration
+	processes []*base.ProcessInfo
+}
+
+func dash(cmd *cobra.Command, args []string) {
+	if dashInterval < time.Second {
+		dashInterval = time.Second
+	}
+	r := rdb.NewRDB(redis.NewClient(&redis.Options{
+		Addr:     viper.GetString("uri"),
+		DB:       viper.GetInt("db"),
+		Password: viper.GetString("password"),
+	}))
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt)
+	ticker := time.NewTicker(dashInterval)
+	defer ticker.Stop()
+
+	fmt.Print("\033[?25l") // hide cursor
+	defer fmt.Print("\033[?25h")
+
+	var prev *dashSnapshot
+	for {
+		var buf bytes.Buffer
+		cur, err := takeDashSnapshot(r)
+		if err != nil {
+			fmt.Fprintf(&buf, "Could not fetch data: %v\n", err)
+		} else {
+			renderDash(&buf, cur, prev)
+			prev = cur
+		}
+		// move the cursor to the top left and clear the screen before redrawing.
+		fmt.Print("\033[H\033[2J")
+		os.Stdout.Write(buf.Bytes())
+
+		select {
+		case <-sigs:
+			fmt.Println()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func takeDashSnapshot(r *rdb.RDB) (*dashSnapshot, error) {
+	stats, err := r.CurrentStats()
+	if err != nil {
+		return nil, err
+	}
+	latencies, err := r.Latencies()
+	if err != nil {
+		return nil, err
+	}
+	processes, err := r.ListProcesses()
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(processes, func(i, j int) bool {
+		x, y := processes[i], processes[j]
+		if x.Host != y.Host {
+			return x.Host < y.Host
+		}
+		return x.PID < y.PID
+	})
+	return &dashSnapshot{stats: stats, latencies: latencies, processes: processes}, nil
+}
+
+// renderDash writes the dashboard for the snapshot cur to w.
+// Changes per second are computed against the previous snapshot prev,
+// which is nil for the first refresh.
+func renderDash(w io.Writer, cur, prev *dashSnapshot) {
+	s := cur.stats
+	fmt.Fprintf(w, "asynqmon dash - %s UTC (every %v, press Ctrl-C to quit)\n\n",
+		s.Timestamp.UTC().Format("2006-01-02 15:04:05"), dashInterval)
+
+	// rate returns the change per second of the value, or "-" if unknown.
+	rate := func(now, before int) string {
+		if prev == nil {
+			return "-"
+		}
+		secs := s.Timestamp.Sub(prev.stats.Timestamp).Seconds()
+		if secs <= 0 {
+			return "-"
+		}
+		return fmt.Sprintf("%+.1f", float64(now-before)/secs)
+	}
+
+	fmt.Fprintln(w, "QUEUES")
+	var qnames []string
+	for qname := range s.Queues {
+		qnames = append(qnames, qname)
+	}
+	sort.Strings(qnames)
+	fprintTable(w, []string{"Queue", "Size", "Change/s", "Latency"}, func(w io.Writer, tmpl string) {
+		for _, qname := range qnames {
+			var before int
+			if prev != nil {
+				before = prev.stats.Queues[qname]
+			}
+			fmt.Fprintf(w, tmpl, qname, s.Queues[qname], rate(s.Queues[qname], before),
+				cur.latencies[qname].Round(time.Second))
+		}
+	})
+	fmt.Fprintln(w)
+
+	fmt.Fprintln(w, "STATES")
+	fprintTable(w, []string{"InProgress", "Enqueued", "Scheduled", "Retry", "Dead"}, func(w io.Writer, tmpl string) {
+		fmt.Fprintf(w, tmpl, s.InProgress, s.Enqueued, s.Scheduled, s.Retry, s.Dead)
+	})
+	fmt.Fprintln(w)
+
+	fmt.Fprintln(w, "TODAY")
+	fprintTable(w, []string{"Processed", "Processed/s", "Failed", "Failed/s", "Error Rate"}, func(w io.Writer, tmpl string) {
+		processedRate, failedRate := "-", "-"
+		// counts are reset at midnight UTC, so rates across days are unknown.
+		if prev != nil && s.Processed >= prev.stats.Processed && s.Failed >= prev.stats.Failed {
+			processedRate = rate(s.Processed, prev.stats.Processed)
+			failedRate = rate(s.Failed, prev.stats.Failed)
+		}
+		errrate := "N/A"
+		if s.Processed > 0 {
+			errrate = fmt.Sprintf("%.2f%%", float64(s.Failed)/float64(s.Processed)*100)
+		}
+		fmt.Fprintf(w, tmpl, s.Processed, processedRate, s.Failed, failedRate, errrate)
+	})
+	fmt.Fprintln(w)
+
+	fmt.Fprintln(w, "PROCESSES")
+	if len(cur.processes) == 0 {
+		fmt.Fprintln(w, "No processes")
+		return
+	}
+	var active, total int
+	fprintTable(w, []string{"Process", "State", "Active Workers", "Queues", "Started"}, func(w io.Writer, tmpl string) {
+		for _, ps := range cur.processes {
+			active += ps.ActiveWorkerCount
+			total += ps.Concurrency
+			fmt.Fprintf(w, tmpl, fmt.Sprintf("%s:%d", ps.Host, ps.PID), ps.Status,
+				fmt.Sprintf("%d/%d", ps.ActiveWorkerCount, ps.Concurrency),
+				formatQueues(ps.Queues), timeAgo(ps.Started))
+		}
+	})
+	fmt.Fprintf(w, "\nActive workers: %d/%d\n", active, total)
+}
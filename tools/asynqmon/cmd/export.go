@@ -0,0 +1,82 @@
+// Copyright 2020 Kentaro Hibino. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/hibiken/asynq"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// Flags
+var (
+	exportStates []string
+	exportOutput string
+)
+
+// exportCmd represents the export command
+var exportCmd = &cobra.Command{
+	Use:   "export [queue name]",
+	Short: "Exports the tasks of a queue to a file",
+	Long: `Export (asynqmon export) will write the tasks of the specified queue as
+newline delimited JSON, one task per line, to be imported with "asynqmon import",
+e.g. into another redis instance.
+
+By default, tasks in enqueued, scheduled, retry and dead states are exported.
+Use --states to export the tasks in some of the states only.
+Tasks are written to the standard output unless --output is given.
+
+Example: asynqmon export default --output=default.ndjson
+Example: asynqmon export critical --states=dead,retry > critical-failed.ndjson`,
+	Args: cobra.ExactArgs(1),
+	Run:  export,
+}
+
+func init() {
+	rootCmd.AddCommand(exportCmd)
+	exportCmd.Flags().StringSliceVar(&exportStates, "states", nil, "states of the tasks to export: enqueued, scheduled, retry or dead")
+	exportCmd.Flags().StringVarP(&exportOutput, "output", "o", "", "file to write the tasks to (default stdout)")
+}
+
+func export(cmd *cobra.Command, args []string) {
+	var states []asynq.TaskState
+	for _, s := range exportStates {
+		state, ok := searchStates[s]
+		if !ok || state == asynq.TaskStateInProgress {
+			fmt.Fprintf(os.Stderr, "invalid state %q: want one of enqueued, scheduled, retry or dead\n", s)
+			os.Exit(1)
+		}
+		states = append(states, state)
+	}
+	var w io.Writer = os.Stdout
+	if exportOutput != "" {
+		f, err := os.Create(exportOutput)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		w = f
+	}
+
+	i := asynq.NewInspector(asynq.RedisClientOpt{
+		Addr:     viper.GetString("uri"),
+		DB:       viper.GetInt("db"),
+		Password: viper.GetString("password"),
+	})
+	defer i.Close()
+
+	n, err := i.ExportTasks(w, args[0], states...)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	// the tasks may be written to stdout, so report to stderr.
+	fmt.Fprintf(os.Stderr, "Exported %d tasks from %q queue\n", n, args[0])
+}
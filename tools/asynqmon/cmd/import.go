@@ -0,0 +1,67 @@
+// Copyright 2020 Kentaro Hibino. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/hibiken/asynq"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var importQueue string
+
+// importCmd represents the import command
+var importCmd = &cobra.Command{
+	Use:   "import [file]",
+	Short: "Imports the tasks exported by the export command",
+	Long: `Import (asynqmon import) will read the tasks written by "asynqmon export"
+from the specified file, or from the standard input if the file is "-",
+and add them in their states.
+
+Tasks are added to their own queue unless --queue is given.
+Note that importing the same file twice enqueues the enqueued tasks twice.
+
+Example: asynqmon import default.ndjson
+Example: asynqmon import critical-failed.ndjson --queue=critical_recovered`,
+	Args: cobra.ExactArgs(1),
+	Run:  importTasks,
+}
+
+func init() {
+	rootCmd.AddCommand(importCmd)
+	importCmd.Flags().StringVarP(&importQueue, "queue", "q", "", "queue to import the tasks to instead of their own queue")
+}
+
+func importTasks(cmd *cobra.Command, args []string) {
+	var r io.Reader = os.Stdin
+	if args[0] != "-" {
+		f, err := os.Open(args[0])
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		r = f
+	}
+
+	i := asynq.NewInspector(asynq.RedisClientOpt{
+		Addr:     viper.GetString("uri"),
+		DB:       viper.GetInt("db"),
+		Password: viper.GetString("password"),
+	})
+	defer i.Close()
+
+	n, err := i.ImportTasks(r, importQueue)
+	if err != nil {
+		fmt.Printf("Imported %d tasks before the error\n", n)
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	fmt.Printf("Imported %d tasks\n", n)
+}
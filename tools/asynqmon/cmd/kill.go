@@ -10,6 +10,7 @@ import (
 
 	"github.com/go-redis/redis/v7"
 	"github.com/hibiken/asynq/internal/rdb"
+	"github.com/rs/xid"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
@@ -21,16 +22,21 @@ var killCmd = &cobra.Command{
 	Long: `Kill (asynqmon kill) will put a task in dead state given an identifier.
 
 The command takes one argument which specifies the task to kill.
-The task should be in either scheduled or retry state.
+The task should be in either enqueued, scheduled or retry state.
 Identifier for a task should be obtained by running "asynqmon ls" command.
+To kill an enqueued task, specify the queue of the task with --queue flag.
 
-Example: asynqmon kill r:1575732274:bnogo8gt6toe23vhef0g`,
+Example: asynqmon kill r:1575732274:bnogo8gt6toe23vhef0g
+Example: asynqmon kill --queue=default bnogo8gt6toe23vhef0g`,
 	Args: cobra.ExactArgs(1),
 	Run:  kill,
 }
 
+var killQueue string
+
 func init() {
 	rootCmd.AddCommand(killCmd)
+	killCmd.Flags().StringVarP(&killQueue, "queue", "q", "", "queue of the enqueued task to kill")
 
 	// Here you will define your flags and configuration settings.
 
@@ -44,16 +50,20 @@ func init() {
 }
 
 func kill(cmd *cobra.Command, args []string) {
-	id, score, qtype, err := parseQueryID(args[0])
-	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
-	}
 	r := rdb.NewRDB(redis.NewClient(&redis.Options{
 		Addr:     viper.GetString("uri"),
 		DB:       viper.GetInt("db"),
 		Password: viper.GetString("password"),
 	}))
+	if killQueue != "" {
+		killEnqueued(r, killQueue, args[0])
+		return
+	}
+	id, score, qtype, err := parseQueryID(args[0])
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
 	switch qtype {
 	case "s":
 		err = r.KillScheduledTask(id, score)
@@ -70,3 +80,16 @@ func kill(cmd *cobra.Command, args []string) {
 	fmt.Printf("Successfully killed %v\n", args[0])
 
 }
+
+func killEnqueued(r *rdb.RDB, qname, arg string) {
+	id, err := xid.FromString(arg)
+	if err != nil {
+		fmt.Println("invalid id")
+		os.Exit(1)
+	}
+	if err := r.KillEnqueuedTask(qname, id); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	fmt.Printf("Successfully killed %v\n", arg)
+}
@@ -9,12 +9,13 @@ import (
 	"os"
 
 	"github.com/go-redis/redis/v7"
+	"github.com/hibiken/asynq/internal/base"
 	"github.com/hibiken/asynq/internal/rdb"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
 
-var killallValidArgs = []string{"scheduled", "retry"}
+var killallValidArgs = []string{"enqueued", "scheduled", "retry"}
 
 // killallCmd represents the killall command
 var killallCmd = &cobra.Command{
@@ -22,16 +23,21 @@ var killallCmd = &cobra.Command{
 	Short: "Kills all tasks in the specified state",
 	Long: `Killall (asynqmon killall) will update all tasks from the specified state to dead state.
 
-The argument should be either "scheduled" or "retry".
+The argument should be one of "enqueued", "scheduled" or "retry".
+For enqueued tasks, specify the queue with --queue flag (defaults to "default" queue).
 
-Example: asynqmon killall retry -> Update all retry tasks to dead tasks`,
+Example: asynqmon killall retry -> Update all retry tasks to dead tasks
+Example: asynqmon killall enqueued --queue=low -> Update all tasks in "low" queue to dead tasks`,
 	ValidArgs: killallValidArgs,
 	Args:      cobra.ExactValidArgs(1),
 	Run:       killall,
 }
 
+var killallQueue string
+
 func init() {
 	rootCmd.AddCommand(killallCmd)
+	killallCmd.Flags().StringVarP(&killallQueue, "queue", "q", base.DefaultQueueName, "queue to kill enqueued tasks from")
 
 	// Here you will define your flags and configuration settings.
 
@@ -54,6 +60,8 @@ func killall(cmd *cobra.Command, args []string) {
 	var n int64
 	var err error
 	switch args[0] {
+	case "enqueued":
+		n, err = r.KillAllEnqueuedTasks(killallQueue)
 	case "scheduled":
 		n, err = r.KillAllScheduledTasks()
 	case "retry":
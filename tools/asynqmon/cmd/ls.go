@@ -68,7 +68,7 @@ func ls(cmd *cobra.Command, args []string) {
 		Password: viper.GetString("password"),
 	})
 	r := rdb.NewRDB(c)
-	parts := strings.Split(args[0], ":")
+	parts := strings.SplitN(args[0], ":", 2)
 	switch parts[0] {
 	case "enqueued":
 		if len(parts) != 2 {
@@ -0,0 +1,58 @@
+// Copyright 2020 Kentaro Hibino. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/go-redis/redis/v7"
+	"github.com/hibiken/asynq/internal/rdb"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// mvCmd represents the mv command
+var mvCmd = &cobra.Command{
+	Use:   "mv --from [queue name] --to [queue name]",
+	Short: "Moves enqueued tasks from one queue to another",
+	Long: `Mv (asynqmon mv) will move enqueued tasks from one queue to another.
+
+By default, it will move all tasks in the source queue.
+Use --type option to move only the tasks whose type matches the given pattern.
+In the pattern, '*' matches any sequence of characters and '?' matches any
+single character.
+
+Example: asynqmon mv --from low --to critical --type "export:*"
+         -> Moves enqueued tasks with type prefixed with "export:" from "low" queue to "critical" queue`,
+	Args: cobra.NoArgs,
+	Run:  mv,
+}
+
+var mvFrom, mvTo, mvType string
+
+func init() {
+	rootCmd.AddCommand(mvCmd)
+	mvCmd.Flags().StringVar(&mvFrom, "from", "", "queue to move tasks from")
+	mvCmd.Flags().StringVar(&mvTo, "to", "", "queue to move tasks to")
+	mvCmd.Flags().StringVarP(&mvType, "type", "t", "", "pattern of task types to move")
+	mvCmd.MarkFlagRequired("from")
+	mvCmd.MarkFlagRequired("to")
+}
+
+func mv(cmd *cobra.Command, args []string) {
+	c := redis.NewClient(&redis.Options{
+		Addr:     viper.GetString("uri"),
+		DB:       viper.GetInt("db"),
+		Password: viper.GetString("password"),
+	})
+	r := rdb.NewRDB(c)
+	n, err := r.MoveTasks(mvFrom, mvTo, mvType)
+	if err != nil {
+		fmt.Printf("error: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Moved %d tasks from %q queue to %q queue\n", n, mvFrom, mvTo)
+}
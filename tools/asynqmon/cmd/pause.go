@@ -0,0 +1,97 @@
+// Copyright 2020 Kentaro Hibino. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/go-redis/redis/v7"
+	"github.com/hibiken/asynq/internal/base"
+	"github.com/hibiken/asynq/internal/rdb"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// pauseCmd represents the pause command
+var pauseCmd = &cobra.Command{
+	Use:   "pause [host:pid]",
+	Short: "Pauses the specified background process from processing new tasks",
+	Long: `Pause (asynqmon pause) will tell the specified background process
+to stop pulling new tasks from queues.
+
+The command takes one argument which specifies the process in "host:pid" format.
+Identifier for a process can be obtained by running "asynqmon ps" command.
+
+Tasks that are already in progress will not be affected.
+Use "asynqmon resume" to resume processing.
+
+Example: asynqmon pause myhost:12345`,
+	Args: cobra.ExactArgs(1),
+	Run:  pause,
+}
+
+// resumeCmd represents the resume command
+var resumeCmd = &cobra.Command{
+	Use:   "resume [host:pid]",
+	Short: "Resumes the specified paused background process",
+	Long: `Resume (asynqmon resume) will tell the specified paused background process
+to start pulling new tasks from queues again.
+
+The command takes one argument which specifies the process in "host:pid" format.
+Identifier for a process can be obtained by running "asynqmon ps" command.
+
+Example: asynqmon resume myhost:12345`,
+	Args: cobra.ExactArgs(1),
+	Run:  resume,
+}
+
+func init() {
+	rootCmd.AddCommand(pauseCmd)
+	rootCmd.AddCommand(resumeCmd)
+}
+
+func pause(cmd *cobra.Command, args []string) {
+	sendControl(args[0], base.PauseCommand)
+	fmt.Printf("Successfully paused process %s\n", args[0])
+}
+
+func resume(cmd *cobra.Command, args []string) {
+	sendControl(args[0], base.ResumeCommand)
+	fmt.Printf("Successfully resumed process %s\n", args[0])
+}
+
+func sendControl(processID, command string) {
+	host, pid, err := parseProcessID(processID)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	r := rdb.NewRDB(redis.NewClient(&redis.Options{
+		Addr:     viper.GetString("uri"),
+		DB:       viper.GetInt("db"),
+		Password: viper.GetString("password"),
+	}))
+	if err := r.PublishControl(host, pid, command); err != nil {
+		fmt.Printf("could not send %s command: %v\n", command, err)
+		os.Exit(1)
+	}
+}
+
+// parseProcessID takes an identifier of the form "host:pid"
+// and returns each part of the identifier.
+func parseProcessID(processID string) (host string, pid int, err error) {
+	i := strings.LastIndex(processID, ":")
+	if i <= 0 {
+		return "", 0, fmt.Errorf("invalid process id %q; want host:pid", processID)
+	}
+	pid, err = strconv.Atoi(processID[i+1:])
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid process id %q; want host:pid", processID)
+	}
+	return processID[:i], pid, nil
+}
@@ -0,0 +1,51 @@
+// Copyright 2020 Kentaro Hibino. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/hibiken/asynq"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// promoteCmd represents the promote command
+var promoteCmd = &cobra.Command{
+	Use:   "promote",
+	Short: "Promotes a replica to process its tasks",
+	Long: `Promote (asynqmon promote) will add the tasks kept by a replica, configured
+with Config.Replica, to their queues so that background processes connected to
+the replica process them. Run it against the replica once the primary redis is lost.
+
+Tasks enqueued or processed in the primary shortly before it was lost may be
+missing or processed again, since tasks are replicated asynchronously.
+
+Example: asynqmon promote --uri=replica.example.com:6379`,
+	Args: cobra.NoArgs,
+	Run:  promote,
+}
+
+func init() {
+	rootCmd.AddCommand(promoteCmd)
+}
+
+func promote(cmd *cobra.Command, args []string) {
+	i := asynq.NewInspector(asynq.RedisClientOpt{
+		Addr:     viper.GetString("uri"),
+		DB:       viper.GetInt("db"),
+		Password: viper.GetString("password"),
+	})
+	defer i.Close()
+
+	n, err := i.PromoteReplica()
+	if err != nil {
+		fmt.Printf("Promoted %d tasks before the error\n", n)
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	fmt.Printf("Promoted %d tasks\n", n)
+}
@@ -29,11 +29,12 @@ The command shows the following for each process:
 * Host and PID of the process
 * Number of active workers out of worker pool
 * Queue configuration
-* State of the worker process ("running" | "stopped")
+* State of the worker process ("running" | "stopped" | "paused")
 * Time the process was started
 
 A "running" process is processing tasks in queues.
-A "stopped" process is no longer processing new tasks.`,
+A "stopped" process is no longer processing new tasks.
+A "paused" process is not processing new tasks until it's resumed.`,
 	Args: cobra.NoArgs,
 	Run:  ps,
 }
@@ -0,0 +1,71 @@
+// Copyright 2020 Kentaro Hibino. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/hibiken/asynq"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// replicationCmd represents the replication command
+var replicationCmd = &cobra.Command{
+	Use:   "replication",
+	Short: "Shows the status of the replication of tasks",
+	Long: `Replication (asynqmon replication) will show the status of the replication
+of the tasks into a replica configured with Config.Replica.
+
+Run it against the primary redis to show whether the tasks are replicated,
+the number of changes not replicated yet and the replication lag.
+Run it against the replica to show the number of tasks kept by the replica
+and the time of the last replicated change.
+
+Example: asynqmon replication
+Example: asynqmon replication --uri=replica.example.com:6379`,
+	Args: cobra.NoArgs,
+	Run:  replication,
+}
+
+func init() {
+	rootCmd.AddCommand(replicationCmd)
+}
+
+func replication(cmd *cobra.Command, args []string) {
+	i := asynq.NewInspector(asynq.RedisClientOpt{
+		Addr:     viper.GetString("uri"),
+		DB:       viper.GetInt("db"),
+		Password: viper.GetString("password"),
+	})
+	defer i.Close()
+
+	s, err := i.ReplicationStatus()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	rs, err := i.ReplicaStatus()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	fmt.Println("AS PRIMARY")
+	printTable([]string{"Enabled", "Backlog", "Lag"}, func(w io.Writer, tmpl string) {
+		fmt.Fprintf(w, tmpl, s.Enabled, s.Backlog, s.Lag.Round(time.Millisecond))
+	})
+	fmt.Println()
+	fmt.Println("AS REPLICA")
+	printTable([]string{"Tasks", "Last Synced"}, func(w io.Writer, tmpl string) {
+		synced := "never"
+		if !rs.LastSynced.IsZero() {
+			synced = timeAgo(rs.LastSynced)
+		}
+		fmt.Fprintf(w, tmpl, rs.Tasks, synced)
+	})
+}
@@ -0,0 +1,77 @@
+// Copyright 2020 Kentaro Hibino. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+	"github.com/hibiken/asynq/internal/rdb"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// rescheduleCmd represents the reschedule command
+var rescheduleCmd = &cobra.Command{
+	Use:   "reschedule [task id] [time]",
+	Short: "Changes the time to process a scheduled task",
+	Long: `Reschedule (asynqmon reschedule) will change the time to process a scheduled task
+given an identifier, keeping the task ID and the rest of the task intact.
+
+The command takes two arguments. The first argument specifies the task to reschedule.
+Identifier for a task should be obtained by running "asynqmon ls scheduled" command.
+The second argument specifies the new time to process the task, either as a
+RFC3339 timestamp or as a duration from now (a negative duration is in the past).
+
+Example: asynqmon reschedule s:1575732274:bnogo8gt6toe23vhef0g 2020-03-15T09:00:00Z
+Example: asynqmon reschedule s:1575732274:bnogo8gt6toe23vhef0g 30m`,
+	Args: cobra.ExactArgs(2),
+	Run:  reschedule,
+}
+
+func init() {
+	rootCmd.AddCommand(rescheduleCmd)
+}
+
+func reschedule(cmd *cobra.Command, args []string) {
+	id, _, qtype, err := parseQueryID(args[0])
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if qtype != "s" {
+		fmt.Println("only scheduled tasks can be rescheduled")
+		os.Exit(1)
+	}
+	processAt, err := parseTime(args[1], time.Now())
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	r := rdb.NewRDB(redis.NewClient(&redis.Options{
+		Addr:     viper.GetString("uri"),
+		DB:       viper.GetInt("db"),
+		Password: viper.GetString("password"),
+	}))
+	if err := r.RescheduleTask("", id, processAt); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	fmt.Printf("Successfully rescheduled %v to %v\n", args[0], processAt.Format(time.RFC3339))
+}
+
+// parseTime parses s as a RFC3339 timestamp or a duration from now.
+func parseTime(s string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(d), nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q: want a RFC3339 timestamp or a duration", s)
+	}
+	return t, nil
+}
@@ -18,8 +18,9 @@ import (
 var rmqCmd = &cobra.Command{
 	Use:   "rmq [queue name]",
 	Short: "Removes the specified queue",
-	Long: `Rmq (asynqmon rmq) will remove the specified queue.
-By default, it will remove the queue only if it's empty.
+	Long: `Rmq (asynqmon rmq) will remove the specified queue along with
+the scheduled, retry and dead tasks which belong to the queue.
+By default, it will remove the queue only if it has no tasks.
 Use --force option to override this behavior.
 
 Example: asynqmon rmq low -> Removes "low" queue`,
@@ -31,7 +32,7 @@ var rmqForce bool
 
 func init() {
 	rootCmd.AddCommand(rmqCmd)
-	rmqCmd.Flags().BoolVarP(&rmqForce, "force", "f", false, "remove the queue regardless of its size")
+	rmqCmd.Flags().BoolVarP(&rmqForce, "force", "f", false, "remove the queue and all of its tasks regardless of its size")
 }
 
 func rmq(cmd *cobra.Command, args []string) {
@@ -97,8 +97,13 @@ func initConfig() {
 // }
 // printTable(cols, printRows)
 func printTable(cols []string, printRows func(w io.Writer, tmpl string)) {
+	fprintTable(os.Stdout, cols, printRows)
+}
+
+// fprintTable is like printTable but writes the table to out.
+func fprintTable(out io.Writer, cols []string, printRows func(w io.Writer, tmpl string)) {
 	format := strings.Repeat("%v\t", len(cols)) + "\n"
-	tw := new(tabwriter.Writer).Init(os.Stdout, 0, 8, 2, ' ', 0)
+	tw := new(tabwriter.Writer).Init(out, 0, 8, 2, ' ', 0)
 	var headers []interface{}
 	var seps []interface{}
 	for _, name := range cols {
@@ -0,0 +1,175 @@
+// Copyright 2020 Kentaro Hibino. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hibiken/asynq"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var searchStates = map[string]asynq.TaskState{
+	"enqueued":   asynq.TaskStateEnqueued,
+	"inprogress": asynq.TaskStateInProgress,
+	"scheduled":  asynq.TaskStateScheduled,
+	"retry":      asynq.TaskStateRetry,
+	"dead":       asynq.TaskStateDead,
+}
+
+// Flags
+var (
+	searchQueue   string
+	searchType    string
+	searchPayload string
+	searchFields  []string
+	searchLabels  []string
+	searchError   string
+	searchAfter   string
+	searchBefore  string
+	searchSize    int
+	searchCursor  string
+)
+
+// searchCmd represents the search command
+var searchCmd = &cobra.Command{
+	Use:   "search [state]",
+	Short: "Searches tasks in the specified state",
+	Long: `Search (asynqmon search) will list the tasks in the specified state which match
+the given filters in a table format, along with a cursor to list the next tasks.
+
+The command takes one argument which specifies the state of tasks.
+The argument value should be one of "enqueued", "inprogress", "scheduled",
+"retry", or "dead". The queue (--queue) is required for enqueued tasks.
+
+Tasks are filtered by type pattern (--type), a string in the JSON encoded
+payload (--payload), payload field values (--field=path=value, repeatable),
+labels (--label=key=value, or --label=key for any value, repeatable),
+a string in the last error message (--error), and the time range the tasks
+were enqueued (--after, --before), given as RFC3339 timestamps or durations
+from now (a negative duration is in the past).
+
+Each call scans a limited number of tasks, so a page may be short.
+Pass the printed cursor with --cursor to continue.
+
+Example: asynqmon search dead --field=customer_id=123
+Example: asynqmon search retry --type="email:*" --error=SMTP --after=-24h
+Example: asynqmon search enqueued --queue=default --cursor=1fk
+Example: asynqmon search inprogress --label=tenant=acme`,
+	Args: cobra.ExactArgs(1),
+	Run:  search,
+}
+
+func init() {
+	rootCmd.AddCommand(searchCmd)
+	searchCmd.Flags().StringVarP(&searchQueue, "queue", "q", "", "queue of the tasks")
+	searchCmd.Flags().StringVarP(&searchType, "type", "t", "", "pattern of the task type, e.g. \"email:*\"")
+	searchCmd.Flags().StringVar(&searchPayload, "payload", "", "string contained in the JSON encoded payload")
+	searchCmd.Flags().StringArrayVar(&searchFields, "field", nil, "payload field value as path=value, e.g. customer.id=123")
+	searchCmd.Flags().StringArrayVar(&searchLabels, "label", nil, "label value as key=value, or key for any value")
+	searchCmd.Flags().StringVarP(&searchError, "error", "e", "", "string contained in the last error message")
+	searchCmd.Flags().StringVar(&searchAfter, "after", "", "list tasks enqueued at or after the time")
+	searchCmd.Flags().StringVar(&searchBefore, "before", "", "list tasks enqueued before the time")
+	searchCmd.Flags().IntVar(&searchSize, "size", 30, "maximum number of tasks to list")
+	searchCmd.Flags().StringVar(&searchCursor, "cursor", "", "cursor printed by the previous search")
+}
+
+func search(cmd *cobra.Command, args []string) {
+	state, ok := searchStates[args[0]]
+	if !ok {
+		fmt.Printf("error: `asynqmon search [state]`\nonly accepts %v as the argument.\n", lsValidArgs)
+		os.Exit(1)
+	}
+	q := &asynq.TaskQuery{
+		State:           state,
+		Queue:           searchQueue,
+		Type:            searchType,
+		PayloadContains: searchPayload,
+		ErrorContains:   searchError,
+		Size:            searchSize,
+		Cursor:          searchCursor,
+	}
+	for _, f := range searchFields {
+		parts := strings.SplitN(f, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			fmt.Printf("invalid field %q: want path=value\n", f)
+			os.Exit(1)
+		}
+		if q.PayloadFields == nil {
+			q.PayloadFields = make(map[string]string)
+		}
+		q.PayloadFields[parts[0]] = parts[1]
+	}
+	for _, l := range searchLabels {
+		parts := strings.SplitN(l, "=", 2)
+		if parts[0] == "" {
+			fmt.Printf("invalid label %q: want key=value or key\n", l)
+			os.Exit(1)
+		}
+		if q.Labels == nil {
+			q.Labels = make(map[string]string)
+		}
+		q.Labels[parts[0]] = ""
+		if len(parts) == 2 {
+			q.Labels[parts[0]] = parts[1]
+		}
+	}
+	now := time.Now()
+	var err error
+	if searchAfter != "" {
+		if q.EnqueuedAfter, err = parseTime(searchAfter, now); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+	if searchBefore != "" {
+		if q.EnqueuedBefore, err = parseTime(searchBefore, now); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+
+	i := asynq.NewInspector(asynq.RedisClientOpt{
+		Addr:     viper.GetString("uri"),
+		DB:       viper.GetInt("db"),
+		Password: viper.GetString("password"),
+	})
+	defer i.Close()
+
+	page, err := i.ListTasks(q)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if len(page.Tasks) == 0 {
+		fmt.Println("No matching tasks")
+	} else {
+		cols := []string{"ID", "Type", "Queue", "Labels", "Last Error"}
+		printTable(cols, func(w io.Writer, tmpl string) {
+			for _, t := range page.Tasks {
+				fmt.Fprintf(w, tmpl, t.ID, t.Type, t.Queue, formatLabels(t.Labels), t.LastErr)
+			}
+		})
+	}
+	if page.Cursor != "" {
+		fmt.Printf("\nMore tasks to search, continue with --cursor=%s\n", page.Cursor)
+	}
+}
+
+// formatLabels returns the labels as sorted, comma separated key=value pairs.
+func formatLabels(labels map[string]string) string {
+	pairs := make([]string, 0, len(labels))
+	for k, v := range labels {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
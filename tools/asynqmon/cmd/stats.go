@@ -6,13 +6,16 @@ package cmd
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"sort"
 	"strconv"
 	"strings"
 	"text/tabwriter"
+	"time"
 
 	"github.com/go-redis/redis/v7"
+	"github.com/hibiken/asynq"
 	"github.com/hibiken/asynq/internal/rdb"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -27,6 +30,8 @@ var statsCmd = &cobra.Command{
 Specifically, the command shows the following:
 * Number of tasks in each state
 * Number of tasks in each queue
+* Latency of each queue (time since the oldest task in the queue was enqueued)
+* Estimated redis memory usage of each queue (n/a if it can't be estimated)
 * Aggregate data for the current day
 * Basic information about the running redis instance
 
@@ -65,11 +70,34 @@ func stats(cmd *cobra.Command, args []string) {
 		fmt.Println(err)
 		os.Exit(1)
 	}
+	latencies, err := r.Latencies()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
 	info, err := r.RedisInfo()
 	if err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
+	inspector := asynq.NewInspector(c)
+	dequeues, err := inspector.DequeueStats()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	failures, err := inspector.FailureStats()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	// memory usage is shown as n/a if it can't be estimated,
+	// e.g. on redis servers without the MEMORY USAGE command.
+	usages := make(map[string]*rdb.MemoryUsage)
+	for qname := range stats.Queues {
+		u, _ := r.QueueMemoryUsage(qname) // nil on error
+		usages[qname] = u
+	}
 	fmt.Println("STATES")
 	printStates(stats)
 	fmt.Println()
@@ -78,6 +106,22 @@ func stats(cmd *cobra.Command, args []string) {
 	printQueues(stats.Queues)
 	fmt.Println()
 
+	fmt.Println("LATENCY")
+	printLatencies(latencies)
+	fmt.Println()
+
+	fmt.Println("MEMORY USAGE (ESTIMATED)")
+	printMemoryUsages(usages)
+	fmt.Println()
+
+	fmt.Println("DEQUEUE")
+	printDequeueStats(dequeues)
+	fmt.Println()
+
+	fmt.Println("FAILURE AGES")
+	printFailureStats(failures)
+	fmt.Println()
+
 	fmt.Printf("STATS FOR %s UTC\n", stats.Timestamp.UTC().Format("2006-01-02"))
 	printStats(stats)
 	fmt.Println()
@@ -114,6 +158,117 @@ func printQueues(queues map[string]int) {
 	tw.Flush()
 }
 
+func printLatencies(latencies map[string]time.Duration) {
+	var qnames, seps, lats []string
+	for q := range latencies {
+		qnames = append(qnames, strings.Title(q))
+	}
+	sort.Strings(qnames) // sort for stable order
+	for _, q := range qnames {
+		seps = append(seps, strings.Repeat("-", len(q)))
+		lats = append(lats, latencies[strings.ToLower(q)].Round(time.Second).String())
+	}
+	format := strings.Repeat("%v\t", len(qnames)) + "\n"
+	tw := new(tabwriter.Writer).Init(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, format, toInterfaceSlice(qnames)...)
+	fmt.Fprintf(tw, format, toInterfaceSlice(seps)...)
+	fmt.Fprintf(tw, format, toInterfaceSlice(lats)...)
+	tw.Flush()
+}
+
+func printMemoryUsages(usages map[string]*rdb.MemoryUsage) {
+	var qnames []string
+	for q := range usages {
+		qnames = append(qnames, q)
+	}
+	sort.Strings(qnames) // sort for stable order
+	cols := []string{"Queue", "Enqueued", "Scheduled", "Retry", "Dead", "Total"}
+	printRows := func(w io.Writer, tmpl string) {
+		for _, q := range qnames {
+			u := usages[q]
+			if u == nil {
+				fmt.Fprintf(w, tmpl, strings.Title(q), "n/a", "n/a", "n/a", "n/a", "n/a")
+				continue
+			}
+			fmt.Fprintf(w, tmpl, strings.Title(q), formatBytes(u.Enqueued), formatBytes(u.Scheduled),
+				formatBytes(u.Retry), formatBytes(u.Dead), formatBytes(u.Enqueued+u.Scheduled+u.Retry+u.Dead))
+		}
+	}
+	printTable(cols, printRows)
+}
+
+func printDequeueStats(stats []*asynq.DequeueStats) {
+	cols := []string{"Queue", "Attempts", "Hits", "Hit Rate", "Last Queried", "Starvation"}
+	printRows := func(w io.Writer, tmpl string) {
+		for _, s := range stats {
+			last := "never"
+			if !s.LastQueried.IsZero() {
+				last = timeAgo(s.LastQueried)
+			}
+			fmt.Fprintf(w, tmpl, strings.Title(s.Queue), s.Attempts, s.Hits,
+				fmt.Sprintf("%.1f%%", s.HitRate()*100), last, s.Starvation.Round(time.Second))
+		}
+	}
+	printTable(cols, printRows)
+}
+
+func printFailureStats(s *asynq.FailureStats) {
+	cols := []string{"State", "Size", "Oldest"}
+	var prev time.Duration
+	for _, b := range s.RetryAges {
+		if b.MaxAge == 0 {
+			cols = append(cols, ">"+formatAge(prev))
+		} else {
+			cols = append(cols, "<"+formatAge(b.MaxAge))
+		}
+		prev = b.MaxAge
+	}
+	printRows := func(w io.Writer, tmpl string) {
+		for _, row := range []struct {
+			state   string
+			size    int
+			oldest  time.Duration
+			buckets []asynq.AgeBucket
+		}{
+			{"Retry", s.Retry, s.OldestRetryAge, s.RetryAges},
+			{"Dead", s.Dead, s.OldestDeadAge, s.DeadAges},
+		} {
+			vals := []interface{}{row.state, row.size, row.oldest.Round(time.Second)}
+			for _, b := range row.buckets {
+				vals = append(vals, b.Count)
+			}
+			fmt.Fprintf(w, tmpl, vals...)
+		}
+	}
+	printTable(cols, printRows)
+	fmt.Printf("\nDead in the last hour: %d\n", s.DeadInLastHour)
+}
+
+// formatAge formats the age in the largest whole unit of days, hours or minutes (e.g. 6h).
+func formatAge(d time.Duration) string {
+	switch {
+	case d >= 24*time.Hour && d%(24*time.Hour) == 0:
+		return fmt.Sprintf("%dd", d/(24*time.Hour))
+	case d >= time.Hour && d%time.Hour == 0:
+		return fmt.Sprintf("%dh", d/time.Hour)
+	}
+	return fmt.Sprintf("%dm", d/time.Minute)
+}
+
+// formatBytes formats the number of bytes in a human readable form (e.g. 1.50MB).
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.2f%cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
 func printStats(s *rdb.Stats) {
 	format := strings.Repeat("%v\t", 3) + "\n"
 	tw := new(tabwriter.Writer).Init(os.Stdout, 0, 8, 2, ' ', 0)
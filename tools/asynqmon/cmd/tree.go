@@ -0,0 +1,77 @@
+// Copyright 2020 Kentaro Hibino. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/hibiken/asynq"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// treeCmd represents the tree command
+var treeCmd = &cobra.Command{
+	Use:   "tree [queue name] [task id]",
+	Short: "Shows the tree of tasks spawned by the specified task",
+	Long: `Tree (asynqmon tree) will show the specified task and the tasks spawned
+by it from its handler with asynq.EnqueueFromHandler, recursively.
+
+The command takes two arguments: the name of the queue of the task and the task ID.
+The task ID can be obtained by running "asynqmon ls" command.
+
+For each task, the type, queue and state of the task are shown.
+Tasks which completed are shown as "unknown" unless background processes
+are configured with a positive TaskHistorySize.
+
+Example: asynqmon tree reports bnogo8gt6toe23vhef0g`,
+	Args: cobra.ExactArgs(2),
+	Run:  tree,
+}
+
+func init() {
+	rootCmd.AddCommand(treeCmd)
+}
+
+func tree(cmd *cobra.Command, args []string) {
+	id, err := parseTaskID(args[1])
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	i := asynq.NewInspector(asynq.RedisClientOpt{
+		Addr:     viper.GetString("uri"),
+		DB:       viper.GetInt("db"),
+		Password: viper.GetString("password"),
+	})
+	defer i.Close()
+
+	t, err := i.GetTaskTree(args[0], id.String())
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	printTaskTree(os.Stdout, t, "", "")
+}
+
+// printTaskTree writes the task of the tree t to w, preceded by prefix,
+// followed by its children, each preceded by childPrefix and the branches.
+func printTaskTree(w io.Writer, t *asynq.TaskTree, prefix, childPrefix string) {
+	task := t.Task
+	typename := task.Type
+	if typename == "" {
+		typename = "-"
+	}
+	fmt.Fprintf(w, "%s%s %s queue=%s state=%s\n", prefix, task.ID, typename, task.Queue, task.State)
+	for i, child := range t.Children {
+		if i == len(t.Children)-1 {
+			printTaskTree(w, child, childPrefix+"└── ", childPrefix+"    ")
+		} else {
+			printTaskTree(w, child, childPrefix+"├── ", childPrefix+"│   ")
+		}
+	}
+}